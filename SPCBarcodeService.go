@@ -1,71 +1,145 @@
 package main
 
 import (
-	"bufio"
-	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
 	"os"
-	"strings"
+	"path/filepath"
 	"sync"
 	"time"
 
+	"github.com/zachthieme/scanandpost/scan"
+
 	"github.com/karalabe/hid"
 	"github.com/kardianos/service"
 	"github.com/sirupsen/logrus"
 )
 
+// version is set at build time with -ldflags "-X main.version=1.2.3"
+var version = "dev"
+
 // Config represents the configuration for the application
 type Config struct {
-	APIEndpoint      string `json:"apiEndpoint"`
-	NumberOfScanners int    `json:"numberOfScanners"`
-	RescanInterval   int    `json:"rescanInterval"`
-	Keyboard         bool   `json:"keyboard"`
+	// ConfigVersion is the schema version of the file, upgraded automatically on load
+	ConfigVersion       int                       `json:"configVersion"`
+	APIEndpoint         string                    `json:"apiEndpoint"`
+	NumberOfScanners    int                       `json:"numberOfScanners"`
+	RescanInterval      int                       `json:"rescanInterval"`
+	Keyboard            bool                      `json:"keyboard"`
+	Alerts              AlertConfig               `json:"alerts"`
+	SNMP                SNMPConfig                `json:"snmp"`
+	Plugins             []PluginConfig            `json:"plugins"`
+	PayloadSchema       string                    `json:"payloadSchema"`
+	Outbox              OutboxConfig              `json:"outbox"`
+	Commands            []ScanCommandConfig       `json:"commands"`
+	CommandAllowlist    []string                  `json:"commandAllowlist"`
+	KeyboardPassthrough KeyboardPassthroughConfig `json:"keyboardPassthrough"`
+	SerialOutput        SerialOutputConfig        `json:"serialOutput"`
+	OPOSBridge          OPOSBridgeConfig          `json:"oposBridge"`
+	Ingest              IngestConfig              `json:"ingest"`
+	APITLS              TLSConfig                 `json:"apiTls"`
+	Outputs             []OutputConfig            `json:"outputs"`
+	TLSPolicy           TLSPolicyConfig           `json:"tlsPolicy"`
+	SlowOutputMillis    int                       `json:"slowOutputMillis"`
+	Admin               AdminConfig               `json:"admin"`
+	Heartbeat           HeartbeatConfig           `json:"heartbeat"`
+	Batching            BatchingConfig            `json:"batching"`
+	// FlushDeadlineSeconds bounds the flush on shutdown and on demand
+	FlushDeadlineSeconds int           `json:"flushDeadlineSeconds"`
+	Fsync                FsyncConfig   `json:"fsync"`
+	Storage              StorageConfig `json:"storage"`
+	// InstanceName tells apart several copies of the service on one machine
+	InstanceName string            `json:"instanceName"`
+	Trace        TraceConfig       `json:"trace"`
+	Degradation  DegradationConfig `json:"degradation"`
+	Geotag       GeotagConfig      `json:"geotag"`
+	CheckInOut   CheckInOutConfig  `json:"checkInOut"`
+	// ScannerNicknames names the scanners in order, e.g. "Receiving Door 3" for scanner0
+	ScannerNicknames []string          `json:"scannerNicknames"`
+	Chaos            ChaosConfig       `json:"chaos"`
+	Split            SplitConfig       `json:"split"`
+	NoiseFilter      NoiseFilterConfig `json:"noiseFilter"`
+	// PoisonAttempts is how many replays the API may reject a queued payload before it is dead-lettered (default 3, negative to never)
+	PoisonAttempts int            `json:"poisonAttempts"`
+	Receipts       ReceiptsConfig `json:"receipts"`
+	Feedback       FeedbackConfig `json:"feedback"`
+	// StationID is assigned by the backend on enrollment
+	StationID      string               `json:"stationId"`
+	CertEnrollment CertEnrollmentConfig `json:"certEnrollment"`
+	NoInputs       NoInputsConfig       `json:"noInputs"`
+	Mirror         MirrorConfig         `json:"mirror"`
+	// Experiments run two transform variants side by side on live traffic
+	Experiments []ExperimentConfig `json:"experiments"`
+	Sequence    SequenceConfig     `json:"sequence"`
+	// Triggers are the host trigger ports of scanners that can be triggered from software
+	Triggers []TriggerConfig `json:"triggers"`
+	Modbus   ModbusConfig    `json:"modbus"`
+	Outcomes OutcomesConfig  `json:"outcomes"`
+	NoRead   NoReadConfig    `json:"noRead"`
+	// DiskPressure gives up logging step by step as the disk fills, keeping room for the queue
+	DiskPressure DiskPressureConfig `json:"diskPressure"`
+	// RingBuffer absorbs bursts of scans in a fixed-size buffer drained by a fixed set of workers
+	RingBuffer RingBufferConfig `json:"ringBuffer"`
+	// Preset is a named base such as "warehouse-tunnel" that the rest of the file is applied on top of
+	Preset string `json:"preset"`
+	// Power sends idle scanners to sleep, for battery-backed carts
+	Power PowerConfig `json:"power"`
+	// Pairing binds scanner slots to Bluetooth units by serial number, so a replacement unit takes over its slot
+	Pairing PairingConfig `json:"pairing"`
+	// Group is the site or group the station belongs to, such as "dc-east-receiving", reported in its status
+	Group string `json:"group"`
+	// Rollup makes this station a relay that sends one heartbeat for the groups of the stations reporting to it
+	Rollup RollupConfig `json:"rollup"`
+	// RetryBudget caps the retries sent per minute across all queued scans
+	RetryBudget RetryBudgetConfig `json:"retryBudget"`
+	// Annotations hold each scan briefly so reason codes or damage flags can be attached from a secondary input
+	Annotations AnnotationConfig `json:"annotations"`
+	// Review holds scans matching its rules until a supervisor approves them
+	Review ReviewConfig `json:"review"`
+	// Retention purges old records from the local logs and files that are not the queue
+	Retention RetentionConfig `json:"retention"`
+	// ScanIDs gives each scan an ID with the configured strategy
+	ScanIDs ScanIDConfig `json:"scanIds"`
+	// Standby pairs this station with another that takes over when one of them dies
+	Standby StandbyConfig `json:"standby"`
+	// Closeout is the end-of-day close-out, run from the command line, the admin API or a barcode
+	Closeout CloseoutConfig `json:"closeout"`
 }
 
 // Payload represents the data to be sent to the API
-type Payload struct {
-	ItemID     string `json:"itemid"`
-	DeviceType string `json:"deviceType"`
-}
-
-func (f *Payload) CleanItemId() {
-	result := f.ItemID
-	idIndex := strings.Index(result, "id=")
-	if idIndex != -1 {
-		//Extract the substring after "id="
-		f.ItemID = result[idIndex+len("id="):]
-	}
-}
+type Payload = scan.Payload
 
 // Service represents the Windows service
 type Service struct {
-	wg sync.WaitGroup
+	wg     sync.WaitGroup
+	mu     sync.Mutex
+	config *Config
 }
 
 var logger = logrus.New()
 
 // readConfig reads the configuration from a file
 func readConfig() (*Config, error) {
-	file, err := os.Open("config.json")
-	if err != nil {
-		return nil, err
-	}
-	defer file.Close()
+	return loadConfigFile("config.json", true)
+}
+
+// apiClient posts to the primary API endpoint using the apiTls settings
+var apiClient = http.DefaultClient
 
-	var config Config
-	decoder := json.NewDecoder(file)
-	err = decoder.Decode(&config)
+var httpPost = func(url, contentType string, body io.Reader) (*http.Response, error) {
+	var device string
+	if b, ok := body.(*deviceBody); ok {
+		body, device = b.Reader, b.device
+	}
+	req, err := http.NewRequestWithContext(withDevice(context.Background(), device), http.MethodPost, url, body)
 	if err != nil {
 		return nil, err
 	}
-	return &config, nil
-}
-
-var httpPost = func(url, contentType string, body io.Reader) (*http.Response, error) {
-	return http.Post(url, contentType, body)
+	req.Header.Set("Content-Type", contentType)
+	return apiClient.Do(req)
 }
 
 func postPayload(config *Config, payload Payload) {
@@ -74,34 +148,40 @@ func postPayload(config *Config, payload Payload) {
 	if err != nil {
 		logger.Errorf("Error marshaling payload: %v", err)
 		logFailure(payload)
+		reportOutcome(payload, outcomeRejected, err.Error())
 		return
 	}
 
-	resp, err := httpPost(config.APIEndpoint, "application/json", bytes.NewBuffer(jsonData))
-	if err != nil || resp.StatusCode != http.StatusOK {
-		logger.Errorf("Error posting payload: %v, response code: %v", err, resp.StatusCode)
+	resp, err := httpPost(config.APIEndpoint, "application/json", newDeviceBody(payload.DeviceType, jsonData))
+	if err != nil || !scan.Accepted(resp.StatusCode, false) {
+		statusCode := 0
+		if resp != nil {
+			statusCode = resp.StatusCode
+		}
+		logger.Errorf("Error posting payload: %v, response code: %v", err, statusCode)
+		bus.postFailed.publish(PostFailed{Payloads: []Payload{payload}, StatusCode: statusCode})
 		logFailure(payload)
 		return
 	}
+	body := readResponseBody(resp)
+	bus.postSucceeded.publish(PostSucceeded{Payloads: []Payload{payload}, StatusCode: resp.StatusCode, Response: body})
 	logger.Infof("Successfully posted payload: %v", payload)
 }
 
+// failuresFile holds the payloads waiting to be replayed
+var failuresFile = "failures.log"
+
 // logFailure logs the payload to the event log and saves it to a file
 func logFailure(payload Payload) {
-	file, err := os.OpenFile("failures.log", os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
-	if err != nil {
-		logger.Errorf("Error opening failures.log: %v", err)
-		return
-	}
-	defer file.Close()
-	data, err := json.Marshal(payload)
+	failuresMu.Lock()
+	defer failuresMu.Unlock()
+	entry, err := encodeQueueEntry(payload, time.Now())
 	if err != nil {
 		logger.Errorf("Error marshaling payload: %v", err)
 		return
 	}
-	_, err = file.WriteString(fmt.Sprintf("%s\n", data))
-	if err != nil {
-		logger.Errorf("Error writing to failures.log: %v", err)
+	if err := appendRecord(failuresFile, []byte(entry)); err != nil {
+		logger.Errorf("Error writing to %s: %v", failuresFile, err)
 	}
 }
 
@@ -109,34 +189,69 @@ func logFailure(payload Payload) {
 func scanDevice(config *Config, deviceID int, payloadCh chan Payload) {
 	for {
 		devices := hid.Enumerate(0, 0)
-		if deviceID >= len(devices) {
-			logger.Warnf("No device found for deviceID %d. Rescanning in %d seconds...", deviceID, config.RescanInterval)
+		// a sleeping scanner may drop off the bus; that is expected, so it is
+		// not reported as missing and its reconnect attempts are not logged
+		asleep := scannerAsleep(scannerName(deviceID))
+		index := deviceIndex(deviceID, devices)
+		if index < 0 {
+			if asleep {
+				logger.Debugf("No device found for deviceID %d while it sleeps", deviceID)
+			} else {
+				logger.Warnf("No device found for deviceID %d. Rescanning in %d seconds...", deviceID, config.RescanInterval)
+				bus.deviceDetached.publish(DeviceDetached{DeviceID: deviceID, At: time.Now()})
+			}
 			time.Sleep(time.Duration(config.RescanInterval) * time.Second)
 			continue
 		}
 
-		device, err := devices[deviceID].Open()
+		device, err := devices[index].Open()
 		if err != nil {
+			if asleep {
+				logger.Debugf("Error opening device while it sleeps: %v", err)
+				time.Sleep(time.Duration(config.RescanInterval) * time.Second)
+				continue
+			}
 			logger.Errorf("Error opening device: %v", err)
+			bus.deviceFailed.publish(DeviceFailed{DeviceID: deviceID, Err: err, At: time.Now()})
 			time.Sleep(time.Duration(config.RescanInterval) * time.Second)
 			continue
 		}
 		defer device.Close()
+		bus.deviceAttached.publish(DeviceAttached{DeviceID: deviceID, Serial: devices[index].Serial, At: time.Now()})
+		if triggerDisabled(scannerName(deviceID)) {
+			// a scanner that was power cycled comes back with its trigger enabled
+			triggerScanner(scannerName(deviceID), triggerDisable)
+		}
 
 		buf := make([]byte, 256)
 		for {
 			n, err := device.Read(buf)
+			if err != nil && scannerAsleep(scannerName(deviceID)) {
+				logger.Debugf("Device %s went away while it sleeps: %v", scannerName(deviceID), err)
+				break
+			}
 			if err != nil {
 				logger.Errorf("Error reading from device: %v", err)
+				bus.deviceFailed.publish(DeviceFailed{DeviceID: deviceID, Err: err, At: time.Now()})
 				break
 			}
 
+			if n > 0 && chaos.isDisconnected(deviceID) {
+				continue
+			}
+			if n > 0 && !plc.allows() {
+				logger.Infof("Dropped read %q from %s: no package present", string(buf[:n]), scannerName(deviceID))
+				continue
+			}
 			if n > 0 {
+				noReads.disarm(scannerName(deviceID))
 				// Convert byte buffer to string
 				payload := Payload{
 					ItemID:     string(buf[:n]),
-					DeviceType: fmt.Sprintf("scanner%d", deviceID),
+					DeviceType: scannerName(deviceID),
+					Nickname:   config.scannerNickname(deviceID),
 				}
+				bus.deviceRead.publish(DeviceRead{DeviceID: deviceID, At: time.Now()})
 				payloadCh <- payload
 			}
 		}
@@ -145,16 +260,7 @@ func scanDevice(config *Config, deviceID int, payloadCh chan Payload) {
 
 // readKeyboardInput reads keyboard input and sends the payload to the channel
 func readKeyboardInput(payloadCh chan Payload) {
-	scanner := bufio.NewScanner(os.Stdin)
-	for scanner.Scan() {
-		//Extract the substring after "id="
-		payload := Payload{
-			ItemID:     scanner.Text(),
-			DeviceType: "keyboard",
-		}
-		payloadCh <- payload
-	}
-	if err := scanner.Err(); err != nil {
+	if err := scan.ReadLines(os.Stdin, "keyboard", payloadCh); err != nil {
 		logger.Fatalf("Error reading standard input: %v", err)
 	}
 }
@@ -167,38 +273,499 @@ func startScanning(config *Config, payloadCh chan Payload) {
 	if config.Keyboard {
 		go readKeyboardInput(payloadCh)
 	}
+	if config.Outbox.enabled() {
+		go tailOutbox(config, payloadCh)
+	}
+	if config.Ingest.Listen != "" {
+		go serveIngest(config, payloadCh)
+	}
+	startInputPlugins(config, payloadCh)
 }
 
-// runService runs the service
-func (s *Service) runService() {
+// prepare reads the config and readies the storage before the service starts
+func (s *Service) prepare() *Config {
 	config, err := readConfig()
 	if err != nil {
 		logger.Fatalf("Error reading config: %v", err)
 	}
+	if err := acquireInstanceLock(config.instanceName()); err != nil {
+		logger.Fatalf("Error starting: %v", err)
+	}
+	if err := applyStorage(config.Storage); err != nil {
+		logger.Fatalf("Error preparing storage: %v", err)
+	}
+	if config.DiskPressure.Enabled {
+		if err := config.DiskPressure.validate(); err != nil {
+			logger.Fatalf("Error in disk pressure thresholds: %v", err)
+		}
+		storage := config.Storage.withDefaults()
+		diskPressure = newDiskGuard(config.DiskPressure, storage.LogDir, storage.QueueDir)
+		go diskPressure.watch()
+	}
+	fsyncPolicy = config.Fsync
+	if err := fsyncPolicy.validate(); err != nil {
+		logger.Fatalf("Error in fsync policy: %v", err)
+	}
+	if fsyncPolicy.Policy == "periodic" {
+		go syncPeriodically()
+	}
+	runQueueCheck()
+	s.mu.Lock()
+	s.config = config
+	s.mu.Unlock()
+	return config
+}
+
+// runService runs the service
+func (s *Service) runService(config *Config) {
+	if err := setupClients(config); err != nil {
+		logger.Fatalf("Error configuring HTTP clients: %v", err)
+	}
+	if config.Pairing.Enabled {
+		if err := startPairing(config); err != nil {
+			logger.Fatalf("Error loading scanner pairings: %v", err)
+		}
+	}
+	logStartupBanner(config)
+	logger.AddHook(recentErrorsHook{})
+	if config.Trace.Enabled {
+		t, err := newScanTracer(config.Trace)
+		if err != nil {
+			logger.Fatalf("Error in trace pattern: %v", err)
+		}
+		tracer = t
+	}
+	var err error
+	if err := config.Split.validate(); err != nil {
+		logger.Fatalf("Error in split configuration: %v", err)
+	}
+	if config.Feedback.Template != "" {
+		if feedback, err = newFeedbackDisplay(config.Feedback); err != nil {
+			logger.Fatalf("Error configuring feedback: %v", err)
+		}
+	}
+	if config.Receipts.Path != "" {
+		receipts = newReceiptWriter(config.Receipts)
+		receipts.sequenced = config.Sequence.Enabled
+	}
+	if deviceStats, err = loadDeviceStats(); err != nil {
+		logger.Errorf("Error loading device statistics, starting over: %v", err)
+		deviceStats = newDeviceStatsStore(filepath.Join(stateDir, "devicestats.json"))
+	}
+	go saveDeviceStats()
+	if config.Sequence.Enabled {
+		if err := config.Sequence.validate(); err != nil {
+			logger.Fatalf("Error in sequence configuration: %v", err)
+		}
+		if sequences, err = loadSequences(); err != nil {
+			// starting over would issue numbers the backend has already seen
+			logger.Fatalf("Error loading sequences: %v", err)
+		}
+		go watchSequences(config)
+	}
+	if config.Mirror.Address != "" {
+		if mirror, err = newScanMirror(config.Mirror, config.StationID); err != nil {
+			logger.Fatalf("Error opening mirror: %v", err)
+		}
+	}
+	if experiments, err = newExperiments(config.Experiments, config.Plugins); err != nil {
+		logger.Fatalf("Error in experiments: %v", err)
+	}
+	if config.NoiseFilter.enabled() {
+		if noise, err = newNoiseFilter(config.NoiseFilter); err != nil {
+			logger.Fatalf("Error in noise filter: %v", err)
+		}
+	}
+	if config.Chaos.Enabled {
+		logger.Warnf("Chaos failure injection is enabled: %+v", config.Chaos)
+		chaos = newChaosMonkey(config.Chaos)
+		httpPost = chaos.wrapPost(httpPost)
+		go chaos.disconnectDevices(config.NumberOfScanners)
+	}
+	if config.CheckInOut.Enabled {
+		checkInOut, err = loadAssetStates(config.CheckInOut)
+		if err != nil {
+			logger.Fatalf("Error loading check-in/check-out state: %v", err)
+		}
+	}
+	if config.Geotag.Enabled {
+		g, err := newGeotagger(config.Geotag)
+		if err != nil {
+			logger.Fatalf("Error configuring geotagging: %v", err)
+		}
+		geotag = g
+		go geotag.watch()
+	}
+	if config.Degradation.Enabled {
+		budget = newErrorBudget(config.Degradation)
+		budget.onRecover = func() {
+			go flushAll(config, config.flushDeadline())
+		}
+	}
+	if config.RetryBudget.Enabled {
+		retryBudget = newRetryLimiter(config.RetryBudget)
+	}
+	if config.Batching.Enabled {
+		if !scan.ValidEncoding(config.Batching.Encoding) {
+			logger.Fatalf("Error configuring batching: unknown encoding %q", config.Batching.Encoding)
+		}
+		apiBatcher = newBatcher(config.Batching.withDefaults(config.APIEndpoint))
+		go apiBatcher.run()
+	}
 	payloadCh := make(chan Payload)
-	go startScanning(config, payloadCh)
-	for payload := range payloadCh {
-		go postPayload(config, payload)
+	if config.NoRead.Enabled {
+		noReads = newNoReadWatcher(config, payloadCh)
+	}
+	if config.Alerts.enabled() {
+		go watchAlerts(config)
+	}
+	if config.SNMP.Listen != "" {
+		go serveSNMP(config)
+	}
+	if config.Admin.Listen != "" {
+		go serveAdmin(config)
+	}
+	if config.Heartbeat.Endpoint != "" {
+		go sendHeartbeats(config)
+	}
+	if config.Annotations.Enabled {
+		if err := startAnnotations(config); err != nil {
+			logger.Fatalf("Error configuring annotations: %v", err)
+		}
+	}
+	if config.Review.Enabled {
+		if err := startReview(config); err != nil {
+			logger.Fatalf("Error configuring the review queue: %v", err)
+		}
+	}
+	if config.Retention.Enabled {
+		if err := config.Retention.validate(); err != nil {
+			logger.Fatalf("Error in retention configuration: %v", err)
+		}
+		go watchRetention(config.Retention.withDefaults())
+	}
+	if config.ScanIDs.Enabled {
+		if err := config.ScanIDs.validate(); err != nil {
+			logger.Fatalf("Error in scan ID configuration: %v", err)
+		}
+		scanIDs = newScanIDGenerator(config.ScanIDs)
+	}
+	if config.Closeout.Enabled {
+		if err := startCloseout(config); err != nil {
+			logger.Fatalf("Error configuring the close-out: %v", err)
+		}
+		go saveCloseoutTally()
+	}
+	if config.Standby.Enabled {
+		if err := startStandby(config); err != nil {
+			logger.Fatalf("Error configuring the standby pair: %v", err)
+		}
+	}
+	if config.Rollup.Enabled {
+		if err := startRollups(config); err != nil {
+			logger.Fatalf("Error configuring the roll-up: %v", err)
+		}
 	}
+	if enrolledCert != nil {
+		go enrolledCert.maintain()
+	}
+	go watchInputs(config)
+	go watchReloadSignal(config)
+	if config.PayloadSchema != "" {
+		payloadSchema, err = loadPayloadSchema(config.PayloadSchema)
+		if err != nil {
+			logger.Fatalf("Error loading payload schema: %v", err)
+		}
+	}
+	if triggers, err = loadTriggers(config); err != nil {
+		logger.Fatalf("Error in trigger configuration: %v", err)
+	}
+	if config.Power.Enabled {
+		if err := startPower(config); err != nil {
+			logger.Fatalf("Error in power management: %v", err)
+		}
+	}
+	if config.Modbus.Address != "" {
+		if err := config.Modbus.validate(); err != nil {
+			logger.Fatalf("Error in Modbus configuration: %v", err)
+		}
+		plc = newPLCLink(config.Modbus)
+		if config.Modbus.PresentCoil != nil {
+			go plc.watchPresent()
+		}
+	}
+	if len(config.Outcomes.Routes) > 0 || config.Outcomes.DuplicateSeconds > 0 {
+		if outcomes, err = newOutcomeRouter(config.Outcomes, config.StationID, plc != nil); err != nil {
+			logger.Fatalf("Error in outcome routing: %v", err)
+		}
+	}
+	scanCommands, err = loadScanCommands(config)
+	if err != nil {
+		logger.Fatalf("Error loading commands: %v", err)
+	}
+	if config.KeyboardPassthrough.Enabled {
+		startKeyboardPassthrough()
+	}
+	if config.SerialOutput.Port != "" {
+		startSerialOutput(config)
+	}
+	if config.OPOSBridge.Listen != "" {
+		opos = &oposBridge{}
+		go serveOPOSBridge(config, opos)
+	}
+	startOutputs(httpOutputs)
+	if config.RingBuffer.Enabled {
+		startScanBuffer(config)
+	}
+	registerPlugins(config)
+	startOutputs(outputPlugins)
+	go func() {
+		// a standby claims its inputs only once it takes over
+		pair.waitActive()
+		startScanning(config, payloadCh)
+	}()
+	for scanned := range payloadCh {
+		if scanBuffer != nil {
+			scanBuffer.put(scanned)
+			continue
+		}
+		for _, payload := range intake(config, scanned) {
+			go dispatchPayload(config, payload)
+		}
+	}
+}
+
+// intake splits a scan from any input and counts the resulting payloads
+func intake(config *Config, scanned Payload) []Payload {
+	if review.consumes(scanned) || closeouts.consumes(config, scanned) {
+		return nil
+	}
+	if annotations.consumes(scanned) {
+		if err := annotations.annotate(scanned.ItemID); err != nil {
+			logger.Warnf("Ignored annotation key from %s: %v", scanned.DeviceType, err)
+		}
+		return nil
+	}
+	payloads := splitPayload(config.Split, scanned)
+	for i := range payloads {
+		// a scan that already has an ID keeps it
+		if payloads[i].ScanID == "" {
+			payloads[i].ScanID = scanIDs.next(time.Now())
+		}
+		bus.scanReceived.publish(ScanReceived{Payload: payloads[i], At: time.Now()})
+	}
+	return payloads
+}
+
+// setupClients applies the TLS policy and creates the HTTP clients for the API and outputs
+func setupClients(config *Config) error {
+	tlsPolicy = config.TLSPolicy
+	if err := tlsPolicy.validate(); err != nil {
+		return fmt.Errorf("TLS policy: %v", err)
+	}
+	if config.SlowOutputMillis > 0 {
+		slowOutputThreshold = time.Duration(config.SlowOutputMillis) * time.Millisecond
+	}
+	if config.CertEnrollment.Server != "" {
+		enrolledCert = newCertManager(config.CertEnrollment, config.StationID)
+		if err := enrolledCert.load(); err != nil && !os.IsNotExist(err) {
+			logger.Errorf("Error loading enrolled client certificate: %v", err)
+		}
+	}
+	var err error
+	apiClient, err = newInstrumentedClient("api", config.APITLS)
+	if err != nil {
+		return fmt.Errorf("API TLS: %v", err)
+	}
+	if err := apiTokens.load(); err != nil {
+		return fmt.Errorf("API token: %v", err)
+	}
+	apiClient.Transport = &bearerTransport{base: apiClient.Transport, tokens: apiTokens, stationID: config.StationID}
+	httpOutputs, err = loadOutputs(config)
+	return err
+}
+
+// dispatchPayload runs the payload through the transforms and delivers it to every output
+func dispatchPayload(config *Config, payload Payload) {
+	trace := tracer.start(payload)
+	payload = chaos.corrupt(payload)
+	if payload.Location == nil {
+		payload.Location = geotag.current()
+	}
+	payload = noReads.convert(payload)
+	if payload.NoRead {
+		// no-reads skip the filters and transforms meant for barcodes
+		reportOutcome(payload, outcomeNoRead, "no barcode decoded")
+		trace.step("no-read")
+	} else {
+		var ok bool
+		if payload, ok = acceptScan(config, payload, trace); !ok {
+			return
+		}
+	}
+	if annotations != nil {
+		payload = annotations.hold(payload)
+		trace.step("held for annotations", "annotations", len(payload.Annotations))
+	}
+	if rule := review.match(payload); rule != "" {
+		review.hold(payload, rule, time.Now())
+		trace.step("held for review", "rule", rule)
+		return
+	}
+	deliverScan(config, payload, trace)
+}
+
+// acceptScan filters and transforms a scan, returning false when it is
+// dropped or consumed before delivery
+func acceptScan(config *Config, payload Payload, trace *scanTrace) (Payload, bool) {
+	if reason := noise.reason(payload.ItemID); reason != "" {
+		logger.Infof("Dropped noise read %q from %s: %s", payload.ItemID, payload.DeviceType, reason)
+		reportOutcome(payload, outcomeNoRead, reason)
+		trace.step("dropped as noise", "reason", reason)
+		return payload, false
+	}
+	payload, ok := applyTransforms(payload)
+	if !ok {
+		trace.step("dropped by transform")
+		return payload, false
+	}
+	trace.step("transformed", "itemId", payload.ItemID)
+	passthroughKeyboard(config, payload)
+	if runScanCommands(payload) {
+		trace.step("consumed by command")
+		return payload, false
+	}
+	if outcomes.duplicate(payload.ItemID, time.Now()) {
+		logger.Infof("Dropped duplicate scan %q from %s", payload.ItemID, payload.DeviceType)
+		reportOutcome(payload, outcomeDuplicate, "")
+		trace.step("dropped as duplicate")
+		return payload, false
+	}
+	if action := checkInOut.resolve(payload.ItemID, time.Now()); action != "" {
+		payload.Action = action
+		trace.step("resolved action", "action", action)
+	}
+	return payload, true
+}
+
+// deliverScan numbers an accepted scan, validates it and hands it to the API
+// and every output. Validation comes last, after annotations, approval and
+// numbering, so the schema checks the payload exactly as it is sent.
+func deliverScan(config *Config, payload Payload, trace *scanTrace) {
+	if sequences != nil {
+		payload.Sequence = sequences.issue(payload.DeviceType, time.Now())
+		trace.step("numbered", "sequence", payload.Sequence)
+	}
+	if payloadSchema != nil {
+		if err := validatePayload(payloadSchema, payload); err != nil {
+			// the dead letter accounts for the sequence just issued
+			deadLetter(payload, err.Error())
+			reportOutcome(payload, outcomeRejected, err.Error())
+			trace.step("dead-lettered", "reason", err.Error())
+			return
+		}
+		trace.step("validated")
+	}
+	// published first, so a slow API post never delays the output pipelines
+	bus.scanAccepted.publish(ScanAccepted{Payload: payload})
+	if !budget.allowPost(time.Now()) {
+		logFailure(payload)
+		reportOutcome(payload, outcomeQueued, "queued while degraded")
+		trace.step("queued while degraded")
+	} else if apiBatcher != nil {
+		apiBatcher.add(payload)
+		trace.step("queued for batch")
+	} else {
+		postPayload(config, payload)
+		trace.step("posted")
+	}
+	writeSerialOutput(config, payload)
+	deliverToOPOS(payload)
+	deliverToOutputPlugins(payload)
+	trace.step("delivered to outputs", "outputs", outputCount(), "outputPlugins", len(outputPlugins))
 }
 
 // Start implements the Start method of the service
 func (s *Service) Start(svc service.Service) error {
 	s.wg.Add(1)
-	go s.runService()
+	go s.runService(s.prepare())
 	return nil
 }
 
-// Stop implements the Stop method of the service
+// Stop implements the Stop method of the service, flushing queued scans first
 func (s *Service) Stop(svc service.Service) error {
+	s.mu.Lock()
+	config := s.config
+	s.mu.Unlock()
+	if config != nil {
+		// buffered scans are dispatched first, so the flush covers the ones that fail
+		if left := scanBuffer.drain(config.flushDeadline()); left > 0 {
+			logger.Warnf("Stopped with %d scans still in the scan buffer", left)
+		}
+		if err := scanBuffer.close(); err != nil {
+			logger.Errorf("Error closing the scan buffer: %v", err)
+		}
+		flushAll(config, config.flushDeadline())
+		stopOutputs()
+		deviceStats.save()
+		closeouts.save()
+		sequences.save()
+	}
 	s.wg.Done()
 	return nil
 }
 
+// logFile is the file the logger currently writes to
+var logFile *os.File
+
+// logMu guards logFile and moving the logger's output between files
+var logMu sync.Mutex
+
+// setLogFile moves logging to the file at path, keeping the output to stdout
+func setLogFile(path string) error {
+	logMu.Lock()
+	defer logMu.Unlock()
+	return openLogFile(path)
+}
+
+// openLogFile does the work of setLogFile for a caller that holds logMu
+func openLogFile(path string) error {
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	setLogOutput(io.MultiWriter(file, os.Stdout))
+	if logFile != nil {
+		logFile.Close()
+	}
+	logFile = file
+	return nil
+}
+
+// logFileName returns the path of service.log, or "" when logging to stdout only
+func logFileName() string {
+	logMu.Lock()
+	defer logMu.Unlock()
+	if logFile == nil {
+		return ""
+	}
+	return logFile.Name()
+}
+
+// setLogOutput points the logger and the scan tracer at out
+func setLogOutput(out io.Writer) {
+	logger.SetOutput(out)
+	tracer.setOutput(out)
+}
+
 // setupLogging configures logging to a file and optionally to stdout
 func setupLogging(serviceMode bool) {
-	logFile, err := os.OpenFile("service.log", os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
-	if err != nil {
+	// until the config is read, log to the default log directory
+	path := "service.log"
+	if dir := storageDefaults().LogDir; os.MkdirAll(dir, 0755) == nil {
+		path = filepath.Join(dir, "service.log")
+	}
+	if err := setLogFile(path); err != nil {
 		logger.Fatalf("Error opening log file: %v", err)
 	}
 
@@ -207,6 +774,8 @@ func setupLogging(serviceMode bool) {
 		FullTimestamp: true,
 	}
 
+	logMu.Lock()
+	defer logMu.Unlock()
 	fileLogger := logrus.New()
 	fileLogger.SetOutput(logFile)
 	fileLogger.SetFormatter(jsonFormatter)
@@ -261,7 +830,96 @@ func main() {
 			fmt.Println("Service uninstalled successfully.")
 			return
 		case "interactive":
-			svc.runService()
+			svc.runService(svc.prepare())
+			return
+		case "flush":
+			config, err := readConfig()
+			if err != nil {
+				logger.Fatalf("Error reading config: %v", err)
+			}
+			if err := applyStorage(config.Storage); err != nil {
+				logger.Fatalf("Error preparing storage: %v", err)
+			}
+			if err := setupClients(config); err != nil {
+				logger.Fatalf("Error configuring HTTP clients: %v", err)
+			}
+			result, err := requestFlush(config)
+			if err != nil {
+				logger.Fatalf("Error flushing: %v", err)
+			}
+			fmt.Printf("Flushed %d payloads, %d remain queued, %d dead-lettered as poison.\n", result.Delivered, result.Remaining, result.Poisoned)
+			return
+		case "monitor":
+			config, err := readConfig()
+			if err != nil {
+				logger.Fatalf("Error reading config: %v", err)
+			}
+			// keep log lines from drawing over the terminal UI
+			logger.SetOutput(io.Discard)
+			if err := runMonitor(config); err != nil {
+				fmt.Fprintf(os.Stderr, "Error running monitor: %v\n", err)
+				os.Exit(1)
+			}
+			return
+		case "soak":
+			if err := runSoak(os.Args[2:]); err != nil {
+				fmt.Fprintf(os.Stderr, "Soak failed: %v\n", err)
+				os.Exit(1)
+			}
+			return
+		case "demo":
+			if err := runDemo(os.Args[2:]); err != nil {
+				fmt.Fprintf(os.Stderr, "Demo failed: %v\n", err)
+				os.Exit(1)
+			}
+			return
+		case "pairing-barcode":
+			if err := runPairingBarcode(os.Args[2:]); err != nil {
+				fmt.Fprintf(os.Stderr, "Error creating pairing barcode: %v\n", err)
+				os.Exit(1)
+			}
+			return
+		case "enroll":
+			if err := runEnroll(os.Args[2:]); err != nil {
+				fmt.Fprintf(os.Stderr, "Enrollment failed: %v\n", err)
+				os.Exit(1)
+			}
+			return
+		case "closeout":
+			config, err := readConfig()
+			if err != nil {
+				logger.Fatalf("Error reading config: %v", err)
+			}
+			if err := applyStorage(config.Storage); err != nil {
+				logger.Fatalf("Error preparing storage: %v", err)
+			}
+			if err := setupClients(config); err != nil {
+				logger.Fatalf("Error configuring HTTP clients: %v", err)
+			}
+			if err := runCloseout(config, os.Args[2:]); err != nil {
+				fmt.Fprintf(os.Stderr, "Close-out failed: %v\n", err)
+				os.Exit(1)
+			}
+			return
+		case "purge":
+			config, err := readConfig()
+			if err != nil {
+				logger.Fatalf("Error reading config: %v", err)
+			}
+			if err := applyStorage(config.Storage); err != nil {
+				logger.Fatalf("Error preparing storage: %v", err)
+			}
+			if err := runPurge(config, os.Args[2:]); err != nil {
+				fmt.Fprintf(os.Stderr, "Purge failed: %v\n", err)
+				os.Exit(1)
+			}
+			return
+		case "support-bundle":
+			path, err := createSupportBundle()
+			if err != nil {
+				logger.Fatalf("Error creating support bundle: %v", err)
+			}
+			fmt.Printf("Support bundle written to %s\n", path)
 			return
 		}
 	}
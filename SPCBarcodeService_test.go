@@ -11,6 +11,20 @@ import (
 	"github.com/stretchr/testify/mock"
 )
 
+func TestMain(m *testing.M) {
+	// keep the service's files out of the platform directories while testing
+	dir, err := os.MkdirTemp("", "scanandpost")
+	if err != nil {
+		panic(err)
+	}
+	storageDefaults = func() StorageConfig {
+		return StorageConfig{LogDir: dir, QueueDir: dir, StateDir: dir}
+	}
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
 // Mocking HTTP Client
 type MockHTTPClient struct {
 	mock.Mock
@@ -23,6 +37,7 @@ func (m *MockHTTPClient) Post(url, contentType string, body io.Reader) (*http.Re
 
 var (
 	validConfig = Config{
+		ConfigVersion:    1,
 		APIEndpoint:      "http://example.com/api",
 		NumberOfScanners: 2,
 		RescanInterval:   5,
@@ -31,6 +46,10 @@ var (
 )
 
 func TestReadConfig(t *testing.T) {
+	wd, _ := os.Getwd()
+	defer os.Chdir(wd)
+	os.Chdir(t.TempDir())
+
 	// Create a sample config.json file for testing
 	configContent := `{
 		"apiEndpoint": "http://example.com/api",
@@ -47,6 +66,10 @@ func TestReadConfig(t *testing.T) {
 }
 
 func TestReadConfig_FileNotFound(t *testing.T) {
+	wd, _ := os.Getwd()
+	defer os.Chdir(wd)
+	os.Chdir(t.TempDir())
+
 	_, err := readConfig()
 	assert.Error(t, err)
 }
@@ -68,7 +91,7 @@ func TestPostPayload_Success(t *testing.T) {
 	payload := Payload{ItemID: "12345", DeviceType: "scanner"}
 	config := &Config{APIEndpoint: "http://example.com/api"}
 
-	client.On("Post", config.APIEndpoint, "application/json", mock.AnythingOfType("*bytes.Buffer")).Return(&http.Response{
+	client.On("Post", config.APIEndpoint, "application/json", mock.AnythingOfType("*main.deviceBody")).Return(&http.Response{
 		StatusCode: http.StatusOK,
 	}, nil)
 
@@ -87,7 +110,7 @@ func TestPostPayload_Failure(t *testing.T) {
 	payload := Payload{ItemID: "12345", DeviceType: "scanner"}
 	config := &Config{APIEndpoint: "http://example.com/api"}
 
-	client.On("Post", config.APIEndpoint, "application/json", mock.AnythingOfType("*bytes.Buffer")).Return(&http.Response{
+	client.On("Post", config.APIEndpoint, "application/json", mock.AnythingOfType("*main.deviceBody")).Return(&http.Response{
 		StatusCode: http.StatusInternalServerError,
 	}, errors.New("post error"))
 
@@ -102,13 +125,13 @@ func TestPostPayload_Failure(t *testing.T) {
 }
 
 func TestLogFailure(t *testing.T) {
+	useTempQueue(t)
 	payload := Payload{ItemID: "12345", DeviceType: "scanner"}
 	logFailure(payload)
 
-	file, err := os.Open("failures.log")
+	file, err := os.Open(failuresFile)
 	assert.NoError(t, err)
 	defer file.Close()
-	defer os.Remove("failures.log")
 
 	data, err := io.ReadAll(file)
 	assert.NoError(t, err)
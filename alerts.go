@@ -0,0 +1,332 @@
+package main
+
+import (
+	"bufio"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"net/smtp"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// AlertConfig represents the configuration for alerting on critical conditions
+type AlertConfig struct {
+	SMTP                 SMTPConfig `json:"smtp"`
+	DeviceMissingMinutes int        `json:"deviceMissingMinutes"`
+	QueueThreshold       int        `json:"queueThreshold"`
+	AuthFailureThreshold int        `json:"authFailureThreshold"`
+	CheckInterval        int        `json:"checkInterval"`
+	CooldownMinutes      int        `json:"cooldownMinutes"`
+	// BacklogAgeMinutes alerts when the oldest payload in failures.log has waited this long
+	BacklogAgeMinutes int `json:"backlogAgeMinutes"`
+	// Maintenance alerts on scanner health trends before a scanner fails outright
+	Maintenance MaintenanceAlertConfig `json:"maintenance"`
+}
+
+// SMTPConfig represents the mail server used to deliver alerts
+type SMTPConfig struct {
+	Host     string   `json:"host"`
+	Port     int      `json:"port"`
+	Username string   `json:"username"`
+	Password string   `json:"password"`
+	From     string   `json:"from"`
+	To       []string `json:"to"`
+	// Security is one of "starttls" (default), "tls" or "none"
+	Security string `json:"security"`
+}
+
+// Alert represents a single critical condition
+type Alert struct {
+	Key     string
+	Subject string
+	Body    string
+}
+
+func (a AlertConfig) enabled() bool {
+	return a.SMTP.Host != "" && len(a.SMTP.To) > 0
+}
+
+func (a AlertConfig) checkInterval() time.Duration {
+	if a.CheckInterval <= 0 {
+		return time.Minute
+	}
+	return time.Duration(a.CheckInterval) * time.Second
+}
+
+func (a AlertConfig) cooldown() time.Duration {
+	if a.CooldownMinutes <= 0 {
+		return time.Hour
+	}
+	return time.Duration(a.CooldownMinutes) * time.Minute
+}
+
+// healthState tracks the conditions that alerts are raised on
+type healthState struct {
+	mu                  sync.Mutex
+	deviceMissingSince  map[int]time.Time
+	consecutiveAuthFail int
+	scansReceived       uint32
+	postsSucceeded      uint32
+	postsFailed         uint32
+	transformsFailed    uint32
+}
+
+var health = &healthState{deviceMissingSince: map[int]time.Time{}}
+
+// markDeviceMissing records the first time a device was found to be missing
+func markDeviceMissing(deviceID int) {
+	health.mu.Lock()
+	defer health.mu.Unlock()
+	if _, ok := health.deviceMissingSince[deviceID]; !ok {
+		health.deviceMissingSince[deviceID] = time.Now()
+	}
+}
+
+// markDevicePresent clears the missing state of a device
+func markDevicePresent(deviceID int) {
+	health.mu.Lock()
+	defer health.mu.Unlock()
+	delete(health.deviceMissingSince, deviceID)
+}
+
+// recordScan counts a payload read from any input
+func recordScan() {
+	health.mu.Lock()
+	defer health.mu.Unlock()
+	health.scansReceived++
+}
+
+// recordTransformFailure counts a transform plugin that failed
+func recordTransformFailure() {
+	health.mu.Lock()
+	defer health.mu.Unlock()
+	health.transformsFailed++
+}
+
+// recordPostResult counts post outcomes and tracks consecutive authentication failures from the API
+func recordPostResult(statusCode int, delivered bool) {
+	budget.record(delivered, time.Now())
+	health.mu.Lock()
+	defer health.mu.Unlock()
+	if delivered {
+		health.postsSucceeded++
+		health.consecutiveAuthFail = 0
+		return
+	}
+	health.postsFailed++
+	if statusCode == http.StatusUnauthorized || statusCode == http.StatusForbidden {
+		health.consecutiveAuthFail++
+	}
+}
+
+// queueDepth returns the number of payloads waiting in failures.log
+func queueDepth() int {
+	file, err := os.Open(failuresFile)
+	if err != nil {
+		return 0
+	}
+	defer file.Close()
+
+	count := 0
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if strings.TrimSpace(scanner.Text()) != "" {
+			count++
+		}
+	}
+	return count
+}
+
+// oldestQueued returns when the oldest payload in failures.log was queued, or
+// the zero time when the queue is empty or no entry records its queue time
+func oldestQueued() time.Time {
+	file, err := os.Open(failuresFile)
+	if err != nil {
+		return time.Time{}
+	}
+	defer file.Close()
+
+	var oldest time.Time
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		if _, queuedAt, err := decodeQueueEntry(line); err == nil && !queuedAt.IsZero() && (oldest.IsZero() || queuedAt.Before(oldest)) {
+			oldest = queuedAt
+		}
+	}
+	return oldest
+}
+
+// backlogAge is how long the oldest payload in failures.log has waited
+func backlogAge(now time.Time) time.Duration {
+	oldest := oldestQueued()
+	if oldest.IsZero() {
+		return 0
+	}
+	return now.Sub(oldest)
+}
+
+// evaluateAlerts returns the alerts whose conditions currently hold
+func evaluateAlerts(config *Config, now time.Time) []Alert {
+	var alerts []Alert
+	health.mu.Lock()
+	if config.Alerts.DeviceMissingMinutes > 0 {
+		limit := time.Duration(config.Alerts.DeviceMissingMinutes) * time.Minute
+		for deviceID, since := range health.deviceMissingSince {
+			if now.Sub(since) >= limit {
+				alerts = append(alerts, Alert{
+					Key:     fmt.Sprintf("device-missing-%d", deviceID),
+					Subject: fmt.Sprintf("%s missing", config.scannerLabel(deviceID)),
+					Body:    fmt.Sprintf("%s has not been found since %s.", config.scannerLabel(deviceID), since.Format(time.RFC3339)),
+				})
+			}
+		}
+	}
+	authFailures := health.consecutiveAuthFail
+	health.mu.Unlock()
+
+	if config.Alerts.AuthFailureThreshold > 0 && authFailures >= config.Alerts.AuthFailureThreshold {
+		alerts = append(alerts, Alert{
+			Key:     "auth-failures",
+			Subject: "repeated authentication failures",
+			Body:    fmt.Sprintf("%d consecutive posts to %s were rejected as unauthorized.", authFailures, config.APIEndpoint),
+		})
+	}
+	if since := budget.degraded(); !since.IsZero() {
+		alerts = append(alerts, Alert{
+			Key:     "degraded",
+			Subject: "posting degraded to queue-only mode",
+			Body:    fmt.Sprintf("Posts to %s have exceeded the error budget since %s. Scans are queued and the API is probed until it recovers.", config.APIEndpoint, since.Format(time.RFC3339)),
+		})
+	}
+	if budget := retryBudget.status(now); budget != nil && budget.ExhaustedSince != nil {
+		alerts = append(alerts, retryBudgetAlert(budget))
+	}
+	alerts = append(alerts, deviceStats.maintenanceAlerts(config, now)...)
+	if standby := pair.status(now); standby != nil && !standby.PeerReachable {
+		alerts = append(alerts, standbyAlert(standby))
+	}
+	if since := noInputs.current(); !since.IsZero() {
+		alerts = append(alerts, noInputsAlert(since))
+	}
+	if pressure := diskPressure.status(); pressure != nil {
+		alerts = append(alerts, diskPressureAlert(pressure))
+	}
+	if config.Alerts.BacklogAgeMinutes > 0 {
+		if oldest := oldestQueued(); !oldest.IsZero() && now.Sub(oldest) >= time.Duration(config.Alerts.BacklogAgeMinutes)*time.Minute {
+			alerts = append(alerts, Alert{
+				Key:     "backlog-age",
+				Subject: "undelivered scans are getting old",
+				Body:    fmt.Sprintf("The oldest payload in failures.log has waited since %s (threshold %d minutes). It may be rejected on every retry.", oldest.Format(time.RFC3339), config.Alerts.BacklogAgeMinutes),
+			})
+		}
+	}
+	if config.Alerts.QueueThreshold > 0 {
+		if depth := queueDepth(); depth >= config.Alerts.QueueThreshold {
+			alerts = append(alerts, Alert{
+				Key:     "queue-depth",
+				Subject: "failure queue above threshold",
+				Body:    fmt.Sprintf("%d payloads are waiting in failures.log (threshold %d).", depth, config.Alerts.QueueThreshold),
+			})
+		}
+	}
+	return alerts
+}
+
+// watchAlerts periodically evaluates alert conditions and mails new alerts
+func watchAlerts(config *Config) {
+	lastSent := map[string]time.Time{}
+	ticker := time.NewTicker(config.Alerts.checkInterval())
+	defer ticker.Stop()
+	for now := range ticker.C {
+		for _, alert := range evaluateAlerts(config, now) {
+			if sent, ok := lastSent[alert.Key]; ok && now.Sub(sent) < config.Alerts.cooldown() {
+				continue
+			}
+			if err := sendAlert(config.Alerts.SMTP, alert); err != nil {
+				logger.Errorf("Error sending alert %q: %v", alert.Key, err)
+				continue
+			}
+			logger.Warnf("Sent alert: %s", alert.Subject)
+			lastSent[alert.Key] = now
+		}
+	}
+}
+
+// sendAlert formats and mails a single alert
+func sendAlert(cfg SMTPConfig, alert Alert) error {
+	hostname, _ := os.Hostname()
+	msg := fmt.Sprintf("From: %s\r\nTo: %s\r\nSubject: [SPCBarcodeService %s] %s\r\n\r\n%s\r\n",
+		cfg.From, strings.Join(cfg.To, ", "), hostname, alert.Subject, alert.Body)
+	return sendMail(cfg, []byte(msg))
+}
+
+var sendMail = func(cfg SMTPConfig, msg []byte) error {
+	port := cfg.Port
+	if port == 0 {
+		port = 587
+		if cfg.Security == "tls" {
+			port = 465
+		}
+	}
+	addr := net.JoinHostPort(cfg.Host, strconv.Itoa(port))
+	tlsConfig := &tls.Config{ServerName: cfg.Host}
+	if err := tlsPolicy.apply(tlsConfig); err != nil {
+		return err
+	}
+
+	var conn net.Conn
+	var err error
+	if cfg.Security == "tls" {
+		conn, err = tls.Dial("tcp", addr, tlsConfig)
+	} else {
+		conn, err = net.DialTimeout("tcp", addr, 30*time.Second)
+	}
+	if err != nil {
+		return err
+	}
+	client, err := smtp.NewClient(conn, cfg.Host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+
+	if cfg.Security == "" || cfg.Security == "starttls" {
+		if err = client.StartTLS(tlsConfig); err != nil {
+			return err
+		}
+	}
+	if cfg.Username != "" {
+		if err = client.Auth(smtp.PlainAuth("", cfg.Username, cfg.Password, cfg.Host)); err != nil {
+			return err
+		}
+	}
+	if err = client.Mail(cfg.From); err != nil {
+		return err
+	}
+	for _, to := range cfg.To {
+		if err = client.Rcpt(to); err != nil {
+			return err
+		}
+	}
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err = w.Write(msg); err != nil {
+		return err
+	}
+	if err = w.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
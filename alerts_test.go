@@ -0,0 +1,88 @@
+package main
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEvaluateAlerts_DeviceMissing(t *testing.T) {
+	health = &healthState{deviceMissingSince: map[int]time.Time{}}
+	config := &Config{Alerts: AlertConfig{DeviceMissingMinutes: 5}}
+
+	markDeviceMissing(1)
+	assert.Empty(t, evaluateAlerts(config, time.Now()))
+
+	alerts := evaluateAlerts(config, time.Now().Add(6*time.Minute))
+	assert.Len(t, alerts, 1)
+	assert.Equal(t, "device-missing-1", alerts[0].Key)
+
+	markDevicePresent(1)
+	assert.Empty(t, evaluateAlerts(config, time.Now().Add(6*time.Minute)))
+}
+
+func TestEvaluateAlerts_AuthFailures(t *testing.T) {
+	health = &healthState{deviceMissingSince: map[int]time.Time{}}
+	config := &Config{Alerts: AlertConfig{AuthFailureThreshold: 2}}
+
+	recordPostResult(401, false)
+	assert.Empty(t, evaluateAlerts(config, time.Now()))
+	recordPostResult(403, false)
+	alerts := evaluateAlerts(config, time.Now())
+	assert.Len(t, alerts, 1)
+	assert.Equal(t, "auth-failures", alerts[0].Key)
+
+	recordPostResult(200, true)
+	assert.Empty(t, evaluateAlerts(config, time.Now()))
+}
+
+func TestEvaluateAlerts_QueueThreshold(t *testing.T) {
+	health = &healthState{deviceMissingSince: map[int]time.Time{}}
+	config := &Config{Alerts: AlertConfig{QueueThreshold: 2}}
+	useTempQueue(t)
+
+	logFailure(Payload{ItemID: "1", DeviceType: "scanner"})
+	assert.Empty(t, evaluateAlerts(config, time.Now()))
+	logFailure(Payload{ItemID: "2", DeviceType: "scanner"})
+	alerts := evaluateAlerts(config, time.Now())
+	assert.Len(t, alerts, 1)
+	assert.Equal(t, "queue-depth", alerts[0].Key)
+}
+
+func TestEvaluateAlerts_BacklogAge(t *testing.T) {
+	health = &healthState{deviceMissingSince: map[int]time.Time{}}
+	config := &Config{Alerts: AlertConfig{BacklogAgeMinutes: 30}}
+	useTempQueue(t)
+	now := time.Now()
+
+	logFailure(Payload{ItemID: "poison", DeviceType: "scanner"})
+	logFailure(Payload{ItemID: "2", DeviceType: "scanner"})
+	assert.Empty(t, evaluateAlerts(config, now.Add(29*time.Minute)))
+	alerts := evaluateAlerts(config, now.Add(31*time.Minute))
+	assert.Len(t, alerts, 1)
+	assert.Equal(t, "backlog-age", alerts[0].Key)
+
+	assert.InDelta(t, 31*time.Minute, backlogAge(now.Add(31*time.Minute)), float64(time.Second))
+	os.Remove(failuresFile)
+	assert.Zero(t, backlogAge(now))
+}
+
+func TestSendAlert(t *testing.T) {
+	var sent string
+	oldSendMail := sendMail
+	defer func() { sendMail = oldSendMail }()
+	sendMail = func(cfg SMTPConfig, msg []byte) error {
+		sent = string(msg)
+		return nil
+	}
+
+	cfg := SMTPConfig{From: "scanner@example.com", To: []string{"ops@example.com", "it@example.com"}}
+	err := sendAlert(cfg, Alert{Key: "k", Subject: "scanner0 missing", Body: "details"})
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(sent, "From: scanner@example.com\r\nTo: ops@example.com, it@example.com\r\n"))
+	assert.Contains(t, sent, "scanner0 missing")
+	assert.Contains(t, sent, "details")
+}
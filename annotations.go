@@ -0,0 +1,223 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// AnnotationConfig represents annotations attached to the most recent scan
+// from a secondary input, such as a numeric keypad or the touchscreen form of
+// the admin API. Each scan is held for the hold window before it is posted,
+// so an operator can flag it first.
+type AnnotationConfig struct {
+	Enabled bool `json:"enabled"`
+	// HoldSeconds is how long a scan waits for annotations (default 5)
+	HoldSeconds int `json:"holdSeconds"`
+	// Input is the device type whose reads are annotation keys instead of scans,
+	// such as "scanner1" for a keypad in that slot, "keyboard" or a plugin input
+	Input string `json:"input"`
+	// Codes maps the keys entered on the input to the annotations they attach, such as {"1": "damaged"}
+	Codes map[string]string `json:"codes"`
+	// ReleaseKey posts the held scan at once, without waiting out the hold window
+	ReleaseKey string `json:"releaseKey"`
+}
+
+func (a AnnotationConfig) withDefaults() AnnotationConfig {
+	if a.HoldSeconds <= 0 {
+		a.HoldSeconds = 5
+	}
+	return a
+}
+
+func (a AnnotationConfig) validate() error {
+	if len(a.Codes) == 0 {
+		return fmt.Errorf("no annotation codes configured")
+	}
+	if _, ok := a.Codes[a.ReleaseKey]; ok && a.ReleaseKey != "" {
+		return fmt.Errorf("release key %q is also an annotation code", a.ReleaseKey)
+	}
+	return nil
+}
+
+// heldScan is a scan waiting for annotations
+type heldScan struct {
+	payload     Payload
+	annotations []string
+	released    bool
+	release     chan struct{}
+}
+
+// annotator holds scans for annotations. A nil annotator holds nothing.
+type annotator struct {
+	config AnnotationConfig
+
+	mu      sync.Mutex
+	current *heldScan
+}
+
+var annotations *annotator
+
+func newAnnotator(config AnnotationConfig) *annotator {
+	return &annotator{config: config.withDefaults()}
+}
+
+// releaseLocked lets a held scan go on; the caller holds the lock
+func (h *heldScan) releaseLocked() {
+	if !h.released {
+		h.released = true
+		close(h.release)
+	}
+}
+
+// consumes reports whether a read from the device is an annotation key
+func (a *annotator) consumes(payload Payload) bool {
+	return a != nil && a.config.Input != "" && payload.DeviceType == a.config.Input
+}
+
+// hold waits until the hold window ends, the release key is entered or a newer
+// scan arrives, and returns the payload with the annotations it received
+func (a *annotator) hold(payload Payload) Payload {
+	if a == nil {
+		return payload
+	}
+	window := time.Duration(a.config.HoldSeconds) * time.Second
+	h := &heldScan{payload: payload, release: make(chan struct{})}
+	a.mu.Lock()
+	if a.current != nil {
+		// annotations only ever go to the most recent scan, so the previous one is done
+		a.current.releaseLocked()
+	}
+	a.current = h
+	a.mu.Unlock()
+
+	timer := time.NewTimer(window)
+	defer timer.Stop()
+	select {
+	case <-h.release:
+	case <-timer.C:
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	h.releaseLocked()
+	if a.current == h {
+		a.current = nil
+	}
+	if len(h.annotations) > 0 {
+		payload.Annotations = append(payload.Annotations, h.annotations...)
+	}
+	return payload
+}
+
+// annotate applies a key entered on the secondary input to the held scan
+func (a *annotator) annotate(key string) error {
+	key = strings.TrimSpace(key)
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.current == nil || a.current.released {
+		return fmt.Errorf("no scan is waiting for annotations")
+	}
+	if key == a.config.ReleaseKey && key != "" {
+		a.current.releaseLocked()
+		return nil
+	}
+	code, ok := a.config.Codes[key]
+	if !ok {
+		return fmt.Errorf("unknown annotation key %q", key)
+	}
+	for _, existing := range a.current.annotations {
+		if existing == code {
+			return nil
+		}
+	}
+	a.current.annotations = append(a.current.annotations, code)
+	logger.Infof("Annotated scan %q from %s with %s", a.current.payload.ItemID, a.current.payload.DeviceType, code)
+	return nil
+}
+
+// release posts the held scan at once
+func (a *annotator) release() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.current != nil {
+		a.current.releaseLocked()
+	}
+}
+
+// held returns a copy of the scan waiting for annotations, or nil
+func (a *annotator) held() *heldScan {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.current == nil || a.current.released {
+		return nil
+	}
+	return &heldScan{payload: a.current.payload, annotations: append([]string{}, a.current.annotations...)}
+}
+
+// annotationCode is a key and the annotation it attaches, for the form
+type annotationCode struct {
+	Key, Code string
+}
+
+// codes returns the configured codes in key order
+func (a *annotator) codes() []annotationCode {
+	var codes []annotationCode
+	for key, code := range a.config.Codes {
+		codes = append(codes, annotationCode{Key: key, Code: code})
+	}
+	sort.Slice(codes, func(i, j int) bool { return codes[i].Key < codes[j].Key })
+	return codes
+}
+
+var annotationForm = template.Must(template.New("annotate").Parse(`<!DOCTYPE html>
+<html><head><meta name="viewport" content="width=device-width, initial-scale=1"><meta http-equiv="refresh" content="1">
+<title>Annotate scan</title>
+<style>body{font-family:sans-serif;margin:1em}button{font-size:1.5em;margin:.3em;padding:.6em 1em;min-width:6em}</style></head>
+<body>
+{{if .Held}}<h1>{{.Held.ItemID}}</h1><p>{{.Held.DeviceType}}{{range .Held.Annotations}} &middot; <b>{{.}}</b>{{end}}</p>
+<form method="post">{{range .Codes}}<button name="key" value="{{.Key}}">{{.Code}}</button>{{end}}
+<button name="release" value="1">Send now</button></form>
+{{else}}<h1>Waiting for a scan</h1>{{end}}
+</body></html>
+`))
+
+// ServeHTTP serves the touchscreen form on GET and applies a key on POST
+func (a *annotator) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		view := struct {
+			Held  *Payload
+			Codes []annotationCode
+		}{Codes: a.codes()}
+		if held := a.held(); held != nil {
+			view.Held = &held.payload
+			view.Held.Annotations = held.annotations
+		}
+		annotationForm.Execute(w, view)
+	case http.MethodPost:
+		if r.FormValue("release") != "" {
+			a.release()
+		} else if err := a.annotate(r.FormValue("key")); err != nil {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		http.Redirect(w, r, r.URL.Path, http.StatusSeeOther)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// startAnnotations creates the annotator
+func startAnnotations(config *Config) error {
+	if err := config.Annotations.validate(); err != nil {
+		return err
+	}
+	annotations = newAnnotator(config.Annotations)
+	return nil
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAnnotationConfig_Validate(t *testing.T) {
+	assert.Error(t, AnnotationConfig{}.validate())
+	assert.Error(t, AnnotationConfig{Codes: map[string]string{"1": "damaged"}, ReleaseKey: "1"}.validate())
+	assert.NoError(t, AnnotationConfig{Codes: map[string]string{"1": "damaged"}, ReleaseKey: "0"}.validate())
+}
+
+// holdAsync holds a payload in the background and waits until it is the held scan
+func holdAsync(t *testing.T, a *annotator, payload Payload) <-chan Payload {
+	done := make(chan Payload, 1)
+	go func() { done <- a.hold(payload) }()
+	assert.Eventually(t, func() bool {
+		held := a.held()
+		return held != nil && held.payload.ItemID == payload.ItemID
+	}, time.Second, time.Millisecond)
+	return done
+}
+
+func TestAnnotator_Hold(t *testing.T) {
+	a := newAnnotator(AnnotationConfig{HoldSeconds: 60, Input: "scanner1", Codes: map[string]string{"1": "damaged", "2": "relabeled"}, ReleaseKey: "0"})
+	assert.True(t, a.consumes(Payload{ItemID: "1", DeviceType: "scanner1"}))
+	assert.False(t, a.consumes(Payload{ItemID: "1", DeviceType: "scanner0"}))
+	assert.Error(t, a.annotate("1"), "nothing is held")
+
+	first := holdAsync(t, a, Payload{ItemID: "A"})
+	assert.NoError(t, a.annotate("1\r\n"))
+	assert.NoError(t, a.annotate("1"))
+	assert.Error(t, a.annotate("9"))
+
+	// a newer scan takes over, so the first one goes out with what it has
+	second := holdAsync(t, a, Payload{ItemID: "B"})
+	assert.Equal(t, Payload{ItemID: "A", Annotations: []string{"damaged"}}, <-first)
+	assert.NoError(t, a.annotate("2"))
+	assert.NoError(t, a.annotate("0"))
+	assert.Equal(t, Payload{ItemID: "B", Annotations: []string{"relabeled"}}, <-second)
+	assert.Nil(t, a.held())
+
+	var none *annotator
+	assert.Equal(t, Payload{ItemID: "C"}, none.hold(Payload{ItemID: "C"}))
+	assert.False(t, none.consumes(Payload{DeviceType: "scanner1"}))
+}
+
+func TestAnnotator_HoldWindow(t *testing.T) {
+	a := newAnnotator(AnnotationConfig{HoldSeconds: 1, Codes: map[string]string{"1": "damaged"}})
+	start := time.Now()
+	assert.Equal(t, Payload{ItemID: "A"}, a.hold(Payload{ItemID: "A"}))
+	assert.GreaterOrEqual(t, time.Since(start), time.Second)
+}
+
+func TestAdminAnnotate(t *testing.T) {
+	old := annotations
+	defer func() { annotations = old }()
+	annotations = nil
+	config := &Config{}
+	server := httptest.NewServer(adminMux(config))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/annotate")
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	annotations = newAnnotator(AnnotationConfig{HoldSeconds: 60, Codes: map[string]string{"1": "damaged"}})
+	held := holdAsync(t, annotations, Payload{ItemID: "A"})
+	resp, err = http.Get(server.URL + "/annotate")
+	assert.NoError(t, err)
+	page, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	assert.Contains(t, string(page), "<h1>A</h1>")
+	assert.Contains(t, string(page), `value="1">damaged</button>`)
+
+	resp, err = http.PostForm(server.URL+"/annotate", url.Values{"key": {"1"}})
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	resp, err = http.PostForm(server.URL+"/annotate", url.Values{"release": {"1"}})
+	assert.NoError(t, err)
+	assert.Equal(t, Payload{ItemID: "A", Annotations: []string{"damaged"}}, <-held)
+
+	config.Admin.ReadOnly = true
+	resp, err = http.PostForm(server.URL+"/annotate", url.Values{"key": {"1"}})
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+}
+
+func TestDispatchPayload_ValidatesAfterHold(t *testing.T) {
+	useTempQueue(t)
+	oldSchema, oldAnnotations, oldTracer := payloadSchema, annotations, tracer
+	defer func() { payloadSchema, annotations, tracer = oldSchema, oldAnnotations, oldTracer }()
+	payloadSchema = &jsonSchema{}
+	annotations = newAnnotator(AnnotationConfig{HoldSeconds: 60, Codes: map[string]string{"1": "damaged"}})
+	var err error
+	tracer, err = newScanTracer(TraceConfig{Enabled: true})
+	assert.NoError(t, err)
+	var buf bytes.Buffer
+	tracer.log.SetOutput(&buf)
+	tracer.log.SetFormatter(&logrus.JSONFormatter{})
+
+	done := make(chan struct{})
+	go func() {
+		dispatchPayload(&Config{}, Payload{ItemID: "A", DeviceType: "scanner0"})
+		close(done)
+	}()
+	assert.Eventually(t, func() bool { return annotations.held() != nil }, time.Second, time.Millisecond)
+	annotations.release()
+	<-done
+
+	trace := buf.String()
+	held, validated := strings.Index(trace, `"step":"held for annotations"`), strings.Index(trace, `"step":"validated"`)
+	assert.True(t, held >= 0 && validated > held, "the scan is validated only after the hold: %s", trace)
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"bytes"
+	"sync/atomic"
+	"time"
+
+	"github.com/zachthieme/scanandpost/scan"
+)
+
+// BatchingConfig represents adaptive batching of posts to the primary API.
+// Batches are posted as an array to Endpoint, which defaults to apiEndpoint.
+type BatchingConfig struct {
+	Enabled        bool   `json:"enabled"`
+	Endpoint       string `json:"endpoint"`
+	MaxBatchSize   int    `json:"maxBatchSize"`
+	MaxFlushMillis int    `json:"maxFlushMillis"`
+	// FastMillis is the time to first byte at or below which the API counts as fast
+	FastMillis int `json:"fastMillis"`
+	// Encoding is "json" (default) or "cbor"
+	Encoding string `json:"encoding"`
+}
+
+func (b BatchingConfig) withDefaults(apiEndpoint string) BatchingConfig {
+	if b.Endpoint == "" {
+		b.Endpoint = apiEndpoint
+	}
+	if b.MaxBatchSize <= 0 {
+		b.MaxBatchSize = 50
+	}
+	if b.MaxFlushMillis <= 0 {
+		b.MaxFlushMillis = 2000
+	}
+	if b.FastMillis <= 0 {
+		b.FastMillis = 200
+	}
+	return b
+}
+
+// batcher posts immediately while the API is fast and idle, and batches
+// increasingly aggressively as latency rises or a backlog forms
+type batcher struct {
+	config   BatchingConfig
+	in       chan Payload
+	flushCh  chan chan struct{}
+	inFlight int32
+	// latency reports the recent time to first byte of the API
+	latency func() time.Duration
+}
+
+var apiBatcher *batcher
+
+func newBatcher(config BatchingConfig) *batcher {
+	return &batcher{
+		config:  config,
+		in:      make(chan Payload, config.MaxBatchSize*4),
+		flushCh: make(chan chan struct{}),
+		latency: metricsFor("api").recentTTFB,
+	}
+}
+
+// add queues a payload for the next batch
+func (b *batcher) add(payload Payload) {
+	b.in <- payload
+}
+
+// targetSize is the batch size to wait for under current conditions
+func (b *batcher) targetSize() int {
+	fast := time.Duration(b.config.FastMillis) * time.Millisecond
+	latency := b.latency()
+	if len(b.in) > 0 {
+		// a backlog is forming, so send as much as allowed in each request
+		return b.config.MaxBatchSize
+	}
+	if latency <= fast && atomic.LoadInt32(&b.inFlight) == 0 {
+		return 1
+	}
+	size := int(latency/fast) + 1
+	if size > b.config.MaxBatchSize {
+		size = b.config.MaxBatchSize
+	}
+	return size
+}
+
+// flushInterval is how long a partial batch may wait under current conditions
+func (b *batcher) flushInterval() time.Duration {
+	interval := b.latency()
+	if max := time.Duration(b.config.MaxFlushMillis) * time.Millisecond; interval > max || interval <= 0 {
+		interval = max
+	}
+	return interval
+}
+
+// run collects payloads into batches and posts them
+func (b *batcher) run() {
+	var batch []Payload
+	var timer <-chan time.Time
+	flush := func() {
+		atomic.AddInt32(&b.inFlight, 1)
+		go b.post(batch)
+		batch, timer = nil, nil
+	}
+	for {
+		select {
+		case payload := <-b.in:
+			batch = append(batch, payload)
+			if len(batch) >= b.targetSize() {
+				flush()
+			} else if timer == nil {
+				timer = time.After(b.flushInterval())
+			}
+		case <-timer:
+			flush()
+		case done := <-b.flushCh:
+			for len(b.in) > 0 {
+				batch = append(batch, <-b.in)
+			}
+			if len(batch) > 0 {
+				atomic.AddInt32(&b.inFlight, 1)
+				b.post(batch)
+				batch, timer = nil, nil
+			}
+			close(done)
+		}
+	}
+}
+
+// flush posts everything waiting for a batch, waiting at most timeout
+func (b *batcher) flush(timeout time.Duration) {
+	done := make(chan struct{})
+	select {
+	case b.flushCh <- done:
+	case <-time.After(timeout):
+		return
+	}
+	select {
+	case <-done:
+	case <-time.After(timeout):
+	}
+}
+
+// post sends one batch to the API, saving every payload for replay on failure
+func (b *batcher) post(batch []Payload) {
+	defer atomic.AddInt32(&b.inFlight, -1)
+	body, contentType, err := scan.Marshal(b.config.Encoding, batch)
+	if err != nil {
+		logger.Errorf("Error marshaling batch: %v", err)
+		for _, payload := range batch {
+			logFailure(payload)
+		}
+		return
+	}
+
+	resp, err := httpPost(b.config.Endpoint, contentType, bytes.NewBuffer(body))
+	statusCode := 0
+	var respBody []byte
+	if resp != nil {
+		statusCode = resp.StatusCode
+		respBody = readResponseBody(resp)
+	}
+	if err != nil || !scan.Accepted(statusCode, false) {
+		bus.postFailed.publish(PostFailed{Payloads: batch, StatusCode: statusCode})
+		for _, payload := range batch {
+			logFailure(payload)
+		}
+		logger.Errorf("Error posting batch of %d payloads: %v, response code: %v", len(batch), err, statusCode)
+		return
+	}
+	bus.postSucceeded.publish(PostSucceeded{Payloads: batch, StatusCode: statusCode, Response: respBody, Batch: true})
+	logger.Infof("Successfully posted batch of %d payloads", len(batch))
+}
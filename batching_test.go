@@ -0,0 +1,58 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBatcher_TargetSize(t *testing.T) {
+	b := newBatcher(BatchingConfig{MaxBatchSize: 10, MaxFlushMillis: 1000, FastMillis: 100})
+	latency := 50 * time.Millisecond
+	b.latency = func() time.Duration { return latency }
+
+	// fast and idle: post immediately
+	assert.Equal(t, 1, b.targetSize())
+	assert.Equal(t, 50*time.Millisecond, b.flushInterval())
+
+	// slow endpoint: batch in proportion to latency, bounded by the limits
+	latency = 450 * time.Millisecond
+	assert.Equal(t, 5, b.targetSize())
+	latency = 5 * time.Second
+	assert.Equal(t, 10, b.targetSize())
+	assert.Equal(t, time.Second, b.flushInterval())
+
+	// backlog: send full batches
+	latency = 50 * time.Millisecond
+	b.in <- Payload{ItemID: "1"}
+	assert.Equal(t, 10, b.targetSize())
+}
+
+func TestBatcher_PostsBatchAsArray(t *testing.T) {
+	posted := make(chan []Payload, 1)
+	oldPost := httpPost
+	defer func() { httpPost = oldPost }()
+	httpPost = func(url, contentType string, body io.Reader) (*http.Response, error) {
+		var batch []Payload
+		json.NewDecoder(body).Decode(&batch)
+		posted <- batch
+		return &http.Response{StatusCode: http.StatusOK}, nil
+	}
+
+	b := newBatcher(BatchingConfig{Endpoint: "http://example.com/batch", MaxBatchSize: 2, MaxFlushMillis: 5000, FastMillis: 100})
+	b.latency = func() time.Duration { return time.Second }
+	go b.run()
+	b.add(Payload{ItemID: "1", DeviceType: "scanner0"})
+	b.add(Payload{ItemID: "2", DeviceType: "scanner0"})
+
+	select {
+	case batch := <-posted:
+		assert.Equal(t, []Payload{{ItemID: "1", DeviceType: "scanner0"}, {ItemID: "2", DeviceType: "scanner0"}}, batch)
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for batch")
+	}
+}
@@ -0,0 +1,304 @@
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CertEnrollmentConfig represents automatic enrollment and renewal of the
+// station's mTLS client certificate over EST (RFC 7030)
+type CertEnrollmentConfig struct {
+	// Server is the EST base URL, e.g. https://est.example.com/.well-known/est or .../est/<label>
+	Server string `json:"server"`
+	// CAFile is a PEM bundle trusted for the EST server instead of the system roots
+	CAFile string `json:"caFile"`
+	// Username and Password authenticate the first enrollment with HTTP Basic.
+	// Without them, the API token stored by enroll is sent as a bearer token.
+	Username string `json:"username"`
+	Password string `json:"password"`
+	// CommonName defaults to the station ID, or the hostname before enrollment
+	CommonName string `json:"commonName"`
+	// RenewBeforeDays renews the certificate this long before it expires (default 30)
+	RenewBeforeDays int `json:"renewBeforeDays"`
+	// CheckHours is how often expiry is checked (default 12)
+	CheckHours int `json:"checkHours"`
+}
+
+const (
+	enrolledCertFile  = "client.crt"
+	enrolledKeySecret = "client.key"
+)
+
+var (
+	oidSignedData = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 2}
+	// errEnrollmentPending is returned while the EST server waits for manual approval
+	errEnrollmentPending = errors.New("enrollment pending approval")
+)
+
+// certManager holds the enrolled client certificate and renews it. A nil manager has no certificate.
+type certManager struct {
+	config    CertEnrollmentConfig
+	stationID string
+	mu        sync.Mutex
+	cert      *tls.Certificate
+	now       func() time.Time
+}
+
+// enrolledCert is the certificate manager of the station, when certificate enrollment is configured
+var enrolledCert *certManager
+
+func newCertManager(config CertEnrollmentConfig, stationID string) *certManager {
+	if config.RenewBeforeDays <= 0 {
+		config.RenewBeforeDays = 30
+	}
+	if config.CheckHours <= 0 {
+		config.CheckHours = 12
+	}
+	return &certManager{config: config, stationID: stationID, now: time.Now}
+}
+
+// current returns the enrolled certificate for a TLS handshake, or an empty
+// certificate when there is none yet so the server can reject the connection
+func (m *certManager) current() *tls.Certificate {
+	if m == nil {
+		return &tls.Certificate{}
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.cert == nil {
+		return &tls.Certificate{}
+	}
+	return m.cert
+}
+
+// load reads the certificate stored by a previous enrollment
+func (m *certManager) load() error {
+	certPEM, err := os.ReadFile(secretPath(enrolledCertFile))
+	if err != nil {
+		return err
+	}
+	keyPEM, err := loadSecret(enrolledKeySecret)
+	if err != nil {
+		return err
+	}
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.cert = &cert
+	return nil
+}
+
+// needsRenewal reports whether there is no certificate or it expires within RenewBeforeDays
+func (m *certManager) needsRenewal() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.cert == nil || len(m.cert.Certificate) == 0 {
+		return true
+	}
+	leaf, err := x509.ParseCertificate(m.cert.Certificate[0])
+	if err != nil {
+		return true
+	}
+	return m.now().Add(time.Duration(m.config.RenewBeforeDays) * 24 * time.Hour).After(leaf.NotAfter)
+}
+
+// renew enrolls with a new key, using simplereenroll over mTLS when a
+// certificate exists and simpleenroll with the configured credentials otherwise
+func (m *certManager) renew() error {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	existing := m.cert
+	m.mu.Unlock()
+
+	subject := pkix.Name{CommonName: m.commonName()}
+	operation := "simpleenroll"
+	if existing != nil && len(existing.Certificate) > 0 {
+		// RFC 7030 requires the subject of a re-enrollment to match the current certificate
+		if leaf, err := x509.ParseCertificate(existing.Certificate[0]); err == nil {
+			subject = leaf.Subject
+			operation = "simplereenroll"
+		}
+	}
+	csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{Subject: subject}, key)
+	if err != nil {
+		return err
+	}
+	certs, err := m.post(operation, csr, existing)
+	if err != nil {
+		return err
+	}
+	chain, err := orderChain(certs, key.Public())
+	if err != nil {
+		return err
+	}
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return err
+	}
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	var certPEM bytes.Buffer
+	for _, cert := range chain {
+		pem.Encode(&certPEM, &pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})
+	}
+	cert, err := tls.X509KeyPair(certPEM.Bytes(), keyPEM)
+	if err != nil {
+		return err
+	}
+	if err := saveSecret(enrolledKeySecret, keyPEM); err != nil {
+		return err
+	}
+	if err := writeFileAtomicMode(secretPath(enrolledCertFile), certPEM.Bytes(), 0644); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	m.cert = &cert
+	m.mu.Unlock()
+	logger.Infof("Enrolled client certificate for %s, valid until %s", chain[0].Subject.CommonName, chain[0].NotAfter.Format(time.RFC3339))
+	return nil
+}
+
+func (m *certManager) commonName() string {
+	if m.config.CommonName != "" {
+		return m.config.CommonName
+	}
+	if m.stationID != "" {
+		return m.stationID
+	}
+	hostname, _ := os.Hostname()
+	return hostname
+}
+
+// post sends a CSR to the EST server and returns the issued certificates
+func (m *certManager) post(operation string, csr []byte, existing *tls.Certificate) ([]*x509.Certificate, error) {
+	tlsConfig, err := TLSConfig{CAFile: m.config.CAFile}.build()
+	if err != nil {
+		return nil, err
+	}
+	if existing != nil {
+		tlsConfig.Certificates = []tls.Certificate{*existing}
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	client := &http.Client{Transport: transport, Timeout: 60 * time.Second}
+
+	url := strings.TrimSuffix(m.config.Server, "/") + "/" + operation
+	req, err := http.NewRequest(http.MethodPost, url, strings.NewReader(base64.StdEncoding.EncodeToString(csr)))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/pkcs10")
+	req.Header.Set("Content-Transfer-Encoding", "base64")
+	if operation == "simpleenroll" {
+		if m.config.Username != "" {
+			req.SetBasicAuth(m.config.Username, m.config.Password)
+		} else if token, err := loadSecret(apiTokenSecret); err == nil {
+			req.Header.Set("Authorization", "Bearer "+string(token))
+		}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusAccepted {
+		return nil, fmt.Errorf("%w, retry after %s", errEnrollmentPending, resp.Header.Get("Retry-After"))
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s rejected with response code: %d", operation, resp.StatusCode)
+	}
+	der, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(string(body)), ""))
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s response: %v", operation, err)
+	}
+	return parseCertsOnly(der)
+}
+
+// parseCertsOnly extracts the certificates of a PKCS#7 certs-only message
+func parseCertsOnly(der []byte) ([]*x509.Certificate, error) {
+	var info struct {
+		ContentType asn1.ObjectIdentifier
+		Content     asn1.RawValue `asn1:"tag:0"`
+	}
+	if _, err := asn1.Unmarshal(der, &info); err != nil {
+		return nil, fmt.Errorf("invalid PKCS#7: %v", err)
+	}
+	if !info.ContentType.Equal(oidSignedData) {
+		return nil, fmt.Errorf("invalid PKCS#7: content type %v is not signed data", info.ContentType)
+	}
+	var signed struct {
+		Version          int
+		DigestAlgorithms asn1.RawValue
+		ContentInfo      asn1.RawValue
+		Certificates     asn1.RawValue `asn1:"optional,tag:0"`
+		CRLs             asn1.RawValue `asn1:"optional,tag:1"`
+		SignerInfos      asn1.RawValue
+	}
+	if _, err := asn1.Unmarshal(info.Content.Bytes, &signed); err != nil {
+		return nil, fmt.Errorf("invalid PKCS#7 signed data: %v", err)
+	}
+	certs, err := x509.ParseCertificates(signed.Certificates.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	if len(certs) == 0 {
+		return nil, errors.New("PKCS#7 response holds no certificates")
+	}
+	return certs, nil
+}
+
+// orderChain puts the certificate for key first, followed by the rest
+func orderChain(certs []*x509.Certificate, key crypto.PublicKey) ([]*x509.Certificate, error) {
+	for i, cert := range certs {
+		if pub, ok := cert.PublicKey.(interface{ Equal(crypto.PublicKey) bool }); ok && pub.Equal(key) {
+			chain := []*x509.Certificate{cert}
+			chain = append(chain, certs[:i]...)
+			return append(chain, certs[i+1:]...), nil
+		}
+	}
+	return nil, errors.New("issued certificate does not match the requested key")
+}
+
+// maintain enrolls when there is no certificate yet and renews it before it
+// expires. Failures are retried every hour until the next scheduled check.
+func (m *certManager) maintain() {
+	for {
+		wait := time.Duration(m.config.CheckHours) * time.Hour
+		if m.needsRenewal() {
+			if err := m.renew(); err != nil {
+				logger.Errorf("Error enrolling client certificate: %v", err)
+				if wait > time.Hour {
+					wait = time.Hour
+				}
+			}
+		}
+		time.Sleep(wait)
+	}
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// certsOnly wraps certificates in a PKCS#7 certs-only message as EST servers return them
+func certsOnly(t *testing.T, certs ...*x509.Certificate) []byte {
+	var raw []byte
+	for _, cert := range certs {
+		raw = append(raw, cert.Raw...)
+	}
+	signed, err := asn1.Marshal(struct {
+		Version          int
+		DigestAlgorithms asn1.RawValue
+		ContentInfo      struct{ ContentType asn1.ObjectIdentifier }
+		Certificates     asn1.RawValue
+		SignerInfos      asn1.RawValue
+	}{
+		Version:          1,
+		DigestAlgorithms: asn1.RawValue{Tag: asn1.TagSet, IsCompound: true},
+		ContentInfo:      struct{ ContentType asn1.ObjectIdentifier }{asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 1}},
+		Certificates:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: raw},
+		SignerInfos:      asn1.RawValue{Tag: asn1.TagSet, IsCompound: true},
+	})
+	assert.NoError(t, err)
+	der, err := asn1.Marshal(struct {
+		ContentType asn1.ObjectIdentifier
+		Content     asn1.RawValue
+	}{oidSignedData, asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: signed}})
+	assert.NoError(t, err)
+	return der
+}
+
+// newESTServer returns an EST server that signs CSRs with a test CA for validFor
+func newESTServer(t *testing.T, validFor time.Duration) (*httptest.Server, *[]string) {
+	caKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	caTemplate := &x509.Certificate{SerialNumber: big.NewInt(1), Subject: pkix.Name{CommonName: "Test CA"}, NotBefore: time.Now().Add(-time.Hour), NotAfter: time.Now().Add(24 * time.Hour), IsCA: true, BasicConstraintsValid: true, KeyUsage: x509.KeyUsageCertSign}
+	caDER, _ := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, caKey.Public(), caKey)
+	ca, _ := x509.ParseCertificate(caDER)
+
+	var operations []string
+	serial := int64(1)
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		operation := r.URL.Path[strings.LastIndex(r.URL.Path, "/")+1:]
+		operations = append(operations, operation)
+		switch operation {
+		case "simpleenroll":
+			if user, password, _ := r.BasicAuth(); user != "station" || password != "secret" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+		case "simplereenroll":
+			if len(r.TLS.PeerCertificates) == 0 {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+		}
+		body, _ := io.ReadAll(r.Body)
+		der, _ := base64.StdEncoding.DecodeString(string(body))
+		csr, err := x509.ParseCertificateRequest(der)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		serial++
+		template := &x509.Certificate{SerialNumber: big.NewInt(serial), Subject: csr.Subject, NotBefore: time.Now().Add(-time.Minute), NotAfter: time.Now().Add(validFor), ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}}
+		leafDER, _ := x509.CreateCertificate(rand.Reader, template, ca, csr.PublicKey, caKey)
+		leaf, _ := x509.ParseCertificate(leafDER)
+		w.Header().Set("Content-Type", "application/pkcs7-mime; smime-type=certs-only")
+		w.Write([]byte(base64.StdEncoding.EncodeToString(certsOnly(t, ca, leaf))))
+	}))
+	server.TLS = &tls.Config{ClientAuth: tls.RequestClientCert}
+	server.StartTLS()
+	return server, &operations
+}
+
+func TestCertManager_EnrollAndRenew(t *testing.T) {
+	server, operations := newESTServer(t, 10*24*time.Hour)
+	defer server.Close()
+	useTempStorage(t)
+
+	config := CertEnrollmentConfig{Server: server.URL + "/.well-known/est", CAFile: writeServerCA(t, server), Username: "station", Password: "wrong"}
+	manager := newCertManager(config, "st-42")
+	assert.True(t, manager.needsRenewal())
+	assert.EqualError(t, manager.renew(), "simpleenroll rejected with response code: 401")
+
+	manager.config.Password = "secret"
+	assert.NoError(t, manager.renew())
+	leaf, err := x509.ParseCertificate(manager.current().Certificate[0])
+	assert.NoError(t, err)
+	assert.Equal(t, "st-42", leaf.Subject.CommonName)
+	assert.Len(t, manager.current().Certificate, 2)
+
+	// a certificate inside the renewal window is re-enrolled over mTLS with the same subject
+	assert.True(t, manager.needsRenewal())
+	assert.NoError(t, manager.renew())
+	assert.Equal(t, []string{"simpleenroll", "simpleenroll", "simplereenroll"}, *operations)
+	renewed, _ := x509.ParseCertificate(manager.current().Certificate[0])
+	assert.Equal(t, "st-42", renewed.Subject.CommonName)
+	assert.NotEqual(t, leaf.SerialNumber, renewed.SerialNumber)
+
+	// the renewed certificate survives a restart
+	reloaded := newCertManager(config, "st-42")
+	assert.NoError(t, reloaded.load())
+	assert.Equal(t, manager.current().Certificate, reloaded.current().Certificate)
+	reloaded.config.RenewBeforeDays = 5
+	assert.False(t, reloaded.needsRenewal())
+}
+
+func TestParseCertsOnly_Invalid(t *testing.T) {
+	_, err := parseCertsOnly([]byte("not asn1"))
+	assert.Error(t, err)
+	data, _ := asn1.Marshal(struct {
+		ContentType asn1.ObjectIdentifier
+		Content     asn1.RawValue
+	}{asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 1}, asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: []byte{4, 1, 'x'}}})
+	_, err = parseCertsOnly(data)
+	assert.ErrorContains(t, err, "is not signed data")
+}
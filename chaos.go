@@ -0,0 +1,135 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// ChaosConfig represents failure injection for staging environments. Never enable it in production.
+type ChaosConfig struct {
+	Enabled bool `json:"enabled"`
+	// DropPostPercent fails this share of posts to the API before they are sent
+	DropPostPercent float64 `json:"dropPostPercent"`
+	// LatencyMillis plus up to LatencyJitterMillis is added to every post to the API
+	LatencyMillis       int `json:"latencyMillis"`
+	LatencyJitterMillis int `json:"latencyJitterMillis"`
+	// CorruptPercent garbles the item ID of this share of scans
+	CorruptPercent float64 `json:"corruptPercent"`
+	// DisconnectEverySeconds unplugs a random scanner for DisconnectSeconds at this interval
+	DisconnectEverySeconds int `json:"disconnectEverySeconds"`
+	DisconnectSeconds      int `json:"disconnectSeconds"`
+	// Seed makes a run reproducible; 0 seeds from the clock
+	Seed int64 `json:"seed"`
+}
+
+var errChaosDrop = errors.New("chaos: post dropped")
+
+// chaosMonkey injects the configured failures. A nil monkey injects nothing.
+type chaosMonkey struct {
+	config       ChaosConfig
+	mu           sync.Mutex
+	rand         *rand.Rand
+	disconnected map[int]bool
+	sleep        func(time.Duration)
+}
+
+var chaos *chaosMonkey
+
+func newChaosMonkey(config ChaosConfig) *chaosMonkey {
+	seed := config.Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	return &chaosMonkey{config: config, rand: rand.New(rand.NewSource(seed)), disconnected: map[int]bool{}, sleep: time.Sleep}
+}
+
+// chance reports true for percent out of every hundred calls on average
+func (c *chaosMonkey) chance(percent float64) bool {
+	if percent <= 0 {
+		return false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.rand.Float64()*100 < percent
+}
+
+func (c *chaosMonkey) intn(n int) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.rand.Intn(n)
+}
+
+// wrapPost adds latency to and drops posts made through post
+func (c *chaosMonkey) wrapPost(post func(string, string, io.Reader) (*http.Response, error)) func(string, string, io.Reader) (*http.Response, error) {
+	return func(url, contentType string, body io.Reader) (*http.Response, error) {
+		delay := time.Duration(c.config.LatencyMillis) * time.Millisecond
+		if c.config.LatencyJitterMillis > 0 {
+			delay += time.Duration(c.intn(c.config.LatencyJitterMillis+1)) * time.Millisecond
+		}
+		if delay > 0 {
+			c.sleep(delay)
+		}
+		if c.chance(c.config.DropPostPercent) {
+			logger.Warnf("Chaos: dropping post to %s", url)
+			return nil, errChaosDrop
+		}
+		return post(url, contentType, body)
+	}
+}
+
+// corrupt garbles the item ID of a share of payloads
+func (c *chaosMonkey) corrupt(payload Payload) Payload {
+	if c == nil || payload.ItemID == "" || !c.chance(c.config.CorruptPercent) {
+		return payload
+	}
+	id := []byte(payload.ItemID)
+	id[c.intn(len(id))] = 0x00
+	for i, j := 0, len(id)-1; i < j; i, j = i+1, j-1 {
+		id[i], id[j] = id[j], id[i]
+	}
+	logger.Warnf("Chaos: corrupting item ID %q", payload.ItemID)
+	payload.ItemID = string(id)
+	return payload
+}
+
+// isDisconnected reports whether the scanner is currently unplugged by chaos
+func (c *chaosMonkey) isDisconnected(deviceID int) bool {
+	if c == nil {
+		return false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.disconnected[deviceID]
+}
+
+// disconnectDevices unplugs a random scanner at every interval until the process exits.
+// Scans from an unplugged scanner are discarded and it is reported as missing.
+func (c *chaosMonkey) disconnectDevices(scanners int) {
+	if scanners <= 0 || c.config.DisconnectEverySeconds <= 0 {
+		return
+	}
+	duration := time.Duration(c.config.DisconnectSeconds) * time.Second
+	if duration <= 0 {
+		duration = 30 * time.Second
+	}
+	for range time.Tick(time.Duration(c.config.DisconnectEverySeconds) * time.Second) {
+		deviceID := c.intn(scanners)
+		logger.Warnf("Chaos: disconnecting %s for %s", scannerName(deviceID), duration)
+		c.mu.Lock()
+		c.disconnected[deviceID] = true
+		c.mu.Unlock()
+		bus.deviceDetached.publish(DeviceDetached{DeviceID: deviceID, At: time.Now()})
+
+		time.AfterFunc(duration, func() {
+			c.mu.Lock()
+			delete(c.disconnected, deviceID)
+			c.mu.Unlock()
+			bus.deviceAttached.publish(DeviceAttached{DeviceID: deviceID, At: time.Now(), Simulated: true})
+			logger.Warnf("Chaos: reconnecting %s", scannerName(deviceID))
+		})
+	}
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestChaosMonkey_DropsAndDelaysPosts(t *testing.T) {
+	c := newChaosMonkey(ChaosConfig{Enabled: true, DropPostPercent: 50, LatencyMillis: 100, LatencyJitterMillis: 50, Seed: 1})
+	var slept time.Duration
+	c.sleep = func(d time.Duration) { slept += d }
+	sent := 0
+	post := c.wrapPost(func(url, contentType string, body io.Reader) (*http.Response, error) {
+		sent++
+		return &http.Response{StatusCode: http.StatusOK}, nil
+	})
+
+	dropped := 0
+	for i := 0; i < 100; i++ {
+		if _, err := post("http://example.com", "application/json", nil); err == errChaosDrop {
+			dropped++
+		}
+	}
+	assert.Equal(t, 100, sent+dropped)
+	assert.InDelta(t, 50, dropped, 15)
+	assert.GreaterOrEqual(t, slept, 100*100*time.Millisecond)
+	assert.LessOrEqual(t, slept, 100*150*time.Millisecond)
+}
+
+func TestChaosMonkey_Corrupt(t *testing.T) {
+	c := newChaosMonkey(ChaosConfig{CorruptPercent: 100, Seed: 1})
+	payload := c.corrupt(Payload{ItemID: "12345", DeviceType: "scanner0"})
+	assert.NotEqual(t, "12345", payload.ItemID)
+	assert.Len(t, payload.ItemID, 5)
+	assert.Contains(t, payload.ItemID, "\x00")
+
+	var none *chaosMonkey
+	assert.Equal(t, "12345", none.corrupt(Payload{ItemID: "12345"}).ItemID)
+	assert.False(t, none.isDisconnected(0))
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+	"time"
+)
+
+// Actions resolved by the check-in/check-out state machine
+const (
+	actionCheckOut = "check-out"
+	actionCheckIn  = "check-in"
+)
+
+// CheckInOutConfig represents toggling assets between checked out and checked in on each scan
+type CheckInOutConfig struct {
+	Enabled bool `json:"enabled"`
+	// Pattern limits the state machine to item IDs matching the regular expression
+	Pattern string `json:"pattern"`
+	// StateFile defaults to checkinout.json in the state directory
+	StateFile string `json:"stateFile"`
+}
+
+// assetStates remembers which assets are checked out, persisted after every change
+type assetStates struct {
+	mu      sync.Mutex
+	path    string
+	pattern *regexp.Regexp
+	// out maps each checked-out asset to when it was checked out
+	out map[string]time.Time
+}
+
+var checkInOut *assetStates
+
+// loadAssetStates reads the persisted states, starting empty if there are none
+func loadAssetStates(config CheckInOutConfig) (*assetStates, error) {
+	s := &assetStates{path: config.StateFile, out: map[string]time.Time{}}
+	if s.path == "" {
+		s.path = filepath.Join(stateDir, "checkinout.json")
+	}
+	if config.Pattern != "" {
+		pattern, err := regexp.Compile(config.Pattern)
+		if err != nil {
+			return nil, err
+		}
+		s.pattern = pattern
+	}
+	data, err := os.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		return s, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &s.out); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// resolve toggles the asset and returns the action this scan performs, or ""
+// for items the state machine does not track
+func (s *assetStates) resolve(itemID string, now time.Time) string {
+	if s == nil || (s.pattern != nil && !s.pattern.MatchString(itemID)) {
+		return ""
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	action := actionCheckOut
+	if _, out := s.out[itemID]; out {
+		action = actionCheckIn
+		delete(s.out, itemID)
+	} else {
+		s.out[itemID] = now
+	}
+	s.save()
+	return action
+}
+
+// save writes the states; the caller holds the lock
+func (s *assetStates) save() {
+	data, err := json.Marshal(s.out)
+	if err == nil {
+		err = writeFileAtomic(s.path, data)
+	}
+	if err != nil {
+		logger.Errorf("Error saving check-in/check-out state to %s: %v", s.path, err)
+	}
+}
+
+// purge forgets the matching assets, as if they were checked in, returning
+// how many match. A dry run only counts them.
+func (s *assetStates) purge(match func(itemID string) bool, dryRun bool) int {
+	if s == nil {
+		return 0
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	removed := 0
+	for id := range s.out {
+		if match(id) {
+			removed++
+			if !dryRun {
+				delete(s.out, id)
+			}
+		}
+	}
+	if removed > 0 && !dryRun {
+		s.save()
+	}
+	return removed
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAssetStates_TogglesAndPersists(t *testing.T) {
+	config := CheckInOutConfig{Enabled: true, Pattern: "^TOOL", StateFile: filepath.Join(t.TempDir(), "state.json")}
+	states, err := loadAssetStates(config)
+	assert.NoError(t, err)
+	now := time.Now()
+
+	assert.Equal(t, actionCheckOut, states.resolve("TOOL1", now))
+	assert.Equal(t, actionCheckOut, states.resolve("TOOL2", now))
+	assert.Equal(t, actionCheckIn, states.resolve("TOOL1", now))
+	assert.Equal(t, "", states.resolve("BOX1", now))
+
+	reloaded, err := loadAssetStates(config)
+	assert.NoError(t, err)
+	assert.Equal(t, actionCheckIn, reloaded.resolve("TOOL2", now))
+	assert.Equal(t, actionCheckOut, reloaded.resolve("TOOL1", now))
+}
+
+func TestAssetStates_Disabled(t *testing.T) {
+	var states *assetStates
+	assert.Equal(t, "", states.resolve("TOOL1", time.Now()))
+}
@@ -0,0 +1,431 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CloseoutConfig represents the end-of-day close-out, which flushes the
+// queue, posts a signed summary of the day, rotates the command audit log and
+// writes a report for the site manager
+type CloseoutConfig struct {
+	Enabled bool `json:"enabled"`
+	// Endpoint receives the summary (default the heartbeat endpoint)
+	Endpoint string `json:"endpoint"`
+	// SigningSecret signs the summary with HMAC-SHA256, sent as X-Closeout-Signature
+	SigningSecret string `json:"signingSecret"`
+	// Barcode runs the close-out when scanned, such as a code on the site manager's badge
+	Barcode string `json:"barcode"`
+	// ReportDir receives the reports of close-outs run by barcode (default the log directory)
+	ReportDir string `json:"reportDir"`
+}
+
+func (c CloseoutConfig) withDefaults(config *Config) CloseoutConfig {
+	if c.Endpoint == "" {
+		c.Endpoint = config.Heartbeat.Endpoint
+	}
+	if c.ReportDir == "" {
+		c.ReportDir = config.Storage.withDefaults().LogDir
+	}
+	return c
+}
+
+func (c CloseoutConfig) validate(config *Config) error {
+	if c.withDefaults(config).Endpoint == "" {
+		return fmt.Errorf("an endpoint for the summary is required when no heartbeat endpoint is set")
+	}
+	if c.SigningSecret == "" {
+		return fmt.Errorf("a signing secret is required")
+	}
+	return nil
+}
+
+// CloseoutSummary is the signed summary of the day posted to the backend
+type CloseoutSummary struct {
+	Type      string    `json:"type"`
+	ID        string    `json:"id"`
+	Hostname  string    `json:"hostname"`
+	StationID string    `json:"stationId,omitempty"`
+	Group     string    `json:"group,omitempty"`
+	From      time.Time `json:"from"`
+	To        time.Time `json:"to"`
+	// Scans counts the scans received, by input
+	Scans        int            `json:"scans"`
+	ScansByInput map[string]int `json:"scansByInput"`
+	Delivered    int            `json:"delivered"`
+	// Failed counts the scans whose first post failed and were queued
+	Failed      int `json:"failed"`
+	DeadLetters int `json:"deadLetters"`
+	// StillQueued counts the scans left in the queue after the flush
+	StillQueued int `json:"stillQueued"`
+}
+
+// CloseoutResult is what a close-out did, with the report for the site manager
+type CloseoutResult struct {
+	Summary   CloseoutSummary `json:"summary"`
+	Flush     FlushResult     `json:"flush"`
+	Posted    bool            `json:"posted"`
+	PostError string          `json:"postError,omitempty"`
+	// AuditLog is where commands.audit.log was rotated to
+	AuditLog string `json:"auditLog,omitempty"`
+	// ReportFile is set when the report was written to a file
+	ReportFile string `json:"reportFile,omitempty"`
+	Report     string `json:"report"`
+}
+
+// closeoutTally counts the scans and posts since the last close-out. The
+// counts are saved to the state directory every minute and on shutdown.
+type closeoutTally struct {
+	path string
+
+	mu    sync.Mutex
+	state closeoutState
+	dirty bool
+	// running keeps a second close-out from starting while one runs
+	running sync.Mutex
+}
+
+type closeoutState struct {
+	Since        time.Time      `json:"since"`
+	ScansByInput map[string]int `json:"scansByInput"`
+	Delivered    int            `json:"delivered"`
+	Failed       int            `json:"failed"`
+}
+
+var closeouts *closeoutTally
+
+// loadCloseoutTally reads the counts kept since the last close-out
+func loadCloseoutTally(path string, now time.Time) (*closeoutTally, error) {
+	t := &closeoutTally{path: path, state: closeoutState{Since: now, ScansByInput: map[string]int{}}}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return t, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &t.state); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	if t.state.ScansByInput == nil {
+		t.state.ScansByInput = map[string]int{}
+	}
+	return t, nil
+}
+
+func (t *closeoutTally) recordScan(input string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.state.ScansByInput[input]++
+	t.dirty = true
+}
+
+func (t *closeoutTally) recordPosts(delivered, failed int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.state.Delivered += delivered
+	t.state.Failed += failed
+	t.dirty = true
+}
+
+// save writes the counts if they changed
+func (t *closeoutTally) save() {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.dirty {
+		return
+	}
+	data, err := json.MarshalIndent(t.state, "", "  ")
+	if err == nil {
+		err = writeFileAtomic(t.path, data)
+	}
+	if err != nil {
+		logger.Errorf("Error saving close-out counts to %s: %v", t.path, err)
+		return
+	}
+	t.dirty = false
+}
+
+// restart returns the counts of the period that ends now, and starts the next one
+func (t *closeoutTally) restart(now time.Time) closeoutState {
+	t.mu.Lock()
+	state := t.state
+	t.state = closeoutState{Since: now, ScansByInput: map[string]int{}}
+	t.dirty = true
+	t.mu.Unlock()
+	t.save()
+	return state
+}
+
+// consumes reports whether the scan is the close-out barcode, starting a close-out if so
+func (t *closeoutTally) consumes(config *Config, payload Payload) bool {
+	if t == nil || config.Closeout.Barcode == "" || strings.TrimSpace(payload.ItemID) != config.Closeout.Barcode {
+		return false
+	}
+	logger.Infof("Close-out barcode read on %s", payload.DeviceType)
+	go func() {
+		reportDir := config.Closeout.withDefaults(config).ReportDir
+		if _, err := closeOut(config, time.Now(), reportDir); err != nil {
+			logger.Errorf("Error running the close-out: %v", err)
+		}
+	}()
+	return true
+}
+
+// countDeadLetters counts the dead letters written from the given time on
+func countDeadLetters(from time.Time) int {
+	data, err := os.ReadFile(deadLetterFile)
+	if err != nil {
+		return 0
+	}
+	count := 0
+	for _, line := range strings.Split(string(data), "\n") {
+		if at, ok := jsonRecordTime(line); ok && !at.Before(from) {
+			count++
+		}
+	}
+	return count
+}
+
+// signCloseout computes the signature sent in X-Closeout-Signature
+func signCloseout(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// postCloseout posts the signed summary
+func postCloseout(config CloseoutConfig, summary CloseoutSummary) error {
+	body, err := json.Marshal(summary)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, config.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Closeout-Signature", signCloseout(config.SigningSecret, body))
+	resp, err := apiClient.Do(req)
+	if err != nil {
+		return err
+	}
+	readResponseBody(resp)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("response code: %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// rotateAuditLog moves commands.audit.log aside, named after the close-out
+// time, so each day's commands are kept in their own file
+func rotateAuditLog(now time.Time) (string, error) {
+	unlock := lockRecords(commandAuditFile)()
+	defer unlock()
+	if _, err := os.Stat(commandAuditFile); os.IsNotExist(err) {
+		return "", nil
+	}
+	rotated := strings.TrimSuffix(commandAuditFile, ".log") + "." + now.Format("20060102-150405") + ".log"
+	if err := os.Rename(commandAuditFile, rotated); err != nil {
+		return "", err
+	}
+	return rotated, nil
+}
+
+// rotatedAuditLogs returns the command audit logs rotated by close-outs
+func rotatedAuditLogs() []string {
+	paths, _ := filepath.Glob(strings.TrimSuffix(commandAuditFile, ".log") + ".*.log")
+	return paths
+}
+
+// closeOut flushes the queue, posts the signed summary of the period since
+// the last close-out, rotates the command audit log and writes the report,
+// to reportDir when it is set. The period ends even when posting fails, so
+// the report is the record of that day.
+func closeOut(config *Config, now time.Time, reportDir string) (CloseoutResult, error) {
+	var result CloseoutResult
+	if closeouts == nil {
+		return result, fmt.Errorf("close-out is not enabled")
+	}
+	closeouts.running.Lock()
+	defer closeouts.running.Unlock()
+	cfg := config.Closeout.withDefaults(config)
+
+	flush, err := flushAll(config, config.flushDeadline())
+	if err != nil {
+		return result, fmt.Errorf("flushing the queue: %v", err)
+	}
+	result.Flush = flush
+
+	state := closeouts.restart(now)
+	hostname, _ := os.Hostname()
+	summary := CloseoutSummary{Type: "closeout", ID: newTriggerGroup(), Hostname: hostname, StationID: config.StationID, Group: config.Group, From: state.Since, To: now, ScansByInput: state.ScansByInput, Delivered: state.Delivered, Failed: state.Failed, DeadLetters: countDeadLetters(state.Since), StillQueued: flush.Remaining}
+	for _, n := range state.ScansByInput {
+		summary.Scans += n
+	}
+	result.Summary = summary
+
+	if err := postCloseout(cfg, summary); err != nil {
+		result.PostError = err.Error()
+		logger.Errorf("Error posting the close-out summary to %s: %v", cfg.Endpoint, err)
+	} else {
+		result.Posted = true
+	}
+	if result.AuditLog, err = rotateAuditLog(now); err != nil {
+		logger.Errorf("Error rotating %s: %v", commandAuditFile, err)
+	}
+
+	result.Report = closeoutReport(result)
+	if reportDir != "" {
+		path := filepath.Join(reportDir, "closeout-"+now.Format("20060102-150405")+".txt")
+		if err := os.WriteFile(path, []byte(result.Report), 0644); err != nil {
+			logger.Errorf("Error writing the close-out report to %s: %v", path, err)
+		} else {
+			result.ReportFile = path
+		}
+	}
+	logger.Infof("Close-out %s: %d scans, %d delivered, %d failed, %d dead letters, %d still queued", summary.ID, summary.Scans, summary.Delivered, summary.Failed, summary.DeadLetters, summary.StillQueued)
+	return result, nil
+}
+
+// closeoutReport formats a close-out for the site manager
+func closeoutReport(result CloseoutResult) string {
+	s := result.Summary
+	var b strings.Builder
+	station := s.Hostname
+	if s.StationID != "" {
+		station = fmt.Sprintf("%s (%s)", s.StationID, s.Hostname)
+	}
+	fmt.Fprintf(&b, "Close-out report %s\n\n", s.ID)
+	fmt.Fprintf(&b, "Station:       %s\n", station)
+	if s.Group != "" {
+		fmt.Fprintf(&b, "Group:         %s\n", s.Group)
+	}
+	fmt.Fprintf(&b, "Period:        %s to %s\n\n", s.From.Format("2006-01-02 15:04"), s.To.Format("2006-01-02 15:04"))
+	fmt.Fprintf(&b, "Scans:         %d\n", s.Scans)
+	inputs := make([]string, 0, len(s.ScansByInput))
+	for input := range s.ScansByInput {
+		inputs = append(inputs, input)
+	}
+	sort.Strings(inputs)
+	for _, input := range inputs {
+		fmt.Fprintf(&b, "  %-12s %d\n", input, s.ScansByInput[input])
+	}
+	fmt.Fprintf(&b, "Delivered:     %d\n", s.Delivered)
+	fmt.Fprintf(&b, "Failed posts:  %d\n", s.Failed)
+	fmt.Fprintf(&b, "Dead letters:  %d\n", s.DeadLetters)
+	fmt.Fprintf(&b, "Still queued:  %d\n\n", s.StillQueued)
+	fmt.Fprintf(&b, "Flush:         %d delivered, %d dead-lettered as poison\n", result.Flush.Delivered, result.Flush.Poisoned)
+	if result.Posted {
+		fmt.Fprintf(&b, "Summary:       posted\n")
+	} else {
+		fmt.Fprintf(&b, "Summary:       NOT POSTED: %s\n", result.PostError)
+	}
+	if result.AuditLog != "" {
+		fmt.Fprintf(&b, "Audit log:     rotated to %s\n", result.AuditLog)
+	}
+	return b.String()
+}
+
+// requestCloseout asks the running service to close out through its admin
+// API, and falls back to closing out directly when the service is not reachable
+func requestCloseout(config *Config) (CloseoutResult, error) {
+	var result CloseoutResult
+	if config.Admin.Listen != "" {
+		addr := config.Admin.Listen
+		if strings.HasPrefix(addr, ":") {
+			addr = "127.0.0.1" + addr
+		}
+		client := newAdminClient(config, config.flushDeadline()+time.Minute)
+		resp, err := client.Post("http://"+addr+"/closeout", "application/json", nil)
+		if err == nil {
+			defer resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				return result, fmt.Errorf("close-out failed with response code: %d", resp.StatusCode)
+			}
+			err = json.NewDecoder(resp.Body).Decode(&result)
+			return result, err
+		}
+		logger.Warnf("Service not reachable on %s, closing out directly: %v", addr, err)
+	}
+	// a service that runs but cannot be reached still flushes and rotates the same files
+	if err := acquireInstanceLock(config.instanceName()); err != nil {
+		return result, fmt.Errorf("not closing out directly: %v", err)
+	}
+	defer instanceLock.release()
+	if err := startCloseout(config); err != nil {
+		return result, err
+	}
+	return closeOut(config, time.Now(), "")
+}
+
+// runCloseout closes out and prints the report, or writes it to --report
+func runCloseout(config *Config, args []string) error {
+	flags := flag.NewFlagSet("closeout", flag.ContinueOnError)
+	report := flags.String("report", "", "write the report to this file instead of printing it")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if !config.Closeout.Enabled {
+		return fmt.Errorf("close-out is not enabled in the config")
+	}
+	result, err := requestCloseout(config)
+	if err != nil {
+		return err
+	}
+	if *report == "" {
+		fmt.Print(result.Report)
+		return nil
+	}
+	if err := os.WriteFile(*report, []byte(result.Report), 0644); err != nil {
+		return err
+	}
+	fmt.Printf("Close-out report written to %s\n", *report)
+	return nil
+}
+
+// startCloseout loads the counts since the last close-out and keeps them up to date
+func startCloseout(config *Config) error {
+	if err := config.Closeout.validate(config); err != nil {
+		return err
+	}
+	tally, err := loadCloseoutTally(filepath.Join(stateDir, "closeout.json"), time.Now())
+	if err != nil {
+		return err
+	}
+	closeouts = tally
+	bus.scanReceived.subscribe(func(e ScanReceived) {
+		tally.recordScan(e.Payload.DeviceType)
+	})
+	bus.postSucceeded.subscribe(func(e PostSucceeded) {
+		tally.recordPosts(len(e.Payloads), 0)
+	})
+	bus.postFailed.subscribe(func(e PostFailed) {
+		// a failed replay was already counted when the scan was first queued
+		if !e.Replay {
+			tally.recordPosts(0, len(e.Payloads))
+		}
+	})
+	return nil
+}
+
+// saveCloseoutTally periodically persists the counts
+func saveCloseoutTally() {
+	for range time.Tick(time.Minute) {
+		closeouts.save()
+	}
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCloseoutConfig_Validate(t *testing.T) {
+	config := &Config{Heartbeat: HeartbeatConfig{Endpoint: "https://backend.example.com/heartbeat"}}
+	assert.NoError(t, CloseoutConfig{SigningSecret: "s"}.validate(config))
+	assert.Equal(t, "https://backend.example.com/heartbeat", CloseoutConfig{}.withDefaults(config).Endpoint)
+	assert.Error(t, CloseoutConfig{}.validate(config))
+	assert.Error(t, CloseoutConfig{SigningSecret: "s"}.validate(&Config{}))
+}
+
+func TestCloseoutTally_Persists(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "closeout.json")
+	since := time.Now().Add(-time.Hour)
+	tally, err := loadCloseoutTally(path, since)
+	assert.NoError(t, err)
+	tally.recordScan("scanner0")
+	tally.recordScan("keyboard")
+	tally.recordPosts(1, 1)
+	tally.save()
+
+	reloaded, err := loadCloseoutTally(path, time.Now())
+	assert.NoError(t, err)
+	state := reloaded.restart(time.Now())
+	assert.True(t, since.Equal(state.Since))
+	assert.Equal(t, map[string]int{"scanner0": 1, "keyboard": 1}, state.ScansByInput)
+	assert.Equal(t, 1, state.Delivered)
+	assert.Equal(t, 1, state.Failed)
+
+	// the next period starts empty
+	reloaded, err = loadCloseoutTally(path, time.Now())
+	assert.NoError(t, err)
+	assert.Empty(t, reloaded.state.ScansByInput)
+}
+
+func TestCloseOut(t *testing.T) {
+	usePurgeFiles(t)
+	oldCloseouts := closeouts
+	defer func() { closeouts = oldCloseouts }()
+	dir := t.TempDir()
+	var err error
+	closeouts, err = loadCloseoutTally(filepath.Join(dir, "closeout.json"), time.Now().Add(-time.Hour))
+	assert.NoError(t, err)
+
+	var received []byte
+	var signature string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received, _ = io.ReadAll(r.Body)
+		signature = r.Header.Get("X-Closeout-Signature")
+	}))
+	defer server.Close()
+
+	closeouts.recordScan("scanner0")
+	closeouts.recordScan("scanner0")
+	closeouts.recordPosts(2, 0)
+	deadLetter(Payload{ItemID: "1", DeviceType: "scanner0"}, "rejected")
+	writeCommandAudit(CommandAudit{Time: time.Now(), Name: "label", ItemID: "1"})
+
+	config := &Config{StationID: "dock-1", Closeout: CloseoutConfig{Enabled: true, Endpoint: server.URL, SigningSecret: "secret"}}
+	now := time.Date(2026, 3, 2, 18, 0, 0, 0, time.UTC)
+	result, err := closeOut(config, now, dir)
+	assert.NoError(t, err)
+	assert.True(t, result.Posted)
+	assert.Equal(t, 2, result.Summary.Scans)
+	assert.Equal(t, 2, result.Summary.Delivered)
+	assert.Equal(t, 1, result.Summary.DeadLetters)
+	assert.Equal(t, "dock-1", result.Summary.StationID)
+
+	// the summary is signed with the secret
+	assert.Equal(t, signCloseout("secret", received), signature)
+	var posted CloseoutSummary
+	assert.NoError(t, json.Unmarshal(received, &posted))
+	assert.Equal(t, result.Summary.ID, posted.ID)
+
+	// the audit log is rotated, and new commands go to a fresh file
+	assert.Equal(t, strings.TrimSuffix(commandAuditFile, ".log")+".20260302-180000.log", result.AuditLog)
+	_, err = os.Stat(commandAuditFile)
+	assert.True(t, os.IsNotExist(err))
+	assert.Equal(t, []string{result.AuditLog}, rotatedAuditLogs())
+
+	// the report goes to the report directory
+	assert.Equal(t, filepath.Join(dir, "closeout-20260302-180000.txt"), result.ReportFile)
+	report, err := os.ReadFile(result.ReportFile)
+	assert.NoError(t, err)
+	assert.Contains(t, string(report), "Station:       dock-1")
+	assert.Contains(t, string(report), "  scanner0     2")
+	assert.Contains(t, string(report), "Summary:       posted")
+}
+
+func TestCloseOut_PostFails(t *testing.T) {
+	usePurgeFiles(t)
+	oldCloseouts := closeouts
+	defer func() { closeouts = oldCloseouts }()
+	var err error
+	closeouts, err = loadCloseoutTally(filepath.Join(t.TempDir(), "closeout.json"), time.Now())
+	assert.NoError(t, err)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer server.Close()
+
+	config := &Config{Closeout: CloseoutConfig{Enabled: true, Endpoint: server.URL, SigningSecret: "secret"}}
+	result, err := closeOut(config, time.Now(), "")
+	assert.NoError(t, err)
+	assert.False(t, result.Posted)
+	assert.Equal(t, "response code: 401", result.PostError)
+	assert.Contains(t, result.Report, "NOT POSTED: response code: 401")
+	assert.Empty(t, result.ReportFile)
+}
+
+func TestCloseouts_Barcode(t *testing.T) {
+	tally := &closeoutTally{}
+	config := &Config{Closeout: CloseoutConfig{Barcode: "CLOSE-DAY"}}
+	assert.False(t, tally.consumes(config, Payload{ItemID: "12345"}))
+	var none *closeoutTally
+	assert.False(t, none.consumes(config, Payload{ItemID: "CLOSE-DAY"}))
+}
+
+func TestAdminMux_Closeout(t *testing.T) {
+	oldCloseouts := closeouts
+	defer func() { closeouts = oldCloseouts }()
+	closeouts = nil
+	config := &Config{}
+	server := httptest.NewServer(adminMux(config))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/closeout")
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusMethodNotAllowed, resp.StatusCode)
+
+	resp, err = http.Post(server.URL+"/closeout", "", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	closeouts = &closeoutTally{}
+	config.Admin.ReadOnly = true
+	resp, err = http.Post(server.URL+"/closeout", "", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+}
+
+func TestRequestCloseout_RefusesWhileServiceRuns(t *testing.T) {
+	oldCloseouts := closeouts
+	defer func() { closeouts = oldCloseouts }()
+	closeouts = nil
+	// a running service that cannot be reached still holds the instance lock
+	lock, err := lockInstance("test-closeout")
+	assert.NoError(t, err)
+	defer lock.release()
+
+	_, err = requestCloseout(&Config{InstanceName: "test-closeout", Closeout: CloseoutConfig{Enabled: true}})
+	assert.ErrorContains(t, err, "not closing out directly")
+	assert.Nil(t, closeouts)
+}
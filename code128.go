@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"strings"
+)
+
+// code128Patterns are the bar and space widths of each Code 128 symbol, in
+// modules, starting with a bar. 104 is Start B and 106 is Stop.
+var code128Patterns = []string{
+	"212222", "222122", "222221", "121223", "121322", "131222", "122213", "122312", "132212", "221213",
+	"221312", "231212", "112232", "122132", "122231", "113222", "123122", "123221", "223211", "221132",
+	"221231", "213212", "223112", "312131", "311222", "321122", "321221", "312212", "322112", "322211",
+	"212123", "212321", "232121", "111323", "131123", "131321", "112313", "132113", "132311", "211313",
+	"231113", "231311", "112133", "112331", "132131", "113123", "113321", "133121", "313121", "211331",
+	"231131", "213113", "213311", "213131", "311123", "311321", "331121", "312113", "312311", "332111",
+	"314111", "221411", "431111", "111224", "111422", "121124", "121421", "141122", "141221", "112214",
+	"112412", "122114", "122411", "142112", "142211", "241211", "221114", "413111", "241112", "134111",
+	"111242", "121142", "121241", "114212", "124112", "124211", "411212", "421112", "421211", "212141",
+	"214121", "412121", "111143", "111341", "131141", "114113", "114311", "411113", "411311", "113141",
+	"114131", "311141", "411131", "211412", "211214", "211232", "2331112",
+}
+
+const (
+	code128StartB = 104
+	code128Stop   = 106
+)
+
+// code128Symbols encodes text in code set B, returning the symbols from the
+// start code to the stop code
+func code128Symbols(text string) ([]int, error) {
+	symbols := []int{code128StartB}
+	checksum := code128StartB
+	for i, r := range text {
+		if r < 32 || r > 127 {
+			return nil, fmt.Errorf("%q cannot be encoded in Code 128 set B", r)
+		}
+		value := int(r) - 32
+		symbols = append(symbols, value)
+		checksum += value * (i + 1)
+	}
+	return append(symbols, checksum%103, code128Stop), nil
+}
+
+// code128SVG renders text as a Code 128 barcode with the text below it
+func code128SVG(text string) (string, error) {
+	symbols, err := code128Symbols(text)
+	if err != nil {
+		return "", err
+	}
+	const module, height, quiet = 2, 80, 10
+	var bars strings.Builder
+	x := quiet * module
+	for _, symbol := range symbols {
+		for i, width := range code128Patterns[symbol] {
+			w := int(width-'0') * module
+			if i%2 == 0 {
+				fmt.Fprintf(&bars, `<rect x="%d" y="0" width="%d" height="%d"/>`, x, w, height)
+			}
+			x += w
+		}
+	}
+	total := x + quiet*module
+	var svg strings.Builder
+	fmt.Fprintf(&svg, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d">`, total, height+24, total, height+24)
+	fmt.Fprintf(&svg, `<rect width="%d" height="%d" fill="white"/><g fill="black">%s</g>`, total, height+24, bars.String())
+	fmt.Fprintf(&svg, `<text x="%d" y="%d" font-family="monospace" font-size="16" text-anchor="middle">%s</text></svg>`, total/2, height+18, html.EscapeString(text))
+	return svg.String(), nil
+}
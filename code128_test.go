@@ -0,0 +1,44 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCode128Patterns(t *testing.T) {
+	assert.Len(t, code128Patterns, 107)
+	seen := map[string]bool{}
+	for i, pattern := range code128Patterns {
+		sum := 0
+		for _, width := range pattern {
+			sum += int(width - '0')
+		}
+		if i == code128Stop {
+			assert.Equal(t, 13, sum)
+		} else {
+			assert.Equal(t, 11, sum, "symbol %d", i)
+		}
+		assert.False(t, seen[pattern], "symbol %d repeats a pattern", i)
+		seen[pattern] = true
+	}
+}
+
+func TestCode128Symbols(t *testing.T) {
+	symbols, err := code128Symbols("A")
+	assert.NoError(t, err)
+	assert.Equal(t, []int{104, 33, 34, 106}, symbols)
+
+	_, err = code128Symbols("é")
+	assert.Error(t, err)
+}
+
+func TestCode128SVG(t *testing.T) {
+	svg, err := code128SVG("LNKB<1>")
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(svg, `<svg xmlns="http://www.w3.org/2000/svg"`))
+	assert.Contains(t, svg, "LNKB&lt;1&gt;")
+	// start, 7 characters and checksum have 3 bars each, the stop 4
+	assert.Equal(t, 9*3+4, strings.Count(svg, `<rect x=`))
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"text/template"
+	"time"
+)
+
+var commandAuditFile = "commands.audit.log"
+
+// ScanCommandConfig maps scans matching a pattern to a local command. Args are
+// text/template strings evaluated against the payload, e.g. "{{.ItemID}}".
+type ScanCommandConfig struct {
+	Name       string   `json:"name"`
+	Pattern    string   `json:"pattern"`
+	Executable string   `json:"executable"`
+	Args       []string `json:"args"`
+	// Consume stops matching scans from being posted
+	Consume bool `json:"consume"`
+	Timeout int  `json:"timeout"`
+	// Trigger sends a trigger action ("read", "stop", "disable" or "enable") instead of running an executable
+	Trigger string `json:"trigger"`
+	// TriggerScanner is the scanner the action goes to, such as "scanner1"; empty means the scanner that read the code
+	TriggerScanner string `json:"triggerScanner"`
+}
+
+// CommandAudit records a single command execution
+type CommandAudit struct {
+	Time       time.Time `json:"time"`
+	Name       string    `json:"name"`
+	ItemID     string    `json:"itemid"`
+	DeviceType string    `json:"deviceType"`
+	Executable string    `json:"executable"`
+	Args       []string  `json:"args"`
+	// Trigger is the trigger action and scanner, such as "disable scanner1"
+	Trigger  string `json:"trigger,omitempty"`
+	ExitCode int    `json:"exitCode"`
+	Error    string `json:"error,omitempty"`
+}
+
+type scanCommand struct {
+	config  ScanCommandConfig
+	pattern *regexp.Regexp
+	args    []*template.Template
+}
+
+var scanCommands []*scanCommand
+
+// loadScanCommands compiles the configured commands, rejecting executables
+// that are not on the allowlist
+func loadScanCommands(config *Config) ([]*scanCommand, error) {
+	allowed := map[string]bool{}
+	for _, exe := range config.CommandAllowlist {
+		allowed[filepath.Clean(exe)] = true
+	}
+
+	var commands []*scanCommand
+	for _, cfg := range config.Commands {
+		if cfg.Trigger != "" {
+			if err := validateTriggerCommand(cfg); err != nil {
+				return nil, err
+			}
+		} else if !filepath.IsAbs(cfg.Executable) || !allowed[filepath.Clean(cfg.Executable)] {
+			return nil, fmt.Errorf("command %s: executable %q is not an allowlisted absolute path", cfg.Name, cfg.Executable)
+		}
+		pattern, err := regexp.Compile(cfg.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("command %s: invalid pattern: %v", cfg.Name, err)
+		}
+		cmd := &scanCommand{config: cfg, pattern: pattern}
+		for _, arg := range cfg.Args {
+			tmpl, err := template.New(cfg.Name).Option("missingkey=error").Parse(arg)
+			if err != nil {
+				return nil, fmt.Errorf("command %s: invalid argument template %q: %v", cfg.Name, arg, err)
+			}
+			cmd.args = append(cmd.args, tmpl)
+		}
+		commands = append(commands, cmd)
+	}
+	return commands, nil
+}
+
+// validateTriggerCommand checks a command that sends a trigger action
+func validateTriggerCommand(cfg ScanCommandConfig) error {
+	if cfg.Executable != "" {
+		return fmt.Errorf("command %s: set either trigger or executable, not both", cfg.Name)
+	}
+	if !validTriggerAction(cfg.Trigger) {
+		return fmt.Errorf("command %s: unknown trigger action %q", cfg.Name, cfg.Trigger)
+	}
+	if _, ok := triggers[cfg.TriggerScanner]; cfg.TriggerScanner != "" && !ok {
+		return fmt.Errorf("command %s: %s has no host trigger configured", cfg.Name, cfg.TriggerScanner)
+	}
+	return nil
+}
+
+// runScanCommands executes every command whose pattern matches the payload.
+// It returns true if a matching command consumes the scan.
+func runScanCommands(payload Payload) bool {
+	consumed := false
+	for _, cmd := range scanCommands {
+		if !cmd.pattern.MatchString(payload.ItemID) {
+			continue
+		}
+		cmd.run(payload)
+		consumed = consumed || cmd.config.Consume
+	}
+	return consumed
+}
+
+func (c *scanCommand) run(payload Payload) {
+	audit := CommandAudit{
+		Time:       time.Now(),
+		Name:       c.config.Name,
+		ItemID:     payload.ItemID,
+		DeviceType: payload.DeviceType,
+		Executable: c.config.Executable,
+		ExitCode:   -1,
+	}
+	defer func() { writeCommandAudit(audit) }()
+
+	if c.config.Trigger != "" {
+		scanner := c.config.TriggerScanner
+		if scanner == "" {
+			scanner = payload.DeviceType
+		}
+		audit.Trigger = c.config.Trigger + " " + scanner
+		if err := triggerScanner(scanner, c.config.Trigger); err != nil {
+			audit.Error = err.Error()
+			return
+		}
+		audit.ExitCode = 0
+		return
+	}
+
+	for _, tmpl := range c.args {
+		var buf bytes.Buffer
+		if err := tmpl.Execute(&buf, payload); err != nil {
+			audit.Error = err.Error()
+			logger.Errorf("Error expanding arguments for command %s: %v", c.config.Name, err)
+			return
+		}
+		audit.Args = append(audit.Args, buf.String())
+	}
+
+	timeout := time.Duration(c.config.Timeout) * time.Second
+	if timeout <= 0 {
+		timeout = 30 * time.Second
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	err := exec.CommandContext(ctx, c.config.Executable, audit.Args...).Run()
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		audit.ExitCode = exitErr.ExitCode()
+	} else if err == nil {
+		audit.ExitCode = 0
+	}
+	if err != nil {
+		audit.Error = err.Error()
+		logger.Errorf("Command %s failed for %s: %v", c.config.Name, payload.ItemID, err)
+		return
+	}
+	logger.Infof("Ran command %s for %s", c.config.Name, payload.ItemID)
+}
+
+// writeCommandAudit appends an execution record to commands.audit.log
+func writeCommandAudit(audit CommandAudit) {
+	if !diskPressure.allowAudit("commandAudit") {
+		return
+	}
+	data, err := json.Marshal(audit)
+	if err != nil {
+		logger.Errorf("Error marshaling command audit: %v", err)
+		return
+	}
+	if err := appendRecord(commandAuditFile, data); err != nil {
+		logger.Errorf("Error writing to %s: %v", commandAuditFile, err)
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadScanCommands_RejectsUnlistedExecutable(t *testing.T) {
+	config := &Config{Commands: []ScanCommandConfig{{Name: "drawer", Pattern: "^DRAWER$", Executable: "/bin/true"}}}
+	_, err := loadScanCommands(config)
+	assert.Error(t, err)
+
+	config.CommandAllowlist = []string{"/bin/true"}
+	commands, err := loadScanCommands(config)
+	assert.NoError(t, err)
+	assert.Len(t, commands, 1)
+}
+
+func TestRunScanCommands(t *testing.T) {
+	sh, err := exec.LookPath("sh")
+	if err != nil {
+		t.Skip("sh not available")
+	}
+	out := filepath.Join(t.TempDir(), "out.txt")
+	config := &Config{
+		Commands: []ScanCommandConfig{{
+			Name:       "lookup",
+			Pattern:    "^SKU",
+			Executable: sh,
+			Args:       []string{"-c", `echo "$1" > ` + out, "sh", "{{.ItemID}}"},
+			Consume:    true,
+		}},
+		CommandAllowlist: []string{sh},
+	}
+	oldCommands := scanCommands
+	defer func() { scanCommands = oldCommands }()
+	scanCommands, err = loadScanCommands(config)
+	assert.NoError(t, err)
+	defer os.Remove(commandAuditFile)
+
+	assert.False(t, runScanCommands(Payload{ItemID: "12345"}))
+	assert.True(t, runScanCommands(Payload{ItemID: "SKU42", DeviceType: "scanner0"}))
+
+	data, err := os.ReadFile(out)
+	assert.NoError(t, err)
+	assert.Equal(t, "SKU42\n", string(data))
+
+	audit, err := os.ReadFile(commandAuditFile)
+	assert.NoError(t, err)
+	assert.Contains(t, string(audit), `"name":"lookup","itemid":"SKU42","deviceType":"scanner0"`)
+	assert.Contains(t, string(audit), `"exitCode":0`)
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// configMigration upgrades a config file from the previous version to version to
+type configMigration struct {
+	to          int
+	description string
+	migrate     func(raw map[string]interface{}) error
+}
+
+// configMigrations are applied in order to config files older than their version.
+// Add a migration whenever a setting is renamed or changes shape, and never edit
+// a released one: stations may skip any number of versions.
+var configMigrations = []configMigration{
+	// config files from before configVersion existed need no changes
+	{to: 1, description: "add configVersion", migrate: func(map[string]interface{}) error { return nil }},
+}
+
+// currentConfigVersion is the config version this build writes
+func currentConfigVersion() int {
+	return configMigrations[len(configMigrations)-1].to
+}
+
+// migrateConfig upgrades config data to the current version. It returns the
+// version the data had and whether it changed.
+func migrateConfig(data []byte) ([]byte, int, bool, error) {
+	var raw map[string]interface{}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, 0, false, err
+	}
+	from := 0
+	if v, ok := raw["configVersion"].(float64); ok {
+		from = int(v)
+	}
+	if from > currentConfigVersion() {
+		logger.Warnf("config.json is version %d, newer than this build supports (%d); unknown settings are ignored", from, currentConfigVersion())
+		return data, from, false, nil
+	}
+	if from == currentConfigVersion() {
+		return data, from, false, nil
+	}
+	for _, m := range configMigrations {
+		if m.to <= from {
+			continue
+		}
+		if err := m.migrate(raw); err != nil {
+			return nil, from, false, fmt.Errorf("migrating config to version %d (%s): %v", m.to, m.description, err)
+		}
+		raw["configVersion"] = m.to
+	}
+	migrated, err := json.MarshalIndent(raw, "", "  ")
+	if err != nil {
+		return nil, from, false, err
+	}
+	return append(migrated, '\n'), from, true, nil
+}
+
+// loadConfigFile reads the config at path, migrating it when it is older than
+// this build. With rewrite, the migrated file replaces it and the original is
+// kept as path.v<version>.bak. A preset named in the file is applied first.
+func loadConfigFile(path string, rewrite bool) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	migrated, from, changed, err := migrateConfig(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	if changed && rewrite {
+		backup := fmt.Sprintf("%s.v%d.bak", path, from)
+		if err := writeFileAtomic(backup, data); err != nil {
+			logger.Errorf("Error backing up %s: %v", path, err)
+		} else if err := writeFileAtomic(path, migrated); err != nil {
+			logger.Errorf("Error writing migrated %s: %v", path, err)
+		} else {
+			logger.Infof("Migrated %s from version %d to %d; the original is %s", path, from, currentConfigVersion(), backup)
+		}
+	}
+
+	var config Config
+	if err := applyPreset(migrated, &config); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	if err := json.Unmarshal(migrated, &config); err != nil {
+		return nil, err
+	}
+	return &config, nil
+}
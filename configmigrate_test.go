@@ -0,0 +1,57 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadConfigFile_Migrates(t *testing.T) {
+	oldMigrations := configMigrations
+	defer func() { configMigrations = oldMigrations }()
+	configMigrations = append(configMigrations, configMigration{to: 2, description: "rename scanners", migrate: func(raw map[string]interface{}) error {
+		if scanners, ok := raw["scanners"]; ok {
+			raw["numberOfScanners"] = scanners
+			delete(raw, "scanners")
+		}
+		return nil
+	}})
+
+	path := filepath.Join(t.TempDir(), "config.json")
+	original := `{"apiEndpoint": "http://example.com/api", "scanners": 3}`
+	os.WriteFile(path, []byte(original), 0644)
+
+	config, err := loadConfigFile(path, true)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, config.ConfigVersion)
+	assert.Equal(t, 3, config.NumberOfScanners)
+	backup, _ := os.ReadFile(path + ".v0.bak")
+	assert.Equal(t, original, string(backup))
+
+	// the rewritten file is current and loads without another migration
+	os.Remove(path + ".v0.bak")
+	config, err = loadConfigFile(path, true)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, config.NumberOfScanners)
+	assert.NoFileExists(t, path+".v0.bak")
+	assert.NoFileExists(t, path+".v2.bak")
+
+	// only the migrations after the file's version run
+	os.WriteFile(path, []byte(`{"configVersion": 1, "numberOfScanners": 1}`), 0644)
+	config, err = loadConfigFile(path, true)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, config.ConfigVersion)
+	assert.Equal(t, 1, config.NumberOfScanners)
+	assert.FileExists(t, path+".v1.bak")
+}
+
+func TestMigrateConfig_NewerVersion(t *testing.T) {
+	data := []byte(`{"configVersion": 99, "numberOfScanners": 1}`)
+	migrated, from, changed, err := migrateConfig(data)
+	assert.NoError(t, err)
+	assert.Equal(t, 99, from)
+	assert.False(t, changed)
+	assert.Equal(t, data, migrated)
+}
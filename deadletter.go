@@ -0,0 +1,37 @@
+package main
+
+import (
+	"encoding/json"
+	"time"
+)
+
+var deadLetterFile = "deadletter.log"
+
+// DeadLetter represents a payload that will never be posted as-is, with the reason why
+type DeadLetter struct {
+	Time    time.Time `json:"time"`
+	Reason  string    `json:"reason"`
+	Payload Payload   `json:"payload"`
+	// Poison marks a queued payload the API kept rejecting, after Attempts replays
+	Poison   bool `json:"poison,omitempty"`
+	Attempts int  `json:"attempts,omitempty"`
+}
+
+// deadLetter saves a payload that cannot be delivered to deadletter.log for inspection
+func deadLetter(payload Payload, reason string) {
+	writeDeadLetter(DeadLetter{Time: time.Now(), Reason: reason, Payload: payload})
+}
+
+// writeDeadLetter appends a dead letter to deadletter.log
+func writeDeadLetter(letter DeadLetter) {
+	logger.Errorf("Dead-lettering payload %v: %s", letter.Payload, letter.Reason)
+	sequences.settle(letter.Payload)
+	data, err := json.Marshal(letter)
+	if err != nil {
+		logger.Errorf("Error marshaling dead letter: %v", err)
+		return
+	}
+	if err := appendRecord(deadLetterFile, data); err != nil {
+		logger.Errorf("Error writing to %s: %v", deadLetterFile, err)
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// DegradationConfig represents automatic queue-only mode when the API keeps failing
+type DegradationConfig struct {
+	Enabled bool `json:"enabled"`
+	// WindowSeconds is how far back post results count towards the failure rate
+	WindowSeconds int `json:"windowSeconds"`
+	// MinPosts is how many posts the window needs before the rate is trusted
+	MinPosts int `json:"minPosts"`
+	// FailureRate between 0 and 1 above which the service degrades
+	FailureRate float64 `json:"failureRate"`
+	// ProbeSeconds is how often one scan is still posted while degraded
+	ProbeSeconds int `json:"probeSeconds"`
+	// RecoverySuccesses is how many probes in a row must succeed to recover
+	RecoverySuccesses int `json:"recoverySuccesses"`
+}
+
+func (d DegradationConfig) withDefaults() DegradationConfig {
+	if d.WindowSeconds <= 0 {
+		d.WindowSeconds = 60
+	}
+	if d.MinPosts <= 0 {
+		d.MinPosts = 10
+	}
+	if d.FailureRate <= 0 {
+		d.FailureRate = 0.5
+	}
+	if d.ProbeSeconds <= 0 {
+		d.ProbeSeconds = 30
+	}
+	if d.RecoverySuccesses <= 0 {
+		d.RecoverySuccesses = 3
+	}
+	return d
+}
+
+type postOutcome struct {
+	at        time.Time
+	delivered bool
+}
+
+// errorBudget tracks the recent post failure rate and switches between normal
+// and degraded (queue-only) mode. A nil budget never degrades.
+type errorBudget struct {
+	mu             sync.Mutex
+	config         DegradationConfig
+	outcomes       []postOutcome
+	degradedSince  time.Time
+	lastProbe      time.Time
+	probeSuccesses int
+	// onRecover runs when the service leaves degraded mode
+	onRecover func()
+}
+
+var budget *errorBudget
+
+func newErrorBudget(config DegradationConfig) *errorBudget {
+	return &errorBudget{config: config.withDefaults()}
+}
+
+// record adds a post result and switches mode when the budget is exceeded or restored
+func (b *errorBudget) record(delivered bool, now time.Time) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	window := time.Duration(b.config.WindowSeconds) * time.Second
+	b.outcomes = append(b.outcomes, postOutcome{at: now, delivered: delivered})
+	for len(b.outcomes) > 0 && now.Sub(b.outcomes[0].at) > window {
+		b.outcomes = b.outcomes[1:]
+	}
+
+	var recovered func()
+	if b.degradedSince.IsZero() {
+		failed := 0
+		for _, o := range b.outcomes {
+			if !o.delivered {
+				failed++
+			}
+		}
+		rate := float64(failed) / float64(len(b.outcomes))
+		if len(b.outcomes) >= b.config.MinPosts && rate >= b.config.FailureRate {
+			b.degradedSince = now
+			b.lastProbe = now
+			b.probeSuccesses = 0
+			logger.Warnf("Post failure rate %.0f%% over the last %ds exceeds the error budget; switching to queue-only mode", rate*100, b.config.WindowSeconds)
+		}
+	} else if !delivered {
+		b.probeSuccesses = 0
+	} else if b.probeSuccesses++; b.probeSuccesses >= b.config.RecoverySuccesses {
+		logger.Infof("API recovered after %s in queue-only mode; resuming posts", now.Sub(b.degradedSince).Round(time.Second))
+		b.degradedSince = time.Time{}
+		b.outcomes = nil
+		recovered = b.onRecover
+	}
+	b.mu.Unlock()
+
+	if recovered != nil {
+		recovered()
+	}
+}
+
+// allowPost reports whether a scan should be posted now. While degraded only
+// one probe is allowed every probeSeconds and the rest go straight to the queue.
+func (b *errorBudget) allowPost(now time.Time) bool {
+	if b == nil {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.degradedSince.IsZero() {
+		return true
+	}
+	if now.Sub(b.lastProbe) < time.Duration(b.config.ProbeSeconds)*time.Second {
+		return false
+	}
+	b.lastProbe = now
+	return true
+}
+
+// degraded returns when degraded mode began, or the zero time when posting normally
+func (b *errorBudget) degraded() time.Time {
+	if b == nil {
+		return time.Time{}
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.degradedSince
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestErrorBudget_DegradesAndRecovers(t *testing.T) {
+	b := newErrorBudget(DegradationConfig{Enabled: true, MinPosts: 4, FailureRate: 0.6, ProbeSeconds: 10, RecoverySuccesses: 2})
+	recovered := false
+	b.onRecover = func() { recovered = true }
+	now := time.Now()
+
+	b.record(true, now)
+	b.record(false, now)
+	b.record(false, now)
+	assert.True(t, b.degraded().IsZero(), "too few posts to judge")
+	b.record(true, now)
+	assert.True(t, b.degraded().IsZero(), "below the failure rate")
+	b.record(false, now)
+	assert.Equal(t, now, b.degraded())
+
+	assert.False(t, b.allowPost(now.Add(5*time.Second)))
+	assert.True(t, b.allowPost(now.Add(10*time.Second)), "probe is due")
+	assert.False(t, b.allowPost(now.Add(11*time.Second)))
+
+	b.record(true, now.Add(10*time.Second))
+	b.record(false, now.Add(20*time.Second))
+	b.record(true, now.Add(30*time.Second))
+	assert.False(t, recovered, "a failed probe resets the count")
+	b.record(true, now.Add(40*time.Second))
+	assert.True(t, recovered)
+	assert.True(t, b.degraded().IsZero())
+	assert.True(t, b.allowPost(now.Add(41*time.Second)))
+}
+
+func TestErrorBudget_WindowExpires(t *testing.T) {
+	b := newErrorBudget(DegradationConfig{WindowSeconds: 60, MinPosts: 2, FailureRate: 0.5})
+	now := time.Now()
+	b.record(false, now)
+	b.record(true, now.Add(2*time.Minute))
+	b.record(true, now.Add(2*time.Minute))
+	assert.True(t, b.degraded().IsZero())
+}
+
+func TestErrorBudget_NilNeverDegrades(t *testing.T) {
+	var b *errorBudget
+	b.record(false, time.Now())
+	assert.True(t, b.allowPost(time.Now()))
+	assert.True(t, b.degraded().IsZero())
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// demoBarcodes are the items the simulated scanner reads, in turn
+var demoBarcodes = []string{
+	"4006381333931",
+	"0012345678905",
+	"PLT-000184-A",
+	"9780201379624",
+	"SKU-77810-RED-M",
+	"5901234123457",
+	"TOTE-0042",
+	"0036000291452",
+}
+
+// demoAPI is the mock backend of the demo command. It answers like a sorting
+// API, telling the operator which bin each item goes to.
+type demoAPI struct {
+	rejectEvery int
+
+	mu    sync.Mutex
+	count int
+}
+
+func (a *demoAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var payload Payload
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	a.mu.Lock()
+	a.count++
+	n := a.count
+	a.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	if a.rejectEvery > 0 && n%a.rejectEvery == 0 {
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		json.NewEncoder(w).Encode(map[string]string{"message": "Unknown item " + payload.ItemID})
+		return
+	}
+	bin := n%12 + 1
+	json.NewEncoder(w).Encode(map[string]interface{}{"bin": bin, "message": fmt.Sprintf("Bin %d - place item there", bin)})
+}
+
+// simulateScanner reads the demo barcodes at rate scans per second until stop is closed
+func simulateScanner(config *Config, rate float64, stop <-chan struct{}) {
+	name := scannerName(0)
+	bus.deviceAttached.publish(DeviceAttached{DeviceID: 0, Serial: "DEMO0001", At: time.Now()})
+	ticker := time.NewTicker(time.Duration(float64(time.Second) / rate))
+	defer ticker.Stop()
+	for i := 0; ; i++ {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+		payload := Payload{ItemID: demoBarcodes[i%len(demoBarcodes)], DeviceType: name, Nickname: config.scannerNickname(0)}
+		bus.deviceRead.publish(DeviceRead{DeviceID: 0, At: time.Now()})
+		for _, payload := range intake(config, payload) {
+			go dispatchPayload(config, payload)
+		}
+	}
+}
+
+// runDemo runs the whole service against a mock backend and a simulated
+// scanner, showing the monitor. Nothing outside a temporary directory is
+// touched, and the admin API is read-only.
+func runDemo(args []string) error {
+	flags := flag.NewFlagSet("demo", flag.ContinueOnError)
+	rate := flags.Float64("rate", 1, "simulated scans per second")
+	admin := flags.String("admin", "127.0.0.1:8089", "address of the read-only admin API")
+	rejectEvery := flags.Int("reject-every", 10, "the mock API rejects every Nth scan (0 never)")
+	headless := flags.Bool("headless", false, "log to the console instead of showing the monitor")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *rate <= 0 {
+		return fmt.Errorf("rate must be positive")
+	}
+
+	dir, err := os.MkdirTemp("", "scanandpost-demo")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+	if err := applyStorage(StorageConfig{LogDir: dir, QueueDir: dir, StateDir: dir}); err != nil {
+		return err
+	}
+	api := httptest.NewServer(&demoAPI{rejectEvery: *rejectEvery})
+	defer api.Close()
+
+	config := &Config{
+		APIEndpoint:      api.URL,
+		NumberOfScanners: 1,
+		ScannerNicknames: []string{"Demo Scanner"},
+		StationID:        "demo",
+		Admin:            AdminConfig{Listen: *admin, ReadOnly: true},
+		Feedback:         FeedbackConfig{Template: "{{.message}}"},
+	}
+	if err := setupClients(config); err != nil {
+		return err
+	}
+	if feedback, err = newFeedbackDisplay(config.Feedback); err != nil {
+		return err
+	}
+	listener, err := net.Listen("tcp", config.Admin.Listen)
+	if err != nil {
+		return fmt.Errorf("admin API: %v", err)
+	}
+	go http.Serve(listener, adminMux(config))
+
+	stop := make(chan struct{})
+	defer close(stop)
+	go simulateScanner(config, *rate, stop)
+
+	if *headless {
+		logger.SetOutput(os.Stdout)
+		fmt.Printf("Demo running: mock API at %s, admin API at http://%s. Press Ctrl+C to stop.\n", api.URL, config.Admin.Listen)
+		interrupt := make(chan os.Signal, 1)
+		signal.Notify(interrupt, os.Interrupt, syscall.SIGTERM)
+		<-interrupt
+		return nil
+	}
+	// keep log lines from drawing over the terminal UI
+	logger.SetOutput(io.Discard)
+	return runMonitor(config)
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDemoAPI_AssignsBinsAndRejects(t *testing.T) {
+	api := &demoAPI{rejectEvery: 2}
+
+	w := httptest.NewRecorder()
+	api.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"itemid": "TOTE-0042"}`)))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"bin": 2, "message": "Bin 2 - place item there"}`, w.Body.String())
+
+	w = httptest.NewRecorder()
+	api.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"itemid": "TOTE-0043"}`)))
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	assert.JSONEq(t, `{"message": "Unknown item TOTE-0043"}`, w.Body.String())
+}
+
+func TestAdminMux_ReadOnly(t *testing.T) {
+	mux := adminMux(&Config{Admin: AdminConfig{ReadOnly: true}})
+	for _, path := range []string{"/flush", "/reload", "/trigger?scanner=scanner0&action=read"} {
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest(http.MethodPost, path, nil))
+		assert.Equal(t, http.StatusForbidden, w.Code, path)
+	}
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/status", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// deviceStatsSaveInterval is how often changed device statistics are written to disk
+const deviceStatsSaveInterval = time.Minute
+
+// DeviceLifetime is the usage of the scanner in one slot, kept across restarts
+type DeviceLifetime struct {
+	// Serial is the HID serial number of the scanner; when another scanner
+	// takes the slot, counting starts over for it
+	Serial    string    `json:"serial,omitempty"`
+	Scans     uint64    `json:"scans"`
+	Errors    uint64    `json:"errors"`
+	FirstSeen time.Time `json:"firstSeen"`
+	LastSeen  time.Time `json:"lastSeen"`
+	// Reconnects counts reopening the scanner after it was lost while the service ran
+	Reconnects uint64 `json:"reconnects"`
+	// Hourly holds the recent counts per hour for trend alerts. It is only kept in devicestats.json.
+	Hourly []deviceHour `json:"hourly,omitempty"`
+}
+
+// deviceHour counts a scanner's activity in one hour
+type deviceHour struct {
+	Hour       int64  `json:"hour"`
+	Scans      uint64 `json:"scans"`
+	Errors     uint64 `json:"errors"`
+	Reconnects uint64 `json:"reconnects"`
+}
+
+// deviceHistoryHours is how much hourly history is kept per scanner
+const deviceHistoryHours = 7 * 24
+
+// hour returns the counts of the hour containing now, dropping hours past deviceHistoryHours
+func (d *DeviceLifetime) hour(now time.Time) *deviceHour {
+	h := now.Unix() / 3600
+	if n := len(d.Hourly); n == 0 || d.Hourly[n-1].Hour != h {
+		d.Hourly = append(d.Hourly, deviceHour{Hour: h})
+	}
+	for len(d.Hourly) > 0 && d.Hourly[0].Hour <= h-deviceHistoryHours {
+		d.Hourly = d.Hourly[1:]
+	}
+	return &d.Hourly[len(d.Hourly)-1]
+}
+
+// recent sums the counts of the last hours, including the current one
+func (d *DeviceLifetime) recent(now time.Time, hours int) deviceHour {
+	var sum deviceHour
+	since := now.Unix()/3600 - int64(hours)
+	for _, h := range d.Hourly {
+		if h.Hour > since {
+			sum.Scans += h.Scans
+			sum.Errors += h.Errors
+			sum.Reconnects += h.Reconnects
+		}
+	}
+	return sum
+}
+
+// deviceStatsStore keeps the lifetime counters of each scanner slot in
+// devicestats.json in the state directory. A nil store records nothing.
+type deviceStatsStore struct {
+	mu      sync.Mutex
+	path    string
+	devices map[string]*DeviceLifetime
+	dirty   bool
+	// opened remembers the slots opened since the service started, to tell reconnects from startup
+	opened map[string]bool
+}
+
+var deviceStats *deviceStatsStore
+
+func newDeviceStatsStore(path string) *deviceStatsStore {
+	return &deviceStatsStore{path: path, devices: map[string]*DeviceLifetime{}, opened: map[string]bool{}}
+}
+
+// loadDeviceStats reads the persisted counters, starting empty if there are none
+func loadDeviceStats() (*deviceStatsStore, error) {
+	s := newDeviceStatsStore(filepath.Join(stateDir, "devicestats.json"))
+	data, err := os.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		return s, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &s.devices); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// device returns the counters of a slot, creating them on first sight. The caller holds s.mu.
+func (s *deviceStatsStore) device(name string, now time.Time) *DeviceLifetime {
+	d, ok := s.devices[name]
+	if !ok {
+		d = &DeviceLifetime{FirstSeen: now}
+		s.devices[name] = d
+	}
+	d.LastSeen = now
+	s.dirty = true
+	return d
+}
+
+// deviceOpened records that the scanner with serial was opened in the slot
+func (s *deviceStatsStore) deviceOpened(name, serial string, now time.Time) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if d, ok := s.devices[name]; ok && d.Serial != "" && serial != "" && d.Serial != serial {
+		logger.Infof("%s is now serial %s, replacing %s after %d scans; starting its counters over", name, serial, d.Serial, d.Scans)
+		delete(s.devices, name)
+		delete(s.opened, name)
+	}
+	d := s.device(name, now)
+	if serial != "" {
+		d.Serial = serial
+	}
+	if s.opened[name] {
+		d.Reconnects++
+		d.hour(now).Reconnects++
+	}
+	s.opened[name] = true
+}
+
+// recordScan counts a scan read from the slot
+func (s *deviceStatsStore) recordScan(name string, now time.Time) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	d := s.device(name, now)
+	d.Scans++
+	d.hour(now).Scans++
+}
+
+// recordError counts a failure to open or read the scanner in the slot
+func (s *deviceStatsStore) recordError(name string, now time.Time) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	d, ok := s.devices[name]
+	if !ok {
+		d = &DeviceLifetime{FirstSeen: now, LastSeen: now}
+		s.devices[name] = d
+	}
+	d.Errors++
+	d.hour(now).Errors++
+	s.dirty = true
+}
+
+// lifetime returns a copy of the counters of a slot, or nil if it was never seen
+func (s *deviceStatsStore) lifetime(name string) *DeviceLifetime {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	d, ok := s.devices[name]
+	if !ok {
+		return nil
+	}
+	lifetime := *d
+	lifetime.Hourly = nil
+	return &lifetime
+}
+
+// snapshot returns a copy of the counters of every slot ever seen
+func (s *deviceStatsStore) snapshot() map[string]DeviceLifetime {
+	result := map[string]DeviceLifetime{}
+	if s == nil {
+		return result
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for name, d := range s.devices {
+		lifetime := *d
+		lifetime.Hourly = nil
+		result[name] = lifetime
+	}
+	return result
+}
+
+// save writes the counters if they changed since the last save
+func (s *deviceStatsStore) save() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.dirty {
+		return
+	}
+	data, err := json.MarshalIndent(s.devices, "", "  ")
+	if err == nil {
+		err = writeFileAtomic(s.path, data)
+	}
+	if err != nil {
+		logger.Errorf("Error saving device statistics to %s: %v", s.path, err)
+		return
+	}
+	s.dirty = false
+}
+
+// saveDeviceStats periodically persists the counters
+func saveDeviceStats() {
+	for range time.Tick(deviceStatsSaveInterval) {
+		deviceStats.save()
+	}
+}
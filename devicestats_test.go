@@ -0,0 +1,42 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDeviceStats_PersistAndReplace(t *testing.T) {
+	oldStateDir := stateDir
+	defer func() { stateDir = oldStateDir }()
+	stateDir = t.TempDir()
+
+	stats, err := loadDeviceStats()
+	assert.NoError(t, err)
+	start := time.Date(2024, 5, 1, 8, 0, 0, 0, time.UTC)
+	stats.deviceOpened("scanner0", "SN1", start)
+	stats.recordScan("scanner0", start.Add(time.Minute))
+	stats.recordScan("scanner0", start.Add(2*time.Minute))
+	stats.recordError("scanner0", start.Add(3*time.Minute))
+	stats.save()
+
+	// the counters survive a restart
+	reloaded, err := loadDeviceStats()
+	assert.NoError(t, err)
+	assert.Equal(t, &DeviceLifetime{Serial: "SN1", Scans: 2, Errors: 1, FirstSeen: start, LastSeen: start.Add(2 * time.Minute)}, reloaded.lifetime("scanner0"))
+	assert.Nil(t, reloaded.lifetime("scanner1"))
+	assert.Equal(t, deviceHour{Scans: 2, Errors: 1}, reloaded.devices["scanner0"].recent(start, 24), "hourly history for trend alerts")
+
+	// reopening the same scanner keeps counting, a new one starts over
+	reloaded.deviceOpened("scanner0", "SN1", start.Add(time.Hour))
+	assert.Equal(t, uint64(2), reloaded.lifetime("scanner0").Scans)
+	replaced := start.Add(2 * time.Hour)
+	reloaded.deviceOpened("scanner0", "SN2", replaced)
+	assert.Equal(t, DeviceLifetime{Serial: "SN2", FirstSeen: replaced, LastSeen: replaced}, reloaded.snapshot()["scanner0"])
+
+	var none *deviceStatsStore
+	none.recordScan("scanner0", start)
+	assert.Nil(t, none.lifetime("scanner0"))
+	assert.Empty(t, none.snapshot())
+}
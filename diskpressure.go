@@ -0,0 +1,218 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// DiskPressureConfig represents giving up logging step by step as the disk
+// fills, so the queue keeps the space it needs. Free space is measured on the
+// fuller of the log and queue volumes.
+type DiskPressureConfig struct {
+	Enabled bool `json:"enabled"`
+	// ReduceLoggingMB drops the log to warnings and errors below this much free space (default 1024)
+	ReduceLoggingMB int `json:"reduceLoggingMB"`
+	// PauseAuditMB also pauses the command audit log and scan traces, counting what was skipped (default 512)
+	PauseAuditMB int `json:"pauseAuditMB"`
+	// CriticalMB logs errors only, so the queue gets what is left (default 128)
+	CriticalMB int `json:"criticalMB"`
+	// CheckSeconds is how often free space is measured (default 30)
+	CheckSeconds int `json:"checkSeconds"`
+}
+
+func (d DiskPressureConfig) withDefaults() DiskPressureConfig {
+	if d.ReduceLoggingMB <= 0 {
+		d.ReduceLoggingMB = 1024
+	}
+	if d.PauseAuditMB <= 0 {
+		d.PauseAuditMB = 512
+	}
+	if d.CriticalMB <= 0 {
+		d.CriticalMB = 128
+	}
+	if d.CheckSeconds <= 0 {
+		d.CheckSeconds = 30
+	}
+	return d
+}
+
+func (d DiskPressureConfig) validate() error {
+	d = d.withDefaults()
+	if d.CriticalMB >= d.PauseAuditMB || d.PauseAuditMB >= d.ReduceLoggingMB {
+		return fmt.Errorf("thresholds must satisfy criticalMB < pauseAuditMB < reduceLoggingMB, got %d, %d and %d", d.CriticalMB, d.PauseAuditMB, d.ReduceLoggingMB)
+	}
+	return nil
+}
+
+// Disk pressure stages, from no pressure to a nearly full disk
+const (
+	diskNormal = iota
+	diskReduced
+	diskPaused
+	diskCritical
+)
+
+var diskStageNames = []string{"normal", "reduced", "paused", "critical"}
+
+// stage returns the stage for the free space in MB
+func (d DiskPressureConfig) stage(freeMB int64) int {
+	switch {
+	case freeMB < int64(d.CriticalMB):
+		return diskCritical
+	case freeMB < int64(d.PauseAuditMB):
+		return diskPaused
+	case freeMB < int64(d.ReduceLoggingMB):
+		return diskReduced
+	}
+	return diskNormal
+}
+
+// DiskPressureStatus is reported in the status while the disk is under pressure
+type DiskPressureStatus struct {
+	Stage  string    `json:"stage"`
+	FreeMB int64     `json:"freeMB"`
+	Since  time.Time `json:"since"`
+	// Skipped counts the records not written since the pressure began, by log
+	Skipped map[string]int `json:"skipped,omitempty"`
+}
+
+// diskGuard measures free space and degrades logging. A nil guard never
+// skips anything.
+type diskGuard struct {
+	config DiskPressureConfig
+	dirs   []string
+
+	mu          sync.Mutex
+	stage       int
+	freeMB      int64
+	since       time.Time
+	skipped     map[string]int
+	normalLevel logrus.Level
+}
+
+var diskPressure *diskGuard
+
+// diskFree measures free space. Tests replace it.
+var diskFree = freeBytes
+
+func newDiskGuard(config DiskPressureConfig, dirs ...string) *diskGuard {
+	return &diskGuard{config: config.withDefaults(), dirs: dirs, skipped: map[string]int{}, normalLevel: logger.GetLevel()}
+}
+
+// check measures free space and moves to the matching stage
+func (g *diskGuard) check(now time.Time) {
+	freeMB := int64(-1)
+	for _, dir := range g.dirs {
+		free, err := diskFree(dir)
+		if err != nil {
+			logger.Debugf("Error measuring free space of %s: %v", dir, err)
+			continue
+		}
+		if mb := int64(free / (1024 * 1024)); freeMB < 0 || mb < freeMB {
+			freeMB = mb
+		}
+	}
+	if freeMB < 0 {
+		return
+	}
+	stage := g.config.stage(freeMB)
+
+	g.mu.Lock()
+	previous := g.stage
+	g.freeMB = freeMB
+	if stage != previous {
+		g.stage = stage
+		if previous == diskNormal {
+			g.since = now
+			g.skipped = map[string]int{}
+		}
+	}
+	skipped := g.skipped
+	g.mu.Unlock()
+	if stage == previous {
+		return
+	}
+
+	if stage > previous {
+		logger.Warnf("Disk pressure %s with %d MB free: %s", diskStageNames[stage], freeMB, diskStageEffect(stage))
+		g.apply(stage)
+		return
+	}
+	g.apply(stage)
+	logger.Warnf("Disk pressure eased to %s with %d MB free; skipped while under pressure: %v", diskStageNames[stage], freeMB, skipped)
+}
+
+// diskStageEffect describes what a stage gives up
+func diskStageEffect(stage int) string {
+	switch stage {
+	case diskReduced:
+		return "logging only warnings and errors"
+	case diskPaused:
+		return "logging only warnings and errors, command audit and scan traces paused"
+	case diskCritical:
+		return "logging only errors, command audit and scan traces paused"
+	}
+	return ""
+}
+
+// apply sets the log level of a stage. Errors always reach service.log,
+// since a service has no console to see them on.
+func (g *diskGuard) apply(stage int) {
+	level := g.normalLevel
+	switch stage {
+	case diskReduced, diskPaused:
+		if level > logrus.WarnLevel {
+			level = logrus.WarnLevel
+		}
+	case diskCritical:
+		if level > logrus.ErrorLevel {
+			level = logrus.ErrorLevel
+		}
+	}
+	logger.SetLevel(level)
+}
+
+// allowAudit reports whether a record of the named log may be written,
+// counting it as skipped while audit logging is paused
+func (g *diskGuard) allowAudit(name string) bool {
+	if g == nil {
+		return true
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.stage < diskPaused {
+		return true
+	}
+	g.skipped[name]++
+	return false
+}
+
+// status returns the disk pressure, or nil when there is none
+func (g *diskGuard) status() *DiskPressureStatus {
+	if g == nil {
+		return nil
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.stage == diskNormal {
+		return nil
+	}
+	status := &DiskPressureStatus{Stage: diskStageNames[g.stage], FreeMB: g.freeMB, Since: g.since, Skipped: map[string]int{}}
+	for name, count := range g.skipped {
+		status.Skipped[name] = count
+	}
+	return status
+}
+
+// watch measures free space periodically
+func (g *diskGuard) watch() {
+	ticker := time.NewTicker(time.Duration(g.config.CheckSeconds) * time.Second)
+	defer ticker.Stop()
+	g.check(time.Now())
+	for now := range ticker.C {
+		g.check(now)
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+// useFakeDiskFree makes diskFree report the given free MB per directory
+func useFakeDiskFree(t *testing.T, free map[string]int64) {
+	oldDiskFree, oldLevel := diskFree, logger.GetLevel()
+	t.Cleanup(func() {
+		diskFree = oldDiskFree
+		logger.SetLevel(oldLevel)
+	})
+	diskFree = func(dir string) (uint64, error) {
+		mb, ok := free[dir]
+		if !ok {
+			return 0, errors.New("no such volume")
+		}
+		return uint64(mb) * 1024 * 1024, nil
+	}
+}
+
+func TestDiskPressureConfig_Validate(t *testing.T) {
+	assert.NoError(t, DiskPressureConfig{}.validate())
+	assert.Error(t, DiskPressureConfig{ReduceLoggingMB: 500, PauseAuditMB: 512}.validate())
+	assert.Error(t, DiskPressureConfig{CriticalMB: 600}.validate())
+}
+
+func TestDiskPressureConfig_Stage(t *testing.T) {
+	config := DiskPressureConfig{}.withDefaults()
+	assert.Equal(t, diskNormal, config.stage(2048))
+	assert.Equal(t, diskReduced, config.stage(1000))
+	assert.Equal(t, diskPaused, config.stage(300))
+	assert.Equal(t, diskCritical, config.stage(10))
+}
+
+func TestDiskGuard_DegradesAndRecovers(t *testing.T) {
+	free := map[string]int64{"logs": 5000, "queue": 5000}
+	useFakeDiskFree(t, free)
+	logger.SetLevel(logrus.DebugLevel)
+	g := newDiskGuard(DiskPressureConfig{}, "logs", "queue", "missing")
+	start := time.Date(2026, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	g.check(start)
+	assert.Nil(t, g.status())
+	assert.True(t, g.allowAudit("trace"))
+
+	// the fuller volume decides the stage
+	free["queue"] = 800
+	g.check(start.Add(time.Minute))
+	assert.Equal(t, logrus.WarnLevel, logger.GetLevel())
+	assert.True(t, g.allowAudit("trace"))
+
+	free["queue"] = 300
+	g.check(start.Add(2 * time.Minute))
+	assert.False(t, g.allowAudit("trace"))
+	assert.False(t, g.allowAudit("trace"))
+	assert.False(t, g.allowAudit("commandAudit"))
+	assert.Equal(t, &DiskPressureStatus{Stage: "paused", FreeMB: 300, Since: start.Add(time.Minute), Skipped: map[string]int{"trace": 2, "commandAudit": 1}}, g.status())
+
+	// errors still reach service.log, since a service has no console
+	out := logger.Out
+	free["queue"] = 50
+	g.check(start.Add(3 * time.Minute))
+	assert.Equal(t, logrus.ErrorLevel, logger.GetLevel())
+	assert.Equal(t, out, logger.Out)
+	assert.Equal(t, "critical", g.status().Stage)
+
+	free["queue"] = 5000
+	g.check(start.Add(4 * time.Minute))
+	assert.Nil(t, g.status())
+	assert.Equal(t, logrus.DebugLevel, logger.GetLevel())
+	assert.True(t, g.allowAudit("trace"))
+}
+
+func TestDiskGuard_Nil(t *testing.T) {
+	var g *diskGuard
+	assert.True(t, g.allowAudit("trace"))
+	assert.Nil(t, g.status())
+}
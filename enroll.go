@@ -0,0 +1,179 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"runtime"
+	"time"
+)
+
+// apiTokenSecret is the name of the stored API token received on enrollment
+const apiTokenSecret = "api-token"
+
+// EnrollmentRequest describes the station to the backend
+type EnrollmentRequest struct {
+	EnrollmentCode string           `json:"enrollmentCode"`
+	Hostname       string           `json:"hostname"`
+	Version        VersionInfo      `json:"version"`
+	CPUs           int              `json:"cpus"`
+	MACAddresses   []string         `json:"macAddresses"`
+	Devices        []EnrolledDevice `json:"devices"`
+}
+
+// EnrolledDevice is a HID device attached to the station at enrollment
+type EnrolledDevice struct {
+	Product      string `json:"product"`
+	Manufacturer string `json:"manufacturer"`
+	Serial       string `json:"serial,omitempty"`
+	Path         string `json:"path"`
+}
+
+// EnrollmentResponse is what the backend assigns to the station
+type EnrollmentResponse struct {
+	StationID string `json:"stationId"`
+	APIToken  string `json:"apiToken"`
+	// DeviceTokens are tokens for individual scanners, e.g. "scanner0", used instead of APIToken for their payloads
+	DeviceTokens map[string]string `json:"deviceTokens"`
+	// CredentialsVersion identifies the tokens for later rotation
+	CredentialsVersion string `json:"credentialsVersion"`
+	// Config replaces config.json when present
+	Config json.RawMessage `json:"config"`
+}
+
+// stationInfo gathers the hostname and hardware sent on enrollment
+func stationInfo(code string) EnrollmentRequest {
+	hostname, _ := os.Hostname()
+	request := EnrollmentRequest{EnrollmentCode: code, Hostname: hostname, Version: versionInfo(), CPUs: runtime.NumCPU()}
+	if interfaces, err := net.Interfaces(); err == nil {
+		for _, iface := range interfaces {
+			if len(iface.HardwareAddr) > 0 && iface.Flags&net.FlagLoopback == 0 {
+				request.MACAddresses = append(request.MACAddresses, iface.HardwareAddr.String())
+			}
+		}
+	}
+	for _, d := range enumerateDevices() {
+		request.Devices = append(request.Devices, EnrolledDevice{Product: d.Product, Manufacturer: d.Manufacturer, Serial: d.Serial, Path: d.Path})
+	}
+	return request
+}
+
+// enroll registers the station with the backend
+func enroll(client *http.Client, url string, request EnrollmentRequest) (EnrollmentResponse, error) {
+	var response EnrollmentResponse
+	data, err := json.Marshal(request)
+	if err != nil {
+		return response, err
+	}
+	resp, err := client.Post(url, "application/json", bytes.NewReader(data))
+	if err != nil {
+		return response, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
+		return response, fmt.Errorf("enrollment rejected with response code: %d", resp.StatusCode)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return response, fmt.Errorf("invalid enrollment response: %v", err)
+	}
+	if response.StationID == "" {
+		return response, errors.New("enrollment response has no stationId")
+	}
+	return response, nil
+}
+
+// applyEnrollment stores the credentials and writes the assigned config to
+// configPath, keeping the previous file as configPath.bak. It returns the config now in effect.
+func applyEnrollment(response EnrollmentResponse, configPath string) (*Config, error) {
+	var raw map[string]interface{}
+	if len(response.Config) > 0 && string(response.Config) != "null" {
+		if err := json.Unmarshal(response.Config, &raw); err != nil {
+			return nil, fmt.Errorf("invalid config in enrollment response: %v", err)
+		}
+	} else if data, err := os.ReadFile(configPath); err == nil {
+		if err := json.Unmarshal(data, &raw); err != nil {
+			return nil, fmt.Errorf("reading %s: %v", configPath, err)
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+	if raw == nil {
+		raw = map[string]interface{}{}
+	}
+	raw["stationId"] = response.StationID
+
+	data, err := json.MarshalIndent(raw, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	var config Config
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("invalid config in enrollment response: %v", err)
+	}
+	// credentials go with the state directory of the new config
+	if err := applyStorage(config.Storage); err != nil {
+		return nil, err
+	}
+	if response.APIToken != "" || len(response.DeviceTokens) > 0 {
+		// a newly enrolled station starts without the device tokens of a previous enrollment
+		devices := response.DeviceTokens
+		if devices == nil {
+			devices = map[string]string{}
+		}
+		if err := apiTokens.apply(TokenRotation{Version: response.CredentialsVersion, Token: response.APIToken, DeviceTokens: devices}); err != nil {
+			return nil, fmt.Errorf("storing API token: %v", err)
+		}
+	}
+	if old, err := os.ReadFile(configPath); err == nil {
+		if err := writeFileAtomic(configPath+".bak", old); err != nil {
+			return nil, err
+		}
+	}
+	return &config, writeFileAtomic(configPath, append(data, '\n'))
+}
+
+// runEnroll implements the enroll command
+func runEnroll(args []string) error {
+	flags := flag.NewFlagSet("enroll", flag.ContinueOnError)
+	url := flags.String("url", "", "enrollment endpoint of the backend")
+	code := flags.String("code", "", "one-time enrollment code issued by the backend")
+	caFile := flags.String("ca-file", "", "PEM bundle to trust for the enrollment endpoint instead of the system roots")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *url == "" {
+		return errors.New("-url is required")
+	}
+	client, err := newHTTPClient(TLSConfig{CAFile: *caFile})
+	if err != nil {
+		return err
+	}
+	client.Timeout = 30 * time.Second
+	response, err := enroll(client, *url, stationInfo(*code))
+	if err != nil {
+		return err
+	}
+	config, err := applyEnrollment(response, "config.json")
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Enrolled as station %s.\n", response.StationID)
+	if config.CertEnrollment.Server != "" {
+		if err := newCertManager(config.CertEnrollment, config.StationID).renew(); err != nil {
+			return fmt.Errorf("client certificate enrollment: %v", err)
+		}
+		fmt.Printf("Client certificate stored in %s.\n", secretPath(enrolledCertFile))
+	}
+	if response.APIToken != "" {
+		fmt.Printf("API token stored in %s.\n", secretPath(apiTokenSecret))
+	}
+	if len(response.Config) > 0 {
+		fmt.Println("Configuration written to config.json; the previous file, if any, is config.json.bak.")
+	}
+	return nil
+}
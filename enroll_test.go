@@ -0,0 +1,71 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEnroll(t *testing.T) {
+	useTempStorage(t)
+	useTempTokens(t)
+	var request EnrollmentRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&request)
+		if request.EnrollmentCode != "ABCD-1234" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		w.Write([]byte(`{"stationId": "st-42", "apiToken": "tok", "config": {"apiEndpoint": "https://backend.example.com/api", "numberOfScanners": 2}}`))
+	}))
+	defer server.Close()
+
+	_, err := enroll(server.Client(), server.URL, stationInfo("wrong"))
+	assert.EqualError(t, err, "enrollment rejected with response code: 403")
+
+	response, err := enroll(server.Client(), server.URL, stationInfo("ABCD-1234"))
+	assert.NoError(t, err)
+	assert.NotEmpty(t, request.Hostname)
+	assert.NotEmpty(t, request.Version.Platform)
+
+	configPath := filepath.Join(t.TempDir(), "config.json")
+	os.WriteFile(configPath, []byte(`{"apiEndpoint": "http://old"}`), 0644)
+	config, err := applyEnrollment(response, configPath)
+	assert.NoError(t, err)
+	assert.Equal(t, "st-42", config.StationID)
+	assert.Equal(t, 2, config.NumberOfScanners)
+
+	old, _ := os.ReadFile(configPath + ".bak")
+	assert.Equal(t, `{"apiEndpoint": "http://old"}`, string(old))
+	data, _ := os.ReadFile(configPath)
+	assert.Contains(t, string(data), `"stationId": "st-42"`)
+
+	token, err := loadSecret(apiTokenSecret)
+	assert.NoError(t, err)
+	assert.Equal(t, "tok", string(token))
+	info, _ := os.Stat(secretPath(apiTokenSecret))
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+}
+
+func TestSetupClients_SendsStationCredentials(t *testing.T) {
+	var auth, station string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth, station = r.Header.Get("Authorization"), r.Header.Get("X-Station-ID")
+	}))
+	defer server.Close()
+	useTempStorage(t)
+	useTempTokens(t)
+	assert.NoError(t, saveSecret(apiTokenSecret, []byte("tok")))
+
+	assert.NoError(t, setupClients(&Config{StationID: "st-42"}))
+	resp, err := apiClient.Post(server.URL, "application/json", nil)
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, "Bearer tok", auth)
+	assert.Equal(t, "st-42", station)
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ScanReceived is published for each payload read from an input, after splitting
+type ScanReceived struct {
+	Payload Payload
+	At      time.Time
+}
+
+// ScanAccepted is published when a numbered scan is handed to delivery,
+// before the API post, so the side outputs never wait for it
+type ScanAccepted struct {
+	Payload Payload
+}
+
+// TransformFailed is published when a transform plugin fails and the payload
+// passes through unchanged
+type TransformFailed struct {
+	Payload Payload
+	Plugin  string
+	Err     error
+}
+
+// PostSucceeded is published when the API accepts a payload or a batch
+type PostSucceeded struct {
+	Payloads   []Payload
+	StatusCode int
+	Response   []byte
+	// Batch is set when Payloads were posted together and Response covers them all
+	Batch bool
+	// Replay is set when the payloads were replayed from the queue
+	Replay bool
+}
+
+// PostFailed is published when a payload or a batch could not be posted
+type PostFailed struct {
+	Payloads   []Payload
+	StatusCode int
+	Replay     bool
+}
+
+// DeviceDetached is published when a scanner goes missing
+type DeviceDetached struct {
+	DeviceID int
+	At       time.Time
+}
+
+// DeviceAttached is published when a scanner is opened or comes back
+type DeviceAttached struct {
+	DeviceID int
+	Serial   string
+	At       time.Time
+	// Simulated is set when chaos testing fakes the reconnect and the device was never reopened
+	Simulated bool
+}
+
+// DeviceRead is published for each read from a scanner, before splitting
+type DeviceRead struct {
+	DeviceID int
+	At       time.Time
+}
+
+// DeviceFailed is published when a scanner could not be opened or read
+type DeviceFailed struct {
+	DeviceID int
+	Err      error
+	At       time.Time
+}
+
+// topic delivers one type of event to its subscribers. Subscribers run in the
+// publisher's goroutine in the order they subscribed, so they must not block.
+type topic[T any] struct {
+	mu       sync.RWMutex
+	handlers []func(T)
+}
+
+func (t *topic[T]) subscribe(handler func(T)) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.handlers = append(t.handlers, handler)
+}
+
+func (t *topic[T]) publish(event T) {
+	t.mu.RLock()
+	handlers := t.handlers
+	t.mu.RUnlock()
+	for _, handler := range handlers {
+		handler(event)
+	}
+}
+
+// bus connects the dispatcher to the modules that react to what happens to
+// scans and devices
+var bus struct {
+	scanReceived    topic[ScanReceived]
+	scanAccepted    topic[ScanAccepted]
+	transformFailed topic[TransformFailed]
+	postSucceeded   topic[PostSucceeded]
+	postFailed      topic[PostFailed]
+	deviceDetached  topic[DeviceDetached]
+	deviceAttached  topic[DeviceAttached]
+	deviceRead      topic[DeviceRead]
+	deviceFailed    topic[DeviceFailed]
+}
+
+func init() {
+	bus.scanReceived.subscribe(func(e ScanReceived) {
+		recordScan()
+		recent.addScan(e.Payload, e.At)
+	})
+	bus.scanAccepted.subscribe(func(e ScanAccepted) {
+		deliverToOutputs(e.Payload)
+		mirror.send(e.Payload)
+	})
+	bus.transformFailed.subscribe(func(TransformFailed) {
+		recordTransformFailure()
+	})
+	bus.postSucceeded.subscribe(func(e PostSucceeded) {
+		if e.Batch {
+			receipts.recordBatch(e.Payloads, e.Response, time.Now())
+		} else {
+			for _, payload := range e.Payloads {
+				receipts.record(payload, e.Response, time.Now())
+			}
+		}
+		for _, payload := range e.Payloads {
+			sequences.settle(payload)
+		}
+		// a replayed scan was already reported when it was queued
+		if !e.Replay {
+			reportOutcomes(e.Payloads, outcomeAccepted, "")
+		}
+	})
+	bus.postSucceeded.subscribe(func(e PostSucceeded) {
+		for range e.Payloads {
+			recordPostResult(e.StatusCode, true)
+		}
+	})
+	bus.postSucceeded.subscribe(func(e PostSucceeded) {
+		switch {
+		case e.Replay:
+		case e.Batch:
+			feedback.showBatch(e.Payloads, e.Response)
+		default:
+			for _, payload := range e.Payloads {
+				feedback.show(payload, e.Response)
+			}
+		}
+	})
+	bus.postFailed.subscribe(func(e PostFailed) {
+		if !e.Replay {
+			reportOutcomes(e.Payloads, postFailedOutcome(e.StatusCode), fmt.Sprintf("response code %d", e.StatusCode))
+		}
+	})
+	bus.postFailed.subscribe(func(e PostFailed) {
+		for range e.Payloads {
+			recordPostResult(e.StatusCode, false)
+		}
+	})
+	bus.deviceDetached.subscribe(func(e DeviceDetached) {
+		markDeviceMissing(e.DeviceID)
+	})
+	bus.deviceAttached.subscribe(func(e DeviceAttached) {
+		markDevicePresent(e.DeviceID)
+		if !e.Simulated {
+			deviceStats.deviceOpened(scannerName(e.DeviceID), e.Serial, e.At)
+		}
+	})
+	bus.deviceRead.subscribe(func(e DeviceRead) {
+		deviceStats.recordScan(scannerName(e.DeviceID), e.At)
+	})
+	bus.deviceFailed.subscribe(func(e DeviceFailed) {
+		deviceStats.recordError(scannerName(e.DeviceID), e.At)
+	})
+}
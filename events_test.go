@@ -0,0 +1,67 @@
+package main
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTopic_PublishesInOrder(t *testing.T) {
+	var topic topic[ScanReceived]
+	var got []string
+	topic.subscribe(func(e ScanReceived) { got = append(got, "first "+e.Payload.ItemID) })
+	topic.subscribe(func(e ScanReceived) { got = append(got, "second "+e.Payload.ItemID) })
+
+	topic.publish(ScanReceived{Payload: Payload{ItemID: "A"}})
+	assert.Equal(t, []string{"first A", "second A"}, got)
+}
+
+func TestBus_HealthSubscribers(t *testing.T) {
+	oldHealth := health
+	defer func() { health = oldHealth }()
+	health = &healthState{deviceMissingSince: map[int]time.Time{}}
+	now := time.Now()
+
+	bus.scanReceived.publish(ScanReceived{Payload: Payload{ItemID: "A"}, At: now})
+	bus.transformFailed.publish(TransformFailed{Payload: Payload{ItemID: "A"}, Plugin: "upper", Err: errors.New("boom")})
+	bus.postSucceeded.publish(PostSucceeded{Payloads: []Payload{{ItemID: "A"}, {ItemID: "B"}}, StatusCode: 200, Batch: true})
+	bus.postFailed.publish(PostFailed{Payloads: []Payload{{ItemID: "C"}}, StatusCode: 500, Replay: true})
+	bus.deviceDetached.publish(DeviceDetached{DeviceID: 1, At: now})
+
+	assert.Equal(t, uint32(1), health.scansReceived)
+	assert.Equal(t, uint32(1), health.transformsFailed)
+	assert.Equal(t, uint32(2), health.postsSucceeded)
+	assert.Equal(t, uint32(1), health.postsFailed)
+	assert.Contains(t, health.deviceMissingSince, 1)
+
+	bus.deviceAttached.publish(DeviceAttached{DeviceID: 1, At: now})
+	assert.NotContains(t, health.deviceMissingSince, 1)
+}
+
+func TestBus_DeliverySubscribers(t *testing.T) {
+	oldSequences, oldStats := sequences, deviceStats
+	defer func() { sequences, deviceStats = oldSequences, oldStats }()
+	dir := t.TempDir()
+	sequences = newSequenceTracker(filepath.Join(dir, "sequences.json"))
+	deviceStats = newDeviceStatsStore(filepath.Join(dir, "devicestats.json"))
+	now := time.Now()
+
+	payload := Payload{ItemID: "A", DeviceType: "scanner0"}
+	payload.Sequence = sequences.issue("scanner0", now)
+	bus.postSucceeded.publish(PostSucceeded{Payloads: []Payload{payload}, StatusCode: 200, Replay: true})
+	assert.Empty(t, sequences.check(now.Add(time.Hour), time.Minute, nil), "the posted sequence was settled")
+
+	bus.deviceAttached.publish(DeviceAttached{DeviceID: 0, Serial: "S1", At: now})
+	bus.deviceRead.publish(DeviceRead{DeviceID: 0, At: now})
+	bus.deviceFailed.publish(DeviceFailed{DeviceID: 0, Err: errors.New("gone"), At: now})
+	// a chaos reconnect never reopened the scanner
+	bus.deviceAttached.publish(DeviceAttached{DeviceID: 0, At: now, Simulated: true})
+	lifetime := deviceStats.lifetime("scanner0")
+	assert.Equal(t, "S1", lifetime.Serial)
+	assert.Equal(t, uint64(1), lifetime.Scans)
+	assert.Equal(t, uint64(1), lifetime.Errors)
+	assert.Zero(t, lifetime.Reconnects)
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"hash/fnv"
+	"strings"
+	"time"
+)
+
+// ExperimentConfig represents an A/B test of two transform variants on live traffic
+type ExperimentConfig struct {
+	Name string `json:"name"`
+	// Device limits the experiment to one device type, such as "scanner0"; empty applies to every device
+	Device string `json:"device"`
+	// A and B name the transform plugins of each variant. A is the control and also runs for devices outside the experiment.
+	A []string `json:"a"`
+	B []string `json:"b"`
+	// Percent is the share of time slices that use variant B
+	Percent int `json:"percent"`
+	// SliceMinutes is how long each variant assignment lasts (default 10)
+	SliceMinutes int `json:"sliceMinutes"`
+}
+
+func (e ExperimentConfig) withDefaults() ExperimentConfig {
+	if e.SliceMinutes <= 0 {
+		e.SliceMinutes = 10
+	}
+	return e
+}
+
+// experiment assigns payloads to a variant and tells which transforms to skip
+type experiment struct {
+	config ExperimentConfig
+	a, b   map[string]bool
+}
+
+var experiments []*experiment
+
+// newExperiments checks the experiments against the configured transform plugins
+func newExperiments(configs []ExperimentConfig, plugins []PluginConfig) ([]*experiment, error) {
+	transforms := map[string]bool{}
+	for _, p := range plugins {
+		if p.Type == "transform" || p.Type == "wasm-transform" {
+			transforms[p.Name] = true
+		}
+	}
+	var result []*experiment
+	seen := map[string]bool{}
+	for _, config := range configs {
+		config = config.withDefaults()
+		if config.Name == "" || strings.ContainsAny(config.Name, ":,") {
+			return nil, fmt.Errorf("experiment name %q must be set and not contain ':' or ','", config.Name)
+		}
+		if seen[config.Name] {
+			return nil, fmt.Errorf("experiment %s is defined twice", config.Name)
+		}
+		seen[config.Name] = true
+		if config.Percent < 0 || config.Percent > 100 {
+			return nil, fmt.Errorf("experiment %s: percent must be between 0 and 100", config.Name)
+		}
+		e := &experiment{config: config, a: map[string]bool{}, b: map[string]bool{}}
+		for _, names := range []struct {
+			set  map[string]bool
+			list []string
+		}{{e.a, config.A}, {e.b, config.B}} {
+			for _, name := range names.list {
+				if !transforms[name] {
+					return nil, fmt.Errorf("experiment %s: %q is not a transform plugin", config.Name, name)
+				}
+				names.set[name] = true
+			}
+		}
+		result = append(result, e)
+	}
+	return result, nil
+}
+
+// variant returns "A" or "B" for the time slice containing now. Every
+// payload in a slice gets the same variant, so the two can be compared over
+// the same stations and shifts.
+func (e *experiment) variant(now time.Time) string {
+	slice := now.Unix() / int64(e.config.SliceMinutes*60)
+	h := fnv.New32a()
+	fmt.Fprintf(h, "%s:%d", e.config.Name, slice)
+	if int(h.Sum32()%100) < e.config.Percent {
+		return "B"
+	}
+	return "A"
+}
+
+// applies reports whether the experiment covers payloads from the device
+func (e *experiment) applies(deviceType string) bool {
+	return e.config.Device == "" || e.config.Device == deviceType
+}
+
+// assignVariants returns the variant tag of the payload, such as
+// "sku-rule:B", and the transform plugins to skip for it
+func assignVariants(payload Payload, now time.Time) (string, map[string]bool) {
+	var tags []string
+	skip := map[string]bool{}
+	for _, e := range experiments {
+		variant := "A"
+		if e.applies(payload.DeviceType) {
+			variant = e.variant(now)
+			tags = append(tags, e.config.Name+":"+variant)
+		}
+		use, other := e.a, e.b
+		if variant == "B" {
+			use, other = e.b, e.a
+		}
+		for name := range other {
+			if !use[name] {
+				skip[name] = true
+			}
+		}
+	}
+	return strings.Join(tags, ","), skip
+}
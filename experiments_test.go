@@ -0,0 +1,72 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// suffixTransform appends its suffix to the item ID
+type suffixTransform struct{ suffix string }
+
+func (s suffixTransform) name() string { return s.suffix }
+
+func (s suffixTransform) call(payload Payload) (*pluginResponse, error) {
+	payload.ItemID += s.suffix
+	return &pluginResponse{Payload: &payload}, nil
+}
+
+func TestNewExperiments_Validation(t *testing.T) {
+	plugins := []PluginConfig{{Name: "old", Type: "transform"}, {Name: "new", Type: "wasm-transform"}, {Name: "erp", Type: "output"}}
+
+	_, err := newExperiments([]ExperimentConfig{{Name: "rule", A: []string{"old"}, B: []string{"new"}, Percent: 50}}, plugins)
+	assert.NoError(t, err)
+	_, err = newExperiments([]ExperimentConfig{{Name: "rule", B: []string{"erp"}}}, plugins)
+	assert.EqualError(t, err, `experiment rule: "erp" is not a transform plugin`)
+	_, err = newExperiments([]ExperimentConfig{{Name: "rule", Percent: 101}}, plugins)
+	assert.Error(t, err)
+	_, err = newExperiments([]ExperimentConfig{{Name: "rule"}, {Name: "rule"}}, plugins)
+	assert.Error(t, err)
+}
+
+func TestExperimentVariant_SlicesAndPercent(t *testing.T) {
+	start := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	e := &experiment{config: ExperimentConfig{Name: "rule", Percent: 30}.withDefaults()}
+
+	// the variant holds for a whole slice
+	assert.Equal(t, e.variant(start), e.variant(start.Add(9*time.Minute)))
+
+	b := 0
+	for i := 0; i < 1000; i++ {
+		if e.variant(start.Add(time.Duration(i)*10*time.Minute)) == "B" {
+			b++
+		}
+	}
+	assert.InDelta(t, 300, b, 60)
+
+	e.config.Percent = 0
+	assert.Equal(t, "A", e.variant(start))
+	e.config.Percent = 100
+	assert.Equal(t, "B", e.variant(start))
+}
+
+func TestApplyTransforms_Experiment(t *testing.T) {
+	oldPlugins, oldExperiments := transformPlugins, experiments
+	defer func() { transformPlugins, experiments = oldPlugins, oldExperiments }()
+	transformPlugins = []payloadTransform{suffixTransform{"-trim"}, suffixTransform{"-old"}, suffixTransform{"-new"}}
+	plugins := []PluginConfig{{Name: "-trim", Type: "transform"}, {Name: "-old", Type: "transform"}, {Name: "-new", Type: "transform"}}
+
+	experiments, _ = newExperiments([]ExperimentConfig{{Name: "rule", Device: "scanner0", A: []string{"-old"}, B: []string{"-new"}, Percent: 100}}, plugins)
+	payload, ok := applyTransforms(Payload{ItemID: "12345", DeviceType: "scanner0"})
+	assert.True(t, ok)
+	assert.Equal(t, Payload{ItemID: "12345-trim-new", DeviceType: "scanner0", Variant: "rule:B"}, payload)
+
+	// other devices keep the control variant and are not tagged
+	payload, _ = applyTransforms(Payload{ItemID: "12345", DeviceType: "scanner1"})
+	assert.Equal(t, Payload{ItemID: "12345-trim-old", DeviceType: "scanner1"}, payload)
+
+	experiments[0].config.Percent = 0
+	payload, _ = applyTransforms(Payload{ItemID: "12345", DeviceType: "scanner0"})
+	assert.Equal(t, Payload{ItemID: "12345-trim-old", DeviceType: "scanner0", Variant: "rule:A"}, payload)
+}
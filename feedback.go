@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"sync"
+	"text/template"
+	"time"
+)
+
+// FeedbackConfig represents operator guidance built from the API's response to each scan
+type FeedbackConfig struct {
+	// Template is a Go template over the JSON response, such as "Bin {{.bin}} - place item there"
+	Template string `json:"template"`
+	// Port is a serial port or pseudo-terminal, as for serialOutput, that each
+	// message is written to, for a pole display or LED sign
+	Port string `json:"port"`
+	// Suffix ends each message written to Port (default "\r\n")
+	Suffix *string `json:"suffix"`
+}
+
+// feedbackDisplay renders and shows feedback messages. A nil display shows nothing.
+type feedbackDisplay struct {
+	config   FeedbackConfig
+	template *template.Template
+	mu       sync.Mutex
+	port     io.WriteCloser
+}
+
+var feedback *feedbackDisplay
+
+func newFeedbackDisplay(config FeedbackConfig) (*feedbackDisplay, error) {
+	t, err := template.New("feedback").Option("missingkey=error").Parse(config.Template)
+	if err != nil {
+		return nil, err
+	}
+	f := &feedbackDisplay{config: config, template: t}
+	if config.Port != "" {
+		port, err := openSerialOutput(config.Port)
+		if err != nil {
+			return nil, err
+		}
+		f.port = port
+	}
+	return f, nil
+}
+
+// show renders the template over the response to a payload and shows the message
+func (f *feedbackDisplay) show(payload Payload, body []byte) {
+	if f == nil {
+		return
+	}
+	var response interface{}
+	if err := json.Unmarshal(body, &response); err != nil {
+		logger.Warnf("No feedback for %s: response is not JSON", payload.ItemID)
+		return
+	}
+	f.render(payload, response)
+}
+
+// showBatch shows a message per payload when the response to a batch is an array in batch order
+func (f *feedbackDisplay) showBatch(batch []Payload, body []byte) {
+	if f == nil {
+		return
+	}
+	var items []interface{}
+	if json.Unmarshal(body, &items) != nil || len(items) != len(batch) {
+		logger.Warnf("No feedback for batch of %d payloads: response is not an array in batch order", len(batch))
+		return
+	}
+	for i, payload := range batch {
+		f.render(payload, items[i])
+	}
+}
+
+func (f *feedbackDisplay) render(payload Payload, response interface{}) {
+	var message bytes.Buffer
+	if err := f.template.Execute(&message, response); err != nil {
+		logger.Warnf("No feedback for %s: %v", payload.ItemID, err)
+		return
+	}
+	logger.Infof("Feedback for %s: %s", payload.ItemID, message.String())
+	recent.addFeedback(payload, message.String(), time.Now())
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.port == nil {
+		return
+	}
+	suffix := "\r\n"
+	if f.config.Suffix != nil {
+		suffix = *f.config.Suffix
+	}
+	if _, err := io.WriteString(f.port, message.String()+suffix); err != nil {
+		logger.Errorf("Error writing feedback to %s: %v", f.config.Port, err)
+	}
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFeedbackDisplay(t *testing.T) {
+	oldRecent := recent
+	defer func() { recent = oldRecent }()
+	recent = &recentEvents{}
+
+	f, err := newFeedbackDisplay(FeedbackConfig{Template: "Bin {{.bin}} - place item there"})
+	assert.NoError(t, err)
+	f.show(Payload{ItemID: "1", DeviceType: "scanner0"}, []byte(`{"bin": "B-12"}`))
+	f.show(Payload{ItemID: "2"}, []byte(`{"shelf": 4}`))
+	f.show(Payload{ItemID: "3"}, []byte(`OK`))
+	f.showBatch([]Payload{{ItemID: "4"}, {ItemID: "5"}}, []byte(`[{"bin": 7}, {"bin": 8}]`))
+
+	var messages []string
+	for _, fb := range recent.snapshot().Feedback {
+		messages = append(messages, fb.ItemID+": "+fb.Message)
+	}
+	assert.Equal(t, []string{"1: Bin B-12 - place item there", "4: Bin 7 - place item there", "5: Bin 8 - place item there"}, messages)
+
+	_, err = newFeedbackDisplay(FeedbackConfig{Template: "{{.bin"})
+	assert.Error(t, err)
+	(*feedbackDisplay)(nil).show(Payload{}, nil)
+}
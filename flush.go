@@ -0,0 +1,165 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/zachthieme/scanandpost/scan"
+)
+
+// failuresMu serializes appends to failures.log with replays that rewrite it
+var failuresMu sync.Mutex
+
+// FlushResult reports the outcome of a flush
+type FlushResult struct {
+	Delivered int `json:"delivered"`
+	Remaining int `json:"remaining"`
+	// Poisoned counts payloads moved to deadletter.log because the API kept rejecting them
+	Poisoned int `json:"poisoned,omitempty"`
+	// Throttled counts payloads left queued because the retry budget was exhausted
+	Throttled int `json:"throttled,omitempty"`
+}
+
+// flushDeadline is how long a flush may take before the rest stays queued
+func (c *Config) flushDeadline() time.Duration {
+	if c.FlushDeadlineSeconds <= 0 {
+		return 10 * time.Second
+	}
+	return time.Duration(c.FlushDeadlineSeconds) * time.Second
+}
+
+// sendPayload posts a payload to the API, returning the response code and body
+func sendPayload(config *Config, payload Payload) (int, []byte, error) {
+	jsonData, err := json.Marshal(payload)
+	if err != nil {
+		return 0, nil, err
+	}
+	resp, err := httpPost(config.APIEndpoint, "application/json", newDeviceBody(payload.DeviceType, jsonData))
+	if err != nil {
+		return 0, nil, err
+	}
+	body := readResponseBody(resp)
+	if !scan.Accepted(resp.StatusCode, false) {
+		return resp.StatusCode, body, fmt.Errorf("response code: %d", resp.StatusCode)
+	}
+	return resp.StatusCode, body, nil
+}
+
+// replayFailures posts the payloads saved in failures.log until the deadline,
+// keeping the ones that could not be delivered
+func replayFailures(config *Config, deadline time.Time) (FlushResult, error) {
+	failuresMu.Lock()
+	defer failuresMu.Unlock()
+
+	var result FlushResult
+	data, err := os.ReadFile(failuresFile)
+	if os.IsNotExist(err) {
+		return result, nil
+	} else if err != nil {
+		return result, err
+	}
+
+	var remaining []string
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		payload, _, err := decodeQueueEntry(line)
+		if err != nil {
+			logger.Errorf("Keeping unreadable failures.log entry %q: %v", line, err)
+			remaining = append(remaining, line)
+			continue
+		}
+		if time.Now().After(deadline) {
+			remaining = append(remaining, line)
+			continue
+		}
+		if !retryBudget.allow(time.Now()) {
+			result.Throttled++
+			remaining = append(remaining, line)
+			continue
+		}
+		statusCode, body, err := sendPayload(config, payload)
+		if err != nil {
+			bus.postFailed.publish(PostFailed{Payloads: []Payload{payload}, StatusCode: statusCode, Replay: true})
+			logger.Warnf("Replay of payload %v failed: %v", payload, err)
+			if checkPoison(config, line, payload, statusCode) {
+				result.Poisoned++
+				continue
+			}
+			remaining = append(remaining, line)
+			continue
+		}
+		bus.postSucceeded.publish(PostSucceeded{Payloads: []Payload{payload}, StatusCode: statusCode, Response: body, Replay: true})
+		delete(poisonAttempts, line)
+		result.Delivered++
+	}
+	result.Remaining = len(remaining)
+
+	content := strings.Join(remaining, "\n")
+	if content != "" {
+		content += "\n"
+	}
+	return result, writeFileAtomic(failuresFile, []byte(content))
+}
+
+// flushAll sends everything queued in memory and on disk, giving up at the deadline
+func flushAll(config *Config, timeout time.Duration) (FlushResult, error) {
+	deadline := time.Now().Add(timeout)
+	if apiBatcher != nil {
+		apiBatcher.flush(timeout)
+	}
+	// a standby's failures.log may hold scans the active station took over and delivers
+	if pair.passive() {
+		logger.Infof("Not replaying %s while acting as the standby", failuresFile)
+		return FlushResult{}, nil
+	}
+	result, err := replayFailures(config, deadline)
+	if err != nil {
+		logger.Errorf("Error flushing failures.log: %v", err)
+	} else {
+		logger.Infof("Flush delivered %d payloads, %d remain queued, %d dead-lettered as poison", result.Delivered, result.Remaining, result.Poisoned)
+		if result.Throttled > 0 {
+			logger.Warnf("Flush held back %d payloads over the retry budget", result.Throttled)
+		}
+	}
+	return result, err
+}
+
+// requestFlush asks the running service to flush through its admin API, and
+// falls back to flushing failures.log directly when the service is not reachable
+func requestFlush(config *Config) (FlushResult, error) {
+	var result FlushResult
+	if config.Admin.Listen != "" {
+		addr := config.Admin.Listen
+		if strings.HasPrefix(addr, ":") {
+			addr = "127.0.0.1" + addr
+		}
+		client := newAdminClient(config, config.flushDeadline()+5*time.Second)
+		resp, err := client.Post("http://"+addr+"/flush", "application/json", nil)
+		if err == nil {
+			defer resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				return result, fmt.Errorf("flush failed with response code: %d", resp.StatusCode)
+			}
+			err = json.NewDecoder(resp.Body).Decode(&result)
+			return result, err
+		}
+		logger.Warnf("Service not reachable on %s, flushing directly: %v", addr, err)
+	}
+	// a service that runs but cannot be reached still replays failures.log
+	if err := acquireInstanceLock(config.instanceName()); err != nil {
+		return result, fmt.Errorf("not flushing directly: %v", err)
+	}
+	defer instanceLock.release()
+	return flushAll(config, config.flushDeadline())
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/zachthieme/scanandpost/scan"
+)
+
+func TestReplayFailures_KeepsUndelivered(t *testing.T) {
+	useTempQueue(t)
+
+	oldPost := httpPost
+	defer func() { httpPost = oldPost }()
+	httpPost = func(url, contentType string, body io.Reader) (*http.Response, error) {
+		var payload Payload
+		json.NewDecoder(body).Decode(&payload)
+		if payload.ItemID == "bad" {
+			return &http.Response{StatusCode: http.StatusInternalServerError}, nil
+		}
+		return &http.Response{StatusCode: http.StatusOK}, nil
+	}
+
+	logFailure(Payload{ItemID: "good", DeviceType: "scanner0"})
+	logFailure(Payload{ItemID: "bad", DeviceType: "scanner0"})
+
+	result, err := replayFailures(&Config{APIEndpoint: "http://example.com"}, time.Now().Add(time.Minute))
+	assert.NoError(t, err)
+	assert.Equal(t, FlushResult{Delivered: 1, Remaining: 1}, result)
+
+	data, _ := os.ReadFile(failuresFile)
+	payload, queuedAt, err := decodeQueueEntry(strings.TrimSuffix(string(data), "\n"))
+	assert.NoError(t, err)
+	assert.Equal(t, Payload{ItemID: "bad", DeviceType: "scanner0"}, payload)
+	assert.False(t, queuedAt.IsZero())
+}
+
+func TestReplayFailures_StopsAtDeadline(t *testing.T) {
+	useTempQueue(t)
+
+	logFailure(Payload{ItemID: "1"})
+	result, err := replayFailures(&Config{}, time.Now().Add(-time.Second))
+	assert.NoError(t, err)
+	assert.Equal(t, FlushResult{Remaining: 1}, result)
+}
+
+func TestBatcher_Flush(t *testing.T) {
+	posted := make(chan []Payload, 1)
+	oldPost := httpPost
+	defer func() { httpPost = oldPost }()
+	httpPost = func(url, contentType string, body io.Reader) (*http.Response, error) {
+		var batch []Payload
+		json.NewDecoder(body).Decode(&batch)
+		posted <- batch
+		return &http.Response{StatusCode: http.StatusOK}, nil
+	}
+
+	b := newBatcher(BatchingConfig{Endpoint: "http://example.com/batch", MaxBatchSize: 10, MaxFlushMillis: 60000, FastMillis: 100})
+	b.latency = func() time.Duration { return time.Minute }
+	go b.run()
+	b.add(Payload{ItemID: "1"})
+	b.flush(5 * time.Second)
+
+	select {
+	case batch := <-posted:
+		assert.Equal(t, []Payload{{ItemID: "1"}}, batch)
+	default:
+		t.Fatal("flush returned before the batch was posted")
+	}
+}
+
+func TestAdminFlush(t *testing.T) {
+	useTempQueue(t)
+
+	w := httptest.NewRecorder()
+	adminMux(&Config{}).ServeHTTP(w, localRequest(http.MethodPost, "/flush"))
+	assert.Equal(t, http.StatusOK, w.Code)
+	var result FlushResult
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &result))
+	assert.Equal(t, FlushResult{}, result)
+
+	w = httptest.NewRecorder()
+	adminMux(&Config{}).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/flush", nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+}
+
+func TestRequestFlush_RefusesWhileServiceRuns(t *testing.T) {
+	useTempQueue(t)
+	logFailure(Payload{ItemID: "1"})
+	// a running service that cannot be reached still holds the instance lock
+	lock, err := lockInstance("test-flush")
+	assert.NoError(t, err)
+	defer lock.release()
+
+	_, err = requestFlush(&Config{InstanceName: "test-flush"})
+	assert.ErrorContains(t, err, "not flushing directly")
+	data, _ := os.ReadFile(failuresFile)
+	assert.Contains(t, string(data), `"1"`)
+}
+
+func TestSendPayload_SameSuccessRuleAsHTTPOutput(t *testing.T) {
+	for _, code := range []int{http.StatusOK, http.StatusCreated, http.StatusNoContent, http.StatusInternalServerError} {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(code)
+		}))
+		_, _, err := sendPayload(&Config{APIEndpoint: server.URL}, Payload{ItemID: "1"})
+		outputErr := (&scan.HTTPOutput{Endpoint: server.URL}).Deliver(Payload{ItemID: "1"})
+		assert.Equal(t, err == nil, outputErr == nil, "response code %d", code)
+		server.Close()
+	}
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/zachthieme/scanandpost/scan"
+)
+
+// GeotagConfig represents attaching a coarse location to every payload
+type GeotagConfig struct {
+	Enabled bool `json:"enabled"`
+	// Source is "static" (default) for the site coordinates below, or "windows"
+	// for the Windows Location API, falling back to the site coordinates
+	Source    string  `json:"source"`
+	Site      string  `json:"site"`
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+	// Decimals coordinates are rounded to, 3 by default (about 100 m)
+	Decimals int `json:"decimals"`
+	// RefreshSeconds is how often the location is read from the operating system
+	RefreshSeconds int `json:"refreshSeconds"`
+}
+
+// Location is the coarse position attached to a payload
+type Location = scan.Location
+
+func (g GeotagConfig) withDefaults() GeotagConfig {
+	if g.Source == "" {
+		g.Source = "static"
+	}
+	if g.Decimals <= 0 {
+		g.Decimals = 3
+	}
+	if g.RefreshSeconds <= 0 {
+		g.RefreshSeconds = 60
+	}
+	return g
+}
+
+// geotagger keeps the latest known location
+type geotagger struct {
+	config GeotagConfig
+	mu     sync.Mutex
+	fix    *Location
+}
+
+var geotag *geotagger
+
+func newGeotagger(config GeotagConfig) (*geotagger, error) {
+	config = config.withDefaults()
+	switch config.Source {
+	case "static", "windows":
+	default:
+		return nil, fmt.Errorf("unknown geotag source %q", config.Source)
+	}
+	return &geotagger{config: config}, nil
+}
+
+// site returns the configured site coordinates, if any
+func (g *geotagger) site() *Location {
+	if g.config.Latitude == 0 && g.config.Longitude == 0 {
+		return nil
+	}
+	return &Location{Latitude: g.config.Latitude, Longitude: g.config.Longitude, Site: g.config.Site, Source: "static", Time: time.Now()}
+}
+
+// current returns the rounded location to attach, or nil when none is known
+func (g *geotagger) current() *Location {
+	if g == nil {
+		return nil
+	}
+	g.mu.Lock()
+	fix := g.fix
+	g.mu.Unlock()
+	if fix == nil {
+		fix = g.site()
+	}
+	if fix == nil {
+		return nil
+	}
+	rounded := *fix
+	scale := math.Pow(10, float64(g.config.Decimals))
+	rounded.Latitude = math.Round(rounded.Latitude*scale) / scale
+	rounded.Longitude = math.Round(rounded.Longitude*scale) / scale
+	return &rounded
+}
+
+// watch refreshes the location from the operating system until the process exits
+func (g *geotagger) watch() {
+	if g.config.Source != "windows" {
+		return
+	}
+	for {
+		fix, err := readSystemLocation()
+		if err != nil {
+			logger.Warnf("Error reading location, using the site coordinates: %v", err)
+		} else {
+			fix.Site = g.config.Site
+			g.mu.Lock()
+			g.fix = &fix
+			g.mu.Unlock()
+		}
+		time.Sleep(time.Duration(g.config.RefreshSeconds) * time.Second)
+	}
+}
+
+// parseLocationFix parses "latitude longitude accuracy" as printed by the location query
+func parseLocationFix(out string) (Location, error) {
+	fields := strings.Fields(out)
+	if len(fields) != 3 {
+		return Location{}, fmt.Errorf("unexpected location output %q", strings.TrimSpace(out))
+	}
+	var values [3]float64
+	for i, field := range fields {
+		v, err := strconv.ParseFloat(field, 64)
+		if err != nil || math.IsNaN(v) {
+			return Location{}, fmt.Errorf("unexpected location output %q", strings.TrimSpace(out))
+		}
+		values[i] = v
+	}
+	return Location{Latitude: values[0], Longitude: values[1], AccuracyMeters: values[2], Source: "windows", Time: time.Now()}, nil
+}
@@ -0,0 +1,12 @@
+//go:build !windows
+
+package main
+
+import (
+	"fmt"
+	"runtime"
+)
+
+func readSystemLocation() (Location, error) {
+	return Location{}, fmt.Errorf("the system location source is not supported on %s", runtime.GOOS)
+}
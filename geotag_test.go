@@ -0,0 +1,45 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGeotagger_StaticSiteRounded(t *testing.T) {
+	g, err := newGeotagger(GeotagConfig{Enabled: true, Site: "Yard A", Latitude: 41.878113, Longitude: -87.629799})
+	assert.NoError(t, err)
+	loc := g.current()
+	assert.Equal(t, 41.878, loc.Latitude)
+	assert.Equal(t, -87.63, loc.Longitude)
+	assert.Equal(t, "Yard A", loc.Site)
+	assert.Equal(t, "static", loc.Source)
+}
+
+func TestGeotagger_PrefersSystemFix(t *testing.T) {
+	g, _ := newGeotagger(GeotagConfig{Source: "windows", Decimals: 2, Latitude: 1, Longitude: 1})
+	fix, err := parseLocationFix("41.8781 -87.6298 25\r\n")
+	assert.NoError(t, err)
+	g.fix = &fix
+	loc := g.current()
+	assert.Equal(t, 41.88, loc.Latitude)
+	assert.Equal(t, -87.63, loc.Longitude)
+	assert.Equal(t, 25.0, loc.AccuracyMeters)
+	assert.Equal(t, "windows", loc.Source)
+}
+
+func TestGeotagger_NoLocation(t *testing.T) {
+	var disabled *geotagger
+	assert.Nil(t, disabled.current())
+	g, _ := newGeotagger(GeotagConfig{Enabled: true})
+	assert.Nil(t, g.current())
+	_, err := newGeotagger(GeotagConfig{Source: "gps"})
+	assert.Error(t, err)
+}
+
+func TestParseLocationFix_Invalid(t *testing.T) {
+	_, err := parseLocationFix("NaN NaN NaN")
+	assert.Error(t, err)
+	_, err = parseLocationFix("41,87 -87,62 25")
+	assert.Error(t, err)
+}
@@ -0,0 +1,31 @@
+//go:build windows
+
+package main
+
+import (
+	"context"
+	"os/exec"
+	"time"
+)
+
+// locationScript asks the Windows Location API for the current position
+const locationScript = `Add-Type -AssemblyName System.Device
+$w = New-Object System.Device.Location.GeoCoordinateWatcher
+$w.Start()
+$deadline = (Get-Date).AddSeconds(15)
+while ($w.Status -ne 'Ready' -and (Get-Date) -lt $deadline) { Start-Sleep -Milliseconds 200 }
+$c = $w.Position.Location
+$w.Stop()
+if ($c.IsUnknown) { exit 1 }
+[string]::Format([Globalization.CultureInfo]::InvariantCulture, '{0} {1} {2}', $c.Latitude, $c.Longitude, $c.HorizontalAccuracy)`
+
+// readSystemLocation reads a fix from the Windows Location API
+func readSystemLocation() (Location, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	out, err := exec.CommandContext(ctx, "powershell", "-NoProfile", "-NonInteractive", "-Command", locationScript).Output()
+	if err != nil {
+		return Location{}, err
+	}
+	return parseLocationFix(string(out))
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"time"
+)
+
+// Heartbeat is the envelope of a delta-encoded heartbeat. A full heartbeat
+// carries every status field; a delta carries "time", "hostname" and the
+// fields that changed since the previous heartbeat, with null for fields that
+// were removed. See docs/heartbeat-schema.json.
+type Heartbeat struct {
+	Type   string                     `json:"type"`
+	Seq    uint64                     `json:"seq"`
+	Status map[string]json.RawMessage `json:"status"`
+}
+
+// heartbeatEncoder turns successive status snapshots into full and delta heartbeats
+type heartbeatEncoder struct {
+	fullEvery time.Duration
+	seq       uint64
+	last      map[string]json.RawMessage
+	lastFull  time.Time
+}
+
+func newHeartbeatEncoder(config HeartbeatConfig) *heartbeatEncoder {
+	fullEvery := time.Duration(config.FullEveryMinutes) * time.Minute
+	if fullEvery <= 0 {
+		fullEvery = 15 * time.Minute
+	}
+	return &heartbeatEncoder{fullEvery: fullEvery}
+}
+
+// next encodes the status as a full heartbeat when none has been sent since
+// start or a failure, or when fullEvery has passed, and as a delta otherwise
+func (e *heartbeatEncoder) next(status Status, now time.Time) (Heartbeat, error) {
+	data, err := json.Marshal(status)
+	if err != nil {
+		return Heartbeat{}, err
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return Heartbeat{}, err
+	}
+	e.seq++
+	heartbeat := Heartbeat{Type: "full", Seq: e.seq, Status: fields}
+	if e.last != nil && now.Sub(e.lastFull) < e.fullEvery {
+		heartbeat.Type = "delta"
+		heartbeat.Status = map[string]json.RawMessage{"time": fields["time"], "hostname": fields["hostname"]}
+		for key, value := range fields {
+			if !bytes.Equal(e.last[key], value) {
+				heartbeat.Status[key] = value
+			}
+		}
+		for key := range e.last {
+			if _, ok := fields[key]; !ok {
+				heartbeat.Status[key] = json.RawMessage("null")
+			}
+		}
+	} else {
+		e.lastFull = now
+	}
+	e.last = fields
+	return heartbeat, nil
+}
+
+// reset makes the next heartbeat full, because the backend may have missed the last one
+func (e *heartbeatEncoder) reset() {
+	e.last = nil
+}
+
+// sendHeartbeats periodically posts the station status to the heartbeat endpoint
+func sendHeartbeats(config *Config) {
+	interval := time.Duration(config.Heartbeat.Interval) * time.Second
+	if interval <= 0 {
+		interval = time.Minute
+	}
+	encoder := newHeartbeatEncoder(config.Heartbeat)
+	for {
+		var data []byte
+		var err error
+		if config.Heartbeat.Delta {
+			var heartbeat Heartbeat
+			if heartbeat, err = encoder.next(currentStatus(config), time.Now()); err == nil {
+				data, err = json.Marshal(heartbeat)
+			}
+		} else {
+			data, err = json.Marshal(currentStatus(config))
+		}
+		if err != nil {
+			logger.Errorf("Error marshaling heartbeat: %v", err)
+		} else if resp, err := apiClient.Post(config.Heartbeat.Endpoint, "application/json", bytes.NewBuffer(data)); err != nil {
+			logger.Warnf("Error sending heartbeat: %v", err)
+			encoder.reset()
+		} else {
+			body := readResponseBody(resp)
+			if resp.StatusCode < 200 || resp.StatusCode > 299 {
+				logger.Warnf("Heartbeat rejected with response code: %d", resp.StatusCode)
+				encoder.reset()
+			} else {
+				handleHeartbeatResponse(body)
+			}
+		}
+		time.Sleep(interval)
+	}
+}
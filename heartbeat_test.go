@@ -0,0 +1,65 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHeartbeatEncoder(t *testing.T) {
+	encoder := newHeartbeatEncoder(HeartbeatConfig{FullEveryMinutes: 10})
+	now := time.Now()
+	status := Status{Time: now, Hostname: "station1", ScansReceived: 1, Devices: []DeviceStatus{{Name: "scanner0", Connected: true}}}
+
+	heartbeat, err := encoder.next(status, now)
+	assert.NoError(t, err)
+	assert.Equal(t, "full", heartbeat.Type)
+	assert.Equal(t, uint64(1), heartbeat.Seq)
+	assert.Contains(t, heartbeat.Status, "devices")
+
+	degraded := now
+	status.Time, status.ScansReceived, status.DegradedSince = now.Add(time.Minute), 2, &degraded
+	heartbeat, _ = encoder.next(status, now.Add(time.Minute))
+	assert.Equal(t, "delta", heartbeat.Type)
+	assert.ElementsMatch(t, []string{"time", "hostname", "scansReceived", "degradedSince"}, keys(heartbeat.Status))
+
+	status.DegradedSince = nil
+	heartbeat, _ = encoder.next(status, now.Add(2*time.Minute))
+	assert.Equal(t, json.RawMessage("null"), heartbeat.Status["degradedSince"])
+
+	// a full snapshot is due every 10 minutes and after a failed post
+	heartbeat, _ = encoder.next(status, now.Add(11*time.Minute))
+	assert.Equal(t, "full", heartbeat.Type)
+	heartbeat, _ = encoder.next(status, now.Add(12*time.Minute))
+	assert.Equal(t, "delta", heartbeat.Type)
+	encoder.reset()
+	heartbeat, _ = encoder.next(status, now.Add(13*time.Minute))
+	assert.Equal(t, "full", heartbeat.Type)
+	assert.Equal(t, uint64(6), heartbeat.Seq)
+}
+
+func TestHeartbeatSchema(t *testing.T) {
+	schema, err := loadPayloadSchema("docs/heartbeat-schema.json")
+	assert.NoError(t, err)
+	encoder := newHeartbeatEncoder(HeartbeatConfig{})
+	status := Status{Time: time.Now(), Hostname: "station1", Health: healthGreen, Devices: []DeviceStatus{{Name: "scanner0", Connected: true}}}
+	for i := 0; i < 2; i++ {
+		heartbeat, _ := encoder.next(status, time.Now())
+		data, _ := json.Marshal(heartbeat)
+		var value interface{}
+		json.Unmarshal(data, &value)
+		var problems []string
+		schema.validate("", value, &problems)
+		assert.Empty(t, problems, heartbeat.Type)
+	}
+}
+
+func keys(m map[string]json.RawMessage) []string {
+	var result []string
+	for key := range m {
+		result = append(result, key)
+	}
+	return result
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/zachthieme/scanandpost/scan"
+)
+
+// IngestConfig represents the HTTP listener other LAN hosts submit scans to
+type IngestConfig struct {
+	Listen string `json:"listen"`
+	Path   string `json:"path"`
+	// HMACSecret enables signature verification of every submission
+	HMACSecret string `json:"hmacSecret"`
+	// ReplayProtection requires a fresh timestamp and unique nonce per submission
+	ReplayProtection bool `json:"replayProtection"`
+	MaxSkewSeconds   int  `json:"maxSkewSeconds"`
+	// Dedupe drops handoff scans of the same item by different stations
+	Dedupe RelayDedupeConfig `json:"dedupe"`
+}
+
+// Headers carrying the replay protection fields of a submission
+const (
+	headerTimestamp = "X-Scan-Timestamp"
+	headerNonce     = "X-Scan-Nonce"
+	headerSignature = "X-Scan-Signature"
+)
+
+func (i IngestConfig) maxSkew() time.Duration {
+	if i.MaxSkewSeconds <= 0 {
+		return 5 * time.Minute
+	}
+	return time.Duration(i.MaxSkewSeconds) * time.Second
+}
+
+// nonceCache remembers nonces until their timestamps fall outside the skew window
+type nonceCache struct {
+	mu   sync.Mutex
+	seen map[string]time.Time
+}
+
+// add records a nonce, returning false if it was already used
+func (c *nonceCache) add(nonce string, expires, now time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for n, exp := range c.seen {
+		if now.After(exp) {
+			delete(c.seen, n)
+		}
+	}
+	if _, ok := c.seen[nonce]; ok {
+		return false
+	}
+	c.seen[nonce] = expires
+	return true
+}
+
+// ingestHandler accepts scan submissions and forwards them to the pipeline
+type ingestHandler struct {
+	config    IngestConfig
+	nonces    *nonceCache
+	dedupe    *relayDedupe
+	payloadCh chan Payload
+	now       func() time.Time
+}
+
+func newIngestHandler(config IngestConfig, payloadCh chan Payload) *ingestHandler {
+	return &ingestHandler{
+		config:    config,
+		nonces:    &nonceCache{seen: map[string]time.Time{}},
+		payloadCh: payloadCh,
+		now:       time.Now,
+	}
+}
+
+// signIngestRequest computes the signature a submitter sends in X-Scan-Signature
+func signIngestRequest(secret, timestamp, nonce string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%s\n%s\n", timestamp, nonce)
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// verify checks the signature, timestamp and nonce of a submission
+func (h *ingestHandler) verify(r *http.Request, body []byte) error {
+	timestamp, nonce := r.Header.Get(headerTimestamp), r.Header.Get(headerNonce)
+
+	if h.config.HMACSecret != "" {
+		expected := signIngestRequest(h.config.HMACSecret, timestamp, nonce, body)
+		if !hmac.Equal([]byte(expected), []byte(r.Header.Get(headerSignature))) {
+			return fmt.Errorf("invalid signature")
+		}
+	}
+
+	if h.config.ReplayProtection {
+		seconds, err := strconv.ParseInt(timestamp, 10, 64)
+		if err != nil {
+			return fmt.Errorf("missing or invalid %s", headerTimestamp)
+		}
+		now, sent := h.now(), time.Unix(seconds, 0)
+		if sent.Before(now.Add(-h.config.maxSkew())) || sent.After(now.Add(h.config.maxSkew())) {
+			return fmt.Errorf("timestamp outside the allowed window")
+		}
+		if nonce == "" {
+			return fmt.Errorf("missing %s", headerNonce)
+		}
+		if !h.nonces.add(nonce, sent.Add(h.config.maxSkew()), now) {
+			return fmt.Errorf("nonce already used")
+		}
+	}
+	return nil
+}
+
+func (h *ingestHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, 64*1024))
+	if err != nil {
+		http.Error(w, "error reading body", http.StatusBadRequest)
+		return
+	}
+	if err := h.verify(r, body); err != nil {
+		logger.Warnf("Rejected ingestion request from %s: %v", r.RemoteAddr, err)
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	var payload Payload
+	if err := scan.Unmarshal(r.Header.Get("Content-Type"), body, &payload); err != nil || payload.ItemID == "" {
+		http.Error(w, "invalid payload", http.StatusBadRequest)
+		return
+	}
+	if payload.DeviceType == "" {
+		payload.DeviceType = "ingest"
+	}
+	station := stationOf(r)
+	if first, dup := h.dedupe.duplicate(payload.ItemID, station, h.now()); dup {
+		logger.Infof("Dropped handoff scan of %s from %s, already scanned by %s at %s",
+			payload.ItemID, station, first.station, first.at.Format(time.RFC3339))
+		w.Header().Set("X-Scan-Duplicate", "true")
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	h.payloadCh <- payload
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// serveIngest runs the ingestion listener
+func serveIngest(config *Config, payloadCh chan Payload) {
+	path := config.Ingest.Path
+	if path == "" {
+		path = "/scan"
+	}
+	handler := newIngestHandler(config.Ingest, payloadCh)
+	dedupe, err := newRelayDedupe(config.Ingest.Dedupe)
+	if err != nil {
+		logger.Errorf("Error configuring relay dedupe: %v", err)
+		return
+	}
+	handler.dedupe = dedupe
+	mux := http.NewServeMux()
+	mux.Handle(path, handler)
+	if rollups != nil {
+		rollupPath := config.Rollup.withDefaults(config.Heartbeat.Endpoint).Path
+		mux.Handle(rollupPath, rollups)
+		logger.Infof("Collecting station heartbeats on %s%s", config.Ingest.Listen, rollupPath)
+	}
+	logger.Infof("Ingestion listener on %s%s", config.Ingest.Listen, path)
+	if err := http.ListenAndServe(config.Ingest.Listen, mux); err != nil {
+		logger.Errorf("Error running ingestion listener: %v", err)
+	}
+}
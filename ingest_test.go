@@ -0,0 +1,72 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/zachthieme/scanandpost/scan"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func ingestRequest(secret, timestamp, nonce, body string) *http.Request {
+	req := httptest.NewRequest(http.MethodPost, "/scan", strings.NewReader(body))
+	req.Header.Set(headerTimestamp, timestamp)
+	req.Header.Set(headerNonce, nonce)
+	req.Header.Set(headerSignature, signIngestRequest(secret, timestamp, nonce, []byte(body)))
+	return req
+}
+
+func TestIngestHandler_AcceptsSignedRequest(t *testing.T) {
+	payloadCh := make(chan Payload, 1)
+	h := newIngestHandler(IngestConfig{HMACSecret: "secret", ReplayProtection: true}, payloadCh)
+	now := strconv.FormatInt(time.Now().Unix(), 10)
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, ingestRequest("secret", now, "n1", `{"itemid":"12345"}`))
+	assert.Equal(t, http.StatusAccepted, w.Code)
+	assert.Equal(t, Payload{ItemID: "12345", DeviceType: "ingest"}, <-payloadCh)
+}
+
+func TestIngestHandler_RejectsForgedAndReplayedRequests(t *testing.T) {
+	payloadCh := make(chan Payload, 1)
+	h := newIngestHandler(IngestConfig{HMACSecret: "secret", ReplayProtection: true}, payloadCh)
+	now := strconv.FormatInt(time.Now().Unix(), 10)
+	stale := strconv.FormatInt(time.Now().Add(-time.Hour).Unix(), 10)
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, ingestRequest("wrong", now, "n1", `{"itemid":"12345"}`))
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, ingestRequest("secret", stale, "n2", `{"itemid":"12345"}`))
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, ingestRequest("secret", now, "n3", `{"itemid":"12345"}`))
+	assert.Equal(t, http.StatusAccepted, w.Code)
+	<-payloadCh
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, ingestRequest("secret", now, "n3", `{"itemid":"12345"}`))
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Contains(t, w.Body.String(), "nonce already used")
+}
+
+func TestIngestHandler_AcceptsCBOR(t *testing.T) {
+	payloadCh := make(chan Payload, 1)
+	h := newIngestHandler(IngestConfig{}, payloadCh)
+	body, contentType, _ := scan.Marshal(scan.EncodingCBOR, Payload{ItemID: "12345", DeviceType: "scanner1"})
+	req := httptest.NewRequest(http.MethodPost, "/scan", bytes.NewReader(body))
+	req.Header.Set("Content-Type", contentType)
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusAccepted, w.Code)
+	assert.Equal(t, Payload{ItemID: "12345", DeviceType: "scanner1"}, <-payloadCh)
+}
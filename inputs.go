@@ -0,0 +1,163 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// NoInputsConfig represents how the service reacts while no input is active,
+// which would otherwise leave a misconfigured station looking healthy
+type NoInputsConfig struct {
+	// WarnEverySeconds repeats the warning while no input is active (default 60)
+	WarnEverySeconds int `json:"warnEverySeconds"`
+	// ExitAfterSeconds exits with code 3 once no input has been active this long,
+	// for orchestration that restarts or flags the station (default 0, never)
+	ExitAfterSeconds int `json:"exitAfterSeconds"`
+}
+
+func (n NoInputsConfig) withDefaults() NoInputsConfig {
+	if n.WarnEverySeconds <= 0 {
+		n.WarnEverySeconds = 60
+	}
+	return n
+}
+
+const (
+	// exitNoInputs is the exit code when ExitAfterSeconds is reached
+	exitNoInputs = 3
+	// inputCheckInterval is how often the active inputs are counted
+	inputCheckInterval = 5 * time.Second
+)
+
+// Health values reported in the status
+const (
+	healthGreen  = "green"
+	healthYellow = "yellow"
+	healthRed    = "red"
+)
+
+// exitProcess is replaced in tests
+var exitProcess = os.Exit
+
+// noInputs tracks since when no input has been active
+var noInputs = &noInputsState{}
+
+type noInputsState struct {
+	mu         sync.Mutex
+	since      time.Time
+	lastWarned time.Time
+}
+
+// activeInputs names the inputs that can currently produce scans
+func activeInputs(config *Config) []string {
+	var active []string
+	health.mu.Lock()
+	for i := 0; i < config.NumberOfScanners; i++ {
+		if _, missing := health.deviceMissingSince[i]; !missing {
+			active = append(active, scannerName(i))
+		}
+	}
+	health.mu.Unlock()
+	if config.Keyboard {
+		active = append(active, "keyboard")
+	}
+	if config.Outbox.enabled() {
+		active = append(active, "outbox")
+	}
+	if config.Ingest.Listen != "" {
+		active = append(active, "ingest")
+	}
+	for _, p := range config.Plugins {
+		if p.Type == "input" {
+			active = append(active, "plugin "+p.Name)
+		}
+	}
+	return active
+}
+
+// check updates the state from the active inputs, warns every WarnEverySeconds
+// while there are none, and reports whether ExitAfterSeconds has been reached
+func (s *noInputsState) check(config *Config, now time.Time) bool {
+	cfg := config.NoInputs.withDefaults()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// a standby keeps its inputs closed until it takes over
+	if len(activeInputs(config)) > 0 || pair.passive() {
+		if !s.since.IsZero() {
+			logger.Infof("Inputs are active again after %s", now.Sub(s.since).Round(time.Second))
+		}
+		s.since = time.Time{}
+		return false
+	}
+	if s.since.IsZero() {
+		s.since = now
+	}
+	if now.Sub(s.lastWarned) >= time.Duration(cfg.WarnEverySeconds)*time.Second {
+		logger.Warnf("No inputs are active since %s: no scanner is connected and no other input is configured. Nothing is being captured.", s.since.Format(time.RFC3339))
+		s.lastWarned = now
+	}
+	return cfg.ExitAfterSeconds > 0 && now.Sub(s.since) >= time.Duration(cfg.ExitAfterSeconds)*time.Second
+}
+
+// current returns since when no input has been active, or the zero time
+func (s *noInputsState) current() time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.since
+}
+
+// watchInputs periodically checks that some input is active
+func watchInputs(config *Config) {
+	ticker := time.NewTicker(inputCheckInterval)
+	defer ticker.Stop()
+	for now := range ticker.C {
+		if noInputs.check(config, now) {
+			logger.Errorf("Error: no inputs active for %d seconds, exiting with code %d", config.NoInputs.ExitAfterSeconds, exitNoInputs)
+			exitProcess(exitNoInputs)
+		}
+	}
+}
+
+// healthOf summarizes a status: red while nothing is captured, yellow while
+// posting is degraded, the standby peer is unreachable or a scanner is missing,
+// green otherwise
+func healthOf(status Status) string {
+	if status.NoInputsSince != nil {
+		return healthRed
+	}
+	if status.DegradedSince != nil || status.DiskPressure != nil {
+		return healthYellow
+	}
+	if status.RetryBudget != nil && status.RetryBudget.ExhaustedSince != nil {
+		return healthYellow
+	}
+	if status.Standby != nil && !status.Standby.PeerReachable {
+		return healthYellow
+	}
+	for _, d := range status.Devices {
+		if !d.Connected {
+			return healthYellow
+		}
+	}
+	return healthGreen
+}
+
+// diskPressureAlert is raised while free disk space is below a threshold
+func diskPressureAlert(status *DiskPressureStatus) Alert {
+	return Alert{
+		Key:     "disk-pressure-" + status.Stage,
+		Subject: fmt.Sprintf("disk nearly full (%d MB free)", status.FreeMB),
+		Body:    fmt.Sprintf("Free disk space has been low since %s and is now %d MB. Logging is degraded to stage %q; records skipped so far: %v.", status.Since.Format(time.RFC3339), status.FreeMB, status.Stage, status.Skipped),
+	}
+}
+
+// noInputsAlert is raised while no input is active
+func noInputsAlert(since time.Time) Alert {
+	return Alert{
+		Key:     "no-inputs",
+		Subject: "no active inputs",
+		Body:    fmt.Sprintf("No scanner has been connected and no other input configured since %s. The station is capturing nothing.", since.Format(time.RFC3339)),
+	}
+}
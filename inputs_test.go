@@ -0,0 +1,57 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNoInputsState_Check(t *testing.T) {
+	oldNoInputs := noInputs
+	defer func() { noInputs = oldNoInputs }()
+	noInputs = &noInputsState{}
+	defer markDevicePresent(0)
+
+	now := time.Now()
+	config := &Config{NumberOfScanners: 1, NoInputs: NoInputsConfig{ExitAfterSeconds: 30}}
+	assert.Equal(t, []string{"scanner0"}, activeInputs(config))
+	assert.False(t, noInputs.check(config, now))
+	assert.True(t, noInputs.current().IsZero())
+
+	markDeviceMissing(0)
+	assert.Empty(t, activeInputs(config))
+	assert.False(t, noInputs.check(config, now))
+	assert.Equal(t, now, noInputs.current())
+	assert.Equal(t, healthRed, currentStatus(config).Health)
+	assert.False(t, noInputs.check(config, now.Add(29*time.Second)))
+	assert.True(t, noInputs.check(config, now.Add(30*time.Second)))
+
+	// another input keeps the station capturing
+	config.Keyboard = true
+	assert.False(t, noInputs.check(config, now.Add(time.Minute)))
+	assert.True(t, noInputs.current().IsZero())
+	assert.Equal(t, healthYellow, currentStatus(config).Health)
+}
+
+func TestAdminHealth(t *testing.T) {
+	oldNoInputs := noInputs
+	defer func() { noInputs = oldNoInputs }()
+	noInputs = &noInputsState{}
+	config := &Config{}
+	handler := adminMux(config)
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/health", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, `{"health": "green"}`, rec.Body.String())
+
+	noInputs.check(config, time.Now())
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/health", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	assert.JSONEq(t, `{"health": "red"}`, rec.Body.String())
+	assert.Contains(t, evaluateAlerts(config, time.Now()), noInputsAlert(noInputs.current()))
+}
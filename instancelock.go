@@ -0,0 +1,33 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// defaultInstanceName is used when config does not name the instance
+const defaultInstanceName = "SPCBarcodeService"
+
+// instanceLock is held for the lifetime of the process once acquired
+var instanceLock interface{ release() }
+
+var unsafeLockChars = regexp.MustCompile(`[^A-Za-z0-9_.-]`)
+
+// instanceName returns the configured instance name or the default
+func (c *Config) instanceName() string {
+	if c.InstanceName == "" {
+		return defaultInstanceName
+	}
+	return c.InstanceName
+}
+
+// acquireInstanceLock makes sure no other copy of the service with the same
+// instance name is running, for any user or session on the machine
+func acquireInstanceLock(name string) error {
+	lock, err := lockInstance(unsafeLockChars.ReplaceAllString(name, "_"))
+	if err != nil {
+		return fmt.Errorf("another instance named %q is already running: %v", name, err)
+	}
+	instanceLock = lock
+	return nil
+}
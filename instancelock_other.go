@@ -0,0 +1,37 @@
+//go:build !windows
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+
+	"golang.org/x/sys/unix"
+)
+
+// fileLock is an exclusive flock on a file in the shared temporary directory
+type fileLock struct {
+	file *os.File
+}
+
+// lockDir is shared by all users, so a lock taken by the service is seen by operators
+var lockDir = os.TempDir()
+
+func lockInstance(name string) (*fileLock, error) {
+	path := filepath.Join(lockDir, "scanandpost-"+name+".lock")
+	// read-only so any user can lock a file created by another
+	file, err := os.OpenFile(path, os.O_RDONLY|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if err := unix.Flock(int(file.Fd()), unix.LOCK_EX|unix.LOCK_NB); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return &fileLock{file: file}, nil
+}
+
+func (l *fileLock) release() {
+	unix.Flock(int(l.file.Fd()), unix.LOCK_UN)
+	l.file.Close()
+}
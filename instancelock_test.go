@@ -0,0 +1,28 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLockInstance_Exclusive(t *testing.T) {
+	first, err := lockInstance("test-exclusive")
+	assert.NoError(t, err)
+
+	_, err = lockInstance("test-exclusive")
+	assert.Error(t, err)
+	other, err := lockInstance("test-other")
+	assert.NoError(t, err)
+	other.release()
+
+	first.release()
+	again, err := lockInstance("test-exclusive")
+	assert.NoError(t, err)
+	again.release()
+}
+
+func TestConfig_InstanceName(t *testing.T) {
+	assert.Equal(t, defaultInstanceName, (&Config{}).instanceName())
+	assert.Equal(t, "lane3", (&Config{InstanceName: "lane3"}).instanceName())
+}
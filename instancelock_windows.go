@@ -0,0 +1,33 @@
+//go:build windows
+
+package main
+
+import (
+	"golang.org/x/sys/windows"
+)
+
+// mutexLock is a named mutex in the Global namespace, shared by every session
+type mutexLock struct {
+	handle windows.Handle
+}
+
+func lockInstance(name string) (*mutexLock, error) {
+	mutexName, err := windows.UTF16PtrFromString(`Global\ScanAndPost-` + name)
+	if err != nil {
+		return nil, err
+	}
+	handle, err := windows.CreateMutex(nil, false, mutexName)
+	if err != nil {
+		// a mutex created by the service account cannot be opened by other
+		// users, which also means it is already held
+		if handle != 0 {
+			windows.CloseHandle(handle)
+		}
+		return nil, err
+	}
+	return &mutexLock{handle: handle}, nil
+}
+
+func (l *mutexLock) release() {
+	windows.CloseHandle(l.handle)
+}
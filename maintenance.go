@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// MaintenanceAlertConfig represents alerts on scanner health trends, raised
+// while a failing cable or scanner still works most of the time
+type MaintenanceAlertConfig struct {
+	// WindowHours is how far back the rates are measured (default 24, at most 168)
+	WindowHours int `json:"windowHours"`
+	// ErrorRatePercent alerts when read errors exceed this share of a scanner's reads in the window
+	ErrorRatePercent float64 `json:"errorRatePercent"`
+	// MinReads is how many reads, scans and errors, the window needs before its error rate is trusted (default 20)
+	MinReads int `json:"minReads"`
+	// ReconnectsPerDay alerts when a scanner reconnects more often than this, averaged over the window
+	ReconnectsPerDay float64 `json:"reconnectsPerDay"`
+	// TrendFactor is how many times the scanner's rate before the window the
+	// window's rate must reach, so a scanner that was always this bad is not flagged (default 2)
+	TrendFactor float64 `json:"trendFactor"`
+}
+
+func (m MaintenanceAlertConfig) withDefaults() MaintenanceAlertConfig {
+	if m.WindowHours <= 0 {
+		m.WindowHours = 24
+	}
+	if m.WindowHours > deviceHistoryHours {
+		m.WindowHours = deviceHistoryHours
+	}
+	if m.MinReads <= 0 {
+		m.MinReads = 20
+	}
+	if m.TrendFactor <= 0 {
+		m.TrendFactor = 2
+	}
+	return m
+}
+
+// maintenanceAlerts returns an alert for each configured scanner whose recent
+// error rate or reconnect frequency is past the thresholds and trending up
+// from its rate before the window
+func (s *deviceStatsStore) maintenanceAlerts(config *Config, now time.Time) []Alert {
+	m := config.Alerts.Maintenance.withDefaults()
+	if s == nil || (m.ErrorRatePercent <= 0 && m.ReconnectsPerDay <= 0) {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var alerts []Alert
+	for i := 0; i < config.NumberOfScanners; i++ {
+		d, ok := s.devices[scannerName(i)]
+		if !ok {
+			continue
+		}
+		recent := d.recent(now, m.WindowHours)
+		if reads := recent.Scans + recent.Errors; m.ErrorRatePercent > 0 && reads >= uint64(m.MinReads) {
+			rate := 100 * float64(recent.Errors) / float64(reads)
+			baseline, known := baselineErrorRate(d, recent, m.MinReads)
+			if rate > m.ErrorRatePercent && (!known || rate >= baseline*m.TrendFactor) {
+				alerts = append(alerts, Alert{
+					Key:     fmt.Sprintf("maintenance-errors-%d", i),
+					Subject: fmt.Sprintf("%s read errors rising", config.scannerLabel(i)),
+					Body: fmt.Sprintf("%s failed %d of %d reads (%.1f%%) in the last %d hours, against %.1f%% before (threshold %.1f%%). Check its cable and connector.",
+						config.scannerLabel(i), recent.Errors, reads, rate, m.WindowHours, baseline, m.ErrorRatePercent),
+				})
+			}
+		}
+		perDay := float64(recent.Reconnects) * 24 / float64(m.WindowHours)
+		if m.ReconnectsPerDay > 0 && perDay > m.ReconnectsPerDay {
+			baseline, known := baselineReconnectsPerDay(d, recent, now, m.WindowHours)
+			if !known || perDay >= baseline*m.TrendFactor {
+				alerts = append(alerts, Alert{
+					Key:     fmt.Sprintf("maintenance-reconnects-%d", i),
+					Subject: fmt.Sprintf("%s reconnecting often", config.scannerLabel(i)),
+					Body: fmt.Sprintf("%s reconnected %d times in the last %d hours (%.1f per day, threshold %.1f), against %.1f per day before. It has reconnected %d times since %s.",
+						config.scannerLabel(i), recent.Reconnects, m.WindowHours, perDay, m.ReconnectsPerDay, baseline, d.Reconnects, d.FirstSeen.Format(time.RFC3339)),
+				})
+			}
+		}
+	}
+	return alerts
+}
+
+// baselineErrorRate is the share of the scanner's reads that failed from when
+// it was first seen until the window, in percent. It is not known until the
+// scanner had minReads reads before the window.
+func baselineErrorRate(d *DeviceLifetime, recent deviceHour, minReads int) (float64, bool) {
+	reads := d.Scans + d.Errors - recent.Scans - recent.Errors
+	if reads < uint64(minReads) {
+		return 0, false
+	}
+	return 100 * float64(d.Errors-recent.Errors) / float64(reads), true
+}
+
+// baselineReconnectsPerDay is how often the scanner reconnected from when it
+// was first seen until the window. It is not known until the scanner was seen
+// for a whole window before this one.
+func baselineReconnectsPerDay(d *DeviceLifetime, recent deviceHour, now time.Time, windowHours int) (float64, bool) {
+	hours := now.Add(-time.Duration(windowHours) * time.Hour).Sub(d.FirstSeen).Hours()
+	if hours < float64(windowHours) {
+		return 0, false
+	}
+	return float64(d.Reconnects-recent.Reconnects) * 24 / hours, true
+}
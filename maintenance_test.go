@@ -0,0 +1,75 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMaintenanceAlerts(t *testing.T) {
+	stats := newDeviceStatsStore("")
+	now := time.Date(2024, 5, 2, 12, 0, 0, 0, time.UTC)
+	config := &Config{NumberOfScanners: 2, ScannerNicknames: []string{"Dock 1"}, Alerts: AlertConfig{
+		Maintenance: MaintenanceAlertConfig{ErrorRatePercent: 5, ReconnectsPerDay: 3},
+	}}
+
+	// a day ago scanner0 was healthy; errors only started in the last hours
+	old := now.Add(-30 * time.Hour)
+	for i := 0; i < 100; i++ {
+		stats.recordScan("scanner0", old)
+	}
+	for i := 0; i < 17; i++ {
+		stats.recordScan("scanner0", now)
+	}
+	stats.recordError("scanner0", now)
+	stats.recordError("scanner0", now)
+	assert.Empty(t, stats.maintenanceAlerts(config, now), "too few reads to trust the rate")
+	stats.recordError("scanner0", now)
+
+	stats.deviceOpened("scanner1", "", now.Add(-5*time.Hour))
+	for i := 0; i < 4; i++ {
+		stats.deviceOpened("scanner1", "", now.Add(-time.Duration(i)*time.Hour))
+	}
+
+	alerts := stats.maintenanceAlerts(config, now)
+	assert.Len(t, alerts, 2)
+	assert.Equal(t, "maintenance-errors-0", alerts[0].Key)
+	assert.Equal(t, "scanner0 (Dock 1) read errors rising", alerts[0].Subject)
+	assert.Contains(t, alerts[0].Body, "failed 3 of 20 reads (15.0%) in the last 24 hours, against 0.0% before")
+	assert.Equal(t, "maintenance-reconnects-1", alerts[1].Key)
+	assert.Contains(t, alerts[1].Body, "reconnected 4 times in the last 24 hours (4.0 per day, threshold 3.0)")
+
+	config.Alerts.Maintenance = MaintenanceAlertConfig{}
+	assert.Empty(t, stats.maintenanceAlerts(config, now))
+}
+
+func TestMaintenanceAlerts_SteadyRatesAreNotATrend(t *testing.T) {
+	stats := newDeviceStatsStore("")
+	now := time.Date(2024, 5, 3, 12, 0, 0, 0, time.UTC)
+	config := &Config{NumberOfScanners: 1, Alerts: AlertConfig{
+		Maintenance: MaintenanceAlertConfig{ErrorRatePercent: 5, ReconnectsPerDay: 3},
+	}}
+
+	// scanner0 always failed one read in ten and reconnected four times a day
+	stats.deviceOpened("scanner0", "", now.Add(-72*time.Hour))
+	for hour := 72; hour > 0; hour-- {
+		at := now.Add(-time.Duration(hour) * time.Hour)
+		for i := 0; i < 9; i++ {
+			stats.recordScan("scanner0", at)
+		}
+		stats.recordError("scanner0", at)
+		if hour%6 == 0 {
+			stats.deviceOpened("scanner0", "", at)
+		}
+	}
+	assert.Empty(t, stats.maintenanceAlerts(config, now))
+
+	// twice as many errors as before is a trend
+	for i := 0; i < 40; i++ {
+		stats.recordError("scanner0", now)
+	}
+	alerts := stats.maintenanceAlerts(config, now)
+	assert.Len(t, alerts, 1)
+	assert.Equal(t, "maintenance-errors-0", alerts[0].Key)
+}
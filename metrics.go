@@ -0,0 +1,170 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptrace"
+	"sort"
+	"sync"
+	"time"
+)
+
+// slowSampleMinimum is how many requests an output needs before it can be flagged as slow
+const slowSampleMinimum = 10
+
+// outputMetrics tracks connection behaviour for one output
+type outputMetrics struct {
+	mu         sync.Mutex
+	requests   int
+	reused     int
+	handshakes int
+	dnsTotal   time.Duration
+	dnsCount   int
+	ttfbTotal  time.Duration
+	ttfbCount  int
+	// ttfbAverage is an exponentially weighted moving average, so old samples fade out
+	ttfbAverage time.Duration
+}
+
+// OutputStatus is the snapshot of an output's connection metrics reported in status
+type OutputStatus struct {
+	Name             string  `json:"name"`
+	Requests         int     `json:"requests"`
+	ReuseRate        float64 `json:"reuseRate"`
+	Handshakes       int     `json:"handshakes"`
+	AvgDNSMillis     float64 `json:"avgDnsMillis"`
+	AvgTTFBMillis    float64 `json:"avgTtfbMillis"`
+	RecentTTFBMillis float64 `json:"recentTtfbMillis"`
+	Slow             bool    `json:"slow"`
+	// Backlog is how many payloads wait in the output's own queue
+	Backlog int `json:"backlog"`
+}
+
+var (
+	outputMetricsMu sync.Mutex
+	outputMetricsBy = map[string]*outputMetrics{}
+	// slowOutputThreshold is the recent time to first byte above which an output is flagged slow
+	slowOutputThreshold = 2 * time.Second
+)
+
+// metricsFor returns the metrics of the named output, creating them if needed
+func metricsFor(name string) *outputMetrics {
+	outputMetricsMu.Lock()
+	defer outputMetricsMu.Unlock()
+	m, ok := outputMetricsBy[name]
+	if !ok {
+		m = &outputMetrics{}
+		outputMetricsBy[name] = m
+	}
+	return m
+}
+
+func (m *outputMetrics) recordTTFB(d time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.ttfbTotal += d
+	m.ttfbCount++
+	if m.ttfbCount == 1 {
+		m.ttfbAverage = d
+	} else {
+		m.ttfbAverage = (m.ttfbAverage*4 + d) / 5
+	}
+}
+
+// recentTTFB returns the weighted recent time to first byte
+func (m *outputMetrics) recentTTFB() time.Duration {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.ttfbAverage
+}
+
+// trace returns a client trace that feeds one request into the metrics
+func (m *outputMetrics) trace() *httptrace.ClientTrace {
+	requestStart := time.Now()
+	var dnsStart time.Time
+	return &httptrace.ClientTrace{
+		DNSStart: func(httptrace.DNSStartInfo) { dnsStart = time.Now() },
+		DNSDone: func(httptrace.DNSDoneInfo) {
+			m.mu.Lock()
+			defer m.mu.Unlock()
+			m.dnsTotal += time.Since(dnsStart)
+			m.dnsCount++
+		},
+		TLSHandshakeStart: func() {
+			m.mu.Lock()
+			defer m.mu.Unlock()
+			m.handshakes++
+		},
+		GotConn: func(info httptrace.GotConnInfo) {
+			m.mu.Lock()
+			defer m.mu.Unlock()
+			m.requests++
+			if info.Reused {
+				m.reused++
+			}
+		},
+		GotFirstResponseByte: func() { m.recordTTFB(time.Since(requestStart)) },
+	}
+}
+
+// status summarizes the metrics of the named output
+func (m *outputMetrics) status(name string) OutputStatus {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s := OutputStatus{Name: name, Requests: m.requests, Handshakes: m.handshakes}
+	if m.requests > 0 {
+		s.ReuseRate = float64(m.reused) / float64(m.requests)
+	}
+	if m.dnsCount > 0 {
+		s.AvgDNSMillis = millis(m.dnsTotal / time.Duration(m.dnsCount))
+	}
+	if m.ttfbCount > 0 {
+		s.AvgTTFBMillis = millis(m.ttfbTotal / time.Duration(m.ttfbCount))
+		s.RecentTTFBMillis = millis(m.ttfbAverage)
+	}
+	s.Slow = m.ttfbCount >= slowSampleMinimum && m.ttfbAverage > slowOutputThreshold
+	return s
+}
+
+func millis(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// outputStatuses returns the metrics of every output that has been used, sorted by name
+func outputStatuses() []OutputStatus {
+	outputMetricsMu.Lock()
+	names := make([]string, 0, len(outputMetricsBy))
+	for name := range outputMetricsBy {
+		names = append(names, name)
+	}
+	outputMetricsMu.Unlock()
+	sort.Strings(names)
+
+	statuses := make([]OutputStatus, 0, len(names))
+	for _, name := range names {
+		status := metricsFor(name).status(name)
+		status.Backlog = outputBacklog(name)
+		statuses = append(statuses, status)
+	}
+	return statuses
+}
+
+// instrumentedTransport records connection metrics for every request it sends
+type instrumentedTransport struct {
+	base    http.RoundTripper
+	metrics *outputMetrics
+}
+
+func (t *instrumentedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.WithContext(httptrace.WithClientTrace(req.Context(), t.metrics.trace()))
+	return t.base.RoundTrip(req)
+}
+
+// newInstrumentedClient returns an HTTP client for the named output that records its connection metrics
+func newInstrumentedClient(name string, t TLSConfig) (*http.Client, error) {
+	client, err := newHTTPClient(t)
+	if err != nil {
+		return nil, err
+	}
+	client.Transport = &instrumentedTransport{base: client.Transport, metrics: metricsFor(name)}
+	return client, nil
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInstrumentedClient_ReuseAndHandshakes(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+	outputMetricsMu.Lock()
+	delete(outputMetricsBy, "test-reuse")
+	outputMetricsMu.Unlock()
+
+	client, err := newInstrumentedClient("test-reuse", TLSConfig{CAFile: writeServerCA(t, server)})
+	assert.NoError(t, err)
+	for i := 0; i < 2; i++ {
+		resp, err := client.Get(server.URL)
+		assert.NoError(t, err)
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+	}
+
+	status := metricsFor("test-reuse").status("test-reuse")
+	assert.Equal(t, 2, status.Requests)
+	assert.Equal(t, 1, status.Handshakes)
+	assert.Equal(t, 0.5, status.ReuseRate)
+	assert.False(t, status.Slow)
+}
+
+func TestOutputMetrics_SlowFlag(t *testing.T) {
+	m := &outputMetrics{}
+	for i := 0; i < slowSampleMinimum-1; i++ {
+		m.recordTTFB(5 * time.Second)
+	}
+	assert.False(t, m.status("slow").Slow)
+	m.recordTTFB(5 * time.Second)
+	assert.True(t, m.status("slow").Slow)
+
+	// the flag clears once recent requests are fast again
+	for i := 0; i < 20; i++ {
+		m.recordTTFB(10 * time.Millisecond)
+	}
+	assert.False(t, m.status("slow").Slow)
+}
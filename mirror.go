@@ -0,0 +1,61 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"time"
+)
+
+// MirrorConfig represents the read-only broadcast of accepted scans to local tools
+type MirrorConfig struct {
+	// Address is a UDP multicast group such as 239.255.42.1:5140, or a loopback address such as 127.0.0.1:5140
+	Address string `json:"address"`
+}
+
+// MirrorMessage is the JSON datagram sent for each accepted scan
+type MirrorMessage struct {
+	Time      time.Time `json:"time"`
+	StationID string    `json:"stationId,omitempty"`
+	Payload
+}
+
+// scanMirror sends accepted scans to the mirror address. A nil mirror sends nothing.
+type scanMirror struct {
+	conn      *net.UDPConn
+	stationID string
+}
+
+var mirror *scanMirror
+
+// newScanMirror opens the mirror. Only multicast and loopback addresses are
+// accepted, so scans are never sent to a remote host by mistake.
+func newScanMirror(config MirrorConfig, stationID string) (*scanMirror, error) {
+	addr, err := net.ResolveUDPAddr("udp", config.Address)
+	if err != nil {
+		return nil, err
+	}
+	if !addr.IP.IsMulticast() && !addr.IP.IsLoopback() {
+		return nil, fmt.Errorf("%s is neither a multicast nor a loopback address", config.Address)
+	}
+	conn, err := net.DialUDP("udp", nil, addr)
+	if err != nil {
+		return nil, err
+	}
+	return &scanMirror{conn: conn, stationID: stationID}, nil
+}
+
+// send broadcasts a scan. Nobody listening is not an error.
+func (m *scanMirror) send(payload Payload) {
+	if m == nil {
+		return
+	}
+	data, err := json.Marshal(MirrorMessage{Time: time.Now(), StationID: m.stationID, Payload: payload})
+	if err != nil {
+		logger.Errorf("Error marshaling mirrored payload: %v", err)
+		return
+	}
+	if _, err := m.conn.Write(data); err != nil {
+		logger.Debugf("Error mirroring payload %v: %v", payload, err)
+	}
+}
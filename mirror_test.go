@@ -0,0 +1,41 @@
+package main
+
+import (
+	"encoding/json"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestScanMirror(t *testing.T) {
+	listener, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	assert.NoError(t, err)
+	defer listener.Close()
+
+	m, err := newScanMirror(MirrorConfig{Address: listener.LocalAddr().String()}, "st-42")
+	assert.NoError(t, err)
+	m.send(Payload{ItemID: "12345", DeviceType: "scanner0"})
+
+	buf := make([]byte, 2048)
+	listener.SetReadDeadline(time.Now().Add(time.Second))
+	n, err := listener.Read(buf)
+	assert.NoError(t, err)
+	var message map[string]interface{}
+	assert.NoError(t, json.Unmarshal(buf[:n], &message))
+	assert.Equal(t, "12345", message["itemid"])
+	assert.Equal(t, "scanner0", message["deviceType"])
+	assert.Equal(t, "st-42", message["stationId"])
+	assert.NotEmpty(t, message["time"])
+
+	var none *scanMirror
+	none.send(Payload{ItemID: "1"})
+}
+
+func TestNewScanMirror_RejectsRemoteAddress(t *testing.T) {
+	_, err := newScanMirror(MirrorConfig{Address: "192.0.2.10:5140"}, "")
+	assert.EqualError(t, err, "192.0.2.10:5140 is neither a multicast nor a loopback address")
+	_, err = newScanMirror(MirrorConfig{Address: "239.255.42.1:5140"}, "")
+	assert.NoError(t, err)
+}
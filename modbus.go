@@ -0,0 +1,264 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// ModbusConfig represents a PLC reached over Modbus TCP that gates scanning on
+// a "package present" coil and receives the post result on OK/NOK coils
+type ModbusConfig struct {
+	// Address is the PLC's Modbus TCP address, such as 192.168.1.10:502
+	Address string `json:"address"`
+	// UnitID addresses the PLC behind a gateway (default 1)
+	UnitID int `json:"unitId"`
+	// PresentCoil is on while a package is in front of the scanners. Scanner reads while it is off are dropped.
+	PresentCoil *int `json:"presentCoil"`
+	// TriggerScanner is software-triggered when PresentCoil turns on, such as "scanner0", see triggers
+	TriggerScanner string `json:"triggerScanner"`
+	// OKCoil and NOKCoil are pulsed on after a scan is accepted or not accepted by the API
+	OKCoil  *int `json:"okCoil"`
+	NOKCoil *int `json:"nokCoil"`
+	// PulseMillis is how long a result coil stays on (default 500)
+	PulseMillis int `json:"pulseMillis"`
+	// PollMillis is how often PresentCoil is read (default 50)
+	PollMillis int `json:"pollMillis"`
+	// TimeoutMillis bounds each request to the PLC (default 1000)
+	TimeoutMillis int `json:"timeoutMillis"`
+}
+
+func (m ModbusConfig) withDefaults() ModbusConfig {
+	if m.UnitID == 0 {
+		m.UnitID = 1
+	}
+	if m.PulseMillis <= 0 {
+		m.PulseMillis = 500
+	}
+	if m.PollMillis <= 0 {
+		m.PollMillis = 50
+	}
+	if m.TimeoutMillis <= 0 {
+		m.TimeoutMillis = 1000
+	}
+	return m
+}
+
+func (m ModbusConfig) validate() error {
+	if m.UnitID < 0 || m.UnitID > 255 {
+		return fmt.Errorf("unitId %d is not between 0 and 255", m.UnitID)
+	}
+	for _, coil := range []*int{m.PresentCoil, m.OKCoil, m.NOKCoil} {
+		if coil != nil && (*coil < 0 || *coil > 0xFFFF) {
+			return fmt.Errorf("coil %d is not between 0 and 65535", *coil)
+		}
+	}
+	if m.TriggerScanner != "" {
+		if m.PresentCoil == nil {
+			return errors.New("triggerScanner needs presentCoil")
+		}
+		if _, ok := triggers[m.TriggerScanner]; !ok {
+			return fmt.Errorf("%s has no host trigger configured", m.TriggerScanner)
+		}
+	}
+	return nil
+}
+
+// Modbus function codes
+const (
+	modbusReadCoils       = 0x01
+	modbusWriteSingleCoil = 0x05
+)
+
+// plcLink is the connection to the PLC, redialed after any error. A nil link
+// gates nothing and signals nothing.
+type plcLink struct {
+	config ModbusConfig
+	mu     sync.Mutex
+	conn   net.Conn
+	txID   uint16
+
+	stateMu sync.Mutex
+	present bool
+	// reachable is false after a failed poll, so a lost PLC does not stop scanning
+	reachable bool
+	// read is set when a scanner read arrives while the current package is present
+	read bool
+}
+
+var plc *plcLink
+
+func newPLCLink(config ModbusConfig) *plcLink {
+	return &plcLink{config: config.withDefaults()}
+}
+
+// request sends one PDU and returns the PDU of the reply
+func (p *plcLink) request(pdu []byte) ([]byte, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	timeout := time.Duration(p.config.TimeoutMillis) * time.Millisecond
+	if p.conn == nil {
+		conn, err := net.DialTimeout("tcp", p.config.Address, timeout)
+		if err != nil {
+			return nil, err
+		}
+		p.conn = conn
+	}
+	reply, err := p.exchange(pdu, timeout)
+	if err != nil {
+		p.conn.Close()
+		p.conn = nil
+		return nil, err
+	}
+	if reply[0] != pdu[0] {
+		if reply[0] == pdu[0]|0x80 && len(reply) > 1 {
+			return nil, fmt.Errorf("modbus exception %d", reply[1])
+		}
+		return nil, fmt.Errorf("unexpected function code %d in reply", reply[0])
+	}
+	return reply, nil
+}
+
+// exchange writes the PDU in an MBAP frame and reads the matching reply. The caller holds p.mu.
+func (p *plcLink) exchange(pdu []byte, timeout time.Duration) ([]byte, error) {
+	p.txID++
+	frame := make([]byte, 7, 7+len(pdu))
+	binary.BigEndian.PutUint16(frame[0:], p.txID)
+	binary.BigEndian.PutUint16(frame[4:], uint16(len(pdu)+1))
+	frame[6] = byte(p.config.UnitID)
+	frame = append(frame, pdu...)
+
+	p.conn.SetDeadline(time.Now().Add(timeout))
+	if _, err := p.conn.Write(frame); err != nil {
+		return nil, err
+	}
+	header := make([]byte, 7)
+	if _, err := io.ReadFull(p.conn, header); err != nil {
+		return nil, err
+	}
+	length := int(binary.BigEndian.Uint16(header[4:]))
+	if length < 2 || length > 254 {
+		return nil, fmt.Errorf("invalid reply length %d", length)
+	}
+	reply := make([]byte, length-1)
+	if _, err := io.ReadFull(p.conn, reply); err != nil {
+		return nil, err
+	}
+	if binary.BigEndian.Uint16(header[0:]) != p.txID {
+		return nil, errors.New("reply to another transaction")
+	}
+	return reply, nil
+}
+
+// readCoil reads one coil
+func (p *plcLink) readCoil(address int) (bool, error) {
+	reply, err := p.request([]byte{modbusReadCoils, byte(address >> 8), byte(address), 0, 1})
+	if err != nil {
+		return false, err
+	}
+	if len(reply) < 3 || reply[1] < 1 {
+		return false, errors.New("short read coils reply")
+	}
+	return reply[2]&1 == 1, nil
+}
+
+// writeCoil switches one coil on or off
+func (p *plcLink) writeCoil(address int, on bool) error {
+	value := byte(0x00)
+	if on {
+		value = 0xFF
+	}
+	_, err := p.request([]byte{modbusWriteSingleCoil, byte(address >> 8), byte(address), value, 0})
+	return err
+}
+
+// allows reports whether a scanner read should be accepted: always without a
+// present coil or while the PLC is unreachable, else only while a package is present
+func (p *plcLink) allows() bool {
+	if p == nil || p.config.PresentCoil == nil {
+		return true
+	}
+	p.stateMu.Lock()
+	defer p.stateMu.Unlock()
+	if p.present {
+		p.read = true
+	}
+	return p.present || !p.reachable
+}
+
+// poll reads the present coil once, triggering the scanner when a package
+// arrives and reporting a no-read when it leaves without any read
+func (p *plcLink) poll() {
+	present, err := p.readCoil(*p.config.PresentCoil)
+	p.stateMu.Lock()
+	wasPresent, wasReachable, read := p.present, p.reachable, p.read
+	p.reachable = err == nil
+	if err == nil {
+		p.present = present
+		if present && !wasPresent {
+			p.read = false
+		}
+	}
+	p.stateMu.Unlock()
+
+	if err != nil {
+		if wasReachable {
+			logger.Errorf("Error reading package present coil from PLC %s, accepting all scans until it answers: %v", p.config.Address, err)
+		}
+		return
+	}
+	if !wasReachable {
+		logger.Infof("PLC %s is reachable", p.config.Address)
+	}
+	if present && !wasPresent && p.config.TriggerScanner != "" {
+		triggerScanner(p.config.TriggerScanner, triggerRead)
+	}
+	// a triggered scanner with no-read payloads reports the no-read itself
+	if !present && wasPresent && !read && !noReads.covers(p.config.TriggerScanner) {
+		reportOutcome(Payload{DeviceType: p.config.TriggerScanner}, outcomeNoRead, "package passed without a read")
+	}
+}
+
+// watchPresent polls the present coil
+func (p *plcLink) watchPresent() {
+	ticker := time.NewTicker(time.Duration(p.config.PollMillis) * time.Millisecond)
+	defer ticker.Stop()
+	for range ticker.C {
+		p.poll()
+	}
+}
+
+// result pulses the OK or NOK coil after the post result of a scan
+func (p *plcLink) result(ok bool) {
+	if p == nil {
+		return
+	}
+	coil := p.config.NOKCoil
+	if ok {
+		coil = p.config.OKCoil
+	}
+	if coil != nil {
+		p.pulse(*coil)
+	}
+}
+
+// pulse switches a coil on for PulseMillis
+func (p *plcLink) pulse(address int) {
+	if p == nil {
+		return
+	}
+	go func() {
+		if err := p.writeCoil(address, true); err != nil {
+			logger.Errorf("Error writing coil %d to PLC %s: %v", address, p.config.Address, err)
+			return
+		}
+		time.Sleep(time.Duration(p.config.PulseMillis) * time.Millisecond)
+		if err := p.writeCoil(address, false); err != nil {
+			logger.Errorf("Error resetting coil %d on PLC %s: %v", address, p.config.Address, err)
+		}
+	}()
+}
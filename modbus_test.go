@@ -0,0 +1,142 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakePLC is a Modbus TCP server holding 16 coils
+type fakePLC struct {
+	mu     sync.Mutex
+	coils  [16]bool
+	writes []string
+}
+
+func (f *fakePLC) coil(address int) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.coils[address]
+}
+
+func (f *fakePLC) set(address int, on bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.coils[address] = on
+}
+
+// start serves the fake PLC and returns its address
+func (f *fakePLC) start(t *testing.T) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go f.serve(conn)
+		}
+	}()
+	return listener.Addr().String()
+}
+
+func (f *fakePLC) serve(conn net.Conn) {
+	defer conn.Close()
+	for {
+		header := make([]byte, 7)
+		if _, err := io.ReadFull(conn, header); err != nil {
+			return
+		}
+		pdu := make([]byte, binary.BigEndian.Uint16(header[4:])-1)
+		if _, err := io.ReadFull(conn, pdu); err != nil {
+			return
+		}
+		address := int(binary.BigEndian.Uint16(pdu[1:]))
+		var reply []byte
+		switch {
+		case address >= len(f.coils):
+			reply = []byte{pdu[0] | 0x80, 2}
+		case pdu[0] == modbusReadCoils:
+			value := byte(0)
+			if f.coil(address) {
+				value = 1
+			}
+			reply = []byte{pdu[0], 1, value}
+		case pdu[0] == modbusWriteSingleCoil:
+			on := pdu[3] == 0xFF
+			f.set(address, on)
+			f.mu.Lock()
+			f.writes = append(f.writes, fmt.Sprintf("%d=%v", address, on))
+			f.mu.Unlock()
+			reply = pdu
+		}
+		frame := append(header[:4:4], 0, byte(len(reply)+1), header[6])
+		conn.Write(append(frame, reply...))
+	}
+}
+
+func coilAddress(address int) *int { return &address }
+
+func TestPLCLink_ReadWriteCoils(t *testing.T) {
+	fake := &fakePLC{}
+	link := newPLCLink(ModbusConfig{Address: fake.start(t)})
+
+	fake.set(3, true)
+	on, err := link.readCoil(3)
+	assert.NoError(t, err)
+	assert.True(t, on)
+	assert.NoError(t, link.writeCoil(4, true))
+	assert.True(t, fake.coil(4))
+
+	_, err = link.readCoil(100)
+	assert.EqualError(t, err, "modbus exception 2")
+}
+
+func TestPLCLink_GatesAndTriggers(t *testing.T) {
+	fake := &fakePLC{}
+	port := useFakeTriggers(t)
+	link := newPLCLink(ModbusConfig{Address: fake.start(t), PresentCoil: coilAddress(0), TriggerScanner: "scanner0"})
+
+	link.poll()
+	assert.False(t, link.allows())
+	fake.set(0, true)
+	link.poll()
+	assert.True(t, link.allows())
+	assert.Equal(t, ssiPacket(ssiOpcodes[triggerRead]), port.Bytes())
+
+	// only the rising edge triggers
+	link.poll()
+	assert.Equal(t, ssiPacket(ssiOpcodes[triggerRead]), port.Bytes())
+
+	// scans are let through while the PLC is unreachable
+	fake.set(0, false)
+	link.poll()
+	assert.False(t, link.allows())
+	link.config.Address = "127.0.0.1:1"
+	link.conn.Close()
+	link.conn = nil
+	link.poll()
+	assert.True(t, link.allows())
+}
+
+func TestPLCLink_ResultPulse(t *testing.T) {
+	fake := &fakePLC{}
+	link := newPLCLink(ModbusConfig{Address: fake.start(t), OKCoil: coilAddress(1), NOKCoil: coilAddress(2), PulseMillis: 10})
+
+	link.result(false)
+	assert.Eventually(t, func() bool {
+		fake.mu.Lock()
+		defer fake.mu.Unlock()
+		return len(fake.writes) == 2
+	}, time.Second, 5*time.Millisecond)
+	assert.Equal(t, []string{"2=true", "2=false"}, fake.writes)
+	assert.False(t, fake.coil(1))
+}
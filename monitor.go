@@ -0,0 +1,186 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// monitorRefresh is how often the monitor polls the admin API
+const monitorRefresh = time.Second
+
+// monitorModel is the terminal UI of the monitor command
+type monitorModel struct {
+	baseURL string
+	client  *http.Client
+	status  *Status
+	recent  Recent
+	err     error
+	width   int
+	height  int
+}
+
+type monitorTick time.Time
+
+type monitorData struct {
+	status *Status
+	recent Recent
+	err    error
+}
+
+func newMonitorModel(config *Config) (monitorModel, error) {
+	if config.Admin.Listen == "" {
+		return monitorModel{}, fmt.Errorf("the monitor needs admin.listen to be configured")
+	}
+	addr := config.Admin.Listen
+	if strings.HasPrefix(addr, ":") {
+		addr = "127.0.0.1" + addr
+	}
+	return monitorModel{baseURL: "http://" + addr, client: newAdminClient(config, 2*time.Second)}, nil
+}
+
+func (m monitorModel) Init() tea.Cmd {
+	return m.fetch
+}
+
+// fetch reads the status and recent events from the running service
+func (m monitorModel) fetch() tea.Msg {
+	var data monitorData
+	var status Status
+	if err := m.getJSON("/status", &status); err != nil {
+		data.err = err
+		return data
+	}
+	data.status = &status
+	data.err = m.getJSON("/recent", &data.recent)
+	return data
+}
+
+func (m monitorModel) getJSON(path string, v interface{}) error {
+	resp, err := m.client.Get(m.baseURL + path)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: response code: %d", path, resp.StatusCode)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+func (m monitorModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case tea.KeyMsg:
+		switch msg.String() {
+		case "q", "ctrl+c", "esc":
+			return m, tea.Quit
+		}
+	case tea.WindowSizeMsg:
+		m.width, m.height = msg.Width, msg.Height
+	case monitorData:
+		m.err = msg.err
+		if msg.status != nil {
+			m.status = msg.status
+			m.recent = msg.recent
+		}
+		return m, tea.Tick(monitorRefresh, func(t time.Time) tea.Msg { return monitorTick(t) })
+	case monitorTick:
+		return m, m.fetch
+	}
+	return m, nil
+}
+
+func (m monitorModel) View() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Scan and Post monitor — %s   (q to quit)\n\n", m.baseURL)
+	if m.err != nil {
+		fmt.Fprintf(&b, "! %v\n\n", m.err)
+	}
+	if m.status == nil {
+		b.WriteString("Waiting for the service...\n")
+		return b.String()
+	}
+	s := m.status
+	fmt.Fprintf(&b, "Host %s   scans %d   posted %d   failed %d   queue %d\n",
+		s.Hostname, s.ScansReceived, s.PostsSucceeded, s.PostsFailed, s.QueueDepth)
+	if s.OldestQueuedAt != nil {
+		fmt.Fprintf(&b, "Oldest queued scan waiting %s\n", time.Duration(s.BacklogAgeSeconds)*time.Second)
+	}
+	if s.DegradedSince != nil {
+		fmt.Fprintf(&b, "DEGRADED to queue-only since %s\n", s.DegradedSince.Local().Format("15:04:05"))
+	}
+	if s.NoInputsSince != nil {
+		fmt.Fprintf(&b, "NO ACTIVE INPUTS since %s — nothing is being captured\n", s.NoInputsSince.Local().Format("15:04:05"))
+	}
+
+	if n := len(m.recent.Feedback); n > 0 {
+		last := m.recent.Feedback[n-1]
+		fmt.Fprintf(&b, "\n>> %s   (%s, %s)\n", last.Message, last.ItemID, last.Time.Local().Format("15:04:05"))
+	}
+
+	b.WriteString("\nDevices\n")
+	for _, d := range s.Devices {
+		state := "connected"
+		if !d.Connected {
+			state = "MISSING"
+			if d.MissingSince != nil {
+				state += " since " + d.MissingSince.Local().Format("15:04:05")
+			}
+		}
+		fmt.Fprintf(&b, "  %-10s %-24s %s\n", d.Name, d.Nickname, state)
+	}
+
+	rows := 10
+	if m.height > 0 {
+		rows = (m.height - 12 - len(s.Devices)) / 2
+		if rows < 3 {
+			rows = 3
+		}
+	}
+	b.WriteString("\nRecent scans\n")
+	for _, scan := range lastN(m.recent.Scans, rows) {
+		device := scan.Device
+		if scan.Nickname != "" {
+			device = scan.Nickname
+		}
+		fmt.Fprintf(&b, "  %s  %-24s %s\n", scan.Time.Local().Format("15:04:05"), device, scan.ItemID)
+	}
+	b.WriteString("\nRecent errors\n")
+	for _, e := range lastN(m.recent.Errors, rows) {
+		fmt.Fprintf(&b, "  %s  %s\n", e.Time.Local().Format("15:04:05"), truncate(e.Message, m.width-14))
+	}
+	return b.String()
+}
+
+// lastN returns the newest n items, newest first
+func lastN[T any](items []T, n int) []T {
+	var out []T
+	for i := len(items) - 1; i >= 0 && len(out) < n; i-- {
+		out = append(out, items[i])
+	}
+	return out
+}
+
+func truncate(s string, width int) string {
+	if width <= 0 || len(s) <= width {
+		return s
+	}
+	if width <= 3 {
+		return s[:width]
+	}
+	return s[:width-3] + "..."
+}
+
+// runMonitor shows the live monitor until the user quits
+func runMonitor(config *Config) error {
+	model, err := newMonitorModel(config)
+	if err != nil {
+		return err
+	}
+	_, err = tea.NewProgram(model, tea.WithAltScreen()).Run()
+	return err
+}
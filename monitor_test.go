@@ -0,0 +1,47 @@
+package main
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMonitorModel_FetchAndView(t *testing.T) {
+	health = &healthState{deviceMissingSince: map[int]time.Time{}}
+	config := &Config{NumberOfScanners: 1, ScannerNicknames: []string{"Dock 3"}}
+	recent = &recentEvents{}
+	recent.addScan(Payload{ItemID: "PAL1", DeviceType: "scanner0", Nickname: "Dock 3"}, time.Now())
+	recent.addError("Error posting payload: boom", time.Now())
+	server := httptest.NewServer(adminMux(config))
+	defer server.Close()
+
+	model, err := newMonitorModel(&Config{Admin: AdminConfig{Listen: strings.TrimPrefix(server.URL, "http://")}})
+	assert.NoError(t, err)
+	updated, _ := model.Update(model.fetch())
+	view := updated.View()
+
+	assert.Contains(t, view, "Dock 3")
+	assert.Contains(t, view, "PAL1")
+	assert.Contains(t, view, "Error posting payload: boom")
+}
+
+func TestNewMonitorModel_NeedsAdmin(t *testing.T) {
+	_, err := newMonitorModel(&Config{})
+	assert.Error(t, err)
+}
+
+func TestRecentEvents_Limit(t *testing.T) {
+	r := &recentEvents{}
+	for i := 0; i < recentLimit+5; i++ {
+		r.addError("e", time.Now())
+	}
+	assert.Len(t, r.snapshot().Errors, recentLimit)
+}
+
+func TestMonitorView_ShowsFeedback(t *testing.T) {
+	m := monitorModel{status: &Status{}, recent: Recent{Feedback: []RecentFeedback{{Time: time.Now(), ItemID: "1", Message: "Bin 7"}}}}
+	assert.Contains(t, m.View(), ">> Bin 7")
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// NoiseFilterConfig represents the filter for bogus reads, such as single
+// characters, whitespace or status strings some scanners send on a misread
+type NoiseFilterConfig struct {
+	// MinLength is the shortest item ID, ignoring surrounding whitespace, that is a real scan
+	MinLength int `json:"minLength"`
+	// Patterns are regular expressions matched against the whole item ID, such as "NR" or "ERROR.*"
+	Patterns []string `json:"patterns"`
+}
+
+// noiseFilter drops reads that match the noise filter. A nil filter drops nothing.
+type noiseFilter struct {
+	minLength int
+	patterns  []*regexp.Regexp
+}
+
+var noise *noiseFilter
+
+func newNoiseFilter(config NoiseFilterConfig) (*noiseFilter, error) {
+	f := &noiseFilter{minLength: config.MinLength}
+	for _, pattern := range config.Patterns {
+		re, err := regexp.Compile(`^(?:` + pattern + `)$`)
+		if err != nil {
+			return nil, fmt.Errorf("pattern %q: %v", pattern, err)
+		}
+		f.patterns = append(f.patterns, re)
+	}
+	return f, nil
+}
+
+func (n NoiseFilterConfig) enabled() bool {
+	return n.MinLength > 0 || len(n.Patterns) > 0
+}
+
+// reason returns why the item ID is noise, or "" for a real scan
+func (f *noiseFilter) reason(itemID string) string {
+	if f == nil {
+		return ""
+	}
+	trimmed := strings.TrimSpace(itemID)
+	if trimmed == "" {
+		return "blank"
+	}
+	if utf8.RuneCountInString(trimmed) < f.minLength {
+		return fmt.Sprintf("shorter than %d characters", f.minLength)
+	}
+	for _, re := range f.patterns {
+		if re.MatchString(trimmed) {
+			return fmt.Sprintf("matches %s", re)
+		}
+	}
+	return ""
+}
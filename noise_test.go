@@ -0,0 +1,38 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNoiseFilter(t *testing.T) {
+	f, err := newNoiseFilter(NoiseFilterConfig{MinLength: 3, Patterns: []string{"NR", "ERROR.*"}})
+	assert.NoError(t, err)
+	assert.Equal(t, "blank", f.reason(" \t"))
+	assert.Equal(t, "shorter than 3 characters", f.reason(" x "))
+	assert.Equal(t, "matches ^(?:ERROR.*)$", f.reason("ERROR 42"))
+	assert.Equal(t, "", f.reason("NRX1234"))
+	assert.Equal(t, "", (*noiseFilter)(nil).reason(""))
+
+	_, err = newNoiseFilter(NoiseFilterConfig{Patterns: []string{"("}})
+	assert.Error(t, err)
+}
+
+func TestDispatchPayload_DropsNoise(t *testing.T) {
+	oldNoise, oldPost := noise, httpPost
+	defer func() { noise, httpPost = oldNoise, oldPost }()
+	noise, _ = newNoiseFilter(NoiseFilterConfig{MinLength: 2})
+	posted := false
+	httpPost = func(url, contentType string, body io.Reader) (*http.Response, error) {
+		posted = true
+		return &http.Response{StatusCode: http.StatusOK}, nil
+	}
+
+	dispatchPayload(&Config{}, Payload{ItemID: "x", DeviceType: "scanner0"})
+	assert.False(t, posted)
+	dispatchPayload(&Config{}, Payload{ItemID: "12345", DeviceType: "scanner0"})
+	assert.True(t, posted)
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+// NoReadConfig represents explicit no-read payloads for fixed-mount scanners,
+// so packages that passed without a decode show up like any other scan
+type NoReadConfig struct {
+	Enabled bool `json:"enabled"`
+	// TimeoutMillis is how long after a software trigger a decode may take before a no-read is sent (default 1000)
+	TimeoutMillis int `json:"timeoutMillis"`
+	// ItemID is the item ID of no-read payloads (default "NOREAD")
+	ItemID string `json:"itemId"`
+	// Codes are what scanners send on a failed decode, such as "NR"; these reads become no-read payloads
+	Codes []string `json:"codes"`
+}
+
+func (n NoReadConfig) withDefaults() NoReadConfig {
+	if n.TimeoutMillis <= 0 {
+		n.TimeoutMillis = 1000
+	}
+	if n.ItemID == "" {
+		n.ItemID = "NOREAD"
+	}
+	return n
+}
+
+// noReadWatcher sends a no-read payload when a triggered scanner decodes
+// nothing in time. A nil watcher sends nothing.
+type noReadWatcher struct {
+	config    NoReadConfig
+	nicknames func(scanner string) string
+	payloadCh chan<- Payload
+
+	mu     sync.Mutex
+	armed  map[string]*time.Timer
+	counts map[string]int
+}
+
+var noReads *noReadWatcher
+
+func newNoReadWatcher(config *Config, payloadCh chan<- Payload) *noReadWatcher {
+	nicknames := map[string]string{}
+	for i := 0; i < config.NumberOfScanners; i++ {
+		nicknames[scannerName(i)] = config.scannerNickname(i)
+	}
+	return &noReadWatcher{
+		config:    config.NoRead.withDefaults(),
+		nicknames: func(scanner string) string { return nicknames[scanner] },
+		payloadCh: payloadCh,
+		armed:     map[string]*time.Timer{},
+		counts:    map[string]int{},
+	}
+}
+
+// arm starts the decode timeout of a scanner that was just triggered
+func (w *noReadWatcher) arm(scanner string) {
+	if w == nil {
+		return
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if timer, ok := w.armed[scanner]; ok {
+		timer.Stop()
+	}
+	var timer *time.Timer
+	timer = time.AfterFunc(time.Duration(w.config.TimeoutMillis)*time.Millisecond, func() {
+		w.mu.Lock()
+		if w.armed[scanner] != timer {
+			w.mu.Unlock()
+			return
+		}
+		delete(w.armed, scanner)
+		w.counts[scanner]++
+		w.mu.Unlock()
+		logger.Infof("No barcode decoded by %s within %d ms of the trigger", scanner, w.config.TimeoutMillis)
+		w.payloadCh <- w.payload(scanner)
+	})
+	w.armed[scanner] = timer
+}
+
+// disarm cancels the decode timeout when the scanner reads a barcode
+func (w *noReadWatcher) disarm(scanner string) {
+	if w == nil {
+		return
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if timer, ok := w.armed[scanner]; ok {
+		timer.Stop()
+		delete(w.armed, scanner)
+	}
+}
+
+// covers reports whether triggers of the scanner produce no-read payloads
+func (w *noReadWatcher) covers(scanner string) bool {
+	return w != nil && scanner != ""
+}
+
+// convert turns a read that is a no-read code into a no-read payload
+func (w *noReadWatcher) convert(payload Payload) Payload {
+	if w == nil || payload.NoRead {
+		return payload
+	}
+	trimmed := strings.TrimSpace(payload.ItemID)
+	for _, code := range w.config.Codes {
+		if trimmed == code {
+			w.mu.Lock()
+			w.counts[payload.DeviceType]++
+			w.mu.Unlock()
+			payload.ItemID = w.config.ItemID
+			payload.NoRead = true
+			return payload
+		}
+	}
+	return payload
+}
+
+// payload builds the no-read payload of a scanner
+func (w *noReadWatcher) payload(scanner string) Payload {
+	return Payload{ItemID: w.config.ItemID, DeviceType: scanner, Nickname: w.nicknames(scanner), NoRead: true}
+}
+
+// count returns the no-reads of a scanner since the service started
+func (w *noReadWatcher) count(scanner string) int {
+	if w == nil {
+		return 0
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.counts[scanner]
+}
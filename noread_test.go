@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNoReadWatcher_TimesOutWithoutDecode(t *testing.T) {
+	payloadCh := make(chan Payload, 2)
+	config := &Config{NumberOfScanners: 2, ScannerNicknames: []string{"Tunnel A"}, NoRead: NoReadConfig{Enabled: true, TimeoutMillis: 20}}
+	w := newNoReadWatcher(config, payloadCh)
+
+	// a decode in time cancels the no-read
+	w.arm("scanner1")
+	w.disarm("scanner1")
+
+	w.arm("scanner0")
+	select {
+	case payload := <-payloadCh:
+		assert.Equal(t, Payload{ItemID: "NOREAD", DeviceType: "scanner0", Nickname: "Tunnel A", NoRead: true}, payload)
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for no-read payload")
+	}
+	time.Sleep(50 * time.Millisecond)
+	assert.Empty(t, payloadCh)
+	assert.Equal(t, 1, w.count("scanner0"))
+	assert.Equal(t, 0, w.count("scanner1"))
+}
+
+func TestNoReadWatcher_TriggerArms(t *testing.T) {
+	useFakeTriggers(t)
+	payloadCh := make(chan Payload, 1)
+	oldNoReads := noReads
+	defer func() { noReads = oldNoReads }()
+	noReads = newNoReadWatcher(&Config{NumberOfScanners: 1, NoRead: NoReadConfig{TimeoutMillis: 20}}, payloadCh)
+
+	assert.NoError(t, triggerScanner("scanner0", triggerRead))
+	select {
+	case payload := <-payloadCh:
+		assert.True(t, payload.NoRead)
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for no-read payload")
+	}
+}
+
+func TestDispatchPayload_NoReadCode(t *testing.T) {
+	oldNoReads, oldNoise, oldPost := noReads, noise, httpPost
+	defer func() { noReads, noise, httpPost = oldNoReads, oldNoise, oldPost }()
+	noReads = newNoReadWatcher(&Config{NoRead: NoReadConfig{Codes: []string{"NR"}}}, nil)
+	// the noise filter would drop "NR", but no-reads bypass it
+	noise, _ = newNoiseFilter(NoiseFilterConfig{MinLength: 3})
+	var posted Payload
+	httpPost = func(url, contentType string, body io.Reader) (*http.Response, error) {
+		data, _ := io.ReadAll(body)
+		json.Unmarshal(data, &posted)
+		return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(""))}, nil
+	}
+
+	dispatchPayload(&Config{}, Payload{ItemID: "NR\r", DeviceType: "scanner0"})
+	assert.Equal(t, Payload{ItemID: "NOREAD", DeviceType: "scanner0", NoRead: true}, posted)
+	assert.Equal(t, 1, noReads.count("scanner0"))
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"net"
+	"regexp"
+	"sync"
+)
+
+// OPOSBridgeConfig represents the local bridge that OPOS/JavaPOS service objects
+// connect to. OPOS service objects are COM components and JavaPOS ones are Java
+// classes, so neither can live in this binary; instead a thin service object
+// registered as a scanner device on the station forwards the POS application's
+// calls to this bridge and raises DataEvents for the scans it receives.
+type OPOSBridgeConfig struct {
+	Listen string `json:"listen"`
+}
+
+// OPOS ScanDataType values from the UnifiedPOS scanner specification
+const (
+	scanSdtUnknown = 0
+	scanSdtUPCA    = 101
+	scanSdtEAN8    = 103
+	scanSdtEAN13   = 104
+)
+
+// oposCommand is sent by a service object: open, claim, release, enable, disable or close
+type oposCommand struct {
+	Cmd string `json:"cmd"`
+}
+
+// oposMessage is sent to a service object, either as a reply to a command or as a data event
+type oposMessage struct {
+	Event         string `json:"event,omitempty"`
+	Result        string `json:"result,omitempty"`
+	ScanData      string `json:"scanData,omitempty"`
+	ScanDataLabel string `json:"scanDataLabel,omitempty"`
+	ScanDataType  int    `json:"scanDataType,omitempty"`
+	DeviceType    string `json:"deviceType,omitempty"`
+}
+
+type oposClient struct {
+	conn    net.Conn
+	enc     *json.Encoder
+	enabled bool
+	pending []oposMessage
+}
+
+// oposBridge hands scans to whichever service object has claimed the scanner,
+// mirroring the exclusive claim semantics of OPOS
+type oposBridge struct {
+	mu      sync.Mutex
+	claimed *oposClient
+}
+
+var opos *oposBridge
+
+var digitsOnly = regexp.MustCompile(`^[0-9]+$`)
+
+// scanDataType guesses the symbology from the label since HID scanners do not report it
+func scanDataType(label string) int {
+	if !digitsOnly.MatchString(label) {
+		return scanSdtUnknown
+	}
+	switch len(label) {
+	case 8:
+		return scanSdtEAN8
+	case 12:
+		return scanSdtUPCA
+	case 13:
+		return scanSdtEAN13
+	}
+	return scanSdtUnknown
+}
+
+// serveOPOSBridge accepts service object connections
+func serveOPOSBridge(config *Config, bridge *oposBridge) {
+	listener, err := net.Listen("tcp", config.OPOSBridge.Listen)
+	if err != nil {
+		logger.Errorf("Error starting OPOS bridge: %v", err)
+		return
+	}
+	logger.Infof("OPOS bridge listening on %s", config.OPOSBridge.Listen)
+	bridge.serve(listener)
+}
+
+func (b *oposBridge) serve(listener net.Listener) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			logger.Errorf("Error accepting OPOS bridge connection: %v", err)
+			return
+		}
+		go b.handle(conn)
+	}
+}
+
+func (b *oposBridge) handle(conn net.Conn) {
+	client := &oposClient{conn: conn, enc: json.NewEncoder(conn)}
+	defer func() {
+		b.release(client)
+		conn.Close()
+	}()
+
+	scanner := bufio.NewScanner(conn)
+	for scanner.Scan() {
+		var cmd oposCommand
+		if err := json.Unmarshal(scanner.Bytes(), &cmd); err != nil {
+			b.reply(client, "error")
+			continue
+		}
+		switch cmd.Cmd {
+		case "open":
+			b.reply(client, "ok")
+		case "claim":
+			b.mu.Lock()
+			if b.claimed != nil && b.claimed != client {
+				b.mu.Unlock()
+				b.reply(client, "claimed")
+				continue
+			}
+			b.claimed = client
+			b.mu.Unlock()
+			logger.Infof("OPOS service object %v claimed the scanner", conn.RemoteAddr())
+			b.reply(client, "ok")
+		case "release":
+			b.release(client)
+			b.reply(client, "ok")
+		case "enable", "disable":
+			b.mu.Lock()
+			client.enabled = cmd.Cmd == "enable"
+			b.mu.Unlock()
+			b.reply(client, "ok")
+			b.flush(client)
+		case "close":
+			b.reply(client, "ok")
+			return
+		default:
+			b.reply(client, "illegal")
+		}
+	}
+}
+
+func (b *oposBridge) reply(client *oposClient, result string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	client.enc.Encode(oposMessage{Result: result})
+}
+
+func (b *oposBridge) release(client *oposClient) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.claimed == client {
+		b.claimed = nil
+		client.pending = nil
+	}
+}
+
+// flush sends data events queued while data events were disabled
+func (b *oposBridge) flush(client *oposClient) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.claimed != client || !client.enabled {
+		return
+	}
+	for _, msg := range client.pending {
+		if err := client.enc.Encode(msg); err != nil {
+			logger.Errorf("Error sending OPOS data event: %v", err)
+			break
+		}
+	}
+	client.pending = nil
+}
+
+// deliver raises a data event for the claiming service object, queueing it
+// while data events are disabled as OPOS does
+func (b *oposBridge) deliver(payload Payload) {
+	payload.CleanItemId()
+	msg := oposMessage{
+		Event:         "data",
+		ScanData:      payload.ItemID,
+		ScanDataLabel: payload.ItemID,
+		ScanDataType:  scanDataType(payload.ItemID),
+		DeviceType:    payload.DeviceType,
+	}
+
+	b.mu.Lock()
+	client := b.claimed
+	if client != nil {
+		client.pending = append(client.pending, msg)
+	}
+	b.mu.Unlock()
+	if client != nil {
+		b.flush(client)
+	}
+}
+
+// deliverToOPOS hands the payload to the OPOS bridge if it is running
+func deliverToOPOS(payload Payload) {
+	if opos != nil {
+		opos.deliver(payload)
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type oposTestClient struct {
+	conn    net.Conn
+	scanner *bufio.Scanner
+}
+
+func dialOPOS(t *testing.T, addr string) *oposTestClient {
+	conn, err := net.Dial("tcp", addr)
+	assert.NoError(t, err)
+	return &oposTestClient{conn: conn, scanner: bufio.NewScanner(conn)}
+}
+
+func (c *oposTestClient) send(t *testing.T, cmd string) oposMessage {
+	_, err := c.conn.Write([]byte(`{"cmd":"` + cmd + `"}` + "\n"))
+	assert.NoError(t, err)
+	return c.read(t)
+}
+
+func (c *oposTestClient) read(t *testing.T) oposMessage {
+	assert.True(t, c.scanner.Scan())
+	var msg oposMessage
+	assert.NoError(t, json.Unmarshal(c.scanner.Bytes(), &msg))
+	return msg
+}
+
+func TestScanDataType(t *testing.T) {
+	assert.Equal(t, scanSdtUPCA, scanDataType("012345678905"))
+	assert.Equal(t, scanSdtEAN13, scanDataType("4006381333931"))
+	assert.Equal(t, scanSdtEAN8, scanDataType("96385074"))
+	assert.Equal(t, scanSdtUnknown, scanDataType("ABC-123"))
+}
+
+func TestOPOSBridge_ClaimAndDataEvents(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer listener.Close()
+	bridge := &oposBridge{}
+	go bridge.serve(listener)
+
+	pos := dialOPOS(t, listener.Addr().String())
+	defer pos.conn.Close()
+	other := dialOPOS(t, listener.Addr().String())
+	defer other.conn.Close()
+
+	assert.Equal(t, "ok", pos.send(t, "open").Result)
+	assert.Equal(t, "ok", pos.send(t, "claim").Result)
+	assert.Equal(t, "claimed", other.send(t, "claim").Result)
+
+	// data events are queued until enabled
+	bridge.deliver(Payload{ItemID: "id=012345678905", DeviceType: "scanner0"})
+	assert.Equal(t, "ok", pos.send(t, "enable").Result)
+	event := pos.read(t)
+	assert.Equal(t, "data", event.Event)
+	assert.Equal(t, "012345678905", event.ScanDataLabel)
+	assert.Equal(t, scanSdtUPCA, event.ScanDataType)
+
+	assert.Equal(t, "ok", pos.send(t, "release").Result)
+	assert.Equal(t, "ok", other.send(t, "claim").Result)
+}
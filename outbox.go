@@ -0,0 +1,139 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	_ "github.com/microsoft/go-mssqldb"
+	_ "modernc.org/sqlite"
+)
+
+// OutboxConfig represents an outbox table in the local POS database whose rows
+// are forwarded as payloads
+type OutboxConfig struct {
+	Driver       string `json:"driver"` // sqlite or sqlserver
+	DSN          string `json:"dsn"`
+	Table        string `json:"table"`
+	IDColumn     string `json:"idColumn"`
+	ItemColumn   string `json:"itemColumn"`
+	PollInterval int    `json:"pollInterval"`
+	BatchSize    int    `json:"batchSize"`
+	OffsetFile   string `json:"offsetFile"`
+}
+
+var sqlIdentifier = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*(\.[A-Za-z_][A-Za-z0-9_]*)?$`)
+
+func (o OutboxConfig) enabled() bool {
+	return o.Driver != "" && o.DSN != "" && o.Table != ""
+}
+
+func (o OutboxConfig) withDefaults() OutboxConfig {
+	if o.IDColumn == "" {
+		o.IDColumn = "id"
+	}
+	if o.ItemColumn == "" {
+		o.ItemColumn = "itemid"
+	}
+	if o.PollInterval <= 0 {
+		o.PollInterval = 5
+	}
+	if o.BatchSize <= 0 {
+		o.BatchSize = 100
+	}
+	if o.OffsetFile == "" {
+		o.OffsetFile = filepath.Join(stateDir, "outbox.offset")
+	}
+	return o
+}
+
+// outboxQuery builds the driver specific query for the next batch of rows
+func outboxQuery(o OutboxConfig) (string, error) {
+	for _, name := range []string{o.Table, o.IDColumn, o.ItemColumn} {
+		if !sqlIdentifier.MatchString(name) {
+			return "", fmt.Errorf("invalid outbox identifier %q", name)
+		}
+	}
+	switch o.Driver {
+	case "sqlite":
+		return fmt.Sprintf("SELECT %s, %s FROM %s WHERE %s > ? ORDER BY %s LIMIT %d",
+			o.IDColumn, o.ItemColumn, o.Table, o.IDColumn, o.IDColumn, o.BatchSize), nil
+	case "sqlserver":
+		return fmt.Sprintf("SELECT TOP %d %s, %s FROM %s WHERE %s > @p1 ORDER BY %s",
+			o.BatchSize, o.IDColumn, o.ItemColumn, o.Table, o.IDColumn, o.IDColumn), nil
+	default:
+		return "", fmt.Errorf("unsupported outbox driver %q", o.Driver)
+	}
+}
+
+// readOutboxOffset returns the last forwarded row ID, or 0 if none was recorded
+func readOutboxOffset(path string) int64 {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0
+	}
+	offset, err := strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64)
+	if err != nil {
+		logger.Errorf("Ignoring invalid outbox offset in %s: %v", path, err)
+		return 0
+	}
+	return offset
+}
+
+func writeOutboxOffset(path string, offset int64) error {
+	return writeFileAtomic(path, []byte(strconv.FormatInt(offset, 10)))
+}
+
+// pollOutbox forwards rows newer than the stored offset and returns the new offset
+func pollOutbox(db *sql.DB, o OutboxConfig, query string, offset int64, payloadCh chan Payload) (int64, error) {
+	rows, err := db.Query(query, offset)
+	if err != nil {
+		return offset, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id int64
+		var item string
+		if err := rows.Scan(&id, &item); err != nil {
+			return offset, err
+		}
+		payloadCh <- Payload{ItemID: item, DeviceType: "outbox"}
+		offset = id
+		if err := writeOutboxOffset(o.OffsetFile, offset); err != nil {
+			return offset, err
+		}
+	}
+	return offset, rows.Err()
+}
+
+// tailOutbox polls the outbox table and forwards new rows as payloads
+func tailOutbox(config *Config, payloadCh chan Payload) {
+	o := config.Outbox.withDefaults()
+	query, err := outboxQuery(o)
+	if err != nil {
+		logger.Errorf("Error configuring outbox input: %v", err)
+		return
+	}
+	db, err := sql.Open(o.Driver, o.DSN)
+	if err != nil {
+		logger.Errorf("Error opening outbox database: %v", err)
+		return
+	}
+	defer db.Close()
+
+	offset := readOutboxOffset(o.OffsetFile)
+	logger.Infof("Tailing outbox table %s from offset %d", o.Table, offset)
+	for {
+		offset, err = pollOutbox(db, o, query, offset, payloadCh)
+		if err != nil {
+			logger.Errorf("Error reading outbox table %s: %v", o.Table, err)
+		}
+		time.Sleep(time.Duration(o.PollInterval) * time.Second)
+	}
+}
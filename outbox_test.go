@@ -0,0 +1,46 @@
+package main
+
+import (
+	"database/sql"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOutboxQuery(t *testing.T) {
+	query, err := outboxQuery(OutboxConfig{Driver: "sqlserver", Table: "dbo.outbox"}.withDefaults())
+	assert.NoError(t, err)
+	assert.Equal(t, "SELECT TOP 100 id, itemid FROM dbo.outbox WHERE id > @p1 ORDER BY id", query)
+
+	_, err = outboxQuery(OutboxConfig{Driver: "sqlite", Table: "outbox; DROP TABLE sales"}.withDefaults())
+	assert.Error(t, err)
+}
+
+func TestPollOutbox(t *testing.T) {
+	dir := t.TempDir()
+	o := OutboxConfig{Driver: "sqlite", DSN: filepath.Join(dir, "pos.db"), Table: "outbox",
+		ItemColumn: "sku", OffsetFile: filepath.Join(dir, "outbox.offset")}.withDefaults()
+
+	db, err := sql.Open(o.Driver, o.DSN)
+	assert.NoError(t, err)
+	defer db.Close()
+	_, err = db.Exec(`CREATE TABLE outbox (id INTEGER PRIMARY KEY, sku TEXT);
+		INSERT INTO outbox (id, sku) VALUES (1, 'A1'), (2, 'B2'), (3, 'C3');`)
+	assert.NoError(t, err)
+
+	query, err := outboxQuery(o)
+	assert.NoError(t, err)
+	payloadCh := make(chan Payload, 10)
+	offset, err := pollOutbox(db, o, query, 1, payloadCh)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), offset)
+	assert.Equal(t, int64(3), readOutboxOffset(o.OffsetFile))
+	assert.Equal(t, Payload{ItemID: "B2", DeviceType: "outbox"}, <-payloadCh)
+	assert.Equal(t, Payload{ItemID: "C3", DeviceType: "outbox"}, <-payloadCh)
+
+	offset, err = pollOutbox(db, o, query, offset, payloadCh)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), offset)
+	assert.Empty(t, payloadCh)
+}
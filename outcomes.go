@@ -0,0 +1,216 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Scan outcomes that can be routed to signals
+const (
+	outcomeAccepted  = "accepted"
+	outcomeRejected  = "rejected"
+	outcomeQueued    = "queued"
+	outcomeNoRead    = "no-read"
+	outcomeDuplicate = "duplicate"
+)
+
+// OutcomesConfig represents routing per-scan outcomes to PLC coils and
+// webhooks, so downstream handling such as a divert can react to each scan
+type OutcomesConfig struct {
+	Routes []OutcomeRoute `json:"routes"`
+	// DuplicateSeconds reports a repeat scan of the same item within this many seconds as a duplicate, which is not posted (0 disables)
+	DuplicateSeconds int `json:"duplicateSeconds"`
+	// TLS configures the connections to webhooks
+	TLS TLSConfig `json:"tls"`
+}
+
+// OutcomeRoute sends one outcome to a coil, a webhook or both
+type OutcomeRoute struct {
+	// Outcome is accepted, rejected, queued, no-read or duplicate
+	Outcome string `json:"outcome"`
+	// Coil is pulsed on the PLC configured in modbus
+	Coil *int `json:"coil"`
+	// Webhook receives a POST of the ScanOutcome as JSON
+	Webhook string `json:"webhook"`
+}
+
+// ScanOutcome is the body posted to outcome webhooks
+type ScanOutcome struct {
+	Time       time.Time `json:"time"`
+	Outcome    string    `json:"outcome"`
+	StationID  string    `json:"stationId,omitempty"`
+	ItemID     string    `json:"itemid,omitempty"`
+	DeviceType string    `json:"deviceType,omitempty"`
+	Sequence   uint64    `json:"sequence,omitempty"`
+	Reason     string    `json:"reason,omitempty"`
+}
+
+// outcomeRouter sends scan outcomes to their routes. A nil router only pulses
+// the PLC's OK/NOK coils.
+type outcomeRouter struct {
+	routes    map[string][]OutcomeRoute
+	window    time.Duration
+	stationID string
+	client    *http.Client
+
+	mu       sync.Mutex
+	lastSeen map[string]time.Time
+}
+
+var outcomes *outcomeRouter
+
+func newOutcomeRouter(config OutcomesConfig, stationID string, hasPLC bool) (*outcomeRouter, error) {
+	client, err := newHTTPClient(config.TLS)
+	if err != nil {
+		return nil, err
+	}
+	client.Timeout = 5 * time.Second
+	r := &outcomeRouter{
+		routes:    map[string][]OutcomeRoute{},
+		window:    time.Duration(config.DuplicateSeconds) * time.Second,
+		stationID: stationID,
+		client:    client,
+		lastSeen:  map[string]time.Time{},
+	}
+	for _, route := range config.Routes {
+		switch route.Outcome {
+		case outcomeAccepted, outcomeRejected, outcomeQueued, outcomeNoRead, outcomeDuplicate:
+		default:
+			return nil, fmt.Errorf("unknown outcome %q", route.Outcome)
+		}
+		if route.Coil == nil && route.Webhook == "" {
+			return nil, fmt.Errorf("route for %s has neither coil nor webhook", route.Outcome)
+		}
+		if route.Coil != nil && !hasPLC {
+			return nil, fmt.Errorf("route for %s uses a coil but no modbus PLC is configured", route.Outcome)
+		}
+		r.routes[route.Outcome] = append(r.routes[route.Outcome], route)
+	}
+	return r, nil
+}
+
+// duplicate reports whether the item was scanned within the duplicate window,
+// and remembers this scan
+func (r *outcomeRouter) duplicate(itemID string, now time.Time) bool {
+	if r == nil || r.window <= 0 {
+		return false
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for id, at := range r.lastSeen {
+		if now.Sub(at) >= r.window {
+			delete(r.lastSeen, id)
+		}
+	}
+	_, seen := r.lastSeen[itemID]
+	r.lastSeen[itemID] = now
+	return seen
+}
+
+// route pulses the coils and calls the webhooks of the outcome
+func (r *outcomeRouter) route(payloads []Payload, outcome, reason string) {
+	if r == nil {
+		return
+	}
+	for _, route := range r.routes[outcome] {
+		if route.Coil != nil {
+			plc.pulse(*route.Coil)
+		}
+		if route.Webhook == "" {
+			continue
+		}
+		for _, payload := range payloads {
+			go r.call(route.Webhook, ScanOutcome{
+				Time:       time.Now(),
+				Outcome:    outcome,
+				StationID:  r.stationID,
+				ItemID:     payload.ItemID,
+				DeviceType: payload.DeviceType,
+				Sequence:   payload.Sequence,
+				Reason:     reason,
+			})
+		}
+	}
+}
+
+// call posts an outcome to a webhook
+func (r *outcomeRouter) call(url string, outcome ScanOutcome) {
+	data, err := json.Marshal(outcome)
+	if err != nil {
+		logger.Errorf("Error marshaling scan outcome: %v", err)
+		return
+	}
+	resp, err := r.client.Post(url, "application/json", bytes.NewReader(data))
+	if err != nil {
+		logger.Errorf("Error calling outcome webhook %s: %v", url, err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		logger.Errorf("Error calling outcome webhook %s: response code %d", url, resp.StatusCode)
+	}
+}
+
+// reportOutcome signals the outcome of one scan
+func reportOutcome(payload Payload, outcome, reason string) {
+	reportOutcomes([]Payload{payload}, outcome, reason)
+}
+
+// reportOutcomes signals one outcome shared by several scans, such as a
+// batch. Coils are pulsed once; webhooks are called for each scan.
+func reportOutcomes(payloads []Payload, outcome, reason string) {
+	if outcome == outcomeAccepted || outcome == outcomeRejected || outcome == outcomeQueued {
+		// a no-read payload was already reported as a no-read, and its post
+		// result must not pulse OK for a package that was never identified
+		var scans []Payload
+		for _, payload := range payloads {
+			if !payload.NoRead {
+				scans = append(scans, payload)
+			}
+		}
+		if len(scans) == 0 {
+			return
+		}
+		payloads = scans
+	}
+	switch outcome {
+	case outcomeAccepted:
+		plc.result(true)
+	case outcomeRejected, outcomeQueued:
+		plc.result(false)
+	}
+	outcomes.route(payloads, outcome, reason)
+}
+
+// postFailedOutcome is the outcome of a failed post: rejected when the API
+// refused the payload itself, queued when the failure is retried from the queue
+func postFailedOutcome(statusCode int) string {
+	if nonRetryable(statusCode) {
+		return outcomeRejected
+	}
+	return outcomeQueued
+}
+
+// purge forgets the matching item IDs seen for duplicate detection, returning
+// how many match. A dry run only counts them.
+func (r *outcomeRouter) purge(match func(itemID string) bool, dryRun bool) int {
+	if r == nil {
+		return 0
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	removed := 0
+	for id := range r.lastSeen {
+		if match(id) {
+			removed++
+			if !dryRun {
+				delete(r.lastSeen, id)
+			}
+		}
+	}
+	return removed
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewOutcomeRouter_Validation(t *testing.T) {
+	_, err := newOutcomeRouter(OutcomesConfig{Routes: []OutcomeRoute{{Outcome: "maybe", Webhook: "http://x"}}}, "", false)
+	assert.EqualError(t, err, `unknown outcome "maybe"`)
+	_, err = newOutcomeRouter(OutcomesConfig{Routes: []OutcomeRoute{{Outcome: outcomeNoRead}}}, "", false)
+	assert.Error(t, err)
+	_, err = newOutcomeRouter(OutcomesConfig{Routes: []OutcomeRoute{{Outcome: outcomeNoRead, Coil: coilAddress(5)}}}, "", false)
+	assert.EqualError(t, err, "route for no-read uses a coil but no modbus PLC is configured")
+}
+
+func TestOutcomeRouter_Duplicate(t *testing.T) {
+	r, err := newOutcomeRouter(OutcomesConfig{DuplicateSeconds: 2}, "", false)
+	assert.NoError(t, err)
+	start := time.Now()
+	assert.False(t, r.duplicate("12345", start))
+	assert.True(t, r.duplicate("12345", start.Add(time.Second)))
+	assert.False(t, r.duplicate("12346", start.Add(time.Second)))
+	assert.False(t, r.duplicate("12345", start.Add(4*time.Second)))
+}
+
+func TestReportOutcome_RoutesToWebhookAndCoil(t *testing.T) {
+	received := make(chan ScanOutcome, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var outcome ScanOutcome
+		json.NewDecoder(r.Body).Decode(&outcome)
+		received <- outcome
+	}))
+	defer server.Close()
+	fake := &fakePLC{}
+	oldPLC, oldOutcomes := plc, outcomes
+	defer func() { plc, outcomes = oldPLC, oldOutcomes }()
+	plc = newPLCLink(ModbusConfig{Address: fake.start(t), NOKCoil: coilAddress(2), PulseMillis: 10})
+	var err error
+	outcomes, err = newOutcomeRouter(OutcomesConfig{Routes: []OutcomeRoute{
+		{Outcome: outcomeRejected, Webhook: server.URL},
+		{Outcome: outcomeDuplicate, Coil: coilAddress(3)},
+	}}, "station-7", true)
+	assert.NoError(t, err)
+
+	reportOutcome(Payload{ItemID: "12345", DeviceType: "scanner0", Sequence: 9}, outcomeRejected, "response code 422")
+	select {
+	case outcome := <-received:
+		assert.Equal(t, outcomeRejected, outcome.Outcome)
+		assert.Equal(t, "station-7", outcome.StationID)
+		assert.Equal(t, "12345", outcome.ItemID)
+		assert.Equal(t, uint64(9), outcome.Sequence)
+		assert.Equal(t, "response code 422", outcome.Reason)
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for outcome webhook")
+	}
+
+	reportOutcome(Payload{ItemID: "12345"}, outcomeDuplicate, "")
+	assert.Eventually(t, func() bool {
+		fake.mu.Lock()
+		defer fake.mu.Unlock()
+		return len(fake.writes) == 4
+	}, time.Second, 5*time.Millisecond)
+	assert.ElementsMatch(t, []string{"2=true", "2=false", "3=true", "3=false"}, fake.writes)
+}
+
+func TestPLCLink_NoRead(t *testing.T) {
+	received := make(chan ScanOutcome, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var outcome ScanOutcome
+		json.NewDecoder(r.Body).Decode(&outcome)
+		received <- outcome
+	}))
+	defer server.Close()
+	oldOutcomes := outcomes
+	defer func() { outcomes = oldOutcomes }()
+	outcomes, _ = newOutcomeRouter(OutcomesConfig{Routes: []OutcomeRoute{{Outcome: outcomeNoRead, Webhook: server.URL}}}, "", false)
+	fake := &fakePLC{}
+	link := newPLCLink(ModbusConfig{Address: fake.start(t), PresentCoil: coilAddress(0)})
+
+	// a package with a read passes quietly
+	fake.set(0, true)
+	link.poll()
+	assert.True(t, link.allows())
+	fake.set(0, false)
+	link.poll()
+
+	// a package without one is a no-read
+	fake.set(0, true)
+	link.poll()
+	fake.set(0, false)
+	link.poll()
+	select {
+	case outcome := <-received:
+		assert.Equal(t, outcomeNoRead, outcome.Outcome)
+		assert.Equal(t, "package passed without a read", outcome.Reason)
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for no-read")
+	}
+	assert.Empty(t, received)
+}
+
+func TestPostFailed_OnlyDefinitiveRejectionsAreRejected(t *testing.T) {
+	received := make(chan ScanOutcome, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var outcome ScanOutcome
+		json.NewDecoder(r.Body).Decode(&outcome)
+		received <- outcome
+	}))
+	defer server.Close()
+	oldOutcomes := outcomes
+	defer func() { outcomes = oldOutcomes }()
+	var err error
+	outcomes, err = newOutcomeRouter(OutcomesConfig{Routes: []OutcomeRoute{
+		{Outcome: outcomeRejected, Webhook: server.URL},
+		{Outcome: outcomeQueued, Webhook: server.URL},
+	}}, "", false)
+	assert.NoError(t, err)
+
+	for statusCode, want := range map[int]string{
+		0:                              outcomeQueued,
+		http.StatusServiceUnavailable:  outcomeQueued,
+		http.StatusTooManyRequests:     outcomeQueued,
+		http.StatusUnprocessableEntity: outcomeRejected,
+	} {
+		bus.postFailed.publish(PostFailed{Payloads: []Payload{{ItemID: "12345"}}, StatusCode: statusCode})
+		select {
+		case outcome := <-received:
+			assert.Equal(t, want, outcome.Outcome, "response code %d", statusCode)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for the outcome of response code %d", statusCode)
+		}
+	}
+}
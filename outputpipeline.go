@@ -0,0 +1,257 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// outputQueueSize is how many payloads an output holds in memory before spilling to its queue file
+	outputQueueSize = 256
+	// outputRetryInterval is how often an output replays its queue file
+	outputRetryInterval = 30 * time.Second
+)
+
+// outputQueueDir holds one queue file per output, set by applyStorage
+var outputQueueDir = filepath.Join("queue", "outputs")
+
+// outputPipeline delivers to one output from its own goroutine, memory queue
+// and queue file, so a slow or failing output never delays the others
+type outputPipeline struct {
+	queue chan Payload
+	quit  chan struct{}
+	done  chan struct{}
+	// fileMu guards the queue file. Others only append; the worker also removes delivered entries from the front.
+	// A pipeline that takes over an output after a reload shares the lock of the one it replaces.
+	fileMu *sync.Mutex
+}
+
+// outputQueueFile is where the payloads the named output could not take yet are kept
+func outputQueueFile(name string) string {
+	name = strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_' || r == '.' {
+			return r
+		}
+		return '_'
+	}, name)
+	return filepath.Join(outputQueueDir, name+".log")
+}
+
+func (o *httpOutput) queueFile() string {
+	return outputQueueFile(o.config.Name)
+}
+
+// start runs the output's pipeline
+func (o *httpOutput) start() {
+	o.open(nil)
+	go o.run()
+}
+
+// open creates the output's pipeline, which takes payloads before it runs.
+// A nil fileMu gives the queue file a lock of its own.
+func (o *httpOutput) open(fileMu *sync.Mutex) {
+	if fileMu == nil {
+		fileMu = &sync.Mutex{}
+	}
+	o.pipeline = &outputPipeline{queue: make(chan Payload, outputQueueSize), quit: make(chan struct{}), done: make(chan struct{}), fileMu: fileMu}
+}
+
+// stop waits for the post in flight and keeps the payloads still in memory in
+// the queue file, for the pipeline that takes over the output after a reload
+func (o *httpOutput) stop() {
+	close(o.pipeline.quit)
+	<-o.pipeline.done
+}
+
+// enqueue hands a payload to the pipeline without waiting for the output
+func (o *httpOutput) enqueue(payload Payload) {
+	select {
+	case o.pipeline.queue <- payload:
+	default:
+		logger.Warnf("Output %s is falling behind; queueing payload %v to %s", o.config.Name, payload, o.queueFile())
+		o.spill(payload)
+	}
+}
+
+func (o *httpOutput) run() {
+	defer close(o.pipeline.done)
+	retry := time.NewTicker(outputRetryInterval)
+	defer retry.Stop()
+	for !o.stopping() {
+		select {
+		case <-o.pipeline.quit:
+		case payload := <-o.pipeline.queue:
+			o.deliver(payload)
+		case <-retry.C:
+			o.replay()
+		}
+	}
+	for {
+		select {
+		case payload := <-o.pipeline.queue:
+			o.spill(payload)
+		default:
+			return
+		}
+	}
+}
+
+// stopping reports whether stop was called
+func (o *httpOutput) stopping() bool {
+	select {
+	case <-o.pipeline.quit:
+		return true
+	default:
+		return false
+	}
+}
+
+// deliver posts a payload, queueing it for a retry on failure
+func (o *httpOutput) deliver(payload Payload) bool {
+	if err := o.post(payload); err != nil {
+		logger.Errorf("Error posting payload %v to output %s: %v", payload, o.config.Name, err)
+		o.spill(payload)
+		return false
+	}
+	logger.Debugf("Posted payload %v to output %s", payload, o.config.Name)
+	return true
+}
+
+// spill appends a payload to the output's queue file
+func (o *httpOutput) spill(payload Payload) {
+	entry, err := encodeQueueEntry(payload, time.Now())
+	if err != nil {
+		logger.Errorf("Error marshaling payload: %v", err)
+		return
+	}
+	o.pipeline.fileMu.Lock()
+	defer o.pipeline.fileMu.Unlock()
+	if err := os.MkdirAll(outputQueueDir, 0755); err != nil {
+		logger.Errorf("Error creating %s: %v", outputQueueDir, err)
+		return
+	}
+	if err := appendRecord(o.queueFile(), []byte(entry)); err != nil {
+		logger.Errorf("Error writing to %s: %v", o.queueFile(), err)
+	}
+}
+
+// readQueueFile returns the lines of the output's queue file
+func (o *httpOutput) readQueueFile() []string {
+	o.pipeline.fileMu.Lock()
+	defer o.pipeline.fileMu.Unlock()
+	file, err := os.Open(o.queueFile())
+	if err != nil {
+		return nil
+	}
+	defer file.Close()
+	var lines []string
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+// replay posts the queued payloads in order, stopping at the first failure
+// since the output is most likely still down. The file is not locked while
+// posting, so payloads can still be queued behind the ones being replayed.
+func (o *httpOutput) replay() {
+	lines := o.readQueueFile()
+	// done counts the delivered entries by content, since a purge may remove entries meanwhile
+	done := map[string]int{}
+	delivered := 0
+	for _, line := range lines {
+		if o.stopping() {
+			break
+		}
+		payload, _, err := decodeQueueEntry(line)
+		if err != nil {
+			logger.Errorf("Error decoding queued payload for output %s, dropping it: %v", o.config.Name, err)
+			done[line]++
+			delivered++
+			continue
+		}
+		if !retryBudget.allow(time.Now()) {
+			break
+		}
+		if err := o.post(payload); err != nil {
+			logger.Warnf("Output %s still failing, %d payloads queued: %v", o.config.Name, len(lines)-delivered, err)
+			break
+		}
+		done[line]++
+		delivered++
+	}
+	if delivered == 0 {
+		return
+	}
+
+	o.pipeline.fileMu.Lock()
+	defer o.pipeline.fileMu.Unlock()
+	data, err := os.ReadFile(o.queueFile())
+	if err != nil {
+		logger.Errorf("Error reading %s: %v", o.queueFile(), err)
+		return
+	}
+	// only the delivered entries are removed; later ones were appended while posting
+	var remaining []string
+	for _, line := range strings.Split(string(data), "\n") {
+		entry := strings.TrimSpace(line)
+		if entry == "" {
+			continue
+		}
+		if done[entry] > 0 {
+			done[entry]--
+			continue
+		}
+		remaining = append(remaining, line+"\n")
+	}
+	if err := writeFileAtomic(o.queueFile(), []byte(strings.Join(remaining, ""))); err != nil {
+		logger.Errorf("Error writing %s: %v", o.queueFile(), err)
+	}
+}
+
+// backlog is the number of payloads waiting for the output
+func (o *httpOutput) backlog() int {
+	if o.pipeline == nil {
+		return 0
+	}
+	return len(o.pipeline.queue) + len(o.readQueueFile())
+}
+
+// startOutputs starts the pipeline of every output
+func startOutputs(outputs []*httpOutput) {
+	for _, o := range outputs {
+		o.start()
+	}
+}
+
+// stopOutputs stops the running pipelines, of the outputs and the output
+// plugins, keeping undelivered payloads in their queue files
+func stopOutputs() {
+	outputsMu.Lock()
+	defer outputsMu.Unlock()
+	for _, o := range append(append([]*httpOutput{}, httpOutputs...), outputPlugins...) {
+		if o.pipeline != nil {
+			o.stop()
+		}
+	}
+}
+
+// outputBacklog is the number of payloads waiting for the named output
+func outputBacklog(name string) int {
+	outputsMu.RLock()
+	defer outputsMu.RUnlock()
+	for _, o := range httpOutputs {
+		if o.config.Name == name {
+			return o.backlog()
+		}
+	}
+	return 0
+}
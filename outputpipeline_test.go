@@ -0,0 +1,75 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOutputPipelines_Independent(t *testing.T) {
+	useTempQueue(t)
+	oldOutputs := httpOutputs
+	defer func() { httpOutputs = oldOutputs }()
+
+	var down int32 = 1
+	var fastPosts, flakyPosts int32
+	release := make(chan bool)
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { <-release }))
+	defer slow.Close()
+	fast := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&fastPosts, 1)
+	}))
+	defer fast.Close()
+	flaky := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.LoadInt32(&down) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		atomic.AddInt32(&flakyPosts, 1)
+	}))
+	defer flaky.Close()
+
+	outputs, err := loadOutputs(&Config{Outputs: []OutputConfig{
+		{Name: "slow", Endpoint: slow.URL}, {Name: "fast", Endpoint: fast.URL}, {Name: "flaky", Endpoint: flaky.URL},
+	}})
+	assert.NoError(t, err)
+	httpOutputs = outputs
+	startOutputs(outputs)
+
+	// a hung output and a failing one do not hold up the healthy one
+	deliverToOutputs(Payload{ItemID: "1"})
+	deliverToOutputs(Payload{ItemID: "2"})
+	assert.Eventually(t, func() bool { return atomic.LoadInt32(&fastPosts) == 2 }, time.Second, 10*time.Millisecond)
+	assert.Eventually(t, func() bool { return outputBacklog("flaky") == 2 }, time.Second, 10*time.Millisecond)
+
+	// the failing output catches up from its own queue once it recovers
+	atomic.StoreInt32(&down, 0)
+	outputs[2].replay()
+	assert.Equal(t, int32(2), atomic.LoadInt32(&flakyPosts))
+	assert.Equal(t, 0, outputBacklog("flaky"))
+
+	close(release)
+	stopOutputs()
+}
+
+func TestOutputPipeline_StopKeepsQueued(t *testing.T) {
+	useTempQueue(t)
+	o := &httpOutput{config: OutputConfig{Name: "relay/a"}}
+	o.pipeline = &outputPipeline{queue: make(chan Payload, 2), quit: make(chan struct{}), done: make(chan struct{}), fileMu: &sync.Mutex{}}
+	o.pipeline.queue <- Payload{ItemID: "1"}
+	o.pipeline.queue <- Payload{ItemID: "2"}
+	close(o.pipeline.quit)
+	o.run()
+
+	assert.Equal(t, 2, o.backlog())
+	assert.Equal(t, "relay_a.log", filepath.Base(o.queueFile()))
+	payload, _, err := decodeQueueEntry(o.readQueueFile()[0])
+	assert.NoError(t, err)
+	assert.Equal(t, "1", payload.ItemID)
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/zachthieme/scanandpost/scan"
+)
+
+// OutputConfig represents an additional HTTP endpoint every payload is posted to,
+// such as a canary backend, a site relay or a webhook
+type OutputConfig struct {
+	Name string `json:"name"`
+	// Endpoint may refer to payload fields, as in https://example.com/items/{itemid}/scan
+	Endpoint string `json:"endpoint"`
+	// Method is POST (default), PUT, PATCH, GET or DELETE
+	Method string    `json:"method"`
+	TLS    TLSConfig `json:"tls"`
+	// Encoding is "json" (default) or "cbor"
+	Encoding string `json:"encoding"`
+	// Type is "http" (default) or "graphql"
+	Type    string        `json:"type"`
+	GraphQL GraphQLConfig `json:"graphql"`
+}
+
+// GraphQLConfig represents the mutation sent by a graphql output
+type GraphQLConfig struct {
+	Query         string `json:"query"`
+	OperationName string `json:"operationName"`
+	// Variables maps each variable to a payload field, or to "payload" for the whole payload
+	Variables map[string]string `json:"variables"`
+}
+
+// httpOutput is a configured output with its own HTTP client
+type httpOutput struct {
+	config   OutputConfig
+	client   *http.Client
+	output   scan.Output
+	pipeline *outputPipeline
+}
+
+// httpOutputs are the configured outputs, guarded by outputsMu
+var httpOutputs []*httpOutput
+
+// outputCount is the number of configured outputs
+func outputCount() int {
+	outputsMu.RLock()
+	defer outputsMu.RUnlock()
+	return len(httpOutputs)
+}
+
+// loadOutputs builds an HTTP client for each configured output
+func loadOutputs(config *Config) ([]*httpOutput, error) {
+	var outputs []*httpOutput
+	for _, cfg := range config.Outputs {
+		if !scan.ValidEncoding(cfg.Encoding) {
+			return nil, fmt.Errorf("output %s: unknown encoding %q", cfg.Name, cfg.Encoding)
+		}
+		client, err := newInstrumentedClient(cfg.Name, cfg.TLS)
+		if err != nil {
+			return nil, fmt.Errorf("output %s: %v", cfg.Name, err)
+		}
+		output, err := newOutput(cfg, client)
+		if err != nil {
+			return nil, fmt.Errorf("output %s: %v", cfg.Name, err)
+		}
+		outputs = append(outputs, &httpOutput{config: cfg, client: client, output: output})
+	}
+	return outputs, nil
+}
+
+// newOutput builds the scan output of the configured type
+func newOutput(cfg OutputConfig, client *http.Client) (scan.Output, error) {
+	switch cfg.Type {
+	case "", "http":
+		switch cfg.Method {
+		case "", http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodGet, http.MethodDelete:
+		default:
+			return nil, fmt.Errorf("unsupported method %q", cfg.Method)
+		}
+		if err := scan.ValidateURLTemplate(cfg.Endpoint); err != nil {
+			return nil, err
+		}
+		// outputs such as webhooks answer PUT and DELETE with 201 or 204
+		return &scan.HTTPOutput{OutputName: cfg.Name, Endpoint: cfg.Endpoint, Method: cfg.Method, Client: client, Encoding: cfg.Encoding, Any2xx: true}, nil
+	case "graphql":
+		if cfg.GraphQL.Query == "" {
+			return nil, fmt.Errorf("graphql query is required")
+		}
+		if err := scan.ValidateGraphQLVariables(cfg.GraphQL.Variables); err != nil {
+			return nil, err
+		}
+		return &scan.GraphQLOutput{OutputName: cfg.Name, Endpoint: cfg.Endpoint, Query: cfg.GraphQL.Query,
+			OperationName: cfg.GraphQL.OperationName, Variables: cfg.GraphQL.Variables, Client: client}, nil
+	}
+	return nil, fmt.Errorf("unknown type %q", cfg.Type)
+}
+
+// post sends the payload to the output
+func (o *httpOutput) post(payload Payload) error {
+	return o.output.Deliver(payload)
+}
+
+// deliverToOutputs hands the payload to the pipeline of every additional output
+func deliverToOutputs(payload Payload) {
+	outputsMu.RLock()
+	defer outputsMu.RUnlock()
+	for _, o := range httpOutputs {
+		o.enqueue(payload)
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDeliverToOutputs(t *testing.T) {
+	received := make(chan string, 1)
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		received <- string(body)
+	}))
+	defer server.Close()
+
+	outputs, err := loadOutputs(&Config{Outputs: []OutputConfig{
+		{Name: "webhook", Endpoint: server.URL, TLS: TLSConfig{CAFile: writeServerCA(t, server)}},
+	}})
+	assert.NoError(t, err)
+	oldOutputs := httpOutputs
+	defer func() { httpOutputs = oldOutputs }()
+	httpOutputs = outputs
+	startOutputs(outputs)
+	defer stopOutputs()
+
+	deliverToOutputs(Payload{ItemID: "12345", DeviceType: "scanner0"})
+	assert.Equal(t, `{"itemid":"12345","deviceType":"scanner0"}`, <-received)
+}
+
+func TestHTTPOutputPost_ErrorStatus(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer server.Close()
+
+	outputs, err := loadOutputs(&Config{Outputs: []OutputConfig{{Name: "relay", Endpoint: server.URL}}})
+	assert.NoError(t, err)
+	assert.EqualError(t, outputs[0].post(Payload{ItemID: "12345"}), "response code: 502")
+}
+
+func TestHTTPOutputPost_MethodAndURLTemplate(t *testing.T) {
+	var method, path string
+	var bodyLen int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		method, path, bodyLen = r.Method, r.URL.EscapedPath(), len(body)
+	}))
+	defer server.Close()
+
+	outputs, err := loadOutputs(&Config{Outputs: []OutputConfig{
+		{Name: "items", Endpoint: server.URL + "/items/{itemid}/scan", Method: http.MethodPut},
+		{Name: "lookup", Endpoint: server.URL + "/lookup/{itemid}", Method: http.MethodGet},
+	}})
+	assert.NoError(t, err)
+
+	assert.NoError(t, outputs[0].post(Payload{ItemID: "AB 12"}))
+	assert.Equal(t, http.MethodPut, method)
+	assert.Equal(t, "/items/AB%2012/scan", path)
+	assert.NotZero(t, bodyLen)
+
+	assert.NoError(t, outputs[1].post(Payload{ItemID: "AB"}))
+	assert.Equal(t, http.MethodGet, method)
+	assert.Zero(t, bodyLen)
+
+	_, err = loadOutputs(&Config{Outputs: []OutputConfig{{Name: "bad", Endpoint: "http://x/{sku}"}}})
+	assert.EqualError(t, err, "output bad: unknown placeholder {sku}")
+	_, err = loadOutputs(&Config{Outputs: []OutputConfig{{Name: "bad", Endpoint: "http://x", Method: "TRACE"}}})
+	assert.EqualError(t, err, `output bad: unsupported method "TRACE"`)
+}
+
+func TestGraphQLOutput(t *testing.T) {
+	var request map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&request)
+		if request["variables"].(map[string]interface{})["item"] == "bad" {
+			w.Write([]byte(`{"data": null, "errors": [{"message": "unknown item", "path": ["recordScan"]}]}`))
+			return
+		}
+		w.Write([]byte(`{"data": {"recordScan": {"id": "1"}}}`))
+	}))
+	defer server.Close()
+
+	outputs, err := loadOutputs(&Config{Outputs: []OutputConfig{{Name: "gql", Type: "graphql", Endpoint: server.URL, GraphQL: GraphQLConfig{
+		Query:     "mutation Scan($item: String!, $station: String!) { recordScan(item: $item, station: $station) { id } }",
+		Variables: map[string]string{"item": "itemid", "station": "deviceType"},
+	}}}})
+	assert.NoError(t, err)
+
+	assert.NoError(t, outputs[0].post(Payload{ItemID: "12345", DeviceType: "scanner0"}))
+	assert.Equal(t, map[string]interface{}{"item": "12345", "station": "scanner0"}, request["variables"])
+	assert.EqualError(t, outputs[0].post(Payload{ItemID: "bad"}), "graphql: unknown item (at [recordScan])")
+
+	_, err = loadOutputs(&Config{Outputs: []OutputConfig{{Name: "gql", Type: "graphql", GraphQL: GraphQLConfig{Query: "mutation", Variables: map[string]string{"x": "sku"}}}}})
+	assert.EqualError(t, err, `output gql: variable x: unknown payload field "sku"`)
+}
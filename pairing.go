@@ -0,0 +1,261 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/karalabe/hid"
+)
+
+// PairingConfig represents scan-to-pair for Bluetooth scanners. Each scanner
+// slot is bound to the serial number of a unit, so a replacement unit that is
+// paired while the old one is gone takes over its slot without editing config.
+type PairingConfig struct {
+	Enabled bool `json:"enabled"`
+	// Address is the Bluetooth address of the host or cradle that scanners pair with, such as "00:1A:7D:DA:71:13"
+	Address string `json:"address"`
+	// Prefix is put before the address in the pairing barcode (default "LNKB", for Zebra scanners)
+	Prefix string `json:"prefix"`
+	// VendorIDs are the HID vendors whose devices count as scanners, such as [1504] for Zebra. Required when enabled.
+	VendorIDs []uint16 `json:"vendorIds"`
+}
+
+func (p PairingConfig) withDefaults() PairingConfig {
+	if p.Prefix == "" {
+		p.Prefix = "LNKB"
+	}
+	return p
+}
+
+var bluetoothAddress = regexp.MustCompile(`^([0-9A-Fa-f]{2}[:-]?){5}[0-9A-Fa-f]{2}$`)
+
+func (p PairingConfig) validate() error {
+	if p.Address != "" && !bluetoothAddress.MatchString(p.Address) {
+		return fmt.Errorf("%q is not a Bluetooth address", p.Address)
+	}
+	// without them every keyboard or badge reader with a serial would be bound to a slot
+	if p.Enabled && len(p.VendorIDs) == 0 {
+		return fmt.Errorf("vendorIds is required when pairing is enabled")
+	}
+	return nil
+}
+
+// barcode returns the data of the pairing barcode
+func (p PairingConfig) barcode() (string, error) {
+	if p.Address == "" {
+		return "", fmt.Errorf("pairing.address is not configured")
+	}
+	address := strings.NewReplacer(":", "", "-", "").Replace(p.Address)
+	return p.withDefaults().Prefix + strings.ToUpper(address), nil
+}
+
+// PairingBinding is the unit bound to a scanner slot
+type PairingBinding struct {
+	Scanner  string    `json:"scanner"`
+	Serial   string    `json:"serial"`
+	BoundAt  time.Time `json:"boundAt"`
+	Present  bool      `json:"present"`
+	Replaced string    `json:"replaced,omitempty"`
+}
+
+// pairingRegistry binds scanner slots to unit serial numbers. A nil registry
+// binds slots by enumeration order.
+type pairingRegistry struct {
+	config PairingConfig
+	path   string
+
+	mu       sync.Mutex
+	bindings map[string]PairingBinding
+}
+
+var pairing *pairingRegistry
+
+// loadPairing reads the bindings, starting empty if there are none
+func loadPairing(config PairingConfig, path string) (*pairingRegistry, error) {
+	p := &pairingRegistry{config: config.withDefaults(), path: path, bindings: map[string]PairingBinding{}}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return p, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var bindings []PairingBinding
+	if err := json.Unmarshal(data, &bindings); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	for _, b := range bindings {
+		p.bindings[b.Scanner] = b
+	}
+	return p, nil
+}
+
+// scanner reports whether a HID device counts as a scanner unit
+func (p *pairingRegistry) scanner(device hid.DeviceInfo) bool {
+	if device.Serial == "" {
+		return false
+	}
+	for _, id := range p.config.VendorIDs {
+		if device.VendorID == id {
+			return true
+		}
+	}
+	return false
+}
+
+// owner returns the slot a serial is bound to, or ""
+func (p *pairingRegistry) owner(serial string) string {
+	for slot, b := range p.bindings {
+		if b.Serial == serial {
+			return slot
+		}
+	}
+	return ""
+}
+
+// lookup returns the index of the device bound to the slot, or -1
+func (p *pairingRegistry) lookup(slot string, devices []hid.DeviceInfo) int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	b, ok := p.bindings[slot]
+	if !ok {
+		return -1
+	}
+	for i, d := range devices {
+		if p.scanner(d) && d.Serial == b.Serial {
+			return i
+		}
+	}
+	return -1
+}
+
+// resolve returns the index of the device for the slot. A slot whose unit is
+// not present takes a newly paired unit, one not bound to any slot.
+func (p *pairingRegistry) resolve(slot string, devices []hid.DeviceInfo, now time.Time) int {
+	if i := p.lookup(slot, devices); i >= 0 {
+		return i
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for i, d := range devices {
+		if !p.scanner(d) || p.owner(d.Serial) != "" {
+			continue
+		}
+		old := p.bindings[slot]
+		p.bindings[slot] = PairingBinding{Scanner: slot, Serial: d.Serial, BoundAt: now, Replaced: old.Serial}
+		if old.Serial != "" {
+			logger.Warnf("Bound newly paired scanner %s to %s, replacing %s", d.Serial, slot, old.Serial)
+		} else {
+			logger.Infof("Bound newly paired scanner %s to %s", d.Serial, slot)
+		}
+		p.save()
+		return i
+	}
+	return -1
+}
+
+// unbind frees a slot, so the next newly paired unit takes it
+func (p *pairingRegistry) unbind(slot string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if _, ok := p.bindings[slot]; !ok {
+		return false
+	}
+	delete(p.bindings, slot)
+	p.save()
+	logger.Infof("Unbound %s", slot)
+	return true
+}
+
+// list returns the bindings in slot order, marking the units that are present
+func (p *pairingRegistry) list(devices []hid.DeviceInfo) []PairingBinding {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	present := map[string]bool{}
+	for _, d := range devices {
+		if p.scanner(d) {
+			present[d.Serial] = true
+		}
+	}
+	result := []PairingBinding{}
+	for _, b := range p.bindings {
+		b.Present = present[b.Serial]
+		result = append(result, b)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Scanner < result[j].Scanner })
+	return result
+}
+
+// save writes the bindings; the caller holds the lock
+func (p *pairingRegistry) save() {
+	var bindings []PairingBinding
+	for _, b := range p.bindings {
+		bindings = append(bindings, b)
+	}
+	sort.Slice(bindings, func(i, j int) bool { return bindings[i].Scanner < bindings[j].Scanner })
+	data, err := json.MarshalIndent(bindings, "", "  ")
+	if err == nil {
+		err = writeFileAtomic(p.path, data)
+	}
+	if err != nil {
+		logger.Errorf("Error saving scanner pairings to %s: %v", p.path, err)
+	}
+}
+
+// deviceIndex returns the index of the device a scanner slot reads from, or -1
+func deviceIndex(deviceID int, devices []hid.DeviceInfo) int {
+	if pairing != nil {
+		return pairing.resolve(scannerName(deviceID), devices, time.Now())
+	}
+	if deviceID < len(devices) {
+		return deviceID
+	}
+	return -1
+}
+
+// startPairing loads the bindings from the state directory
+func startPairing(config *Config) error {
+	if err := config.Pairing.validate(); err != nil {
+		return err
+	}
+	registry, err := loadPairing(config.Pairing, filepath.Join(stateDir, "pairing.json"))
+	if err != nil {
+		return err
+	}
+	pairing = registry
+	return nil
+}
+
+// runPairingBarcode writes the pairing barcode as SVG to the file named in args, or to stdout
+func runPairingBarcode(args []string) error {
+	config, err := loadConfigFile("config.json", false)
+	if err != nil {
+		return err
+	}
+	if err := config.Pairing.validate(); err != nil {
+		return err
+	}
+	data, err := config.Pairing.barcode()
+	if err != nil {
+		return err
+	}
+	svg, err := code128SVG(data)
+	if err != nil {
+		return err
+	}
+	if len(args) == 0 {
+		fmt.Println(svg)
+		return nil
+	}
+	if err := os.WriteFile(args[0], []byte(svg), 0644); err != nil {
+		return err
+	}
+	fmt.Printf("Pairing barcode %s written to %s\n", data, args[0])
+	return nil
+}
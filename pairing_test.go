@@ -0,0 +1,73 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/karalabe/hid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPairingConfig_Barcode(t *testing.T) {
+	data, err := PairingConfig{Address: "00:1a:7d:da:71:13"}.barcode()
+	assert.NoError(t, err)
+	assert.Equal(t, "LNKB001A7DDA7113", data)
+
+	_, err = PairingConfig{}.barcode()
+	assert.Error(t, err)
+	assert.Error(t, PairingConfig{Address: "cradle 3"}.validate())
+	assert.EqualError(t, PairingConfig{Enabled: true}.validate(), "vendorIds is required when pairing is enabled")
+	assert.NoError(t, PairingConfig{Enabled: true, VendorIDs: []uint16{0x05e0}}.validate())
+}
+
+func TestPairingRegistry_BindsAndReplaces(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "pairing.json")
+	p, err := loadPairing(PairingConfig{VendorIDs: []uint16{0x05e0}}, path)
+	assert.NoError(t, err)
+	now := time.Date(2026, 5, 1, 8, 0, 0, 0, time.UTC)
+	keyboard := hid.DeviceInfo{VendorID: 0x046d, Serial: "KB1"}
+	unitA := hid.DeviceInfo{VendorID: 0x05e0, Serial: "A"}
+	unitB := hid.DeviceInfo{VendorID: 0x05e0, Serial: "B"}
+	unitC := hid.DeviceInfo{VendorID: 0x05e0, Serial: "C"}
+
+	// new units are bound to slots in turn; other HID devices are ignored
+	assert.Equal(t, 1, p.resolve("scanner0", []hid.DeviceInfo{keyboard, unitA, unitB}, now))
+	assert.Equal(t, 2, p.resolve("scanner1", []hid.DeviceInfo{keyboard, unitA, unitB}, now))
+	assert.Equal(t, -1, p.resolve("scanner2", []hid.DeviceInfo{keyboard, unitA, unitB}, now))
+
+	// the bindings survive a restart, whatever the enumeration order
+	p, err = loadPairing(PairingConfig{VendorIDs: []uint16{0x05e0}}, path)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, p.resolve("scanner1", []hid.DeviceInfo{unitB, unitA}, now))
+
+	// unit A breaks and C is paired in its place; B keeps its slot
+	devices := []hid.DeviceInfo{unitB, unitC}
+	assert.Equal(t, 0, p.resolve("scanner1", devices, now))
+	assert.Equal(t, 1, p.resolve("scanner0", devices, now))
+	assert.Equal(t, []PairingBinding{
+		{Scanner: "scanner0", Serial: "C", BoundAt: now, Present: true, Replaced: "A"},
+		{Scanner: "scanner1", Serial: "B", BoundAt: now, Present: true},
+	}, p.list(devices))
+
+	assert.True(t, p.unbind("scanner0"))
+	assert.False(t, p.unbind("scanner0"))
+	assert.Equal(t, -1, p.lookup("scanner0", devices))
+}
+
+func TestDeviceIndex_WithoutPairing(t *testing.T) {
+	devices := []hid.DeviceInfo{{Serial: "A"}}
+	assert.Equal(t, 0, deviceIndex(0, devices))
+	assert.Equal(t, -1, deviceIndex(1, devices))
+}
+
+func TestAdminPairingBarcode(t *testing.T) {
+	mux := adminMux(&Config{Pairing: PairingConfig{Enabled: true, Address: "001A7DDA7113"}})
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/pairing/barcode.svg", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "image/svg+xml", w.Header().Get("Content-Type"))
+	assert.Contains(t, w.Body.String(), "LNKB001A7DDA7113")
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// FsyncConfig represents when persisted state is flushed to disk
+type FsyncConfig struct {
+	// Policy is "always" (default), "periodic" or "never"
+	Policy string `json:"policy"`
+	// IntervalMillis is how often dirty files are synced under the periodic policy
+	IntervalMillis int `json:"intervalMillis"`
+}
+
+// fsyncPolicy is the effective policy, set from config at startup
+var fsyncPolicy FsyncConfig
+
+var (
+	dirtyMu    sync.Mutex
+	dirtyFiles = map[string]bool{}
+)
+
+// validate checks that the policy is known
+func (f FsyncConfig) validate() error {
+	switch f.Policy {
+	case "", "always", "periodic", "never":
+		return nil
+	}
+	return fmt.Errorf("unknown fsync policy %q", f.Policy)
+}
+
+func (f FsyncConfig) always() bool {
+	return f.Policy == "" || f.Policy == "always"
+}
+
+func (f FsyncConfig) interval() time.Duration {
+	if f.IntervalMillis <= 0 {
+		return time.Second
+	}
+	return time.Duration(f.IntervalMillis) * time.Millisecond
+}
+
+// syncFile flushes an open file according to the policy
+func syncFile(file *os.File) error {
+	switch {
+	case fsyncPolicy.always():
+		return file.Sync()
+	case fsyncPolicy.Policy == "periodic":
+		dirtyMu.Lock()
+		dirtyFiles[file.Name()] = true
+		dirtyMu.Unlock()
+	}
+	return nil
+}
+
+// syncDir flushes a directory entry after a rename, where the platform allows it
+func syncDir(dir string) {
+	if !fsyncPolicy.always() {
+		return
+	}
+	d, err := os.Open(dir)
+	if err != nil {
+		return
+	}
+	// directories cannot be synced on Windows, where rename is durable on its own
+	d.Sync()
+	d.Close()
+}
+
+// writeFileAtomic replaces path with data by writing a temporary file in the
+// same directory and renaming it over the original, so a reader sees either
+// the old or the new content and never a partial write
+func writeFileAtomic(path string, data []byte) error {
+	return writeFileAtomicMode(path, data, 0644)
+}
+
+// writeFileAtomicMode is writeFileAtomic with the given permissions
+func writeFileAtomicMode(path string, data []byte, mode os.FileMode) error {
+	dir := filepath.Dir(path)
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if fsyncPolicy.Policy != "never" {
+		if err := tmp.Sync(); err != nil {
+			tmp.Close()
+			return err
+		}
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), mode); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+	syncDir(dir)
+	return nil
+}
+
+// recordLocks holds a mutex per journal file, serializing appends to it with
+// the purges that rewrite it
+var recordLocks sync.Map
+
+// recordLock returns the mutex of the journal file at path
+func recordLock(path string) *sync.Mutex {
+	mu, _ := recordLocks.LoadOrStore(filepath.Clean(path), &sync.Mutex{})
+	return mu.(*sync.Mutex)
+}
+
+// appendRecord appends one newline-terminated record to a journal file. If an
+// earlier write was torn, the record starts on a new line so it stays readable.
+func appendRecord(path string, record []byte) error {
+	mu := recordLock(path)
+	mu.Lock()
+	defer mu.Unlock()
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	var data []byte
+	if size := info.Size(); size > 0 {
+		last := make([]byte, 1)
+		if _, err := file.ReadAt(last, size-1); err == nil && last[0] != '\n' {
+			data = append(data, '\n')
+		}
+	}
+	data = append(append(data, record...), '\n')
+	if _, err := file.Write(data); err != nil {
+		return err
+	}
+	return syncFile(file)
+}
+
+// syncPeriodically syncs the files written since the last pass under the periodic policy
+func syncPeriodically() {
+	for range time.Tick(fsyncPolicy.interval()) {
+		dirtyMu.Lock()
+		paths := dirtyFiles
+		dirtyFiles = map[string]bool{}
+		dirtyMu.Unlock()
+		for path := range paths {
+			file, err := os.OpenFile(path, os.O_RDWR, 0)
+			if err != nil {
+				logger.Errorf("Error opening %s for sync: %v", path, err)
+				continue
+			}
+			if err := file.Sync(); err != nil {
+				logger.Errorf("Error syncing %s: %v", path, err)
+			}
+			file.Close()
+		}
+	}
+}
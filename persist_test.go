@@ -0,0 +1,39 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWriteFileAtomic(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "offset")
+	assert.NoError(t, os.WriteFile(path, []byte("old"), 0644))
+
+	assert.NoError(t, writeFileAtomic(path, []byte("new")))
+	data, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, "new", string(data))
+
+	entries, _ := os.ReadDir(dir)
+	assert.Len(t, entries, 1, "temporary file left behind")
+}
+
+func TestAppendRecord_StartsAfterTornLine(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "journal.log")
+	assert.NoError(t, os.WriteFile(path, []byte(`{"torn":`), 0644))
+
+	assert.NoError(t, appendRecord(path, []byte(`{"ok":true}`)))
+	assert.NoError(t, appendRecord(path, []byte(`{"ok":false}`)))
+	data, _ := os.ReadFile(path)
+	assert.Equal(t, "{\"torn\":\n{\"ok\":true}\n{\"ok\":false}\n", string(data))
+}
+
+func TestFsyncConfig_Validate(t *testing.T) {
+	assert.NoError(t, FsyncConfig{}.validate())
+	assert.NoError(t, FsyncConfig{Policy: "periodic"}.validate())
+	assert.Error(t, FsyncConfig{Policy: "sometimes"}.validate())
+}
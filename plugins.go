@@ -0,0 +1,276 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"sync"
+	"time"
+)
+
+// PluginConfig represents an external process plugin. Plugins exchange one JSON
+// message per line over stdin/stdout, so they can be written in any language.
+type PluginConfig struct {
+	Name    string   `json:"name"`
+	Type    string   `json:"type"` // input, transform, wasm-transform or output
+	Command string   `json:"command"`
+	Args    []string `json:"args"`
+	// Module is the path of the WASM module for wasm-transform plugins
+	Module string `json:"module"`
+	// Timeout is the number of seconds to wait for a transform or output reply
+	Timeout int `json:"timeout"`
+}
+
+// pluginRequest is written to transform and output plugins for every payload
+type pluginRequest struct {
+	Type    string  `json:"type"`
+	Payload Payload `json:"payload"`
+}
+
+// pluginResponse is read back from transform and output plugins, and is the
+// line format input plugins emit
+type pluginResponse struct {
+	Payload *Payload `json:"payload,omitempty"`
+	Drop    bool     `json:"drop,omitempty"`
+	Error   string   `json:"error,omitempty"`
+}
+
+// plugin is a running external process
+type plugin struct {
+	config PluginConfig
+	mu     sync.Mutex
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	lines  chan []byte
+}
+
+// payloadTransform is implemented by process and WASM transform plugins
+type payloadTransform interface {
+	name() string
+	call(payload Payload) (*pluginResponse, error)
+}
+
+var (
+	transformPlugins []payloadTransform
+	// outputPlugins each run behind their own pipeline and queue file, like the HTTP outputs
+	outputPlugins []*httpOutput
+)
+
+var startPluginProcess = func(cfg PluginConfig) (*exec.Cmd, io.WriteCloser, io.ReadCloser, error) {
+	cmd := exec.Command(cfg.Command, cfg.Args...)
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, nil, nil, err
+	}
+	return cmd, stdin, stdout, nil
+}
+
+// registerPlugins registers the transform and output plugins, which start on
+// first use. Input plugins are started with the other inputs.
+func registerPlugins(config *Config) {
+	for _, cfg := range config.Plugins {
+		p := &plugin{config: cfg}
+		switch cfg.Type {
+		case "input":
+		case "transform":
+			transformPlugins = append(transformPlugins, p)
+		case "wasm-transform":
+			w, err := loadWASMTransform(cfg)
+			if err != nil {
+				logger.Errorf("Error loading WASM transform %s: %v", cfg.Name, err)
+				continue
+			}
+			transformPlugins = append(transformPlugins, w)
+		case "output":
+			outputPlugins = append(outputPlugins, newPluginOutput(p))
+		default:
+			logger.Errorf("Plugin %s has unknown type %q", cfg.Name, cfg.Type)
+		}
+	}
+}
+
+// startInputPlugins launches the input plugins
+func startInputPlugins(config *Config, payloadCh chan Payload) {
+	for _, cfg := range config.Plugins {
+		if cfg.Type == "input" {
+			p := &plugin{config: cfg}
+			go p.runInput(config, payloadCh)
+		}
+	}
+}
+
+func (p *plugin) name() string {
+	return p.config.Name
+}
+
+// newPluginOutput wraps an output plugin in an output, so it gets a pipeline
+// and a queue file of its own. The queue file is named apart from those of
+// the HTTP outputs.
+func newPluginOutput(p *plugin) *httpOutput {
+	return &httpOutput{config: OutputConfig{Name: "plugin." + p.config.Name}, output: p}
+}
+
+// Name implements scan.Output
+func (p *plugin) Name() string {
+	return p.config.Name
+}
+
+// Deliver implements scan.Output, failing when the plugin replies with an error
+func (p *plugin) Deliver(payload Payload) error {
+	_, err := p.call(payload)
+	return err
+}
+
+// start launches the plugin process and begins reading its output lines
+func (p *plugin) start() error {
+	cmd, stdin, stdout, err := startPluginProcess(p.config)
+	if err != nil {
+		return err
+	}
+	lines := make(chan []byte)
+	go func() {
+		defer close(lines)
+		scanner := bufio.NewScanner(stdout)
+		for scanner.Scan() {
+			lines <- append([]byte(nil), scanner.Bytes()...)
+		}
+	}()
+	p.cmd, p.stdin, p.lines = cmd, stdin, lines
+	return nil
+}
+
+// stop kills the plugin process so it is restarted on next use
+func (p *plugin) stop() {
+	if p.stdin != nil {
+		p.stdin.Close()
+	}
+	if p.cmd != nil && p.cmd.Process != nil {
+		p.cmd.Process.Kill()
+		go p.cmd.Wait()
+	}
+	if p.lines != nil {
+		// unblock the reader goroutine if it is holding an unread line
+		go func(lines chan []byte) {
+			for range lines {
+			}
+		}(p.lines)
+	}
+	p.cmd, p.stdin, p.lines = nil, nil, nil
+}
+
+// runInput forwards every payload an input plugin prints, restarting it when it exits
+func (p *plugin) runInput(config *Config, payloadCh chan Payload) {
+	for {
+		if err := p.start(); err != nil {
+			logger.Errorf("Error starting input plugin %s: %v", p.config.Name, err)
+		} else {
+			logger.Infof("Started input plugin %s", p.config.Name)
+			for line := range p.lines {
+				var msg pluginResponse
+				if err := json.Unmarshal(line, &msg); err != nil || msg.Payload == nil {
+					logger.Errorf("Input plugin %s sent an invalid message: %s", p.config.Name, line)
+					continue
+				}
+				if msg.Payload.DeviceType == "" {
+					msg.Payload.DeviceType = p.config.Name
+				}
+				payloadCh <- *msg.Payload
+			}
+			p.stop()
+			logger.Warnf("Input plugin %s exited", p.config.Name)
+		}
+		time.Sleep(time.Duration(config.RescanInterval) * time.Second)
+	}
+}
+
+// call sends a payload to a transform or output plugin and waits for its reply
+func (p *plugin) call(payload Payload) (*pluginResponse, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.cmd == nil {
+		if err := p.start(); err != nil {
+			return nil, err
+		}
+	}
+
+	data, err := json.Marshal(pluginRequest{Type: p.config.Type, Payload: payload})
+	if err != nil {
+		return nil, err
+	}
+	if _, err := p.stdin.Write(append(data, '\n')); err != nil {
+		p.stop()
+		return nil, err
+	}
+
+	timeout := time.Duration(p.config.Timeout) * time.Second
+	if timeout <= 0 {
+		timeout = 5 * time.Second
+	}
+	select {
+	case line, ok := <-p.lines:
+		if !ok {
+			p.stop()
+			return nil, errors.New("plugin exited")
+		}
+		var resp pluginResponse
+		if err := json.Unmarshal(line, &resp); err != nil {
+			return nil, fmt.Errorf("invalid reply %q: %v", line, err)
+		}
+		if resp.Error != "" {
+			return &resp, errors.New(resp.Error)
+		}
+		return &resp, nil
+	case <-time.After(timeout):
+		p.stop()
+		return nil, errors.New("timed out waiting for plugin reply")
+	}
+}
+
+// applyTransforms runs the payload through each transform plugin in order.
+// It returns false if a plugin dropped the payload.
+func applyTransforms(payload Payload) (Payload, bool) {
+	variant, skip := assignVariants(payload, time.Now())
+	if variant != "" {
+		payload.Variant = variant
+	}
+	for _, p := range transformPlugins {
+		if skip[p.name()] {
+			continue
+		}
+		resp, err := p.call(payload)
+		if err != nil {
+			logger.Errorf("Transform plugin %s failed, passing payload through unchanged: %v", p.name(), err)
+			bus.transformFailed.publish(TransformFailed{Payload: payload, Plugin: p.name(), Err: err})
+			continue
+		}
+		if resp.Drop {
+			logger.Infof("Transform plugin %s dropped payload: %v", p.name(), payload)
+			return payload, false
+		}
+		if resp.Payload != nil {
+			payload = *resp.Payload
+			if variant != "" {
+				payload.Variant = variant
+			}
+		}
+	}
+	return payload, true
+}
+
+// deliverToOutputPlugins hands the payload to the pipeline of each output
+// plugin, which retries it from the plugin's queue file when the plugin fails
+func deliverToOutputPlugins(payload Payload) {
+	for _, o := range outputPlugins {
+		o.enqueue(payload)
+	}
+}
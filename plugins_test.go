@@ -0,0 +1,79 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestApplyTransforms_Passthrough(t *testing.T) {
+	// cat echoes the request back, which is a valid reply carrying the same payload
+	p := &plugin{config: PluginConfig{Name: "echo", Type: "transform", Command: "cat"}}
+	defer p.stop()
+	oldPlugins := transformPlugins
+	defer func() { transformPlugins = oldPlugins }()
+	transformPlugins = []payloadTransform{p}
+
+	payload, ok := applyTransforms(Payload{ItemID: "12345", DeviceType: "scanner0"})
+	assert.True(t, ok)
+	assert.Equal(t, Payload{ItemID: "12345", DeviceType: "scanner0"}, payload)
+}
+
+func TestApplyTransforms_Drop(t *testing.T) {
+	p := &plugin{config: PluginConfig{Name: "dropper", Type: "transform", Command: "sh",
+		Args: []string{"-c", `while read line; do echo '{"drop":true}'; done`}}}
+	defer p.stop()
+	oldPlugins := transformPlugins
+	defer func() { transformPlugins = oldPlugins }()
+	transformPlugins = []payloadTransform{p}
+
+	_, ok := applyTransforms(Payload{ItemID: "12345", DeviceType: "scanner0"})
+	assert.False(t, ok)
+}
+
+func TestPluginCall_Error(t *testing.T) {
+	p := &plugin{config: PluginConfig{Name: "failing", Type: "output", Command: "sh",
+		Args: []string{"-c", `while read line; do echo '{"error":"backend down"}'; done`}}}
+	defer p.stop()
+
+	_, err := p.call(Payload{ItemID: "12345"})
+	assert.EqualError(t, err, "backend down")
+}
+
+func TestPluginRunInput(t *testing.T) {
+	p := &plugin{config: PluginConfig{Name: "pos", Type: "input", Command: "sh",
+		Args: []string{"-c", `echo '{"payload":{"itemid":"abc"}}'; sleep 10`}}}
+	payloadCh := make(chan Payload)
+	go p.runInput(&Config{RescanInterval: 1}, payloadCh)
+
+	select {
+	case payload := <-payloadCh:
+		assert.Equal(t, Payload{ItemID: "abc", DeviceType: "pos"}, payload)
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for input plugin payload")
+	}
+}
+
+func TestOutputPlugin_QueuesFailedDeliveries(t *testing.T) {
+	useTempQueue(t)
+	p := &plugin{config: PluginConfig{Name: "erp", Type: "output", Command: "sh",
+		Args: []string{"-c", `while read line; do echo '{"error":"backend down"}'; done`}}}
+	defer p.stop()
+	oldPlugins := outputPlugins
+	defer func() { outputPlugins = oldPlugins }()
+	o := newPluginOutput(p)
+	outputPlugins = []*httpOutput{o}
+	startOutputs(outputPlugins)
+
+	deliverToOutputPlugins(Payload{ItemID: "12345", DeviceType: "scanner0"})
+	o.stop()
+
+	lines := o.readQueueFile()
+	assert.Len(t, lines, 1)
+	payload, _, err := decodeQueueEntry(lines[0])
+	assert.NoError(t, err)
+	assert.Equal(t, "12345", payload.ItemID)
+	assert.Equal(t, "plugin.erp.log", filepath.Base(o.queueFile()))
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// poisonAttempts counts the rejections of each failures.log entry during
+// replays, by entry line. It is guarded by failuresMu and starts over on restart.
+var poisonAttempts = map[string]int{}
+
+// poisonLimit is the number of non-retryable rejections after which a queued
+// payload is dead-lettered as poison, or 0 when detection is off
+func (c *Config) poisonLimit() int {
+	if c.PoisonAttempts < 0 {
+		return 0
+	}
+	if c.PoisonAttempts == 0 {
+		return 3
+	}
+	return c.PoisonAttempts
+}
+
+// nonRetryable reports whether the API rejected the payload itself, so sending
+// it again cannot succeed. Authentication failures, timeouts and rate limiting
+// concern the station rather than the payload and stay retryable.
+func nonRetryable(statusCode int) bool {
+	switch statusCode {
+	case http.StatusUnauthorized, http.StatusForbidden, http.StatusRequestTimeout, http.StatusTooEarly, http.StatusTooManyRequests:
+		return false
+	}
+	return statusCode >= 400 && statusCode < 500
+}
+
+// checkPoison records a failed replay of a failures.log entry and, once the
+// entry has been rejected as non-retryable often enough, dead-letters it with
+// the poison flag and reports true so it is dropped from the queue.
+// The caller must hold failuresMu.
+func checkPoison(config *Config, line string, payload Payload, statusCode int) bool {
+	limit := config.poisonLimit()
+	if limit == 0 || !nonRetryable(statusCode) {
+		return false
+	}
+	poisonAttempts[line]++
+	attempts := poisonAttempts[line]
+	if attempts < limit {
+		return false
+	}
+	delete(poisonAttempts, line)
+	writeDeadLetter(DeadLetter{
+		Time:     time.Now(),
+		Reason:   fmt.Sprintf("rejected with response code %d on %d replays", statusCode, attempts),
+		Payload:  payload,
+		Poison:   true,
+		Attempts: attempts,
+	})
+	return true
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReplayFailures_DeadLettersPoison(t *testing.T) {
+	useTempQueue(t)
+	oldPost := httpPost
+	defer func() { httpPost = oldPost }()
+	httpPost = func(url, contentType string, body io.Reader) (*http.Response, error) {
+		var payload Payload
+		json.NewDecoder(body).Decode(&payload)
+		switch payload.ItemID {
+		case "poison":
+			return &http.Response{StatusCode: http.StatusUnprocessableEntity}, nil
+		case "down":
+			return &http.Response{StatusCode: http.StatusServiceUnavailable}, nil
+		}
+		return &http.Response{StatusCode: http.StatusOK}, nil
+	}
+
+	logFailure(Payload{ItemID: "poison", DeviceType: "scanner0"})
+	logFailure(Payload{ItemID: "down", DeviceType: "scanner0"})
+	logFailure(Payload{ItemID: "good", DeviceType: "scanner0"})
+	config := &Config{APIEndpoint: "http://example.com", PoisonAttempts: 2}
+
+	result, err := replayFailures(config, time.Now().Add(time.Minute))
+	assert.NoError(t, err)
+	assert.Equal(t, FlushResult{Delivered: 1, Remaining: 2}, result)
+
+	result, err = replayFailures(config, time.Now().Add(time.Minute))
+	assert.NoError(t, err)
+	assert.Equal(t, FlushResult{Remaining: 1, Poisoned: 1}, result)
+
+	data, _ := os.ReadFile(deadLetterFile)
+	var letter DeadLetter
+	assert.NoError(t, json.Unmarshal(data, &letter))
+	assert.True(t, letter.Poison)
+	assert.Equal(t, 2, letter.Attempts)
+	assert.Equal(t, "poison", letter.Payload.ItemID)
+	assert.Equal(t, 1, queueDepth())
+}
+
+func TestNonRetryable(t *testing.T) {
+	assert.True(t, nonRetryable(http.StatusBadRequest))
+	assert.True(t, nonRetryable(http.StatusUnprocessableEntity))
+	assert.False(t, nonRetryable(http.StatusUnauthorized))
+	assert.False(t, nonRetryable(http.StatusTooManyRequests))
+	assert.False(t, nonRetryable(http.StatusBadGateway))
+	assert.False(t, nonRetryable(0))
+	assert.Equal(t, 0, (&Config{PoisonAttempts: -1}).poisonLimit())
+}
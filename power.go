@@ -0,0 +1,131 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// PowerConfig represents sending idle scanners to sleep, for battery-backed
+// mobile carts. Only scanners with a host trigger can be put to sleep. A
+// sleeping scanner is woken before any trigger action, such as a read
+// requested by the PLC, and wakes by itself when its trigger is pulled.
+type PowerConfig struct {
+	Enabled bool `json:"enabled"`
+	// IdleSeconds is how long a scanner may go without a scan before it is sent to sleep (default 300)
+	IdleSeconds int `json:"idleSeconds"`
+	// Scanners are the IDs of the scanners to manage, 0 for scanner0 (default all with a host trigger)
+	Scanners []int `json:"scanners"`
+}
+
+func (p PowerConfig) withDefaults() PowerConfig {
+	if p.IdleSeconds <= 0 {
+		p.IdleSeconds = 300
+	}
+	return p
+}
+
+// powerManager sends scanners to sleep after they were idle. A nil manager
+// manages nothing.
+type powerManager struct {
+	idle     time.Duration
+	scanners []string
+
+	mu         sync.Mutex
+	lastActive map[string]time.Time
+}
+
+var power *powerManager
+
+// newPowerManager checks that every managed scanner has a host trigger
+func newPowerManager(config PowerConfig, now time.Time) (*powerManager, error) {
+	config = config.withDefaults()
+	p := &powerManager{idle: time.Duration(config.IdleSeconds) * time.Second, lastActive: map[string]time.Time{}}
+	if len(config.Scanners) == 0 {
+		for name := range triggers {
+			p.scanners = append(p.scanners, name)
+		}
+	}
+	for _, id := range config.Scanners {
+		name := scannerName(id)
+		if _, ok := triggers[name]; !ok {
+			return nil, fmt.Errorf("%s has no host trigger configured, so it cannot be put to sleep", name)
+		}
+		p.scanners = append(p.scanners, name)
+	}
+	if len(p.scanners) == 0 {
+		return nil, fmt.Errorf("no scanner has a host trigger configured")
+	}
+	for _, name := range p.scanners {
+		p.lastActive[name] = now
+	}
+	return p, nil
+}
+
+// touch records activity on a scanner. A scan from a sleeping scanner means
+// it was woken by its own trigger.
+func (p *powerManager) touch(scanner string, now time.Time) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	_, managed := p.lastActive[scanner]
+	if managed {
+		p.lastActive[scanner] = now
+	}
+	p.mu.Unlock()
+	if managed && scannerAsleep(scanner) {
+		scannerWoke(scanner)
+		logger.Infof("%s woke up", scanner)
+	}
+}
+
+// check sends the scanners that have been idle long enough to sleep
+func (p *powerManager) check(now time.Time) {
+	p.mu.Lock()
+	var idle []string
+	for _, name := range p.scanners {
+		if now.Sub(p.lastActive[name]) >= p.idle {
+			idle = append(idle, name)
+		}
+	}
+	p.mu.Unlock()
+	for _, name := range idle {
+		if scannerAsleep(name) {
+			continue
+		}
+		logger.Infof("Sending %s to sleep after %s without a scan", name, p.idle)
+		triggerScanner(name, triggerSleep)
+	}
+}
+
+// watch checks for idle scanners until the service stops
+func (p *powerManager) watch() {
+	interval := p.idle / 10
+	if interval > 30*time.Second {
+		interval = 30 * time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for now := range ticker.C {
+		p.check(now)
+	}
+}
+
+// startPower creates the power manager and has it follow scan activity
+func startPower(config *Config) error {
+	manager, err := newPowerManager(config.Power, time.Now())
+	if err != nil {
+		return err
+	}
+	power = manager
+	bus.scanReceived.subscribe(func(e ScanReceived) {
+		power.touch(e.Payload.DeviceType, e.At)
+	})
+	bus.deviceAttached.subscribe(func(e DeviceAttached) {
+		// reconnecting after a sleep counts as waking, so the idle time starts over
+		power.touch(scannerName(e.DeviceID), e.At)
+	})
+	go power.watch()
+	return nil
+}
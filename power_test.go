@@ -0,0 +1,61 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewPowerManager_Validation(t *testing.T) {
+	useFakeTriggers(t)
+	_, err := newPowerManager(PowerConfig{Scanners: []int{1}}, time.Now())
+	assert.EqualError(t, err, "scanner1 has no host trigger configured, so it cannot be put to sleep")
+
+	p, err := newPowerManager(PowerConfig{}, time.Now())
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"scanner0"}, p.scanners)
+	assert.Equal(t, 300*time.Second, p.idle)
+}
+
+func TestPowerManager_SleepsIdleAndWakes(t *testing.T) {
+	port := useFakeTriggers(t)
+	oldDelay := ssiWakeDelay
+	defer func() { ssiWakeDelay = oldDelay }()
+	ssiWakeDelay = 0
+	start := time.Now()
+	p, _ := newPowerManager(PowerConfig{IdleSeconds: 60}, start)
+
+	p.touch("scanner0", start.Add(30*time.Second))
+	p.check(start.Add(60 * time.Second))
+	assert.Equal(t, 0, port.Len())
+	assert.False(t, scannerAsleep("scanner0"))
+
+	p.check(start.Add(90 * time.Second))
+	assert.Equal(t, ssiPacket(ssiOpcodes[triggerSleep]), port.Bytes())
+	assert.True(t, scannerAsleep("scanner0"))
+	assert.Equal(t, []TriggerState{{Scanner: "scanner0", Asleep: true}}, triggerStates())
+
+	// already asleep, so nothing more is sent
+	port.Reset()
+	p.check(start.Add(120 * time.Second))
+	assert.Equal(t, 0, port.Len())
+
+	// a trigger action wakes the scanner first
+	assert.NoError(t, triggerScanner("scanner0", triggerRead))
+	assert.Equal(t, append([]byte{0x00}, ssiPacket(ssiOpcodes[triggerRead])...), port.Bytes())
+	assert.False(t, scannerAsleep("scanner0"))
+}
+
+func TestPowerManager_ScanWakes(t *testing.T) {
+	port := useFakeTriggers(t)
+	start := time.Now()
+	p, _ := newPowerManager(PowerConfig{IdleSeconds: 60}, start)
+	p.check(start.Add(time.Minute))
+	assert.True(t, scannerAsleep("scanner0"))
+
+	port.Reset()
+	p.touch("scanner0", start.Add(2*time.Minute))
+	assert.False(t, scannerAsleep("scanner0"))
+	assert.Equal(t, 0, port.Len())
+}
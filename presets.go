@@ -0,0 +1,79 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+)
+
+// configPresets are named bases for config.json, selected with "preset". The
+// settings in config.json are applied on top of the preset, so a station only
+// lists what differs. Presets are JSON in the same shape as config.json.
+var configPresets = map[string]string{
+	// a checkout lane: one handheld scanner, a person waiting on every post
+	"retail-pos": `{
+		"rescanInterval": 5,
+		"poisonAttempts": 3,
+		"flushDeadlineSeconds": 10,
+		"fsync": {"policy": "always"},
+		"degradation": {"enabled": true},
+		"noiseFilter": {"minLength": 4},
+		"outcomes": {"duplicateSeconds": 2}
+	}`,
+	// fixed-mount tunnel scanners on a conveyor: bursts of hundreds of scans,
+	// packages that pass without a decode, and flash storage
+	"warehouse-tunnel": `{
+		"rescanInterval": 2,
+		"poisonAttempts": 5,
+		"flushDeadlineSeconds": 60,
+		"fsync": {"policy": "periodic", "intervalMillis": 1000},
+		"ringBuffer": {"enabled": true, "size": 4096, "workers": 16, "mapped": true},
+		"batching": {"enabled": true, "maxBatchSize": 100, "maxFlushMillis": 250},
+		"degradation": {"enabled": true},
+		"noRead": {"enabled": true, "codes": ["NR", "NOREAD"]},
+		"outcomes": {"duplicateSeconds": 10}
+	}`,
+	// a self-service or lab kiosk: keyboard input, slow repeated scans, and a
+	// trace of every scan for troubleshooting
+	"lab-kiosk": `{
+		"keyboard": true,
+		"rescanInterval": 10,
+		"poisonAttempts": 1,
+		"flushDeadlineSeconds": 15,
+		"fsync": {"policy": "always"},
+		"trace": {"enabled": true, "sampleEvery": 1},
+		"feedback": {"suffix": "\n"},
+		"outcomes": {"duplicateSeconds": 5}
+	}`,
+}
+
+// presetNames returns the names of the presets in order
+func presetNames() []string {
+	var names []string
+	for name := range configPresets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// applyPreset fills config with the preset named in the config data, if any
+func applyPreset(data []byte, config *Config) error {
+	var selected struct {
+		Preset string `json:"preset"`
+	}
+	if err := json.Unmarshal(data, &selected); err != nil {
+		return err
+	}
+	if selected.Preset == "" {
+		return nil
+	}
+	preset, ok := configPresets[selected.Preset]
+	if !ok {
+		return fmt.Errorf("unknown preset %q, expected one of %v", selected.Preset, presetNames())
+	}
+	if err := json.Unmarshal([]byte(preset), config); err != nil {
+		return fmt.Errorf("preset %s: %v", selected.Preset, err)
+	}
+	return nil
+}
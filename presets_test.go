@@ -0,0 +1,44 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConfigPresets_AreValid(t *testing.T) {
+	for _, name := range presetNames() {
+		decoder := json.NewDecoder(bytes.NewReader([]byte(configPresets[name])))
+		decoder.DisallowUnknownFields()
+		var config Config
+		assert.NoError(t, decoder.Decode(&config), name)
+		assert.NoError(t, config.Fsync.validate(), name)
+	}
+}
+
+func TestLoadConfigFile_Preset(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	os.WriteFile(path, []byte(`{"configVersion": 1, "preset": "warehouse-tunnel", "apiEndpoint": "http://example.com/api", "ringBuffer": {"workers": 4}, "noRead": {"enabled": false}}`), 0644)
+
+	config, err := loadConfigFile(path, false)
+	assert.NoError(t, err)
+	assert.Equal(t, "http://example.com/api", config.APIEndpoint)
+	assert.Equal(t, 5, config.PoisonAttempts)
+	assert.True(t, config.Batching.Enabled)
+	// settings in the file win, field by field
+	assert.Equal(t, RingBufferConfig{Enabled: true, Size: 4096, Workers: 4, Mapped: true}, config.RingBuffer)
+	assert.False(t, config.NoRead.Enabled)
+	assert.Equal(t, []string{"NR", "NOREAD"}, config.NoRead.Codes)
+}
+
+func TestLoadConfigFile_UnknownPreset(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	os.WriteFile(path, []byte(`{"configVersion": 1, "preset": "airport"}`), 0644)
+
+	_, err := loadConfigFile(path, false)
+	assert.ErrorContains(t, err, `unknown preset "airport", expected one of [lab-kiosk retail-pos warehouse-tunnel]`)
+}
@@ -0,0 +1,366 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// purgeCertificateFile receives a certificate for every purge by identifier
+var purgeCertificateFile = "purge.certificates.log"
+
+// PurgeRequest asks for every local record of the matching item IDs to be removed
+type PurgeRequest struct {
+	// Item is a regular expression matched against item IDs
+	Item string `json:"item"`
+	// Reference identifies the deletion request, such as a ticket number
+	Reference string `json:"reference,omitempty"`
+	// DryRun counts the matching records without removing them
+	DryRun bool `json:"dryRun,omitempty"`
+}
+
+// PurgeCertificate records that a purge took place. It names the pattern only
+// by its SHA-256, so the certificate does not keep the identifier it removed.
+type PurgeCertificate struct {
+	ID            string        `json:"id"`
+	Time          time.Time     `json:"time"`
+	Hostname      string        `json:"hostname"`
+	StationID     string        `json:"stationId,omitempty"`
+	Reference     string        `json:"reference,omitempty"`
+	PatternSHA256 string        `json:"patternSha256"`
+	DryRun        bool          `json:"dryRun,omitempty"`
+	Stores        []PurgedStore `json:"stores"`
+	// Removed is the total over all stores
+	Removed int `json:"removed"`
+}
+
+// searched reports whether the purge went through the store
+func (c PurgeCertificate) searched(store string) bool {
+	for _, s := range c.Stores {
+		if s.Store == store {
+			return true
+		}
+	}
+	return false
+}
+
+// PurgedStore is what a purge removed from one store
+type PurgedStore struct {
+	Store   string `json:"store"`
+	Path    string `json:"path,omitempty"`
+	Removed int    `json:"removed"`
+	Error   string `json:"error,omitempty"`
+}
+
+// itemMatcher matches item IDs, and the item IDs within free text such as log lines
+type itemMatcher struct {
+	pattern *regexp.Regexp
+}
+
+func newItemMatcher(pattern string) (*itemMatcher, error) {
+	if pattern == "" {
+		return nil, fmt.Errorf("an item pattern is required")
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid item pattern: %v", err)
+	}
+	return &itemMatcher{pattern: re}, nil
+}
+
+func (m *itemMatcher) item(itemID string) bool {
+	return m.pattern.MatchString(itemID)
+}
+
+// text matches the whole text or any word of it, so anchored patterns also
+// find item IDs quoted in log messages
+func (m *itemMatcher) text(text string) bool {
+	if m.pattern.MatchString(text) {
+		return true
+	}
+	for _, word := range strings.FieldsFunc(text, func(r rune) bool {
+		return r == ' ' || r == '\t' || strings.ContainsRune(`"'{}[](),=\`, r)
+	}) {
+		if m.pattern.MatchString(word) {
+			return true
+		}
+	}
+	return false
+}
+
+// queueEntry matches a queue entry by its payload, or its text when it cannot be decoded
+func (m *itemMatcher) queueEntry(line string) bool {
+	payload, _, err := decodeQueueEntry(line)
+	if err != nil {
+		return m.text(line)
+	}
+	return m.item(payload.ItemID)
+}
+
+// jsonRecord matches a dead letter or command audit record by its item ID
+func (m *itemMatcher) jsonRecord(line string) bool {
+	var record struct {
+		ItemID  string `json:"itemid"`
+		Payload *struct {
+			ItemID string `json:"itemid"`
+		} `json:"payload"`
+	}
+	if json.Unmarshal([]byte(line), &record) != nil {
+		return m.text(line)
+	}
+	if record.Payload != nil {
+		return m.item(record.Payload.ItemID)
+	}
+	return m.item(record.ItemID)
+}
+
+// receipt matches a receipts CSV line by its item ID column
+func (m *itemMatcher) receipt(line string) bool {
+	fields, err := csv.NewReader(strings.NewReader(line)).Read()
+	if err != nil || len(fields) < 2 {
+		return m.text(line)
+	}
+	return m.item(fields[1])
+}
+
+// purgeFile is a record file searched by a purge
+type purgeFile struct {
+	store  string
+	path   string
+	header int
+	lock   func() func()
+	match  func(line string) bool
+}
+
+// purgeFiles returns the record files that can hold item IDs
+func purgeFiles(m *itemMatcher) []purgeFile {
+	files := []purgeFile{
+		{store: "queue", path: failuresFile, lock: func() func() {
+			failuresMu.Lock()
+			return failuresMu.Unlock
+		}, match: m.queueEntry},
+		{store: "quarantine", path: quarantineFile, lock: lockRecords(quarantineFile), match: m.queueEntry},
+		{store: "deadLetters", path: deadLetterFile, lock: lockRecords(deadLetterFile), match: m.jsonRecord},
+		{store: "commandAudit", path: commandAuditFile, lock: lockRecords(commandAuditFile), match: m.jsonRecord},
+	}
+	for _, path := range rotatedAuditLogs() {
+		files = append(files, purgeFile{store: "commandAudit", path: path, lock: lockRecords(path), match: m.jsonRecord})
+	}
+	outputQueues, _ := filepath.Glob(filepath.Join(outputQueueDir, "*.log"))
+	for _, path := range outputQueues {
+		files = append(files, purgeFile{store: "outputQueue", path: path, lock: lockOutputQueue(path), match: m.queueEntry})
+	}
+	if pair != nil {
+		files = append(files, purgeFile{store: "standbyQueue", path: pair.snapshotFile, lock: pair.lockSnapshot, match: m.queueEntry})
+	}
+	if receipts != nil {
+		files = append(files, purgeFile{store: "receipts", path: receipts.config.Path, header: 1, lock: receipts.lock, match: m.receipt})
+	}
+	if path := logFileName(); path != "" {
+		files = append(files, purgeFile{store: "serviceLog", path: path, lock: lockServiceLog, match: m.text})
+	}
+	return files
+}
+
+// lockOutputQueue returns the lock of the running output that owns the queue file
+func lockOutputQueue(path string) func() func() {
+	return func() func() {
+		outputsMu.RLock()
+		defer outputsMu.RUnlock()
+		for _, o := range append(append([]*httpOutput{}, httpOutputs...), outputPlugins...) {
+			if o.pipeline != nil && o.queueFile() == path {
+				o.pipeline.fileMu.Lock()
+				return o.pipeline.fileMu.Unlock
+			}
+		}
+		return func() {}
+	}
+}
+
+// purgeItems removes every local record of the matching item IDs and writes
+// a certificate of the purge
+func purgeItems(config *Config, request PurgeRequest, now time.Time) (PurgeCertificate, error) {
+	m, err := newItemMatcher(request.Item)
+	if err != nil {
+		return PurgeCertificate{}, err
+	}
+	hostname, _ := os.Hostname()
+	digest := sha256.Sum256([]byte(request.Item))
+	cert := PurgeCertificate{ID: newTriggerGroup(), Time: now, Hostname: hostname, StationID: config.StationID, Reference: request.Reference, PatternSHA256: hex.EncodeToString(digest[:]), DryRun: request.DryRun, Stores: []PurgedStore{}}
+
+	for _, f := range purgeFiles(m) {
+		store := PurgedStore{Store: f.store, Path: f.path}
+		unlock := f.lock()
+		_, _, _, err := filterRecords(f.path, f.header, func(records []string, headerBytes int64) []bool {
+			keep := make([]bool, len(records))
+			for i, record := range records {
+				matched := f.match(record)
+				if matched {
+					store.Removed++
+				}
+				// a dry run counts the matches but keeps everything
+				keep[i] = !matched || request.DryRun
+			}
+			return keep
+		})
+		unlock()
+		if err != nil {
+			store.Error = err.Error()
+		}
+		cert.Stores = append(cert.Stores, store)
+	}
+
+	caches := []struct {
+		store string
+		purge func() int
+	}{
+		{"review", func() int { return review.purge(m.item, request.DryRun) }},
+		{"checkInOut", func() int { return checkInOut.purge(m.item, request.DryRun) }},
+		{"duplicates", func() int { return outcomes.purge(m.item, request.DryRun) }},
+		{"recent", func() int { return recent.purge(m.item, m.text, request.DryRun) }},
+	}
+	for _, c := range caches {
+		cert.Stores = append(cert.Stores, PurgedStore{Store: c.store, Removed: c.purge()})
+	}
+	if scanBuffer != nil {
+		store := PurgedStore{Store: "scanBuffer", Removed: scanBuffer.purge(m.item, request.DryRun)}
+		if config.RingBuffer.Mapped {
+			store.Path = scanBufferFile()
+		}
+		cert.Stores = append(cert.Stores, store)
+	}
+	for _, store := range cert.Stores {
+		cert.Removed += store.Removed
+	}
+
+	if request.DryRun {
+		return cert, nil
+	}
+	data, err := json.Marshal(cert)
+	if err != nil {
+		return cert, err
+	}
+	if err := appendRecord(purgeCertificateFile, data); err != nil {
+		return cert, fmt.Errorf("writing the purge certificate to %s: %v", purgeCertificateFile, err)
+	}
+	logger.Infof("Purge %s removed %d records; certificate written to %s", cert.ID, cert.Removed, purgeCertificateFile)
+	return cert, nil
+}
+
+// requestPurge asks the running service to purge through its admin API, so
+// its caches are purged too, and falls back to purging the files directly
+// when the service is not reachable
+func requestPurge(config *Config, request PurgeRequest) (PurgeCertificate, error) {
+	var cert PurgeCertificate
+	if config.Admin.Listen != "" {
+		addr := config.Admin.Listen
+		if strings.HasPrefix(addr, ":") {
+			addr = "127.0.0.1" + addr
+		}
+		body, _ := json.Marshal(request)
+		client := newAdminClient(config, time.Minute)
+		resp, err := client.Post("http://"+addr+"/purge", "application/json", bytes.NewReader(body))
+		if err == nil {
+			defer resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				return cert, fmt.Errorf("purge failed with response code: %d", resp.StatusCode)
+			}
+			err = json.NewDecoder(resp.Body).Decode(&cert)
+			return cert, err
+		}
+		logger.Warnf("Service not reachable on %s, purging the files directly: %v", addr, err)
+	}
+	// a service that runs but cannot be reached still writes the files
+	if err := acquireInstanceLock(config.instanceName()); err != nil {
+		return cert, fmt.Errorf("not purging the files directly: %v", err)
+	}
+	defer instanceLock.release()
+	// service.log is only purged by the service, so this process stops logging to it
+	detachLogFile()
+	if err := loadPurgeStores(config); err != nil {
+		return cert, err
+	}
+	// the purged scan buffer is left mapped in place for the next start
+	defer scanBuffer.close()
+	return purgeItems(config, request, time.Now())
+}
+
+// detachLogFile moves logging to stdout and closes service.log
+func detachLogFile() {
+	logMu.Lock()
+	defer logMu.Unlock()
+	setLogOutput(os.Stdout)
+	if logFile != nil {
+		logFile.Close()
+		logFile = nil
+	}
+}
+
+// loadPurgeStores loads the persisted stores a running service would hold,
+// for a purge without the service
+func loadPurgeStores(config *Config) error {
+	var err error
+	if config.Receipts.Path != "" {
+		receipts = newReceiptWriter(config.Receipts)
+	}
+	if config.CheckInOut.Enabled {
+		if checkInOut, err = loadAssetStates(config.CheckInOut); err != nil {
+			return fmt.Errorf("check-in/check-out state: %v", err)
+		}
+	}
+	if config.Review.Enabled {
+		if review, err = newReviewQueue(config.Review, filepath.Join(stateDir, "review.json"), nil); err != nil {
+			return fmt.Errorf("review queue: %v", err)
+		}
+	}
+	if config.Standby.Enabled {
+		pair = newStandbyPair(config.Standby, standbySnapshotFile(), nil)
+	}
+	if config.RingBuffer.Enabled && config.RingBuffer.Mapped {
+		if scanBuffer, err = newScanRing(config.RingBuffer, scanBufferFile()); err != nil {
+			return fmt.Errorf("scan buffer: %v", err)
+		}
+	}
+	return nil
+}
+
+// runPurge removes the records of the item IDs matching --item
+func runPurge(config *Config, args []string) error {
+	flags := flag.NewFlagSet("purge", flag.ContinueOnError)
+	item := flags.String("item", "", "regular expression matching the item IDs to remove")
+	reference := flags.String("reference", "", "reference of the deletion request, recorded in the certificate")
+	dryRun := flags.Bool("dry-run", false, "count the matching records without removing them")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	cert, err := requestPurge(config, PurgeRequest{Item: *item, Reference: *reference, DryRun: *dryRun})
+	if err != nil {
+		return err
+	}
+	for _, store := range cert.Stores {
+		if store.Error != "" {
+			fmt.Printf("%-14s error: %s\n", store.Store, store.Error)
+		} else if store.Removed > 0 {
+			fmt.Printf("%-14s %d\n", store.Store, store.Removed)
+		}
+	}
+	if !cert.searched("serviceLog") {
+		fmt.Println("service.log was not searched, since only the running service purges it.")
+	}
+	if cert.DryRun {
+		fmt.Printf("Dry run: %d records match. Nothing was removed.\n", cert.Removed)
+		return nil
+	}
+	fmt.Printf("Removed %d records. Purge certificate %s written to %s.\n", cert.Removed, cert.ID, purgeCertificateFile)
+	return nil
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// usePurgeFiles points the command audit and purge certificate logs at a temp dir
+func usePurgeFiles(t *testing.T) {
+	useTempQueue(t)
+	audit, certificates, oldRecent := commandAuditFile, purgeCertificateFile, recent
+	t.Cleanup(func() {
+		commandAuditFile, purgeCertificateFile, recent = audit, certificates, oldRecent
+	})
+	dir := filepath.Dir(failuresFile)
+	commandAuditFile = filepath.Join(dir, "commands.audit.log")
+	purgeCertificateFile = filepath.Join(dir, "purge.certificates.log")
+	recent = &recentEvents{}
+}
+
+func TestItemMatcher_Text(t *testing.T) {
+	m, err := newItemMatcher(`^PAT-42$`)
+	assert.NoError(t, err)
+	assert.True(t, m.item("PAT-42"))
+	assert.False(t, m.item("PAT-421"))
+	assert.True(t, m.text(`Error posting "PAT-42": timeout`))
+	assert.True(t, m.text(`{"itemid":"PAT-42"}`))
+	assert.False(t, m.text("Error posting PAT-421"))
+
+	_, err = newItemMatcher("")
+	assert.Error(t, err)
+	_, err = newItemMatcher("(")
+	assert.Error(t, err)
+}
+
+func TestPurgeItems(t *testing.T) {
+	usePurgeFiles(t)
+	now := time.Now()
+	logFailure(Payload{ItemID: "PAT-42", DeviceType: "scanner0"})
+	logFailure(Payload{ItemID: "PAT-7", DeviceType: "scanner0"})
+	deadLetter(Payload{ItemID: "PAT-42", DeviceType: "scanner0"}, "rejected")
+	writeCommandAudit(CommandAudit{Time: now, Name: "label", ItemID: "PAT-42"})
+	recent.addScan(Payload{ItemID: "PAT-42"}, now)
+	recent.addScan(Payload{ItemID: "PAT-7"}, now)
+
+	cert, err := purgeItems(&Config{StationID: "dock-1"}, PurgeRequest{Item: "^PAT-42$", Reference: "GDPR-1"}, now)
+	assert.NoError(t, err)
+	removed := map[string]int{}
+	for _, store := range cert.Stores {
+		removed[store.Store] += store.Removed
+	}
+	assert.Equal(t, 1, removed["queue"])
+	assert.Equal(t, 1, removed["deadLetters"])
+	assert.Equal(t, 1, removed["commandAudit"])
+	assert.Equal(t, "dock-1", cert.StationID)
+
+	failures, _ := os.ReadFile(failuresFile)
+	assert.NotContains(t, string(failures), "PAT-42")
+	assert.Contains(t, string(failures), "PAT-7")
+	deadLetters, _ := os.ReadFile(deadLetterFile)
+	assert.Empty(t, strings.TrimSpace(string(deadLetters)))
+	audit, _ := os.ReadFile(commandAuditFile)
+	assert.Empty(t, strings.TrimSpace(string(audit)))
+	assert.Len(t, recent.snapshot().Scans, 1)
+
+	// the certificate names the pattern only by its hash
+	data, err := os.ReadFile(purgeCertificateFile)
+	assert.NoError(t, err)
+	assert.NotContains(t, string(data), "PAT-42")
+	var written PurgeCertificate
+	assert.NoError(t, json.Unmarshal(data, &written))
+	assert.Equal(t, cert.PatternSHA256, written.PatternSHA256)
+	assert.Equal(t, "GDPR-1", written.Reference)
+}
+
+func TestPurgeItems_StandbyCopyAndScanBuffer(t *testing.T) {
+	usePurgeFiles(t)
+	oldPair, oldBuffer := pair, scanBuffer
+	defer func() { pair, scanBuffer = oldPair, oldBuffer }()
+	dir := t.TempDir()
+	snapshot := filepath.Join(dir, "standby.queue.log")
+	for _, id := range []string{"PAT-42", "PAT-7"} {
+		entry, err := encodeQueueEntry(Payload{ItemID: id, DeviceType: "scanner0"}, time.Now())
+		assert.NoError(t, err)
+		assert.NoError(t, appendRecord(snapshot, []byte(entry)))
+	}
+	pair = newStandbyPair(StandbyConfig{Role: "standby", Peer: "127.0.0.1:1", Token: "secret"}, snapshot, nil)
+	var err error
+	scanBuffer, err = newScanRing(RingBufferConfig{Size: 4, Mapped: true}, filepath.Join(dir, "scanbuffer.ring"))
+	assert.NoError(t, err)
+	defer scanBuffer.close()
+	scanBuffer.put(Payload{ItemID: "PAT-42"})
+
+	cert, err := purgeItems(&Config{}, PurgeRequest{Item: "^PAT-42$"}, time.Now())
+	assert.NoError(t, err)
+	removed := map[string]int{}
+	for _, store := range cert.Stores {
+		removed[store.Store] += store.Removed
+	}
+	assert.Equal(t, 1, removed["standbyQueue"])
+	assert.Equal(t, 1, removed["scanBuffer"])
+	data, _ := os.ReadFile(snapshot)
+	assert.NotContains(t, string(data), "PAT-42")
+	assert.Equal(t, 1, pair.status(time.Now()).ReplicatedQueue)
+	assert.Empty(t, decodeRing(scanBuffer.mapped))
+}
+
+func TestPurgeItems_DryRun(t *testing.T) {
+	usePurgeFiles(t)
+	logFailure(Payload{ItemID: "PAT-42", DeviceType: "scanner0"})
+	before, _ := os.ReadFile(failuresFile)
+
+	cert, err := purgeItems(&Config{}, PurgeRequest{Item: "PAT-42", DryRun: true}, time.Now())
+	assert.NoError(t, err)
+	assert.Equal(t, 1, cert.Removed)
+	after, _ := os.ReadFile(failuresFile)
+	assert.Equal(t, before, after)
+	_, err = os.Stat(purgeCertificateFile)
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestAdminMux_Purge(t *testing.T) {
+	usePurgeFiles(t)
+	logFailure(Payload{ItemID: "PAT-42", DeviceType: "scanner0"})
+	config := &Config{}
+	server := httptest.NewServer(adminMux(config))
+	defer server.Close()
+
+	resp, err := http.Post(server.URL+"/purge", "application/json", strings.NewReader(`{"item":"("}`))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	config.Admin.ReadOnly = true
+	resp, err = http.Post(server.URL+"/purge", "application/json", strings.NewReader(`{"item":"PAT-42"}`))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+	config.Admin.ReadOnly = false
+	resp, err = http.Post(server.URL+"/purge", "application/json", strings.NewReader(`{"item":"PAT-42"}`))
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	var cert PurgeCertificate
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&cert))
+	assert.Equal(t, 1, cert.Removed)
+}
+
+func TestRequestPurge_Direct(t *testing.T) {
+	useTempStorage(t)
+	usePurgeFiles(t)
+	assert.NoError(t, setLogFile(filepath.Join(t.TempDir(), "service.log")))
+	logFailure(Payload{ItemID: "PAT-42", DeviceType: "scanner0"})
+	config := &Config{InstanceName: "test-purge"}
+
+	// a running service that cannot be reached still holds the instance lock
+	lock, err := lockInstance("test-purge")
+	assert.NoError(t, err)
+	_, err = requestPurge(config, PurgeRequest{Item: "PAT-42"})
+	assert.ErrorContains(t, err, "not purging the files directly")
+	assert.NotNil(t, logFile)
+	lock.release()
+
+	cert, err := requestPurge(config, PurgeRequest{Item: "PAT-42"})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, cert.Removed)
+	assert.False(t, cert.searched("serviceLog"))
+	assert.Nil(t, logFile)
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/zachthieme/scanandpost/scan"
+)
+
+// quarantineFile receives failures.log entries that could not be repaired
+var quarantineFile = "failures.quarantine.log"
+
+// QueueCheck reports the outcome of the startup integrity check of failures.log
+type QueueCheck struct {
+	Valid       int `json:"valid"`
+	Repaired    int `json:"repaired"`
+	Quarantined int `json:"quarantined"`
+}
+
+var (
+	queueCheckMu     sync.Mutex
+	lastQueueCheck   QueueCheck
+	errQueueChecksum = scan.ErrChecksum
+)
+
+// encodeQueueEntry formats a payload queued at the given time as a failures.log
+// line prefixed with its CRC-32
+func encodeQueueEntry(payload Payload, queuedAt time.Time) (string, error) {
+	return scan.EncodeQueueEntryAt(payload, queuedAt)
+}
+
+// decodeQueueEntry parses a failures.log line, verifying its checksum. The
+// time is zero for entries written before queue times were recorded.
+func decodeQueueEntry(line string) (Payload, time.Time, error) {
+	return scan.DecodeQueueEntryAt(line)
+}
+
+// checkQueue verifies every entry in failures.log, rewriting entries that can
+// be repaired and moving the rest to the quarantine file
+func checkQueue() (QueueCheck, error) {
+	failuresMu.Lock()
+	defer failuresMu.Unlock()
+
+	var result QueueCheck
+	data, err := os.ReadFile(failuresFile)
+	if os.IsNotExist(err) {
+		return result, nil
+	} else if err != nil {
+		return result, err
+	}
+
+	var kept, quarantined []string
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 64*1024), len(data)+1)
+	for scanner.Scan() {
+		raw := scanner.Text()
+		// a pulled plug often leaves runs of NUL bytes where the last write was
+		line := strings.TrimSpace(strings.ReplaceAll(raw, "\x00", ""))
+		if line == "" {
+			continue
+		}
+		payload, queuedAt, err := decodeQueueEntry(line)
+		if err != nil {
+			logger.Warnf("Quarantining corrupt failures.log entry %q: %v", line, err)
+			quarantined = append(quarantined, raw)
+			continue
+		}
+		entry, err := encodeQueueEntry(payload, queuedAt)
+		if err != nil {
+			quarantined = append(quarantined, raw)
+			continue
+		}
+		if entry == raw {
+			result.Valid++
+		} else {
+			result.Repaired++
+		}
+		kept = append(kept, entry)
+	}
+	result.Quarantined = len(quarantined)
+
+	for _, line := range quarantined {
+		if err := appendRecord(quarantineFile, []byte(line)); err != nil {
+			return result, err
+		}
+	}
+	if result.Repaired == 0 && result.Quarantined == 0 && bytes.HasSuffix(data, []byte("\n")) {
+		return result, nil
+	}
+	content := strings.Join(kept, "\n")
+	if content != "" {
+		content += "\n"
+	}
+	return result, writeFileAtomic(failuresFile, []byte(content))
+}
+
+// runQueueCheck checks failures.log at startup and records the result for the status report
+func runQueueCheck() {
+	result, err := checkQueue()
+	if err != nil {
+		logger.Errorf("Error checking failures.log: %v", err)
+	}
+	logger.Infof("Queue check: %d valid, %d repaired, %d quarantined entries", result.Valid, result.Repaired, result.Quarantined)
+	queueCheckMu.Lock()
+	lastQueueCheck = result
+	queueCheckMu.Unlock()
+}
+
+// queueCheckResult returns the result of the startup queue check
+func queueCheckResult() QueueCheck {
+	queueCheckMu.Lock()
+	defer queueCheckMu.Unlock()
+	return lastQueueCheck
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// useTempQueue points the queue files into a fresh temporary directory for one test
+func useTempQueue(t *testing.T) {
+	failures, quarantine, deadLetters, outputQueues := failuresFile, quarantineFile, deadLetterFile, outputQueueDir
+	t.Cleanup(func() {
+		failuresFile, quarantineFile, deadLetterFile, outputQueueDir = failures, quarantine, deadLetters, outputQueues
+	})
+	dir := t.TempDir()
+	failuresFile = filepath.Join(dir, "failures.log")
+	quarantineFile = filepath.Join(dir, "failures.quarantine.log")
+	deadLetterFile = filepath.Join(dir, "deadletter.log")
+	outputQueueDir = filepath.Join(dir, "outputs")
+}
+
+func TestQueueEntry_RoundTrip(t *testing.T) {
+	queued := time.UnixMilli(1700000000123)
+	entry, err := encodeQueueEntry(Payload{ItemID: "1", DeviceType: "scanner0"}, queued)
+	assert.NoError(t, err)
+	payload, queuedAt, err := decodeQueueEntry(entry)
+	assert.NoError(t, err)
+	assert.Equal(t, Payload{ItemID: "1", DeviceType: "scanner0"}, payload)
+	assert.True(t, queued.Equal(queuedAt))
+
+	_, _, err = decodeQueueEntry(entry[:len(entry)-3] + `x"}`)
+	assert.ErrorIs(t, err, errQueueChecksum)
+
+	payload, queuedAt, err = decodeQueueEntry(`{"itemid":"2","deviceType":"scanner0"}`)
+	assert.NoError(t, err)
+	assert.Equal(t, "2", payload.ItemID)
+	assert.True(t, queuedAt.IsZero())
+}
+
+func TestCheckQueue_RepairsAndQuarantines(t *testing.T) {
+	useTempQueue(t)
+
+	valid, _ := encodeQueueEntry(Payload{ItemID: "1"}, time.Now())
+	legacy := `{"itemid":"2","deviceType":""}`
+	torn := `{"itemid":"3","devi`
+	os.WriteFile(failuresFile, []byte(valid+"\n"+legacy+"\n"+torn+"\x00\x00\x00"), 0644)
+
+	result, err := checkQueue()
+	assert.NoError(t, err)
+	assert.Equal(t, QueueCheck{Valid: 1, Repaired: 1, Quarantined: 1}, result)
+
+	repaired, _ := encodeQueueEntry(Payload{ItemID: "2"}, time.Time{})
+	data, _ := os.ReadFile(failuresFile)
+	assert.Equal(t, valid+"\n"+repaired+"\n", string(data))
+	data, _ = os.ReadFile(quarantineFile)
+	assert.Contains(t, string(data), torn)
+
+	result, err = checkQueue()
+	assert.NoError(t, err)
+	assert.Equal(t, QueueCheck{Valid: 2}, result)
+}
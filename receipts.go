@@ -0,0 +1,145 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// ReceiptsConfig represents the CSV of delivered scans kept for legacy reconciliation
+type ReceiptsConfig struct {
+	// Path is the CSV file, for example on a network share
+	Path string `json:"path"`
+	// IDField is the field of the API's JSON response that holds the backend ID (default "id")
+	IDField string `json:"idField"`
+}
+
+// receiptWriter appends one CSV line per delivered scan. A nil writer writes nothing.
+type receiptWriter struct {
+	config ReceiptsConfig
+	// sequenced adds the sequence and status columns, so the file also flags missing sequences
+	sequenced bool
+	mu        sync.Mutex
+}
+
+var receipts *receiptWriter
+
+var receiptHeader = []string{"timestamp", "itemid", "deviceType", "backendId"}
+
+var sequenceReceiptHeader = []string{"sequence", "status"}
+
+func newReceiptWriter(config ReceiptsConfig) *receiptWriter {
+	if config.IDField == "" {
+		config.IDField = "id"
+	}
+	return &receiptWriter{config: config}
+}
+
+// record appends a receipt for a payload the API accepted with the given response body
+func (r *receiptWriter) record(payload Payload, body []byte, at time.Time) {
+	if r == nil {
+		return
+	}
+	r.write([][]string{r.line(payload, r.backendID(body), at)})
+}
+
+// recordBatch appends a receipt for each payload of an accepted batch. The
+// backend IDs are taken from the response when it is an array in batch order.
+func (r *receiptWriter) recordBatch(batch []Payload, body []byte, at time.Time) {
+	if r == nil {
+		return
+	}
+	var items []json.RawMessage
+	json.Unmarshal(body, &items)
+	lines := make([][]string, len(batch))
+	for i, payload := range batch {
+		id := ""
+		if len(items) == len(batch) {
+			id = r.backendID(items[i])
+		}
+		lines[i] = r.line(payload, id, at)
+	}
+	r.write(lines)
+}
+
+func (r *receiptWriter) line(payload Payload, backendID string, at time.Time) []string {
+	line := []string{at.Format(time.RFC3339), payload.ItemID, payload.DeviceType, backendID}
+	if r.sequenced {
+		line = append(line, sequenceField(payload.Sequence), "delivered")
+	}
+	return line
+}
+
+// recordMissing appends a line flagging a sequence that was never delivered
+func (r *receiptWriter) recordMissing(gap SequenceGap) {
+	if r == nil || !r.sequenced {
+		return
+	}
+	r.write([][]string{{gap.IssuedAt.Format(time.RFC3339), "", gap.Device, "", sequenceField(gap.Sequence), "missing"}})
+}
+
+func sequenceField(sequence uint64) string {
+	if sequence == 0 {
+		return ""
+	}
+	return strconv.FormatUint(sequence, 10)
+}
+
+// backendID extracts the ID from a response that is a JSON object holding
+// IDField, or a bare string or number, and returns "" otherwise
+func (r *receiptWriter) backendID(body []byte) string {
+	var value interface{}
+	if json.Unmarshal(body, &value) != nil {
+		return ""
+	}
+	if object, ok := value.(map[string]interface{}); ok {
+		value = object[r.config.IDField]
+	}
+	switch v := value.(type) {
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	}
+	return ""
+}
+
+// write appends lines to the CSV, opening it each time so a share that
+// dropped and came back is picked up again
+func (r *receiptWriter) write(lines [][]string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	file, err := os.OpenFile(r.config.Path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		logger.Errorf("Error opening receipts file %s: %v", r.config.Path, err)
+		return
+	}
+	defer file.Close()
+	w := csv.NewWriter(file)
+	if info, err := file.Stat(); err == nil && info.Size() == 0 {
+		header := receiptHeader
+		if r.sequenced {
+			header = append(append([]string{}, receiptHeader...), sequenceReceiptHeader...)
+		}
+		w.Write(header)
+	}
+	w.WriteAll(lines)
+	if err := w.Error(); err != nil {
+		logger.Errorf("Error writing to receipts file %s: %v", r.config.Path, err)
+	}
+}
+
+// readResponseBody reads and closes the body of an API response, up to 64 KiB
+func readResponseBody(resp *http.Response) []byte {
+	if resp.Body == nil {
+		return nil
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	return body
+}
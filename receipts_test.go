@@ -0,0 +1,67 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReceiptWriter(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "receipts.csv")
+	r := newReceiptWriter(ReceiptsConfig{Path: path, IDField: "scanId"})
+	at := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	r.record(Payload{ItemID: "A,1", DeviceType: "scanner0"}, []byte(`{"scanId": 981}`), at)
+	r.recordBatch([]Payload{{ItemID: "B"}, {ItemID: "C"}}, []byte(`[{"scanId": "x-1"}, {"scanId": "x-2"}]`), at)
+	r.recordBatch([]Payload{{ItemID: "D"}}, []byte(`accepted`), at)
+
+	data, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, strings.Join([]string{
+		"timestamp,itemid,deviceType,backendId",
+		`2024-05-01T12:00:00Z,"A,1",scanner0,981`,
+		"2024-05-01T12:00:00Z,B,,x-1",
+		"2024-05-01T12:00:00Z,C,,x-2",
+		"2024-05-01T12:00:00Z,D,,",
+		"",
+	}, "\n"), string(data))
+}
+
+func TestReceiptWriter_Sequenced(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "receipts.csv")
+	r := newReceiptWriter(ReceiptsConfig{Path: path})
+	r.sequenced = true
+	at := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	r.record(Payload{ItemID: "A", DeviceType: "scanner0", Sequence: 1}, []byte(`{"id": 981}`), at)
+	r.recordMissing(SequenceGap{Device: "scanner0", Sequence: 2, IssuedAt: at, FlaggedAt: at.Add(time.Hour)})
+
+	data, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, strings.Join([]string{
+		"timestamp,itemid,deviceType,backendId,sequence,status",
+		"2024-05-01T12:00:00Z,A,scanner0,981,1,delivered",
+		"2024-05-01T12:00:00Z,,scanner0,,2,missing",
+		"",
+	}, "\n"), string(data))
+}
+
+func TestPostPayload_WritesReceipt(t *testing.T) {
+	oldReceipts, oldPost := receipts, httpPost
+	defer func() { receipts, httpPost = oldReceipts, oldPost }()
+	path := filepath.Join(t.TempDir(), "receipts.csv")
+	receipts = newReceiptWriter(ReceiptsConfig{Path: path})
+	httpPost = func(url, contentType string, body io.Reader) (*http.Response, error) {
+		return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(`{"id": "b-7"}`))}, nil
+	}
+
+	postPayload(&Config{}, Payload{ItemID: "12345", DeviceType: "scanner0"})
+	data, _ := os.ReadFile(path)
+	assert.Contains(t, string(data), ",12345,scanner0,b-7\n")
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// recentLimit is how many scans and errors the admin API keeps for monitoring
+const recentLimit = 50
+
+// RecentScan is a scan as shown by the monitor
+type RecentScan struct {
+	Time     time.Time `json:"time"`
+	ItemID   string    `json:"itemid"`
+	Device   string    `json:"deviceType"`
+	Nickname string    `json:"nickname,omitempty"`
+}
+
+// RecentError is an error logged by the service
+type RecentError struct {
+	Time    time.Time `json:"time"`
+	Message string    `json:"message"`
+}
+
+// RecentFeedback is a message shown to the operator for a scan
+type RecentFeedback struct {
+	Time    time.Time `json:"time"`
+	ItemID  string    `json:"itemid"`
+	Device  string    `json:"deviceType"`
+	Message string    `json:"message"`
+}
+
+// Recent holds the latest scans, errors and feedback, newest last
+type Recent struct {
+	Scans    []RecentScan     `json:"scans"`
+	Errors   []RecentError    `json:"errors"`
+	Feedback []RecentFeedback `json:"feedback,omitempty"`
+}
+
+// recentEvents keeps the latest scans, errors and feedback in memory
+type recentEvents struct {
+	mu       sync.Mutex
+	scans    []RecentScan
+	errors   []RecentError
+	feedback []RecentFeedback
+}
+
+var recent = &recentEvents{}
+
+func (r *recentEvents) addScan(payload Payload, now time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.scans = append(r.scans, RecentScan{Time: now, ItemID: payload.ItemID, Device: payload.DeviceType, Nickname: payload.Nickname})
+	if len(r.scans) > recentLimit {
+		r.scans = r.scans[len(r.scans)-recentLimit:]
+	}
+}
+
+func (r *recentEvents) addError(message string, now time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.errors = append(r.errors, RecentError{Time: now, Message: message})
+	if len(r.errors) > recentLimit {
+		r.errors = r.errors[len(r.errors)-recentLimit:]
+	}
+}
+
+func (r *recentEvents) addFeedback(payload Payload, message string, now time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.feedback = append(r.feedback, RecentFeedback{Time: now, ItemID: payload.ItemID, Device: payload.DeviceType, Message: message})
+	if len(r.feedback) > recentLimit {
+		r.feedback = r.feedback[len(r.feedback)-recentLimit:]
+	}
+}
+
+// snapshot copies the recent events
+func (r *recentEvents) snapshot() Recent {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return Recent{
+		Scans:    append([]RecentScan{}, r.scans...),
+		Errors:   append([]RecentError{}, r.errors...),
+		Feedback: append([]RecentFeedback(nil), r.feedback...),
+	}
+}
+
+// recentErrorsHook copies every error logged by the service into recent
+type recentErrorsHook struct{}
+
+func (recentErrorsHook) Levels() []logrus.Level {
+	return []logrus.Level{logrus.PanicLevel, logrus.FatalLevel, logrus.ErrorLevel}
+}
+
+func (recentErrorsHook) Fire(entry *logrus.Entry) error {
+	recent.addError(entry.Message, entry.Time)
+	return nil
+}
+
+// purge forgets the scans and feedback whose item ID matches, and the errors
+// whose message does, returning how many entries match. A dry run only counts them.
+func (r *recentEvents) purge(match func(itemID string) bool, matchText func(text string) bool, dryRun bool) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var scans []RecentScan
+	var errors []RecentError
+	var feedback []RecentFeedback
+	for _, s := range r.scans {
+		if !match(s.ItemID) {
+			scans = append(scans, s)
+		}
+	}
+	for _, e := range r.errors {
+		if !matchText(e.Message) {
+			errors = append(errors, e)
+		}
+	}
+	for _, f := range r.feedback {
+		if !match(f.ItemID) {
+			feedback = append(feedback, f)
+		}
+	}
+	removed := len(r.scans) - len(scans) + len(r.errors) - len(errors) + len(r.feedback) - len(feedback)
+	if !dryRun {
+		r.scans, r.errors, r.feedback = scans, errors, feedback
+	}
+	return removed
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"regexp"
+	"sync"
+	"time"
+)
+
+// headerStation optionally names the submitting station; the remote address is used otherwise
+const headerStation = "X-Scan-Station"
+
+// RelayDedupeConfig represents dropping handoff scans of the same item by
+// different stations, for a relay that receives scans from several stations
+type RelayDedupeConfig struct {
+	// WindowSeconds applies to classes that do not set their own window
+	WindowSeconds int           `json:"windowSeconds"`
+	Classes       []DedupeClass `json:"classes"`
+}
+
+// DedupeClass is a kind of barcode, such as pallet labels, that is deduplicated across stations
+type DedupeClass struct {
+	Name          string `json:"name"`
+	Pattern       string `json:"pattern"`
+	WindowSeconds int    `json:"windowSeconds"`
+}
+
+type dedupeClass struct {
+	name    string
+	pattern *regexp.Regexp
+	window  time.Duration
+}
+
+type firstScan struct {
+	station string
+	at      time.Time
+	expires time.Time
+}
+
+// relayDedupe remembers the first station to scan each item of a class until the window passes
+type relayDedupe struct {
+	classes []dedupeClass
+	mu      sync.Mutex
+	seen    map[string]firstScan
+}
+
+func newRelayDedupe(config RelayDedupeConfig) (*relayDedupe, error) {
+	if len(config.Classes) == 0 {
+		return nil, nil
+	}
+	defaultWindow := 10 * time.Second
+	if config.WindowSeconds > 0 {
+		defaultWindow = time.Duration(config.WindowSeconds) * time.Second
+	}
+	d := &relayDedupe{seen: map[string]firstScan{}}
+	for _, c := range config.Classes {
+		pattern, err := regexp.Compile(c.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("dedupe class %s: %v", c.Name, err)
+		}
+		window := defaultWindow
+		if c.WindowSeconds > 0 {
+			window = time.Duration(c.WindowSeconds) * time.Second
+		}
+		d.classes = append(d.classes, dedupeClass{name: c.Name, pattern: pattern, window: window})
+	}
+	return d, nil
+}
+
+// duplicate reports whether another station scanned the same item of the same
+// class within the window, returning that first scan. Items matching no class
+// and repeat scans by the same station are never duplicates.
+func (d *relayDedupe) duplicate(itemID, station string, now time.Time) (firstScan, bool) {
+	if d == nil {
+		return firstScan{}, false
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for key, scan := range d.seen {
+		if now.After(scan.expires) {
+			delete(d.seen, key)
+		}
+	}
+	for _, c := range d.classes {
+		if !c.pattern.MatchString(itemID) {
+			continue
+		}
+		key := c.name + "\x00" + itemID
+		if first, ok := d.seen[key]; ok && first.station != station {
+			return first, true
+		}
+		d.seen[key] = firstScan{station: station, at: now, expires: now.Add(c.window)}
+		return firstScan{}, false
+	}
+	return firstScan{}, false
+}
+
+// stationOf identifies the station that submitted a request
+func stationOf(r *http.Request) string {
+	if station := r.Header.Get(headerStation); station != "" {
+		return station
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
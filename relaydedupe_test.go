@@ -0,0 +1,63 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRelayDedupe_AcrossStations(t *testing.T) {
+	d, err := newRelayDedupe(RelayDedupeConfig{WindowSeconds: 10, Classes: []DedupeClass{
+		{Name: "pallet", Pattern: `^PAL\d+$`},
+		{Name: "tote", Pattern: `^TOTE`, WindowSeconds: 2},
+	}})
+	assert.NoError(t, err)
+	now := time.Now()
+
+	_, dup := d.duplicate("PAL1", "dock1", now)
+	assert.False(t, dup)
+	_, dup = d.duplicate("PAL1", "dock1", now.Add(time.Second))
+	assert.False(t, dup, "repeat scans by the same station pass")
+	first, dup := d.duplicate("PAL1", "dock2", now.Add(2*time.Second))
+	assert.True(t, dup)
+	assert.Equal(t, "dock1", first.station)
+	_, dup = d.duplicate("PAL1", "dock2", now.Add(20*time.Second))
+	assert.False(t, dup, "window has passed")
+
+	d.duplicate("TOTE7", "dock1", now)
+	_, dup = d.duplicate("TOTE7", "dock2", now.Add(3*time.Second))
+	assert.False(t, dup, "class window is shorter")
+
+	d.duplicate("ITEM9", "dock1", now)
+	_, dup = d.duplicate("ITEM9", "dock2", now)
+	assert.False(t, dup, "items outside every class are not deduplicated")
+}
+
+func TestNewRelayDedupe_InvalidPattern(t *testing.T) {
+	_, err := newRelayDedupe(RelayDedupeConfig{Classes: []DedupeClass{{Name: "bad", Pattern: "("}}})
+	assert.Error(t, err)
+}
+
+func TestIngestHandler_DropsHandoffDuplicate(t *testing.T) {
+	payloadCh := make(chan Payload, 2)
+	h := newIngestHandler(IngestConfig{}, payloadCh)
+	h.dedupe, _ = newRelayDedupe(RelayDedupeConfig{Classes: []DedupeClass{{Name: "pallet", Pattern: "^PAL"}}})
+
+	for i, station := range []string{"dock1", "dock2"} {
+		req := httptest.NewRequest(http.MethodPost, "/scan", strings.NewReader(`{"itemid":"PAL1"}`))
+		req.Header.Set(headerStation, station)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		if i == 0 {
+			assert.Equal(t, http.StatusAccepted, w.Code)
+		} else {
+			assert.Equal(t, http.StatusOK, w.Code)
+			assert.Equal(t, "true", w.Header().Get("X-Scan-Duplicate"))
+		}
+	}
+	assert.Len(t, payloadCh, 1)
+}
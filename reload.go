@@ -0,0 +1,111 @@
+package main
+
+import (
+	"reflect"
+	"sync"
+	"time"
+)
+
+// outputsMu guards httpOutputs. Deliveries hold it for reading while they hand
+// payloads to the output pipelines, and a reload swaps the outputs under it in
+// one step, so every payload goes to either the old or the new outputs. The
+// old pipelines finish their posts in flight after the swap, so a hung output
+// never holds back dispatch.
+var outputsMu sync.RWMutex
+
+// reloadMu keeps one reload from swapping outputs another is still draining
+var reloadMu sync.Mutex
+
+// ReloadResult describes the outputs changed by a reload
+type ReloadResult struct {
+	Added   []string `json:"added"`
+	Removed []string `json:"removed"`
+	Changed []string `json:"changed"`
+	// DrainMillis is how long the swap waited for posts in flight
+	DrainMillis int64 `json:"drainMillis"`
+}
+
+// reloadOutputs re-reads config.json and swaps in its outputs when they
+// changed. An invalid config leaves the current outputs in place.
+func reloadOutputs(config *Config) (ReloadResult, error) {
+	var result ReloadResult
+	updated, err := readConfig()
+	if err != nil {
+		return result, err
+	}
+	// clients are built before the swap so a bad TLS file never drops the old outputs
+	outputs, err := loadOutputs(updated)
+	if err != nil {
+		return result, err
+	}
+
+	reloadMu.Lock()
+	defer reloadMu.Unlock()
+	outputsMu.RLock()
+	result.Added, result.Removed, result.Changed = diffOutputs(config.Outputs, updated.Outputs)
+	outputsMu.RUnlock()
+	if len(result.Added)+len(result.Removed)+len(result.Changed) == 0 {
+		return result, nil
+	}
+
+	outputsMu.Lock()
+	old := httpOutputs
+	// an output that keeps its name takes over its queue file, and its lock
+	fileLocks := map[string]*sync.Mutex{}
+	for _, o := range old {
+		if o.pipeline != nil {
+			fileLocks[o.config.Name] = o.pipeline.fileMu
+		}
+	}
+	for _, o := range outputs {
+		o.open(fileLocks[o.config.Name])
+	}
+	httpOutputs = outputs
+	config.Outputs = updated.Outputs
+	outputsMu.Unlock()
+
+	// the new pipelines hold their payloads until the old ones are done posting
+	start := time.Now()
+	for _, o := range old {
+		if o.pipeline != nil {
+			o.stop()
+		}
+	}
+	result.DrainMillis = time.Since(start).Milliseconds()
+	for _, o := range outputs {
+		go o.run()
+	}
+	for _, name := range result.Removed {
+		logger.Warnf("Output %s was removed; payloads still queued for it are kept in %s", name, outputQueueFile(name))
+	}
+
+	for _, o := range old {
+		o.client.CloseIdleConnections()
+	}
+	logger.Infof("Reloaded outputs: added %v, removed %v, changed %v after draining for %dms", result.Added, result.Removed, result.Changed, result.DrainMillis)
+	return result, nil
+}
+
+// diffOutputs compares outputs by name
+func diffOutputs(before, after []OutputConfig) (added, removed, changed []string) {
+	old := map[string]OutputConfig{}
+	for _, cfg := range before {
+		old[cfg.Name] = cfg
+	}
+	for _, cfg := range after {
+		previous, ok := old[cfg.Name]
+		switch {
+		case !ok:
+			added = append(added, cfg.Name)
+		case !reflect.DeepEqual(previous, cfg):
+			changed = append(changed, cfg.Name)
+		}
+		delete(old, cfg.Name)
+	}
+	for _, cfg := range before {
+		if _, ok := old[cfg.Name]; ok {
+			removed = append(removed, cfg.Name)
+		}
+	}
+	return added, removed, changed
+}
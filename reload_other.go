@@ -0,0 +1,20 @@
+//go:build !windows
+
+package main
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// watchReloadSignal reloads the outputs on SIGHUP
+func watchReloadSignal(config *Config) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+	for range signals {
+		if _, err := reloadOutputs(config); err != nil {
+			logger.Errorf("Error reloading outputs: %v", err)
+		}
+	}
+}
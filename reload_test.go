@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReloadOutputs_DrainsAndSwaps(t *testing.T) {
+	useTempQueue(t)
+	wd, _ := os.Getwd()
+	defer os.Chdir(wd)
+	os.Chdir(t.TempDir())
+	oldOutputs := httpOutputs
+	defer func() { httpOutputs = oldOutputs }()
+
+	var oldPosts, newPosts int32
+	arrived, release := make(chan bool), make(chan bool)
+	oldServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&oldPosts, 1)
+		arrived <- true
+		<-release
+	}))
+	defer oldServer.Close()
+	newServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&newPosts, 1)
+	}))
+	defer newServer.Close()
+
+	config := &Config{Outputs: []OutputConfig{{Name: "relay", Endpoint: oldServer.URL}}}
+	var err error
+	httpOutputs, err = loadOutputs(config)
+	assert.NoError(t, err)
+	startOutputs(httpOutputs)
+	defer stopOutputs()
+
+	// a bad config keeps the current outputs
+	os.WriteFile("config.json", []byte(`{"configVersion": 1, "outputs": [{"name": "relay", "endpoint": "http://x", "encoding": "xml"}]}`), 0644)
+	_, err = reloadOutputs(config)
+	assert.EqualError(t, err, `output relay: unknown encoding "xml"`)
+
+	os.WriteFile("config.json", []byte(fmt.Sprintf(`{"configVersion": 1, "outputs": [{"name": "relay", "endpoint": %q}, {"name": "webhook", "endpoint": %q}]}`, newServer.URL, newServer.URL)), 0644)
+	deliverToOutputs(Payload{ItemID: "1"})
+	<-arrived
+
+	reloaded := make(chan ReloadResult)
+	go func() {
+		result, err := reloadOutputs(config)
+		assert.NoError(t, err)
+		reloaded <- result
+	}()
+	select {
+	case <-reloaded:
+		t.Fatal("reload did not wait for the post in flight")
+	case <-time.After(50 * time.Millisecond):
+	}
+	// scans keep flowing to the new outputs while the old one drains
+	delivered := make(chan bool)
+	go func() {
+		deliverToOutputs(Payload{ItemID: "2"})
+		delivered <- true
+	}()
+	select {
+	case <-delivered:
+	case <-time.After(time.Second):
+		t.Fatal("delivery waited for the post in flight")
+	}
+	release <- true
+	result := <-reloaded
+	assert.Equal(t, []string{"webhook"}, result.Added)
+	assert.Equal(t, []string{"relay"}, result.Changed)
+	assert.Empty(t, result.Removed)
+	// the drain starts a little after the 50ms wait does, so it is only known to have waited
+	assert.Positive(t, result.DrainMillis)
+
+	deliverToOutputs(Payload{ItemID: "3"})
+	assert.Eventually(t, func() bool { return atomic.LoadInt32(&newPosts) == 4 }, time.Second, 10*time.Millisecond)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&oldPosts))
+
+	// reloading the same outputs changes nothing
+	result, err = reloadOutputs(config)
+	assert.NoError(t, err)
+	assert.Equal(t, ReloadResult{}, result)
+}
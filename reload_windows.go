@@ -0,0 +1,4 @@
+package main
+
+// watchReloadSignal does nothing on Windows, which has no SIGHUP; use POST /reload on the admin API
+func watchReloadSignal(config *Config) {}
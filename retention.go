@@ -0,0 +1,317 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RetentionConfig represents how long local artifacts are kept, for privacy
+// requirements and for kiosks with tiny disks. The queue itself is never
+// purged, since it holds scans not yet delivered.
+type RetentionConfig struct {
+	Enabled bool `json:"enabled"`
+	// IntervalMinutes is how often the artifacts are purged (default 60)
+	IntervalMinutes int `json:"intervalMinutes"`
+	// CommandAudit applies to commands.audit.log
+	CommandAudit RetentionPolicy `json:"commandAudit"`
+	// DeadLetters applies to deadletter.log
+	DeadLetters RetentionPolicy `json:"deadLetters"`
+	// Quarantine applies to failures.quarantine.log, the queue entries that could not be repaired
+	Quarantine RetentionPolicy `json:"quarantine"`
+	// Receipts applies to the receipts CSV of delivered scans
+	Receipts RetentionPolicy `json:"receipts"`
+	// ServiceLog applies to service.log
+	ServiceLog RetentionPolicy `json:"serviceLog"`
+}
+
+// RetentionPolicy limits one artifact. Zero means no limit.
+type RetentionPolicy struct {
+	// MaxAgeDays removes records older than this many days
+	MaxAgeDays int `json:"maxAgeDays"`
+	// MaxSizeMB removes the oldest records until the file is at most this size
+	MaxSizeMB int `json:"maxSizeMB"`
+}
+
+func (r RetentionConfig) withDefaults() RetentionConfig {
+	if r.IntervalMinutes <= 0 {
+		r.IntervalMinutes = 60
+	}
+	return r
+}
+
+func (r RetentionConfig) validate() error {
+	for _, a := range r.artifacts() {
+		if a.policy.MaxAgeDays < 0 || a.policy.MaxSizeMB < 0 {
+			return fmt.Errorf("%s: limits cannot be negative", a.name)
+		}
+	}
+	return nil
+}
+
+// PurgeReport lists what a retention purge removed
+type PurgeReport struct {
+	Time  time.Time    `json:"time"`
+	Files []PurgedFile `json:"files"`
+}
+
+// PurgedFile is the purge result of one artifact
+type PurgedFile struct {
+	Artifact     string `json:"artifact"`
+	Path         string `json:"path"`
+	Removed      int    `json:"removed"`
+	RemovedBytes int64  `json:"removedBytes"`
+	Kept         int    `json:"kept"`
+	Error        string `json:"error,omitempty"`
+}
+
+// retainedArtifact is a record file under a retention policy
+type retainedArtifact struct {
+	name   string
+	path   string
+	policy RetentionPolicy
+	// header is the number of lines at the top that are always kept
+	header int
+	// recordTime returns when a record was written, or false when it cannot tell
+	recordTime func(line string) (time.Time, bool)
+	// lock keeps writers out while the file is rewritten and returns the unlock
+	lock func() func()
+}
+
+// artifacts returns the files under retention, with their current paths
+func (r RetentionConfig) artifacts() []retainedArtifact {
+	artifacts := []retainedArtifact{
+		{name: "commandAudit", path: commandAuditFile, policy: r.CommandAudit, recordTime: jsonRecordTime, lock: lockRecords(commandAuditFile)},
+		{name: "deadLetters", path: deadLetterFile, policy: r.DeadLetters, recordTime: jsonRecordTime, lock: lockRecords(deadLetterFile)},
+		{name: "quarantine", path: quarantineFile, policy: r.Quarantine, recordTime: queueRecordTime, lock: lockRecords(quarantineFile)},
+	}
+	for _, path := range rotatedAuditLogs() {
+		artifacts = append(artifacts, retainedArtifact{name: "commandAudit", path: path, policy: r.CommandAudit, recordTime: jsonRecordTime, lock: lockRecords(path)})
+	}
+	if receipts != nil {
+		artifacts = append(artifacts, retainedArtifact{name: "receipts", path: receipts.config.Path, policy: r.Receipts, header: 1, recordTime: csvRecordTime, lock: receipts.lock})
+	}
+	if path := logFileName(); path != "" {
+		artifacts = append(artifacts, retainedArtifact{name: "serviceLog", path: path, policy: r.ServiceLog, recordTime: jsonRecordTime, lock: lockServiceLog})
+	}
+	return artifacts
+}
+
+// lockRecords returns the lock that keeps appendRecord out of the file at path
+func lockRecords(path string) func() func() {
+	return func() func() {
+		mu := recordLock(path)
+		mu.Lock()
+		return mu.Unlock
+	}
+}
+
+// lock keeps receipts from being written and returns the unlock
+func (r *receiptWriter) lock() func() {
+	r.mu.Lock()
+	return r.mu.Unlock
+}
+
+// heldLog keeps the lines logged while service.log is closed
+type heldLog struct {
+	mu    sync.Mutex
+	lines bytes.Buffer
+}
+
+func (h *heldLog) Write(p []byte) (int, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.lines.Write(p)
+}
+
+// lockServiceLog closes service.log so it can be rewritten, since Windows
+// cannot replace an open file, and returns the function that reopens it.
+// Lines logged meanwhile go to stdout and are appended once it reopens.
+// Only one rewrite of service.log holds it closed at a time.
+func lockServiceLog() func() {
+	logMu.Lock()
+	path := logFile.Name()
+	logMu.Unlock()
+	rewrite := recordLock(path)
+	rewrite.Lock()
+
+	logMu.Lock()
+	held := &heldLog{}
+	setLogOutput(io.MultiWriter(held, os.Stdout))
+	logFile.Close()
+	logMu.Unlock()
+	return func() {
+		defer rewrite.Unlock()
+		logMu.Lock()
+		defer logMu.Unlock()
+		if err := openLogFile(path); err != nil {
+			logger.Errorf("Error reopening %s: %v", path, err)
+			return
+		}
+		held.mu.Lock()
+		defer held.mu.Unlock()
+		if _, err := logFile.Write(held.lines.Bytes()); err != nil {
+			logger.Errorf("Error writing the lines logged during the purge to %s: %v", path, err)
+		}
+	}
+}
+
+// jsonRecordTime reads the "time" field of a JSON record
+func jsonRecordTime(line string) (time.Time, bool) {
+	var record struct {
+		Time time.Time `json:"time"`
+	}
+	if json.Unmarshal([]byte(line), &record) != nil || record.Time.IsZero() {
+		return time.Time{}, false
+	}
+	return record.Time, true
+}
+
+// queueRecordTime reads when a queue entry was queued
+func queueRecordTime(line string) (time.Time, bool) {
+	_, queuedAt, err := decodeQueueEntry(line)
+	return queuedAt, err == nil && !queuedAt.IsZero()
+}
+
+// csvRecordTime reads the timestamp in the first column of a receipt
+func csvRecordTime(line string) (time.Time, bool) {
+	fields, err := csv.NewReader(strings.NewReader(line)).Read()
+	if err != nil || len(fields) == 0 {
+		return time.Time{}, false
+	}
+	at, err := time.Parse(time.RFC3339, fields[0])
+	return at, err == nil
+}
+
+// filterRecords rewrites a record file with the header lines and the records
+// keep selects, returning how many records and bytes were removed and how
+// many records were kept. keep is also given the size of the header lines.
+// The caller holds the file's lock.
+func filterRecords(path string, header int, keep func(records []string, headerBytes int64) []bool) (removed int, removedBytes int64, kept int, err error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return 0, 0, 0, nil
+	} else if err != nil {
+		return 0, 0, 0, err
+	}
+	lines := strings.SplitAfter(string(data), "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	if header > len(lines) {
+		header = len(lines)
+	}
+	records := lines[header:]
+	trimmed := make([]string, len(records))
+	for i, record := range records {
+		trimmed[i] = strings.TrimRight(record, "\r\n")
+	}
+	var content bytes.Buffer
+	for _, line := range lines[:header] {
+		content.WriteString(line)
+	}
+	selected := keep(trimmed, int64(content.Len()))
+
+	for i, record := range records {
+		if selected[i] {
+			content.WriteString(record)
+			kept++
+			continue
+		}
+		removed++
+		removedBytes += int64(len(record))
+	}
+	if removed == 0 {
+		return 0, 0, kept, nil
+	}
+	return removed, removedBytes, kept, writeFileAtomic(path, content.Bytes())
+}
+
+// retain selects the records within the age and size limits. Records of
+// unknown age are only removed to meet the size limit.
+func (a retainedArtifact) retain(records []string, headerBytes int64, now time.Time) []bool {
+	keep := make([]bool, len(records))
+	size := headerBytes
+	for i, record := range records {
+		keep[i] = true
+		if a.policy.MaxAgeDays > 0 {
+			if at, ok := a.recordTime(record); ok && now.Sub(at) > time.Duration(a.policy.MaxAgeDays)*24*time.Hour {
+				keep[i] = false
+				continue
+			}
+		}
+		size += int64(len(record)) + 1
+	}
+	// the records are in the order written, so the oldest go first
+	limit := int64(a.policy.MaxSizeMB) * 1024 * 1024
+	for i := 0; a.policy.MaxSizeMB > 0 && size > limit && i < len(records); i++ {
+		if keep[i] {
+			keep[i] = false
+			size -= int64(len(records[i])) + 1
+		}
+	}
+	return keep
+}
+
+// purge applies the policy to the artifact
+func (a retainedArtifact) purge(now time.Time) PurgedFile {
+	result := PurgedFile{Artifact: a.name, Path: a.path}
+	unlock := a.lock()
+	var err error
+	result.Removed, result.RemovedBytes, result.Kept, err = filterRecords(a.path, a.header, func(records []string, headerBytes int64) []bool {
+		return a.retain(records, headerBytes, now)
+	})
+	unlock()
+	if err != nil {
+		result.Error = err.Error()
+	}
+	return result
+}
+
+var (
+	retentionMu sync.Mutex
+	// lastPurge is the report of the most recent purge, nil before the first
+	lastPurge *PurgeReport
+)
+
+// purgeArtifacts applies every retention policy and logs what was removed
+func purgeArtifacts(config RetentionConfig, now time.Time) PurgeReport {
+	report := PurgeReport{Time: now, Files: []PurgedFile{}}
+	for _, a := range config.artifacts() {
+		if a.policy == (RetentionPolicy{}) {
+			continue
+		}
+		result := a.purge(now)
+		if result.Error != "" {
+			logger.Errorf("Error purging %s: %s", result.Path, result.Error)
+		} else if result.Removed > 0 {
+			logger.Infof("Retention purge removed %d records (%d bytes) from %s, %d kept", result.Removed, result.RemovedBytes, result.Path, result.Kept)
+		}
+		report.Files = append(report.Files, result)
+	}
+	retentionMu.Lock()
+	lastPurge = &report
+	retentionMu.Unlock()
+	return report
+}
+
+// lastPurgeReport returns the report of the most recent purge, or nil
+func lastPurgeReport() *PurgeReport {
+	retentionMu.Lock()
+	defer retentionMu.Unlock()
+	return lastPurge
+}
+
+// watchRetention purges at startup and then every interval
+func watchRetention(config RetentionConfig) {
+	purgeArtifacts(config, time.Now())
+	for now := range time.Tick(time.Duration(config.IntervalMinutes) * time.Minute) {
+		purgeArtifacts(config, now)
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRetentionConfig_Validate(t *testing.T) {
+	assert.NoError(t, RetentionConfig{DeadLetters: RetentionPolicy{MaxAgeDays: 30}}.validate())
+	assert.Error(t, RetentionConfig{Quarantine: RetentionPolicy{MaxSizeMB: -1}}.validate())
+}
+
+func TestPurgeArtifacts_Age(t *testing.T) {
+	useTempQueue(t)
+	now := time.Now()
+	for _, age := range []int{40, 10, 1} {
+		data, _ := json.Marshal(DeadLetter{Time: now.AddDate(0, 0, -age), Reason: fmt.Sprintf("%d days old", age), Payload: Payload{ItemID: "1"}})
+		assert.NoError(t, appendRecord(deadLetterFile, data))
+	}
+	// a record of unknown age is kept by the age limit
+	assert.NoError(t, appendRecord(deadLetterFile, []byte("not json")))
+
+	report := purgeArtifacts(RetentionConfig{DeadLetters: RetentionPolicy{MaxAgeDays: 7}}, now)
+	assert.Len(t, report.Files, 1)
+	assert.Equal(t, "deadLetters", report.Files[0].Artifact)
+	assert.Equal(t, 2, report.Files[0].Removed)
+	assert.Equal(t, 2, report.Files[0].Kept)
+	assert.Equal(t, &report, lastPurgeReport())
+
+	data, err := os.ReadFile(deadLetterFile)
+	assert.NoError(t, err)
+	assert.NotContains(t, string(data), "40 days old")
+	assert.NotContains(t, string(data), "10 days old")
+	assert.Contains(t, string(data), "1 days old")
+	assert.True(t, strings.HasSuffix(string(data), "not json\n"))
+}
+
+func TestPurgeArtifacts_Size(t *testing.T) {
+	oldReceipts := receipts
+	defer func() { receipts = oldReceipts }()
+	path := filepath.Join(t.TempDir(), "receipts.csv")
+	receipts = newReceiptWriter(ReceiptsConfig{Path: path})
+
+	// about 1.6 MB of receipts, oldest first
+	line := strings.Repeat("x", 1000)
+	now := time.Now()
+	for i := 0; i < 1600; i++ {
+		receipts.record(Payload{ItemID: fmt.Sprintf("%04d%s", i, line), DeviceType: "scanner0"}, nil, now)
+	}
+	report := purgeArtifacts(RetentionConfig{Receipts: RetentionPolicy{MaxSizeMB: 1}}, now)
+	assert.Len(t, report.Files, 1)
+	assert.Greater(t, report.Files[0].Removed, 500)
+
+	info, err := os.Stat(path)
+	assert.NoError(t, err)
+	assert.LessOrEqual(t, info.Size(), int64(1024*1024))
+	data, _ := os.ReadFile(path)
+	assert.True(t, strings.HasPrefix(string(data), "timestamp,itemid,deviceType,backendId\n"))
+	assert.Contains(t, string(data), "1599"+line)
+	assert.NotContains(t, string(data), "0000"+line)
+}
+
+func TestCSVRecordTime(t *testing.T) {
+	at, ok := csvRecordTime("2024-03-01T10:00:00Z,123,scanner0,")
+	assert.True(t, ok)
+	assert.Equal(t, time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC), at.UTC())
+	_, ok = csvRecordTime(",,scanner0,,5,missing")
+	assert.False(t, ok)
+}
+
+func TestLockServiceLog_KeepsLinesLoggedMeanwhile(t *testing.T) {
+	useTempStorage(t)
+	oldTracer := tracer
+	defer func() { tracer = oldTracer }()
+	var err error
+	tracer, err = newScanTracer(TraceConfig{Enabled: true})
+	assert.NoError(t, err)
+	path := filepath.Join(t.TempDir(), "service.log")
+	assert.NoError(t, setLogFile(path))
+
+	unlock := lockServiceLog()
+	logger.Info("logged during the purge")
+	tracer.start(Payload{ItemID: "PAL1"})
+	unlock()
+	logger.Info("logged after the purge")
+
+	data, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Contains(t, string(data), "logged during the purge")
+	assert.Contains(t, string(data), "PAL1")
+	assert.Contains(t, string(data), "logged after the purge")
+}
+
+func TestLockServiceLog_WhileLogging(t *testing.T) {
+	useTempStorage(t)
+	path := filepath.Join(t.TempDir(), "service.log")
+	assert.NoError(t, setLogFile(path))
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			logger.Infof("line %d", i)
+			logFileName()
+		}
+	}()
+	for i := 0; i < 10; i++ {
+		lockServiceLog()()
+	}
+	<-done
+
+	data, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Contains(t, string(data), "line 99")
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// RetryBudgetConfig represents a cap on the retries sent to the API and the
+// outputs, shared by all queued scans, so a station with an expired token or
+// broken DNS does not send thousands of requests that are bound to fail
+type RetryBudgetConfig struct {
+	Enabled bool `json:"enabled"`
+	// MaxPerMinute is how many retries may be sent in any one minute (default 60)
+	MaxPerMinute int `json:"maxPerMinute"`
+}
+
+func (r RetryBudgetConfig) withDefaults() RetryBudgetConfig {
+	if r.MaxPerMinute <= 0 {
+		r.MaxPerMinute = 60
+	}
+	return r
+}
+
+// RetryBudgetStatus reports the retries sent in the last minute
+type RetryBudgetStatus struct {
+	MaxPerMinute int `json:"maxPerMinute"`
+	Used         int `json:"used"`
+	// ExhaustedSince is present while retries are being held back
+	ExhaustedSince *time.Time `json:"exhaustedSince,omitempty"`
+	// Skipped counts the retries held back since the service started
+	Skipped int `json:"skipped"`
+}
+
+// retryLimiter counts retries over a sliding one-minute window. A nil limiter
+// allows every retry.
+type retryLimiter struct {
+	max int
+
+	mu             sync.Mutex
+	sent           []time.Time
+	exhaustedSince time.Time
+	lastSkipped    time.Time
+	skipped        int
+}
+
+var retryBudget *retryLimiter
+
+func newRetryLimiter(config RetryBudgetConfig) *retryLimiter {
+	return &retryLimiter{max: config.withDefaults().MaxPerMinute}
+}
+
+// expire drops the retries older than a minute, and ends the exhaustion once
+// nothing was held back for a minute; the caller holds the lock
+func (l *retryLimiter) expire(now time.Time) {
+	for len(l.sent) > 0 && now.Sub(l.sent[0]) >= time.Minute {
+		l.sent = l.sent[1:]
+	}
+	if !l.exhaustedSince.IsZero() && now.Sub(l.lastSkipped) >= time.Minute {
+		logger.Infof("Retry budget available again after %s; %d retries held back so far", now.Sub(l.exhaustedSince).Round(time.Second), l.skipped)
+		l.exhaustedSince = time.Time{}
+	}
+}
+
+// allow reports whether a retry may be sent now, counting it if so
+func (l *retryLimiter) allow(now time.Time) bool {
+	if l == nil {
+		return true
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.expire(now)
+	if len(l.sent) >= l.max {
+		if l.exhaustedSince.IsZero() {
+			l.exhaustedSince = now
+			logger.Warnf("Retry budget of %d per minute exhausted; holding back retries", l.max)
+		}
+		l.lastSkipped = now
+		l.skipped++
+		return false
+	}
+	l.sent = append(l.sent, now)
+	return true
+}
+
+// status returns nil when no budget is configured
+func (l *retryLimiter) status(now time.Time) *RetryBudgetStatus {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.expire(now)
+	status := &RetryBudgetStatus{MaxPerMinute: l.max, Used: len(l.sent), Skipped: l.skipped}
+	if !l.exhaustedSince.IsZero() {
+		since := l.exhaustedSince
+		status.ExhaustedSince = &since
+	}
+	return status
+}
+
+// retryBudgetAlert is raised while retries are being held back
+func retryBudgetAlert(status *RetryBudgetStatus) Alert {
+	return Alert{
+		Key:     "retry-budget",
+		Subject: "retry budget exhausted",
+		Body:    fmt.Sprintf("More than %d retries per minute have been needed since %s, so the rest stay queued; %d retries were held back so far. If it persists, retries are most likely all failing, for example on expired credentials or a DNS problem.", status.MaxPerMinute, status.ExhaustedSince.Format(time.RFC3339), status.Skipped),
+	}
+}
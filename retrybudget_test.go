@@ -0,0 +1,58 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRetryLimiter_Window(t *testing.T) {
+	now := time.Now()
+	limiter := newRetryLimiter(RetryBudgetConfig{MaxPerMinute: 2})
+	assert.True(t, limiter.allow(now))
+	assert.True(t, limiter.allow(now.Add(10*time.Second)))
+	assert.False(t, limiter.allow(now.Add(20*time.Second)))
+
+	status := limiter.status(now.Add(30 * time.Second))
+	assert.Equal(t, 2, status.Used)
+	assert.Equal(t, 1, status.Skipped)
+	assert.Equal(t, now.Add(20*time.Second), *status.ExhaustedSince)
+	assert.Equal(t, "retry-budget", retryBudgetAlert(status).Key)
+
+	// the first retry leaves the window, but the exhaustion lasts until nothing was held back for a minute
+	assert.True(t, limiter.allow(now.Add(time.Minute)))
+	assert.NotNil(t, limiter.status(now.Add(time.Minute)).ExhaustedSince)
+	assert.Nil(t, limiter.status(now.Add(80*time.Second)).ExhaustedSince)
+
+	var none *retryLimiter
+	assert.True(t, none.allow(now))
+	assert.Nil(t, none.status(now))
+}
+
+func TestReplayFailures_RetryBudget(t *testing.T) {
+	useTempQueue(t)
+	oldPost, oldBudget := httpPost, retryBudget
+	defer func() { httpPost, retryBudget = oldPost, oldBudget }()
+	posts := 0
+	httpPost = func(url, contentType string, body io.Reader) (*http.Response, error) {
+		posts++
+		return &http.Response{StatusCode: http.StatusUnauthorized}, nil
+	}
+	retryBudget = newRetryLimiter(RetryBudgetConfig{MaxPerMinute: 2})
+
+	for _, id := range []string{"1", "2", "3", "4"} {
+		logFailure(Payload{ItemID: id})
+	}
+	result, err := replayFailures(&Config{APIEndpoint: "http://example.com", PoisonAttempts: -1}, time.Now().Add(time.Minute))
+	assert.NoError(t, err)
+	assert.Equal(t, FlushResult{Remaining: 4, Throttled: 2}, result)
+	assert.Equal(t, 2, posts)
+	assert.Equal(t, 4, queueDepth())
+
+	status := currentStatus(&Config{})
+	assert.NotNil(t, status.RetryBudget.ExhaustedSince)
+	assert.Equal(t, healthYellow, status.Health)
+}
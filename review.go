@@ -0,0 +1,330 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ReviewConfig represents holding scans that match a rule, such as movements
+// of high-value items, until a supervisor approves them on a dashboard through
+// the admin API or by scanning an approval barcode. Held scans are kept in the
+// state directory, so they survive a restart.
+type ReviewConfig struct {
+	Enabled bool         `json:"enabled"`
+	Rules   []ReviewRule `json:"rules"`
+	// Supervisors are the people who can decide on held scans with a barcode
+	Supervisors []ReviewSupervisor `json:"supervisors"`
+}
+
+// ReviewRule selects the scans that need approval
+type ReviewRule struct {
+	Name string `json:"name"`
+	// Pattern is a regular expression matched against the item ID
+	Pattern string `json:"pattern"`
+	// Scanners limit the rule to these device types, such as ["scanner0"] (default all)
+	Scanners []string `json:"scanners"`
+}
+
+// ReviewSupervisor has the barcodes that approve or reject the held scans of
+// the scanner they are read on
+type ReviewSupervisor struct {
+	Name        string `json:"name"`
+	ApproveCode string `json:"approveCode"`
+	RejectCode  string `json:"rejectCode"`
+}
+
+// ReviewItem is a scan waiting for approval
+type ReviewItem struct {
+	ID      int64     `json:"id"`
+	Rule    string    `json:"rule"`
+	HeldAt  time.Time `json:"heldAt"`
+	Payload Payload   `json:"payload"`
+}
+
+type reviewRule struct {
+	config  ReviewRule
+	pattern *regexp.Regexp
+}
+
+// reviewQueue holds scans pending approval. A nil queue holds nothing.
+type reviewQueue struct {
+	rules []reviewRule
+	// codes maps approval and rejection barcodes to the supervisor and the decision
+	codes map[string]reviewCode
+	path  string
+	// deliver posts an approved scan
+	deliver func(Payload)
+
+	mu      sync.Mutex
+	nextID  int64
+	pending []ReviewItem
+	// settling has the IDs of the held scans being posted or dead-lettered;
+	// they stay held until that is done, so a stop in between keeps them
+	settling map[int64]bool
+}
+
+type reviewCode struct {
+	supervisor string
+	approve    bool
+}
+
+var review *reviewQueue
+
+// newReviewQueue compiles the rules and loads the scans still held from the last run
+func newReviewQueue(config ReviewConfig, path string, deliver func(Payload)) (*reviewQueue, error) {
+	if len(config.Rules) == 0 {
+		return nil, fmt.Errorf("no review rules configured")
+	}
+	q := &reviewQueue{codes: map[string]reviewCode{}, path: path, deliver: deliver, nextID: 1, settling: map[int64]bool{}}
+	for _, rule := range config.Rules {
+		pattern, err := regexp.Compile(rule.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("rule %s: invalid pattern: %v", rule.Name, err)
+		}
+		q.rules = append(q.rules, reviewRule{config: rule, pattern: pattern})
+	}
+	for _, s := range config.Supervisors {
+		if s.Name == "" {
+			return nil, fmt.Errorf("a supervisor has no name")
+		}
+		if s.ApproveCode == "" && s.RejectCode == "" {
+			return nil, fmt.Errorf("supervisor %s has no barcodes", s.Name)
+		}
+		if s.ApproveCode == s.RejectCode {
+			return nil, fmt.Errorf("supervisor %s: the approve and reject barcodes must differ", s.Name)
+		}
+		for code, approve := range map[string]bool{s.ApproveCode: true, s.RejectCode: false} {
+			if code == "" {
+				continue
+			}
+			if _, ok := q.codes[code]; ok {
+				return nil, fmt.Errorf("supervisor %s: barcode %q is already in use", s.Name, code)
+			}
+			q.codes[code] = reviewCode{supervisor: s.Name, approve: approve}
+		}
+	}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return q, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &q.pending); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	for _, item := range q.pending {
+		if item.ID >= q.nextID {
+			q.nextID = item.ID + 1
+		}
+	}
+	if len(q.pending) > 0 {
+		logger.Infof("%d scans are still waiting for review", len(q.pending))
+	}
+	return q, nil
+}
+
+// match returns the name of the first rule the scan matches, or ""
+func (q *reviewQueue) match(payload Payload) string {
+	if q == nil {
+		return ""
+	}
+	for _, rule := range q.rules {
+		if len(rule.config.Scanners) > 0 && !containsString(rule.config.Scanners, payload.DeviceType) {
+			continue
+		}
+		if rule.pattern.MatchString(payload.ItemID) {
+			return rule.config.Name
+		}
+	}
+	return ""
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// hold keeps a scan until it is approved or rejected
+func (q *reviewQueue) hold(payload Payload, rule string, now time.Time) ReviewItem {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	item := ReviewItem{ID: q.nextID, Rule: rule, HeldAt: now, Payload: payload}
+	q.nextID++
+	q.pending = append(q.pending, item)
+	q.save()
+	logger.Infof("Holding scan %q from %s for review under rule %s", payload.ItemID, payload.DeviceType, rule)
+	return item
+}
+
+// take removes and returns the held scans that are selected; the caller holds the lock
+func (q *reviewQueue) take(selected func(ReviewItem) bool) []ReviewItem {
+	var taken, remaining []ReviewItem
+	for _, item := range q.pending {
+		if selected(item) {
+			taken = append(taken, item)
+		} else {
+			remaining = append(remaining, item)
+		}
+	}
+	if len(taken) > 0 {
+		q.pending = remaining
+		q.save()
+	}
+	return taken
+}
+
+// claim returns the held scans that are selected and not already being
+// settled, marking them as settling; the caller holds the lock
+func (q *reviewQueue) claim(selected func(ReviewItem) bool) []ReviewItem {
+	var claimed []ReviewItem
+	for _, item := range q.pending {
+		if !q.settling[item.ID] && selected(item) {
+			q.settling[item.ID] = true
+			claimed = append(claimed, item)
+		}
+	}
+	return claimed
+}
+
+// decide approves or rejects one held scan
+func (q *reviewQueue) decide(id int64, approve bool, supervisor string) error {
+	if supervisor == "" {
+		return fmt.Errorf("the supervisor deciding is required")
+	}
+	q.mu.Lock()
+	items := q.claim(func(item ReviewItem) bool { return item.ID == id })
+	q.mu.Unlock()
+	if len(items) == 0 {
+		return fmt.Errorf("no scan %d is waiting for review", id)
+	}
+	q.settle(items, approve, supervisor)
+	return nil
+}
+
+// consumes handles a read of a supervisor barcode, deciding on every scan held
+// from the scanner it was read on. It reports false for any other read.
+func (q *reviewQueue) consumes(payload Payload) bool {
+	if q == nil {
+		return false
+	}
+	code, ok := q.codes[strings.TrimSpace(payload.ItemID)]
+	if !ok {
+		return false
+	}
+	q.mu.Lock()
+	items := q.claim(func(item ReviewItem) bool { return item.Payload.DeviceType == payload.DeviceType })
+	q.mu.Unlock()
+	if len(items) == 0 {
+		logger.Warnf("Supervisor barcode of %s read on %s, but no scan from it is waiting for review", code.supervisor, payload.DeviceType)
+		return true
+	}
+	q.settle(items, code.approve, code.supervisor)
+	return true
+}
+
+// settle posts approved scans and dead-letters rejected ones, and only then
+// stops holding them
+func (q *reviewQueue) settle(items []ReviewItem, approve bool, supervisor string) {
+	settled := map[int64]bool{}
+	for _, item := range items {
+		settled[item.ID] = true
+		payload := item.Payload
+		if !approve {
+			reason := fmt.Sprintf("rejected in review by %s", supervisor)
+			logger.Infof("Scan %q from %s %s", payload.ItemID, payload.DeviceType, reason)
+			deadLetter(payload, reason)
+			reportOutcome(payload, outcomeRejected, reason)
+			continue
+		}
+		logger.Infof("Scan %q from %s approved by %s", payload.ItemID, payload.DeviceType, supervisor)
+		payload.ApprovedBy = supervisor
+		// a failed post queues the scan to failures.log before it is released
+		q.deliver(payload)
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for id := range settled {
+		delete(q.settling, id)
+	}
+	q.take(func(item ReviewItem) bool { return settled[item.ID] })
+}
+
+// unverifiedSupervisor labels a supervisor name given to the admin API, which
+// does not authenticate it, so the audit trail does not present it as verified
+func unverifiedSupervisor(name string) string {
+	if name == "" {
+		return ""
+	}
+	return name + " (unverified)"
+}
+
+// purge drops the matching held scans without posting or dead-lettering them,
+// returning how many match. A dry run only counts them.
+func (q *reviewQueue) purge(match func(itemID string) bool, dryRun bool) int {
+	if q == nil {
+		return 0
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if dryRun {
+		removed := 0
+		for _, item := range q.pending {
+			if match(item.Payload.ItemID) {
+				removed++
+			}
+		}
+		return removed
+	}
+	return len(q.take(func(item ReviewItem) bool { return match(item.Payload.ItemID) }))
+}
+
+// list returns the scans waiting for review, oldest first
+func (q *reviewQueue) list() []ReviewItem {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return append([]ReviewItem{}, q.pending...)
+}
+
+// count returns how many scans are waiting for review
+func (q *reviewQueue) count() int {
+	if q == nil {
+		return 0
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.pending)
+}
+
+// save writes the held scans; the caller holds the lock
+func (q *reviewQueue) save() {
+	data, err := json.MarshalIndent(q.pending, "", "  ")
+	if err == nil {
+		err = writeFileAtomic(q.path, data)
+	}
+	if err != nil {
+		logger.Errorf("Error saving scans held for review to %s: %v", q.path, err)
+	}
+}
+
+// startReview loads the review queue from the state directory
+func startReview(config *Config) error {
+	queue, err := newReviewQueue(config.Review, filepath.Join(stateDir, "review.json"), func(payload Payload) {
+		deliverScan(config, payload, nil)
+	})
+	if err != nil {
+		return err
+	}
+	review = queue
+	return nil
+}
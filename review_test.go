@@ -0,0 +1,138 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var testReviewConfig = ReviewConfig{
+	Rules:       []ReviewRule{{Name: "high-value", Pattern: `^HV-`}, {Name: "cage", Pattern: `^CAGE`, Scanners: []string{"scanner1"}}},
+	Supervisors: []ReviewSupervisor{{Name: "Dana", ApproveCode: "SUP-DANA-OK", RejectCode: "SUP-DANA-NO"}},
+}
+
+func TestNewReviewQueue_Validate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "review.json")
+	_, err := newReviewQueue(ReviewConfig{}, path, nil)
+	assert.Error(t, err)
+	_, err = newReviewQueue(ReviewConfig{Rules: []ReviewRule{{Name: "x", Pattern: "("}}}, path, nil)
+	assert.Error(t, err)
+	_, err = newReviewQueue(ReviewConfig{Rules: testReviewConfig.Rules, Supervisors: []ReviewSupervisor{{Name: "A", ApproveCode: "OK"}, {Name: "B", RejectCode: "OK"}}}, path, nil)
+	assert.Error(t, err)
+}
+
+func TestReviewQueue_Match(t *testing.T) {
+	q, err := newReviewQueue(testReviewConfig, filepath.Join(t.TempDir(), "review.json"), nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "high-value", q.match(Payload{ItemID: "HV-1", DeviceType: "scanner0"}))
+	assert.Equal(t, "", q.match(Payload{ItemID: "CAGE7", DeviceType: "scanner0"}))
+	assert.Equal(t, "cage", q.match(Payload{ItemID: "CAGE7", DeviceType: "scanner1"}))
+	assert.Equal(t, "", q.match(Payload{ItemID: "4006381333931", DeviceType: "scanner0"}))
+
+	var none *reviewQueue
+	assert.Equal(t, "", none.match(Payload{ItemID: "HV-1"}))
+	assert.False(t, none.consumes(Payload{ItemID: "SUP-DANA-OK"}))
+	assert.Equal(t, 0, none.count())
+}
+
+func TestReviewQueue_Decisions(t *testing.T) {
+	useTempQueue(t)
+	path := filepath.Join(t.TempDir(), "review.json")
+	delivered := make(chan Payload, 4)
+	q, err := newReviewQueue(testReviewConfig, path, func(payload Payload) { delivered <- payload })
+	assert.NoError(t, err)
+
+	now := time.Now()
+	q.hold(Payload{ItemID: "HV-1", DeviceType: "scanner0"}, "high-value", now)
+	q.hold(Payload{ItemID: "HV-2", DeviceType: "scanner0"}, "high-value", now)
+	q.hold(Payload{ItemID: "HV-3", DeviceType: "scanner1"}, "high-value", now)
+
+	// held scans survive a restart
+	q, err = newReviewQueue(testReviewConfig, path, func(payload Payload) { delivered <- payload })
+	assert.NoError(t, err)
+	assert.Equal(t, 3, q.count())
+
+	// the approval barcode releases only the scans of the scanner it is read on
+	assert.True(t, q.consumes(Payload{ItemID: "SUP-DANA-OK\r", DeviceType: "scanner0"}))
+	got := map[string]string{}
+	for i := 0; i < 2; i++ {
+		payload := <-delivered
+		got[payload.ItemID] = payload.ApprovedBy
+	}
+	assert.Equal(t, map[string]string{"HV-1": "Dana", "HV-2": "Dana"}, got)
+	assert.Equal(t, 1, q.count())
+	assert.False(t, q.consumes(Payload{ItemID: "HV-4", DeviceType: "scanner0"}))
+
+	// rejecting from the dashboard dead-letters the scan
+	id := q.list()[0].ID
+	assert.Error(t, q.decide(id, false, ""))
+	assert.NoError(t, q.decide(id, false, "Lee"))
+	assert.Error(t, q.decide(id, true, "Lee"))
+	assert.Equal(t, 0, q.count())
+	data, err := os.ReadFile(deadLetterFile)
+	assert.NoError(t, err)
+	assert.Contains(t, string(data), "rejected in review by Lee")
+	assert.Contains(t, string(data), "HV-3")
+}
+
+func TestAdminReview(t *testing.T) {
+	useTempQueue(t)
+	old := review
+	defer func() { review = old }()
+	delivered := make(chan Payload, 1)
+	var err error
+	review, err = newReviewQueue(testReviewConfig, filepath.Join(t.TempDir(), "review.json"), func(payload Payload) { delivered <- payload })
+	assert.NoError(t, err)
+	item := review.hold(Payload{ItemID: "HV-1", DeviceType: "scanner0"}, "high-value", time.Now())
+
+	config := &Config{}
+	server := httptest.NewServer(adminMux(config))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/review")
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	resp, err = http.Post(server.URL+"/review/approve?id=x&by=Lee", "", strings.NewReader(""))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	config.Admin.ReadOnly = true
+	resp, err = http.Post(server.URL+"/review/approve?id=1&by=Lee", "", strings.NewReader(""))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+	config.Admin.ReadOnly = false
+	resp, err = http.Post(server.URL+"/review/approve?by=Lee&id="+strconv.FormatInt(item.ID, 10), "", strings.NewReader(""))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+	assert.Equal(t, "Lee (unverified)", (<-delivered).ApprovedBy)
+}
+
+func TestReviewQueue_HoldsUntilDelivered(t *testing.T) {
+	useTempQueue(t)
+	path := filepath.Join(t.TempDir(), "review.json")
+	var q *reviewQueue
+	var heldDuringDelivery int
+	q, err := newReviewQueue(testReviewConfig, path, func(payload Payload) {
+		// a stop now still finds the scan in review.json
+		reloaded, err := newReviewQueue(testReviewConfig, path, nil)
+		assert.NoError(t, err)
+		heldDuringDelivery = reloaded.count()
+		// a second decision while the first is posting does not post it again
+		assert.Error(t, q.decide(1, true, "Lee"))
+	})
+	assert.NoError(t, err)
+	q.hold(Payload{ItemID: "HV-1", DeviceType: "scanner0"}, "high-value", time.Now())
+
+	assert.NoError(t, q.decide(1, true, "Lee"))
+	assert.Equal(t, 1, heldDuringDelivery)
+	assert.Equal(t, 0, q.count())
+}
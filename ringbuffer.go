@@ -0,0 +1,387 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/zachthieme/scanandpost/scan"
+)
+
+// RingBufferConfig represents a fixed-size buffer between the inputs and the
+// dispatcher. Bursts from tunnel scanners are absorbed by the buffer and
+// dispatched by a fixed set of workers instead of a goroutine per scan.
+type RingBufferConfig struct {
+	Enabled bool `json:"enabled"`
+	// Size is how many scans the buffer holds (default 1024)
+	Size int `json:"size"`
+	// Workers is how many scans are dispatched at the same time (default 8)
+	Workers int `json:"workers"`
+	// Mapped memory-maps the buffer to scanbuffer.ring in the state directory, so scans still buffered or being dispatched when the service stops are dispatched on the next start
+	Mapped bool `json:"mapped"`
+}
+
+func (r RingBufferConfig) withDefaults() RingBufferConfig {
+	if r.Size <= 0 {
+		r.Size = 1024
+	}
+	if r.Workers <= 0 {
+		r.Workers = 8
+	}
+	return r
+}
+
+// RingBufferStatus reports the scan buffer in the status
+type RingBufferStatus struct {
+	Size     int `json:"size"`
+	Buffered int `json:"buffered"`
+	// HighWater is the most scans buffered at once since the service started
+	HighWater int `json:"highWater"`
+	// Overflows counts the scans that found the buffer full and held up their input
+	Overflows int `json:"overflows"`
+}
+
+// Layout of the mapped file: a header, then fixed-size slots each holding the
+// length of a CBOR-encoded payload followed by the payload
+const (
+	ringMagic      = "SRB1"
+	ringHeaderSize = 64
+	ringSlotSize   = 512
+)
+
+// scanRing is a bounded FIFO of scans. A nil ring is not in use.
+type scanRing struct {
+	mu       sync.Mutex
+	notEmpty *sync.Cond
+	notFull  *sync.Cond
+	slots    []Payload
+	// head is the number of scans ever put, next the number handed to the
+	// workers and tail the number dispatched, so tail <= next <= head
+	head, next, tail uint64
+	// dispatched marks the slots whose scans were dispatched ahead of tail,
+	// and the slots not yet handed out whose scans were purged
+	dispatched []bool
+
+	mapped []byte
+	unmap  func() error
+
+	highWater int
+	overflows int
+	// closed stops the workers, leaving the scans still buffered in place
+	closed bool
+}
+
+var scanBuffer *scanRing
+
+// newScanRing creates the buffer. A mapped buffer first recovers the scans
+// left in path, growing to hold them all if it has been made smaller.
+func newScanRing(config RingBufferConfig, path string) (*scanRing, error) {
+	config = config.withDefaults()
+	var recovered []Payload
+	if config.Mapped {
+		data, err := os.ReadFile(path)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		recovered = decodeRing(data)
+	}
+	size := config.Size
+	if len(recovered) > size {
+		size = len(recovered)
+	}
+	r := &scanRing{slots: make([]Payload, size), dispatched: make([]bool, size)}
+	r.notEmpty = sync.NewCond(&r.mu)
+	r.notFull = sync.NewCond(&r.mu)
+	if config.Mapped {
+		if err := r.mapFile(path); err != nil {
+			return nil, err
+		}
+	}
+	for _, payload := range recovered {
+		r.store(payload)
+	}
+	if len(recovered) > 0 {
+		logger.Infof("Recovered %d buffered scans from %s", len(recovered), path)
+	}
+	return r, nil
+}
+
+// mapFile maps a fresh, empty buffer file
+func (r *scanRing) mapFile(path string) error {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	size := ringHeaderSize + len(r.slots)*ringSlotSize
+	if err := file.Truncate(0); err != nil {
+		return err
+	}
+	if err := file.Truncate(int64(size)); err != nil {
+		return err
+	}
+	data, unmap, err := mapFile(file, size)
+	if err != nil {
+		return fmt.Errorf("mapping %s: %v", path, err)
+	}
+	r.mapped, r.unmap = data, unmap
+	copy(r.mapped, ringMagic)
+	binary.LittleEndian.PutUint32(r.mapped[4:], uint32(len(r.slots)))
+	r.writeHeader()
+	return nil
+}
+
+// decodeRing returns the scans that were buffered but not taken in a mapped file
+func decodeRing(data []byte) []Payload {
+	if len(data) < ringHeaderSize || string(data[:4]) != ringMagic {
+		return nil
+	}
+	count := uint64(binary.LittleEndian.Uint32(data[4:]))
+	head := binary.LittleEndian.Uint64(data[8:])
+	tail := binary.LittleEndian.Uint64(data[16:])
+	if count == 0 || head < tail || head-tail > count {
+		return nil
+	}
+	var result []Payload
+	for i := tail; i < head; i++ {
+		offset := ringHeaderSize + int(i%count)*ringSlotSize
+		if offset+ringSlotSize > len(data) {
+			break
+		}
+		slot := data[offset : offset+ringSlotSize]
+		length := int(binary.LittleEndian.Uint32(slot))
+		if length == 0 || length > ringSlotSize-4 {
+			continue
+		}
+		var payload Payload
+		if err := scan.Unmarshal("application/cbor", slot[4:4+length], &payload); err != nil {
+			logger.Errorf("Error decoding buffered scan: %v", err)
+			continue
+		}
+		result = append(result, payload)
+	}
+	return result
+}
+
+func (r *scanRing) writeHeader() {
+	binary.LittleEndian.PutUint64(r.mapped[8:], r.head)
+	binary.LittleEndian.PutUint64(r.mapped[16:], r.tail)
+}
+
+// store adds a scan the caller has made room for
+func (r *scanRing) store(payload Payload) {
+	index := int(r.head % uint64(len(r.slots)))
+	r.slots[index] = payload
+	if r.mapped != nil {
+		slot := r.mapped[ringHeaderSize+index*ringSlotSize : ringHeaderSize+(index+1)*ringSlotSize]
+		body, _, err := scan.Marshal(scan.EncodingCBOR, payload)
+		switch {
+		case err != nil:
+			logger.Errorf("Error encoding buffered scan: %v", err)
+			binary.LittleEndian.PutUint32(slot, 0)
+		case len(body) > ringSlotSize-4:
+			// kept in memory only, so it is lost if the service stops first
+			logger.Warnf("Scan %q is too large to map and is buffered in memory only", payload.ItemID)
+			binary.LittleEndian.PutUint32(slot, 0)
+		default:
+			binary.LittleEndian.PutUint32(slot, uint32(len(body)))
+			copy(slot[4:], body)
+		}
+	}
+	r.head++
+	if buffered := int(r.head - r.tail); buffered > r.highWater {
+		r.highWater = buffered
+	}
+	if r.mapped != nil {
+		r.writeHeader()
+	}
+	r.notEmpty.Signal()
+}
+
+// put adds a scan, holding up the input while the buffer is full. Once the
+// buffer is closed, the scan is queued to failures.log instead.
+func (r *scanRing) put(payload Payload) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.head-r.tail == uint64(len(r.slots)) && !r.closed {
+		r.overflows++
+		logger.Warnf("Scan buffer is full with %d scans; holding up %s", len(r.slots), payload.DeviceType)
+		for r.head-r.tail == uint64(len(r.slots)) && !r.closed {
+			r.notFull.Wait()
+		}
+	}
+	if r.closed {
+		logger.Warnf("Scan buffer is closed; queueing %q from %s to %s", payload.ItemID, payload.DeviceType, failuresFile)
+		logFailure(payload)
+		return
+	}
+	r.store(payload)
+}
+
+// take hands out the oldest scan not yet handed out, waiting for one if there
+// is none, with its position for done. It returns false once the buffer is closed.
+func (r *scanRing) take() (Payload, uint64, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for {
+		for r.next == r.head && !r.closed {
+			r.notEmpty.Wait()
+		}
+		if r.closed {
+			return Payload{}, 0, false
+		}
+		seq := r.next
+		r.next++
+		if !r.dispatched[seq%uint64(len(r.slots))] {
+			return r.slots[seq%uint64(len(r.slots))], seq, true
+		}
+		// purged while it waited
+		r.advance()
+	}
+}
+
+// done records that the scan take returned at seq was dispatched. The tail,
+// in memory and in the mapped file, only moves past scans that were
+// dispatched, so a scan is not dropped from the buffer while it is in flight.
+func (r *scanRing) done(seq uint64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.dispatched[seq%uint64(len(r.slots))] = true
+	r.advance()
+}
+
+// advance moves the tail past the dispatched scans; the caller holds the lock
+func (r *scanRing) advance() {
+	advanced := false
+	for r.tail < r.next && r.dispatched[r.tail%uint64(len(r.slots))] {
+		index := r.tail % uint64(len(r.slots))
+		r.dispatched[index] = false
+		r.slots[index] = Payload{}
+		r.tail++
+		advanced = true
+	}
+	if !advanced {
+		return
+	}
+	if r.mapped != nil {
+		r.writeHeader()
+	}
+	r.notFull.Broadcast()
+}
+
+// work dispatches buffered scans until the buffer is closed
+func (r *scanRing) work(config *Config) {
+	for {
+		next, seq, ok := r.take()
+		if !ok {
+			return
+		}
+		for _, payload := range intake(config, next) {
+			dispatchPayload(config, payload)
+		}
+		r.done(seq)
+	}
+}
+
+// drain waits until the workers dispatched every buffered scan, including the
+// ones in flight, or the deadline passed, and returns how many are left
+func (r *scanRing) drain(deadline time.Duration) int {
+	if r == nil {
+		return 0
+	}
+	end := time.Now().Add(deadline)
+	for {
+		r.mu.Lock()
+		buffered := int(r.head - r.tail)
+		r.mu.Unlock()
+		if buffered == 0 || !time.Now().Before(end) {
+			return buffered
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// purge clears the buffered scans whose item IDs match, in memory and in the
+// mapped file, returning how many match. Scans waiting for a worker are not
+// dispatched; scans already being dispatched are posted but no longer kept.
+// A dry run only counts them.
+func (r *scanRing) purge(match func(itemID string) bool, dryRun bool) int {
+	if r == nil {
+		return 0
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	removed := 0
+	for seq := r.tail; seq < r.head; seq++ {
+		index := int(seq % uint64(len(r.slots)))
+		// a cleared slot has no item ID left
+		itemID := r.slots[index].ItemID
+		if itemID == "" || !match(itemID) {
+			continue
+		}
+		removed++
+		if dryRun {
+			continue
+		}
+		r.slots[index] = Payload{}
+		if seq >= r.next {
+			r.dispatched[index] = true
+		}
+		if r.mapped != nil {
+			slot := r.mapped[ringHeaderSize+index*ringSlotSize : ringHeaderSize+(index+1)*ringSlotSize]
+			for i := range slot {
+				slot[i] = 0
+			}
+		}
+	}
+	return removed
+}
+
+// status returns the buffer's fill level, or nil when it is not in use
+func (r *scanRing) status() *RingBufferStatus {
+	if r == nil {
+		return nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return &RingBufferStatus{Size: len(r.slots), Buffered: int(r.head - r.tail), HighWater: r.highWater, Overflows: r.overflows}
+}
+
+// close stops the workers and unmaps the buffer file, where the scans still
+// buffered wait for the next start
+func (r *scanRing) close() error {
+	if r == nil {
+		return nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.closed = true
+	r.notEmpty.Broadcast()
+	r.notFull.Broadcast()
+	if r.unmap == nil {
+		return nil
+	}
+	err := r.unmap()
+	r.mapped, r.unmap = nil, nil
+	return err
+}
+
+// scanBufferFile is where a mapped buffer keeps its scans
+func scanBufferFile() string {
+	return filepath.Join(stateDir, "scanbuffer.ring")
+}
+
+// startScanBuffer creates the buffer and its workers
+func startScanBuffer(config *Config) {
+	ring, err := newScanRing(config.RingBuffer, scanBufferFile())
+	if err != nil {
+		logger.Fatalf("Error creating scan buffer: %v", err)
+	}
+	scanBuffer = ring
+	for i := 0; i < config.RingBuffer.withDefaults().Workers; i++ {
+		go scanBuffer.work(config)
+	}
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// mustTake takes a scan from a ring that is still open and marks it dispatched
+func mustTake(t *testing.T, r *scanRing) Payload {
+	payload, seq, ok := r.take()
+	assert.True(t, ok)
+	r.done(seq)
+	return payload
+}
+
+func TestScanRing_FIFO(t *testing.T) {
+	r, err := newScanRing(RingBufferConfig{Size: 2}, "")
+	assert.NoError(t, err)
+	r.put(Payload{ItemID: "a"})
+	r.put(Payload{ItemID: "b"})
+	assert.Equal(t, "a", mustTake(t, r).ItemID)
+	r.put(Payload{ItemID: "c"})
+	assert.Equal(t, "b", mustTake(t, r).ItemID)
+	assert.Equal(t, "c", mustTake(t, r).ItemID)
+	assert.Equal(t, &RingBufferStatus{Size: 2, Buffered: 0, HighWater: 2}, r.status())
+}
+
+func TestScanRing_FullHoldsUpInput(t *testing.T) {
+	r, _ := newScanRing(RingBufferConfig{Size: 1}, "")
+	r.put(Payload{ItemID: "a"})
+	done := make(chan struct{})
+	go func() {
+		r.put(Payload{ItemID: "b"})
+		close(done)
+	}()
+	select {
+	case <-done:
+		t.Fatal("put did not wait for room")
+	case <-time.After(50 * time.Millisecond):
+	}
+	assert.Equal(t, "a", mustTake(t, r).ItemID)
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("put still waiting after take")
+	}
+	assert.Equal(t, "b", mustTake(t, r).ItemID)
+	assert.Equal(t, 1, r.status().Overflows)
+}
+
+func TestScanRing_MappedRecovery(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "scanbuffer.ring")
+	r, err := newScanRing(RingBufferConfig{Size: 4, Mapped: true}, path)
+	assert.NoError(t, err)
+	r.put(Payload{ItemID: "a", DeviceType: "scanner0"})
+	r.put(Payload{ItemID: "b", DeviceType: "scanner0", Sequence: 7})
+	r.put(Payload{ItemID: "c", DeviceType: "scanner1"})
+	assert.Equal(t, "a", mustTake(t, r).ItemID)
+	assert.NoError(t, r.close())
+
+	// a smaller buffer grows to hold what was left
+	r, err = newScanRing(RingBufferConfig{Size: 1, Mapped: true}, path)
+	assert.NoError(t, err)
+	defer r.close()
+	assert.Equal(t, 2, r.status().Size)
+	assert.Equal(t, Payload{ItemID: "b", DeviceType: "scanner0", Sequence: 7}, mustTake(t, r))
+	assert.Equal(t, Payload{ItemID: "c", DeviceType: "scanner1"}, mustTake(t, r))
+}
+
+func TestScanRing_DrainAndClose(t *testing.T) {
+	r, _ := newScanRing(RingBufferConfig{Size: 4}, "")
+	r.put(Payload{ItemID: "a"})
+	r.put(Payload{ItemID: "b"})
+	assert.Equal(t, 2, r.drain(20*time.Millisecond), "no worker took the scans")
+
+	taken := make(chan string, 4)
+	stopped := make(chan bool)
+	go func() {
+		for {
+			payload, seq, ok := r.take()
+			if !ok {
+				close(stopped)
+				return
+			}
+			taken <- payload.ItemID
+			r.done(seq)
+		}
+	}()
+	assert.Equal(t, 0, r.drain(time.Second))
+	assert.Equal(t, "a", <-taken)
+	assert.Equal(t, "b", <-taken)
+
+	// closing wakes the idle worker, which stops
+	assert.NoError(t, r.close())
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("worker still waiting after close")
+	}
+
+	var none *scanRing
+	assert.Zero(t, none.drain(time.Second))
+	assert.NoError(t, none.close())
+}
+
+func TestScanRing_KeepsScansInFlight(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "scanbuffer.ring")
+	r, err := newScanRing(RingBufferConfig{Size: 4, Mapped: true}, path)
+	assert.NoError(t, err)
+	r.put(Payload{ItemID: "a"})
+	r.put(Payload{ItemID: "b"})
+	a, seqA, _ := r.take()
+	_, seqB, _ := r.take()
+	assert.Equal(t, "a", a.ItemID)
+
+	// b finishes first, but a is still being dispatched
+	r.done(seqB)
+	assert.Equal(t, 2, r.drain(20*time.Millisecond))
+	assert.Len(t, decodeRing(r.mapped), 2)
+	r.done(seqA)
+	assert.Equal(t, 0, r.drain(time.Second))
+	assert.Empty(t, decodeRing(r.mapped))
+	assert.NoError(t, r.close())
+}
+
+func TestScanRing_PutAfterClose(t *testing.T) {
+	useTempQueue(t)
+	r, _ := newScanRing(RingBufferConfig{Size: 1}, "")
+	r.put(Payload{ItemID: "a"})
+	done := make(chan struct{})
+	go func() {
+		r.put(Payload{ItemID: "b"})
+		close(done)
+	}()
+	time.Sleep(20 * time.Millisecond)
+
+	// closing releases the input held up by the full buffer
+	assert.NoError(t, r.close())
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("put still waiting after close")
+	}
+	r.put(Payload{ItemID: "c"})
+	data, err := os.ReadFile(failuresFile)
+	assert.NoError(t, err)
+	assert.Contains(t, string(data), `"b"`)
+	assert.Contains(t, string(data), `"c"`)
+	assert.Equal(t, 1, r.status().Buffered)
+}
+
+func TestDecodeRing_Invalid(t *testing.T) {
+	assert.Nil(t, decodeRing(nil))
+	assert.Nil(t, decodeRing(make([]byte, ringHeaderSize)))
+}
+
+func TestScanRing_Purge(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "scanbuffer.ring")
+	r, err := newScanRing(RingBufferConfig{Size: 4, Mapped: true}, path)
+	assert.NoError(t, err)
+	r.put(Payload{ItemID: "PAT-42"})
+	r.put(Payload{ItemID: "PAT-7"})
+	r.put(Payload{ItemID: "PAT-42"})
+	inFlight, seq, _ := r.take()
+	assert.Equal(t, "PAT-42", inFlight.ItemID)
+
+	match := func(itemID string) bool { return itemID == "PAT-42" }
+	assert.Equal(t, 2, r.purge(match, true))
+	assert.Len(t, decodeRing(r.mapped), 3)
+	assert.Equal(t, 2, r.purge(match, false))
+	assert.Equal(t, []Payload{{ItemID: "PAT-7"}}, decodeRing(r.mapped))
+	assert.Equal(t, 0, r.purge(match, false))
+
+	// the purged scan waiting for a worker is skipped
+	r.done(seq)
+	assert.Equal(t, "PAT-7", mustTake(t, r).ItemID)
+	stopped := make(chan bool)
+	go func() {
+		_, _, ok := r.take()
+		stopped <- ok
+	}()
+	assert.Equal(t, 0, r.drain(time.Second))
+	assert.NoError(t, r.close())
+	assert.False(t, <-stopped)
+
+	var none *scanRing
+	assert.Zero(t, none.purge(match, false))
+}
@@ -0,0 +1,29 @@
+//go:build windows
+
+package main
+
+import (
+	"os"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// mapFile maps size bytes of file into memory for reading and writing
+func mapFile(file *os.File, size int) ([]byte, func() error, error) {
+	mapping, err := windows.CreateFileMapping(windows.Handle(file.Fd()), nil, windows.PAGE_READWRITE, 0, uint32(size), nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	addr, err := windows.MapViewOfFile(mapping, windows.FILE_MAP_WRITE, 0, 0, uintptr(size))
+	if err != nil {
+		windows.CloseHandle(mapping)
+		return nil, nil, err
+	}
+	data := unsafe.Slice((*byte)(unsafe.Add(nil, addr)), size)
+	return data, func() error {
+		err := windows.UnmapViewOfFile(addr)
+		windows.CloseHandle(mapping)
+		return err
+	}, nil
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// RollupConfig represents a relay that collects the heartbeats of the
+// stations on its site and sends one roll-up heartbeat per interval with the
+// status of each station group
+type RollupConfig struct {
+	Enabled bool `json:"enabled"`
+	// Path on the ingest listener that stations send their heartbeats to (default "/heartbeat")
+	Path string `json:"path"`
+	// Endpoint receives the roll-up heartbeat (default the relay's own heartbeat.endpoint)
+	Endpoint string `json:"endpoint"`
+	// IntervalSeconds is how often the roll-up is sent (default 60)
+	IntervalSeconds int `json:"intervalSeconds"`
+	// OfflineSeconds is how long a station may be silent before it counts as offline (default 180)
+	OfflineSeconds int `json:"offlineSeconds"`
+}
+
+func (r RollupConfig) withDefaults(heartbeatEndpoint string) RollupConfig {
+	if r.Path == "" {
+		r.Path = "/heartbeat"
+	}
+	if r.Endpoint == "" {
+		r.Endpoint = heartbeatEndpoint
+	}
+	if r.IntervalSeconds <= 0 {
+		r.IntervalSeconds = 60
+	}
+	if r.OfflineSeconds <= 0 {
+		r.OfflineSeconds = 180
+	}
+	return r
+}
+
+// GroupRollup summarizes the stations of one group
+type GroupRollup struct {
+	Group    string `json:"group"`
+	Stations int    `json:"stations"`
+	// AllHealthy is set when every station is online and green
+	AllHealthy bool `json:"allHealthy"`
+	Green      int  `json:"green"`
+	Yellow     int  `json:"yellow"`
+	Red        int  `json:"red"`
+	Offline    int  `json:"offline"`
+	// OfflineIDs names the offline stations by station ID, or hostname before enrollment
+	OfflineIDs []string `json:"offlineStations,omitempty"`
+	// QueueDepth is the total of the online stations' queues
+	QueueDepth int `json:"queueDepth"`
+}
+
+// RollupHeartbeat is the body sent to the roll-up endpoint
+type RollupHeartbeat struct {
+	Type     string        `json:"type"`
+	Time     time.Time     `json:"time"`
+	Hostname string        `json:"hostname"`
+	Groups   []GroupRollup `json:"groups"`
+}
+
+// stationHeartbeat is the last status a station reported
+type stationHeartbeat struct {
+	fields   map[string]json.RawMessage
+	lastSeen time.Time
+}
+
+// rollupCollector keeps the latest status of each station, by hostname
+type rollupCollector struct {
+	offline time.Duration
+
+	mu       sync.Mutex
+	stations map[string]*stationHeartbeat
+}
+
+var rollups *rollupCollector
+
+func newRollupCollector(config RollupConfig) *rollupCollector {
+	return &rollupCollector{offline: time.Duration(config.OfflineSeconds) * time.Second, stations: map[string]*stationHeartbeat{}}
+}
+
+// record applies a heartbeat, full or delta, to the station's last status
+func (c *rollupCollector) record(body []byte, now time.Time) error {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(body, &fields); err != nil {
+		return err
+	}
+	delta := false
+	if _, ok := fields["status"]; ok {
+		var heartbeat Heartbeat
+		if err := json.Unmarshal(body, &heartbeat); err != nil {
+			return err
+		}
+		fields, delta = heartbeat.Status, heartbeat.Type == "delta"
+	}
+	// deltas always carry the hostname, but the station ID only when it changed
+	id := rawString(fields["hostname"])
+	if id == "" {
+		return fmt.Errorf("heartbeat has no hostname")
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	station, ok := c.stations[id]
+	if !ok || !delta {
+		station = &stationHeartbeat{fields: map[string]json.RawMessage{}}
+		c.stations[id] = station
+	}
+	for key, value := range fields {
+		if string(value) == "null" {
+			delete(station.fields, key)
+			continue
+		}
+		station.fields[key] = value
+	}
+	station.lastSeen = now
+	return nil
+}
+
+// rawString returns a JSON string value, or "" for anything else
+func rawString(value json.RawMessage) string {
+	var s string
+	json.Unmarshal(value, &s)
+	return s
+}
+
+// groups summarizes the stations by group, in group order
+func (c *rollupCollector) groups(now time.Time) []GroupRollup {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	byGroup := map[string]*GroupRollup{}
+	var ids []string
+	for id := range c.stations {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		station := c.stations[id]
+		name := rawString(station.fields["group"])
+		group, ok := byGroup[name]
+		if !ok {
+			group = &GroupRollup{Group: name}
+			byGroup[name] = group
+		}
+		group.Stations++
+		if now.Sub(station.lastSeen) > c.offline {
+			group.Offline++
+			if stationID := rawString(station.fields["stationId"]); stationID != "" {
+				id = stationID
+			}
+			group.OfflineIDs = append(group.OfflineIDs, id)
+			continue
+		}
+		switch rawString(station.fields["health"]) {
+		case healthGreen:
+			group.Green++
+		case healthYellow:
+			group.Yellow++
+		case healthRed:
+			group.Red++
+		}
+		var depth int
+		json.Unmarshal(station.fields["queueDepth"], &depth)
+		group.QueueDepth += depth
+	}
+	result := []GroupRollup{}
+	for _, group := range byGroup {
+		group.AllHealthy = group.Green == group.Stations
+		result = append(result, *group)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Group < result[j].Group })
+	return result
+}
+
+// ServeHTTP accepts a station heartbeat
+func (c *rollupCollector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, 1<<20))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := c.record(body, time.Now()); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// sendRollups periodically posts the roll-up heartbeat
+func sendRollups(config RollupConfig) {
+	hostname, _ := os.Hostname()
+	for range time.Tick(time.Duration(config.IntervalSeconds) * time.Second) {
+		now := time.Now()
+		data, err := json.Marshal(RollupHeartbeat{Type: "rollup", Time: now, Hostname: hostname, Groups: rollups.groups(now)})
+		if err != nil {
+			logger.Errorf("Error marshaling roll-up heartbeat: %v", err)
+			continue
+		}
+		resp, err := apiClient.Post(config.Endpoint, "application/json", bytes.NewBuffer(data))
+		if err != nil {
+			logger.Warnf("Error sending roll-up heartbeat: %v", err)
+			continue
+		}
+		resp.Body.Close()
+		if resp.StatusCode < 200 || resp.StatusCode > 299 {
+			logger.Warnf("Roll-up heartbeat rejected with response code: %d", resp.StatusCode)
+		}
+	}
+}
+
+// startRollups creates the collector and the roll-up sender
+func startRollups(config *Config) error {
+	if config.Ingest.Listen == "" {
+		return fmt.Errorf("the roll-up needs ingest.listen for stations to send heartbeats to")
+	}
+	rollup := config.Rollup.withDefaults(config.Heartbeat.Endpoint)
+	if rollup.Endpoint == "" {
+		return fmt.Errorf("no roll-up endpoint: set rollup.endpoint or heartbeat.endpoint")
+	}
+	rollups = newRollupCollector(rollup)
+	go sendRollups(rollup)
+	return nil
+}
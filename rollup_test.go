@@ -0,0 +1,52 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRollupCollector_Groups(t *testing.T) {
+	c := newRollupCollector(RollupConfig{}.withDefaults("http://backend/heartbeat"))
+	start := time.Date(2026, 5, 1, 8, 0, 0, 0, time.UTC)
+
+	assert.NoError(t, c.record([]byte(`{"hostname": "pos1", "stationId": "S1", "group": "front", "health": "green", "queueDepth": 2}`), start))
+	assert.NoError(t, c.record([]byte(`{"type": "full", "seq": 1, "status": {"hostname": "pos2", "stationId": "S2", "group": "front", "health": "green", "queueDepth": 0}}`), start))
+	assert.NoError(t, c.record([]byte(`{"hostname": "dock1", "stationId": "S3", "group": "dock", "health": "green", "queueDepth": 0}`), start))
+	assert.Error(t, c.record([]byte(`{"health": "green"}`), start))
+
+	// a delta updates only what changed
+	later := start.Add(4 * time.Minute)
+	assert.NoError(t, c.record([]byte(`{"type": "delta", "seq": 2, "status": {"hostname": "pos2", "health": "yellow", "queueDepth": 5}}`), later))
+	assert.NoError(t, c.record([]byte(`{"hostname": "pos1", "stationId": "S1", "group": "front", "health": "green", "queueDepth": 1}`), later))
+
+	assert.Equal(t, []GroupRollup{
+		{Group: "dock", Stations: 1, Offline: 1, OfflineIDs: []string{"S3"}},
+		{Group: "front", Stations: 2, Green: 1, Yellow: 1, QueueDepth: 6},
+	}, c.groups(later))
+
+	assert.NoError(t, c.record([]byte(`{"hostname": "dock1", "stationId": "S3", "group": "dock", "health": "green", "queueDepth": 0}`), later))
+	assert.Equal(t, GroupRollup{Group: "dock", Stations: 1, AllHealthy: true, Green: 1}, c.groups(later)[0])
+}
+
+func TestRollupCollector_ServeHTTP(t *testing.T) {
+	c := newRollupCollector(RollupConfig{}.withDefaults(""))
+	w := httptest.NewRecorder()
+	c.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/heartbeat", strings.NewReader(`{"hostname": "pos1", "health": "red"}`)))
+	assert.Equal(t, http.StatusNoContent, w.Code)
+
+	w = httptest.NewRecorder()
+	c.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/heartbeat", strings.NewReader(`not json`)))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	assert.Equal(t, []GroupRollup{{Stations: 1, Red: 1}}, c.groups(time.Now()))
+}
+
+func TestStartRollups_Validation(t *testing.T) {
+	assert.Error(t, startRollups(&Config{Rollup: RollupConfig{Enabled: true}, Heartbeat: HeartbeatConfig{Endpoint: "http://backend"}}))
+	assert.Error(t, startRollups(&Config{Rollup: RollupConfig{Enabled: true}, Ingest: IngestConfig{Listen: ":8085"}}))
+}
@@ -0,0 +1,16 @@
+// Package scan is the embeddable core of the scan-and-post service: the
+// payload format, line-oriented inputs, a transform and output pipeline, and
+// the checksummed journal format of the failure queue.
+//
+// The service reads keyboard input with ReadLines, posts to its additional
+// outputs with HTTPOutput and GraphQLOutput, and writes failures.log in the
+// queue format. Pipeline is not what the service runs: the service also
+// filters, deduplicates, holds and batches scans, and queues a failed post to
+// failures.log, while Pipeline only transforms and delivers. Both apply the
+// same success rule, see Accepted: the API endpoint must answer 200, while
+// the service sets Any2xx on its additional outputs.
+//
+// The exported API of this package follows semantic versioning with the
+// module's release tags. Everything in the main package is internal to the
+// service and may change in any release.
+package scan
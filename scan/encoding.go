@@ -0,0 +1,62 @@
+package scan
+
+import (
+	"encoding/json"
+	"fmt"
+	"mime"
+
+	"github.com/fxamacker/cbor/v2"
+)
+
+// Encodings of payloads and batches on the wire
+const (
+	EncodingJSON = "json"
+	// EncodingCBOR is RFC 8949 CBOR with the integer keys in the cbor struct
+	// tags, Unix times and the shortest lossless floats, for slow links where
+	// JSON overhead matters
+	EncodingCBOR = "cbor"
+)
+
+var (
+	cborEnc cbor.EncMode
+	cborDec cbor.DecMode
+)
+
+func init() {
+	var err error
+	cborEnc, err = cbor.EncOptions{Time: cbor.TimeUnixDynamic, ShortestFloat: cbor.ShortestFloat16}.EncMode()
+	if err != nil {
+		panic(err)
+	}
+	cborDec, err = cbor.DecOptions{}.DecMode()
+	if err != nil {
+		panic(err)
+	}
+}
+
+// ValidEncoding reports whether encoding is "", "json" or "cbor"
+func ValidEncoding(encoding string) bool {
+	return encoding == "" || encoding == EncodingJSON || encoding == EncodingCBOR
+}
+
+// Marshal encodes a payload or batch in the given encoding, defaulting to
+// JSON, and returns the body with its content type
+func Marshal(encoding string, v interface{}) (body []byte, contentType string, err error) {
+	switch encoding {
+	case "", EncodingJSON:
+		body, err = json.Marshal(v)
+		return body, "application/json", err
+	case EncodingCBOR:
+		body, err = cborEnc.Marshal(v)
+		return body, "application/cbor", err
+	}
+	return nil, "", fmt.Errorf("unknown encoding %q", encoding)
+}
+
+// Unmarshal decodes a body by its content type, accepting JSON and CBOR
+func Unmarshal(contentType string, body []byte, v interface{}) error {
+	if mediaType, _, _ := mime.ParseMediaType(contentType); mediaType == "application/cbor" {
+		return cborDec.Unmarshal(body, v)
+	}
+	return json.Unmarshal(body, v)
+}
@@ -0,0 +1,29 @@
+package scan
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMarshal_CBORRoundTripAndSize(t *testing.T) {
+	payload := Payload{ItemID: "0012345678905", DeviceType: "scanner0", Location: &Location{
+		Latitude: 51.5, Longitude: -0.125, Source: "gps", Time: time.Unix(1700000000, 0).UTC(),
+	}}
+	body, contentType, err := Marshal(EncodingCBOR, payload)
+	assert.NoError(t, err)
+	assert.Equal(t, "application/cbor", contentType)
+
+	jsonBody, _ := json.Marshal(payload)
+	assert.Less(t, len(body)*2, len(jsonBody))
+
+	var decoded Payload
+	assert.NoError(t, Unmarshal("application/cbor; charset=binary", body, &decoded))
+	decoded.Location.Time = decoded.Location.Time.UTC()
+	assert.Equal(t, payload, decoded)
+
+	_, _, err = Marshal("xml", payload)
+	assert.EqualError(t, err, `unknown encoding "xml"`)
+}
@@ -0,0 +1,48 @@
+package scan_test
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/zachthieme/scanandpost/scan"
+)
+
+// printOutput is an output that prints each payload
+type printOutput struct{}
+
+func (printOutput) Name() string { return "print" }
+
+func (printOutput) Deliver(p scan.Payload) error {
+	fmt.Printf("%s from %s\n", p.ItemID, p.DeviceType)
+	return nil
+}
+
+func Example() {
+	payloads := make(chan scan.Payload, 10)
+	scan.ReadLines(strings.NewReader("https://example.com/?id=12345\nNOISE\n"), "keyboard", payloads)
+	close(payloads)
+
+	pipeline := &scan.Pipeline{
+		Transforms: []scan.Transform{
+			func(p scan.Payload) (scan.Payload, bool) {
+				p.CleanItemId()
+				return p, p.ItemID != "NOISE"
+			},
+		},
+		Outputs: []scan.Output{printOutput{}},
+	}
+	for p := range payloads {
+		pipeline.Process(p)
+	}
+	// Output: 12345 from keyboard
+}
+
+func ExampleEncodeQueueEntry() {
+	line, _ := scan.EncodeQueueEntry(scan.Payload{ItemID: "12345", DeviceType: "scanner0"})
+	fmt.Println(line)
+	payload, err := scan.DecodeQueueEntry(line)
+	fmt.Println(payload.ItemID, err)
+	// Output:
+	// 42dcdf93 {"itemid":"12345","deviceType":"scanner0"}
+	// 12345 <nil>
+}
@@ -0,0 +1,121 @@
+package scan
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// GraphQLOutput sends each payload as a GraphQL mutation and fails on HTTP
+// errors as well as on errors reported in the GraphQL response
+type GraphQLOutput struct {
+	OutputName string
+	Endpoint   string
+	// Query is the mutation document, for example
+	// mutation Scan($item: String!) { recordScan(item: $item) { id } }
+	Query         string
+	OperationName string
+	// Variables maps each variable to the JSON name of a payload field, such as
+	// "item": "itemid", or to "payload" for the whole payload. Without
+	// variables, the payload is sent as the variable "payload".
+	Variables map[string]string
+	// Client defaults to http.DefaultClient
+	Client *http.Client
+}
+
+type graphQLRequest struct {
+	Query         string                 `json:"query"`
+	OperationName string                 `json:"operationName,omitempty"`
+	Variables     map[string]interface{} `json:"variables"`
+}
+
+type graphQLResponse struct {
+	Data   json.RawMessage `json:"data"`
+	Errors []struct {
+		Message string        `json:"message"`
+		Path    []interface{} `json:"path"`
+	} `json:"errors"`
+}
+
+func (o *GraphQLOutput) Name() string {
+	return o.OutputName
+}
+
+// ValidateGraphQLVariables checks that every variable names a payload field or "payload"
+func ValidateGraphQLVariables(variables map[string]string) error {
+	fields := templateFields(Payload{})
+	for name, field := range variables {
+		if _, ok := fields[field]; !ok && field != "payload" {
+			return fmt.Errorf("variable %s: unknown payload field %q", name, field)
+		}
+	}
+	return nil
+}
+
+// Deliver sends the mutation
+func (o *GraphQLOutput) Deliver(payload Payload) error {
+	return o.DeliverContext(context.Background(), payload)
+}
+
+// DeliverContext sends the mutation, giving up when the context is done
+func (o *GraphQLOutput) DeliverContext(ctx context.Context, payload Payload) error {
+	request := graphQLRequest{Query: o.Query, OperationName: o.OperationName, Variables: map[string]interface{}{}}
+	fields := templateFields(payload)
+	for name, field := range o.Variables {
+		if field == "payload" {
+			request.Variables[name] = payload
+		} else {
+			request.Variables[name] = fields[field]
+		}
+	}
+	if len(o.Variables) == 0 {
+		request.Variables["payload"] = payload
+	}
+	data, err := json.Marshal(request)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, o.Endpoint, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/graphql-response+json, application/json")
+	client := o.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	var response graphQLResponse
+	decodeErr := json.NewDecoder(resp.Body).Decode(&response)
+	// GraphQL over HTTP servers may answer 4xx with errors in the body, which say more than the code
+	if len(response.Errors) > 0 {
+		messages := make([]string, len(response.Errors))
+		for i, e := range response.Errors {
+			messages[i] = e.Message
+			if len(e.Path) > 0 {
+				messages[i] += fmt.Sprintf(" (at %v)", e.Path)
+			}
+		}
+		return fmt.Errorf("graphql: %s", strings.Join(messages, "; "))
+	}
+	if !Accepted(resp.StatusCode, true) {
+		return fmt.Errorf("response code: %d", resp.StatusCode)
+	}
+	if decodeErr != nil {
+		return fmt.Errorf("graphql: invalid response: %v", decodeErr)
+	}
+	if len(response.Data) == 0 || string(response.Data) == "null" {
+		return errors.New("graphql: response has no data")
+	}
+	return nil
+}
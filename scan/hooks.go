@@ -0,0 +1,74 @@
+package scan
+
+import (
+	"context"
+	"time"
+)
+
+// Logger receives the pipeline's log output. *logrus.Logger satisfies it.
+type Logger interface {
+	Debugf(format string, args ...interface{})
+	Errorf(format string, args ...interface{})
+}
+
+// Metrics receives the pipeline's measurements, so a host can feed its own registry
+type Metrics interface {
+	IncCounter(name string, labels map[string]string)
+	ObserveDuration(name string, d time.Duration, labels map[string]string)
+}
+
+// Names of the metrics reported to Metrics
+const (
+	MetricPayloads         = "scan_payloads_total"
+	MetricDeliveries       = "scan_deliveries_total"
+	MetricDeliveryDuration = "scan_delivery_duration"
+)
+
+// EventType tells what happened to a payload
+type EventType string
+
+// Event types reported to Pipeline.OnEvent
+const (
+	EventScan      EventType = "scan"
+	EventDropped   EventType = "dropped"
+	EventDelivered EventType = "delivered"
+	EventFailed    EventType = "failed"
+)
+
+// Event is a step of a payload through the pipeline. Output, Err and Duration
+// are set for delivery events only.
+type Event struct {
+	Type     EventType
+	Payload  Payload
+	Output   string
+	Err      error
+	Duration time.Duration
+}
+
+// EventsTo returns an OnEvent callback that sends every event to ch, waiting
+// for room unless the context of the payload is done
+func EventsTo(ch chan<- Event) func(context.Context, Event) {
+	return func(ctx context.Context, e Event) {
+		select {
+		case ch <- e:
+		case <-ctx.Done():
+		}
+	}
+}
+
+// ContextOutput is an Output that honours cancellation and deadlines.
+// Pipelines call DeliverContext instead of Deliver when an output has it.
+type ContextOutput interface {
+	Output
+	DeliverContext(ctx context.Context, payload Payload) error
+}
+
+type nopLogger struct{}
+
+func (nopLogger) Debugf(string, ...interface{}) {}
+func (nopLogger) Errorf(string, ...interface{}) {}
+
+type nopMetrics struct{}
+
+func (nopMetrics) IncCounter(string, map[string]string)                     {}
+func (nopMetrics) ObserveDuration(string, time.Duration, map[string]string) {}
@@ -0,0 +1,68 @@
+package scan
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type recordingMetrics struct {
+	mu       sync.Mutex
+	counters map[string]int
+}
+
+func (m *recordingMetrics) IncCounter(name string, labels map[string]string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.counters[name+"/"+labels["result"]]++
+}
+
+func (m *recordingMetrics) ObserveDuration(string, time.Duration, map[string]string) {}
+
+func TestPipeline_Hooks(t *testing.T) {
+	metrics := &recordingMetrics{counters: map[string]int{}}
+	events := make(chan Event, 10)
+	p := &Pipeline{
+		Transforms: []Transform{func(p Payload) (Payload, bool) { return p, p.ItemID != "drop" }},
+		Outputs:    []Output{failingOutput{}},
+		Metrics:    metrics,
+		OnEvent:    EventsTo(events),
+	}
+	p.Process(Payload{ItemID: "drop"})
+	p.Process(Payload{ItemID: "1"})
+	close(events)
+
+	var types []EventType
+	for e := range events {
+		types = append(types, e.Type)
+		if e.Type == EventFailed {
+			assert.Equal(t, "failing", e.Output)
+			assert.Error(t, e.Err)
+		}
+	}
+	assert.Equal(t, []EventType{EventScan, EventDropped, EventScan, EventFailed}, types)
+	assert.Equal(t, map[string]int{
+		MetricPayloads + "/dropped":  1,
+		MetricPayloads + "/accepted": 1,
+		MetricDeliveries + "/failed": 1,
+	}, metrics.counters)
+}
+
+func TestHTTPOutput_DeliverContext(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { <-release }))
+	defer server.Close()
+	defer close(release)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	p := &Pipeline{Outputs: []Output{&HTTPOutput{OutputName: "api", Endpoint: server.URL, Client: server.Client()}}}
+	_, errs := p.ProcessContext(ctx, Payload{ItemID: "1"})
+	assert.True(t, errors.Is(errors.Join(errs...), context.DeadlineExceeded))
+}
@@ -0,0 +1,28 @@
+package scan
+
+import (
+	"bufio"
+	"context"
+	"io"
+)
+
+// ReadLines sends every line read from r as a payload with the given device
+// type, until r is exhausted. Keyboard wedges and serial scanners produce one
+// barcode per line.
+func ReadLines(r io.Reader, deviceType string, out chan<- Payload) error {
+	return ReadLinesContext(context.Background(), r, deviceType, out)
+}
+
+// ReadLinesContext is ReadLines that stops sending when the context is done.
+// A read already blocked on r only returns once r yields or is closed.
+func ReadLinesContext(ctx context.Context, r io.Reader, deviceType string, out chan<- Payload) error {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		select {
+		case out <- Payload{ItemID: scanner.Text(), DeviceType: deviceType}:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return scanner.Err()
+}
@@ -0,0 +1,52 @@
+package scan
+
+import (
+	"strings"
+	"time"
+)
+
+// Payload is one scan as posted to the API. The CBOR encoding uses the
+// integer keys in the cbor tags instead of field names.
+type Payload struct {
+	ItemID     string    `json:"itemid" cbor:"1,keyasint"`
+	DeviceType string    `json:"deviceType" cbor:"2,keyasint"`
+	Location   *Location `json:"location,omitempty" cbor:"3,keyasint,omitempty"`
+	// Nickname is the human-friendly name of the scanner, when configured
+	Nickname string `json:"nickname,omitempty" cbor:"4,keyasint,omitempty"`
+	// Action is check-out or check-in when the check-in/check-out mode tracks the item
+	Action string `json:"action,omitempty" cbor:"5,keyasint,omitempty"`
+	// TriggerGroup is shared by the codes an imager decoded from a single trigger
+	TriggerGroup string `json:"triggerGroup,omitempty" cbor:"6,keyasint,omitempty"`
+	// Variant tags the transform experiment variants the payload went through, such as "sku-rule:B"
+	Variant string `json:"variant,omitempty" cbor:"7,keyasint,omitempty"`
+	// Sequence numbers the payloads of a device without gaps, starting at 1, when sequence tracking is enabled
+	Sequence uint64 `json:"sequence,omitempty" cbor:"8,keyasint,omitempty"`
+	// NoRead marks a payload sent because a scanner decoded nothing, see NoReadConfig
+	NoRead bool `json:"noRead,omitempty" cbor:"9,keyasint,omitempty"`
+	// Annotations are codes, such as reason codes or damage flags, attached to the scan at the station before it was posted
+	Annotations []string `json:"annotations,omitempty" cbor:"10,keyasint,omitempty"`
+	// ApprovedBy names the supervisor who released the scan from the review queue
+	ApprovedBy string `json:"approvedBy,omitempty" cbor:"11,keyasint,omitempty"`
+	// ScanID identifies the scan across retries, when scan IDs are enabled
+	ScanID string `json:"scanId,omitempty" cbor:"12,keyasint,omitempty"`
+}
+
+// Location is the coarse position attached to a payload
+type Location struct {
+	Latitude       float64   `json:"latitude" cbor:"1,keyasint"`
+	Longitude      float64   `json:"longitude" cbor:"2,keyasint"`
+	AccuracyMeters float64   `json:"accuracyMeters,omitempty" cbor:"3,keyasint,omitempty"`
+	Site           string    `json:"site,omitempty" cbor:"4,keyasint,omitempty"`
+	Source         string    `json:"source" cbor:"5,keyasint"`
+	Time           time.Time `json:"time" cbor:"6,keyasint"`
+}
+
+// CleanItemId keeps only the part of the item ID after "id=", for scanners that encode URLs
+func (f *Payload) CleanItemId() {
+	result := f.ItemID
+	idIndex := strings.Index(result, "id=")
+	if idIndex != -1 {
+		//Extract the substring after "id="
+		f.ItemID = result[idIndex+len("id="):]
+	}
+}
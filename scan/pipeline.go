@@ -0,0 +1,165 @@
+package scan
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// Transform changes a payload, or drops it by returning false
+type Transform func(Payload) (Payload, bool)
+
+// Output receives every payload that makes it through the transforms
+type Output interface {
+	Name() string
+	Deliver(Payload) error
+}
+
+// Pipeline runs payloads through its transforms in order and delivers the
+// result to every output. The hooks are optional; a pipeline keeps no global
+// state, so a host can run several side by side.
+type Pipeline struct {
+	Transforms []Transform
+	Outputs    []Output
+
+	// Logger defaults to discarding everything
+	Logger Logger
+	// Metrics defaults to discarding everything
+	Metrics Metrics
+	// OnEvent is called for every scan, drop and delivery, see EventsTo
+	OnEvent func(context.Context, Event)
+}
+
+// Process transforms the payload and delivers it, returning the error of each
+// output that failed. A dropped payload is not delivered.
+func (p *Pipeline) Process(payload Payload) (delivered bool, errs []error) {
+	return p.ProcessContext(context.Background(), payload)
+}
+
+// ProcessContext is Process with a context that is passed to the event
+// callback and to outputs that implement ContextOutput
+func (p *Pipeline) ProcessContext(ctx context.Context, payload Payload) (delivered bool, errs []error) {
+	log, metrics := p.Logger, p.Metrics
+	if log == nil {
+		log = nopLogger{}
+	}
+	if metrics == nil {
+		metrics = nopMetrics{}
+	}
+	p.emit(ctx, Event{Type: EventScan, Payload: payload})
+
+	for _, transform := range p.Transforms {
+		var ok bool
+		if payload, ok = transform(payload); !ok {
+			log.Debugf("Dropped payload %v", payload)
+			metrics.IncCounter(MetricPayloads, map[string]string{"result": "dropped"})
+			p.emit(ctx, Event{Type: EventDropped, Payload: payload})
+			return false, nil
+		}
+	}
+	metrics.IncCounter(MetricPayloads, map[string]string{"result": "accepted"})
+
+	for _, output := range p.Outputs {
+		start := time.Now()
+		var err error
+		if co, ok := output.(ContextOutput); ok {
+			err = co.DeliverContext(ctx, payload)
+		} else {
+			err = output.Deliver(payload)
+		}
+		event := Event{Type: EventDelivered, Payload: payload, Output: output.Name(), Duration: time.Since(start)}
+		result := "delivered"
+		if err != nil {
+			err = fmt.Errorf("%s: %w", output.Name(), err)
+			log.Errorf("Error delivering payload %v: %v", payload, err)
+			errs = append(errs, err)
+			event.Type, event.Err, result = EventFailed, err, "failed"
+		}
+		labels := map[string]string{"output": output.Name(), "result": result}
+		metrics.IncCounter(MetricDeliveries, labels)
+		metrics.ObserveDuration(MetricDeliveryDuration, event.Duration, labels)
+		p.emit(ctx, event)
+	}
+	return true, errs
+}
+
+func (p *Pipeline) emit(ctx context.Context, e Event) {
+	if p.OnEvent != nil {
+		p.OnEvent(ctx, e)
+	}
+}
+
+// Accepted reports whether an endpoint that answered with the status code
+// took the payload. Like the service's API endpoint, only 200 OK counts,
+// unless any2xx is set, as for the service's additional outputs.
+func Accepted(statusCode int, any2xx bool) bool {
+	if any2xx {
+		return statusCode >= 200 && statusCode <= 299
+	}
+	return statusCode == http.StatusOK
+}
+
+// HTTPOutput sends each payload to an endpoint and expects a 200 response,
+// or any 2xx response with Any2xx
+type HTTPOutput struct {
+	OutputName string
+	// Endpoint is a URL template, see ExpandURL
+	Endpoint string
+	// Method defaults to POST. GET, HEAD and DELETE requests carry no body.
+	Method string
+	// Client defaults to http.DefaultClient
+	Client *http.Client
+	// Encoding is EncodingJSON (the default) or EncodingCBOR
+	Encoding string
+	// Any2xx accepts any 2xx response, such as 201 or 204, instead of only 200
+	Any2xx bool
+}
+
+func (o *HTTPOutput) Name() string {
+	return o.OutputName
+}
+
+// Deliver sends the payload
+func (o *HTTPOutput) Deliver(payload Payload) error {
+	return o.DeliverContext(context.Background(), payload)
+}
+
+// DeliverContext sends the payload, giving up when the context is done
+func (o *HTTPOutput) DeliverContext(ctx context.Context, payload Payload) error {
+	method := o.Method
+	if method == "" {
+		method = http.MethodPost
+	}
+	var body io.Reader
+	var contentType string
+	if method != http.MethodGet && method != http.MethodHead && method != http.MethodDelete {
+		data, ct, err := Marshal(o.Encoding, payload)
+		if err != nil {
+			return err
+		}
+		body, contentType = bytes.NewReader(data), ct
+	}
+	req, err := http.NewRequestWithContext(ctx, method, ExpandURL(o.Endpoint, payload), body)
+	if err != nil {
+		return err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	client := o.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if !Accepted(resp.StatusCode, o.Any2xx) {
+		return fmt.Errorf("response code: %d", resp.StatusCode)
+	}
+	return nil
+}
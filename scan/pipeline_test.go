@@ -0,0 +1,60 @@
+package scan
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type failingOutput struct{}
+
+func (failingOutput) Name() string          { return "failing" }
+func (failingOutput) Deliver(Payload) error { return errors.New("down") }
+
+func TestPipeline_Process(t *testing.T) {
+	var received []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = append(received, r.URL.Path)
+	}))
+	defer server.Close()
+
+	p := &Pipeline{
+		Transforms: []Transform{func(p Payload) (Payload, bool) { return p, p.ItemID != "drop" }},
+		Outputs:    []Output{&HTTPOutput{OutputName: "api", Endpoint: server.URL + "/api"}, failingOutput{}},
+	}
+	delivered, errs := p.Process(Payload{ItemID: "drop"})
+	assert.False(t, delivered)
+	assert.Empty(t, errs)
+
+	delivered, errs = p.Process(Payload{ItemID: "12345"})
+	assert.True(t, delivered)
+	assert.EqualError(t, errors.Join(errs...), "failing: down")
+	assert.Equal(t, []string{"/api"}, received)
+}
+
+func TestDecodeQueueEntry_Checksum(t *testing.T) {
+	line, _ := EncodeQueueEntry(Payload{ItemID: "1"})
+	_, err := DecodeQueueEntry(line[:len(line)-2] + `x}`)
+	assert.ErrorIs(t, err, ErrChecksum)
+}
+
+func TestAccepted(t *testing.T) {
+	assert.True(t, Accepted(http.StatusOK, false))
+	assert.False(t, Accepted(http.StatusNoContent, false))
+	assert.True(t, Accepted(http.StatusNoContent, true))
+	assert.False(t, Accepted(http.StatusMultipleChoices, true))
+	assert.False(t, Accepted(http.StatusInternalServerError, true))
+}
+
+func TestHTTPOutput_SuccessRule(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	assert.EqualError(t, (&HTTPOutput{Endpoint: server.URL}).Deliver(Payload{ItemID: "1"}), "response code: 204")
+	assert.NoError(t, (&HTTPOutput{Endpoint: server.URL, Any2xx: true}).Deliver(Payload{ItemID: "1"}))
+}
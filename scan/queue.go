@@ -0,0 +1,68 @@
+package scan
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrChecksum is returned for a queue entry whose content does not match its checksum
+var ErrChecksum = errors.New("checksum mismatch")
+
+// EncodeQueueEntry formats a payload as one line of the failure queue,
+// prefixed with the CRC-32 of its JSON
+func EncodeQueueEntry(payload Payload) (string, error) {
+	return EncodeQueueEntryAt(payload, time.Time{})
+}
+
+// EncodeQueueEntryAt is EncodeQueueEntry that also records when the payload was
+// queued, as Unix milliseconds between the checksum and the JSON. The checksum
+// covers both. A zero time is left out.
+func EncodeQueueEntryAt(payload Payload, queuedAt time.Time) (string, error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return "", err
+	}
+	if !queuedAt.IsZero() {
+		data = append([]byte(strconv.FormatInt(queuedAt.UnixMilli(), 10)+" "), data...)
+	}
+	return fmt.Sprintf("%08x %s", crc32.ChecksumIEEE(data), data), nil
+}
+
+// DecodeQueueEntry parses a line of the failure queue, verifying its checksum.
+// Lines written before checksums were added are plain JSON and are accepted as is.
+func DecodeQueueEntry(line string) (Payload, error) {
+	payload, _, err := DecodeQueueEntryAt(line)
+	return payload, err
+}
+
+// DecodeQueueEntryAt is DecodeQueueEntry that also returns when the payload was
+// queued, or the zero time for entries that do not record it
+func DecodeQueueEntryAt(line string) (Payload, time.Time, error) {
+	var payload Payload
+	var queuedAt time.Time
+	data := line
+	if sum, rest, ok := strings.Cut(line, " "); ok && len(sum) == 8 && !strings.HasPrefix(line, "{") {
+		var want uint32
+		if _, err := fmt.Sscanf(sum, "%08x", &want); err != nil {
+			return payload, queuedAt, err
+		}
+		if crc32.ChecksumIEEE([]byte(rest)) != want {
+			return payload, queuedAt, ErrChecksum
+		}
+		data = rest
+		if millis, rest, ok := strings.Cut(data, " "); ok && !strings.HasPrefix(data, "{") {
+			ms, err := strconv.ParseInt(millis, 10, 64)
+			if err != nil {
+				return payload, queuedAt, err
+			}
+			queuedAt, data = time.UnixMilli(ms), rest
+		}
+	}
+	err := json.Unmarshal([]byte(data), &payload)
+	return payload, queuedAt, err
+}
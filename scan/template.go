@@ -0,0 +1,68 @@
+package scan
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var placeholder = regexp.MustCompile(`\{([A-Za-z]+)\}`)
+
+// templateFields are the payload fields a URL template can refer to, by JSON name
+func templateFields(payload Payload) map[string]string {
+	return map[string]string{
+		"itemid":       payload.ItemID,
+		"deviceType":   payload.DeviceType,
+		"nickname":     payload.Nickname,
+		"action":       payload.Action,
+		"triggerGroup": payload.TriggerGroup,
+		"variant":      payload.Variant,
+		"sequence":     sequenceString(payload.Sequence),
+		"scanId":       payload.ScanID,
+	}
+}
+
+func sequenceString(sequence uint64) string {
+	if sequence == 0 {
+		return ""
+	}
+	return strconv.FormatUint(sequence, 10)
+}
+
+// ValidateURLTemplate checks that every {placeholder} in a URL template names a payload field
+func ValidateURLTemplate(template string) error {
+	fields := templateFields(Payload{})
+	for _, match := range placeholder.FindAllStringSubmatch(template, -1) {
+		if _, ok := fields[match[1]]; !ok {
+			return fmt.Errorf("unknown placeholder %s", match[0])
+		}
+	}
+	return nil
+}
+
+// ExpandURL replaces each {placeholder} in a URL template, such as
+// https://example.com/items/{itemid}/scan, with the escaped payload field.
+// Unknown placeholders are left as they are.
+func ExpandURL(template string, payload Payload) string {
+	fields := templateFields(payload)
+	query := strings.Index(template, "?")
+	var b strings.Builder
+	last := 0
+	for _, loc := range placeholder.FindAllStringSubmatchIndex(template, -1) {
+		value, ok := fields[template[loc[2]:loc[3]]]
+		if !ok {
+			continue
+		}
+		b.WriteString(template[last:loc[0]])
+		if query >= 0 && loc[0] > query {
+			b.WriteString(url.QueryEscape(value))
+		} else {
+			b.WriteString(url.PathEscape(value))
+		}
+		last = loc[1]
+	}
+	b.WriteString(template[last:])
+	return b.String()
+}
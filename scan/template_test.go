@@ -0,0 +1,17 @@
+package scan
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExpandURL(t *testing.T) {
+	payload := Payload{ItemID: "A/1 2&3", DeviceType: "scanner0"}
+	assert.Equal(t, "https://example.com/items/A%2F1%202&3/scan?station=scanner0&id=A%2F1+2%263&x={unknown}",
+		ExpandURL("https://example.com/items/{itemid}/scan?station={deviceType}&id={itemid}&x={unknown}", payload))
+	assert.Equal(t, "https://example.com/api", ExpandURL("https://example.com/api", payload))
+
+	assert.NoError(t, ValidateURLTemplate("https://example.com/{itemid}/{triggerGroup}"))
+	assert.EqualError(t, ValidateURLTemplate("https://example.com/{itemId}"), "unknown placeholder {itemId}")
+}
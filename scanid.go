@@ -0,0 +1,171 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// ScanIDConfig represents the ID given to each scan, carried in the payload
+// as scanId through the queue and retries. Backends that partition by time
+// want time-sortable IDs rather than random ones.
+type ScanIDConfig struct {
+	Enabled bool `json:"enabled"`
+	// Strategy is uuidv4, uuidv7, ulid or snowflake (default uuidv4)
+	Strategy string `json:"strategy"`
+	// NodeID is the 10-bit node of snowflake IDs, 0 to 1023, unique on the site. Required for snowflake.
+	NodeID *int `json:"nodeId"`
+}
+
+const (
+	scanIDUUIDv4    = "uuidv4"
+	scanIDUUIDv7    = "uuidv7"
+	scanIDULID      = "ulid"
+	scanIDSnowflake = "snowflake"
+)
+
+// snowflakeEpoch is the start of the 41-bit millisecond clock of snowflake IDs
+var snowflakeEpoch = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+func (s ScanIDConfig) withDefaults() ScanIDConfig {
+	if s.Strategy == "" {
+		s.Strategy = scanIDUUIDv4
+	}
+	return s
+}
+
+func (s ScanIDConfig) validate() error {
+	switch s.withDefaults().Strategy {
+	case scanIDUUIDv4, scanIDUUIDv7, scanIDULID, scanIDSnowflake:
+	default:
+		return fmt.Errorf("unknown strategy %q", s.Strategy)
+	}
+	// nodes must be unique on a site, which only the site can assign
+	if s.Strategy == scanIDSnowflake && s.NodeID == nil {
+		return fmt.Errorf("nodeId is required for snowflake IDs")
+	}
+	if s.NodeID != nil && (*s.NodeID < 0 || *s.NodeID > 1023) {
+		return fmt.Errorf("nodeId %d is not between 0 and 1023", *s.NodeID)
+	}
+	return nil
+}
+
+// scanIDGenerator gives each scan an ID. A nil generator gives none.
+type scanIDGenerator struct {
+	strategy string
+	node     int64
+
+	mu sync.Mutex
+	// lastMillis and sequence keep the IDs of one millisecond in order
+	lastMillis int64
+	sequence   int64
+}
+
+var scanIDs *scanIDGenerator
+
+// newScanIDGenerator creates the generator of a validated config
+func newScanIDGenerator(config ScanIDConfig) *scanIDGenerator {
+	config = config.withDefaults()
+	g := &scanIDGenerator{strategy: config.Strategy}
+	if config.NodeID != nil {
+		g.node = int64(*config.NodeID)
+	}
+	return g
+}
+
+// next returns the ID of a new scan, or "" when no generator is configured
+func (g *scanIDGenerator) next(now time.Time) string {
+	if g == nil {
+		return ""
+	}
+	switch g.strategy {
+	case scanIDUUIDv7:
+		return g.uuidV7(now)
+	case scanIDULID:
+		return g.ulid(now)
+	case scanIDSnowflake:
+		return g.snowflake(now)
+	}
+	return uuidV4()
+}
+
+// tick returns the millisecond of an ID and its sequence within it. When
+// the sequence would pass max, or the clock went back, the millisecond moves
+// on from the last one, so the IDs stay in order.
+func (g *scanIDGenerator) tick(now time.Time, max int64) (millis, sequence int64) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	millis = now.UnixMilli()
+	if millis > g.lastMillis {
+		g.lastMillis, g.sequence = millis, 0
+		return millis, 0
+	}
+	g.sequence++
+	if g.sequence > max {
+		g.lastMillis++
+		g.sequence = 0
+	}
+	return g.lastMillis, g.sequence
+}
+
+func uuidV4() string {
+	var id [16]byte
+	rand.Read(id[:])
+	id[6] = id[6]&0x0f | 0x40
+	id[8] = id[8]&0x3f | 0x80
+	return formatUUID(id)
+}
+
+// uuidV7 puts the millisecond in the first 48 bits and the sequence in the
+// 12 bits after the version, as in RFC 9562 method 1
+func (g *scanIDGenerator) uuidV7(now time.Time) string {
+	millis, sequence := g.tick(now, 0xfff)
+	var id [16]byte
+	rand.Read(id[8:])
+	binary.BigEndian.PutUint64(id[:8], uint64(millis)<<16|0x7000|uint64(sequence))
+	id[8] = id[8]&0x3f | 0x80
+	return formatUUID(id)
+}
+
+func formatUUID(id [16]byte) string {
+	s := hex.EncodeToString(id[:])
+	return s[:8] + "-" + s[8:12] + "-" + s[12:16] + "-" + s[16:20] + "-" + s[20:]
+}
+
+const crockford = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+// ulid puts the millisecond in the first 48 bits and the sequence in the
+// next 16, ahead of the random bits, and encodes the 128 bits as 26
+// characters of Crockford base32
+func (g *scanIDGenerator) ulid(now time.Time) string {
+	millis, sequence := g.tick(now, 0xffff)
+	var id [16]byte
+	rand.Read(id[8:])
+	binary.BigEndian.PutUint64(id[:8], uint64(millis)<<16|uint64(sequence))
+	out := make([]byte, 26)
+	for i := range out {
+		v := 0
+		for b := 0; b < 5; b++ {
+			// the first character only holds 3 bits
+			bit := i*5 + b - 2
+			v <<= 1
+			if bit >= 0 && id[bit/8]&(0x80>>(bit%8)) != 0 {
+				v |= 1
+			}
+		}
+		out[i] = crockford[v]
+	}
+	return string(out)
+}
+
+// snowflake packs 41 bits of milliseconds since snowflakeEpoch, the 10-bit
+// node and a 12-bit sequence into a decimal 63-bit integer
+func (g *scanIDGenerator) snowflake(now time.Time) string {
+	millis, sequence := g.tick(now, 0xfff)
+	id := (millis-snowflakeEpoch.UnixMilli())<<22 | g.node<<12 | sequence
+	return strconv.FormatInt(id, 10)
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"regexp"
+	"sort"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestScanIDConfig_Validate(t *testing.T) {
+	node := 1024
+	assert.NoError(t, ScanIDConfig{}.validate())
+	assert.NoError(t, ScanIDConfig{Strategy: "ulid"}.validate())
+	assert.Error(t, ScanIDConfig{Strategy: "uuidv1"}.validate())
+	assert.Error(t, ScanIDConfig{Strategy: "snowflake", NodeID: &node}.validate())
+	assert.EqualError(t, ScanIDConfig{Strategy: "snowflake"}.validate(), "nodeId is required for snowflake IDs")
+}
+
+func TestScanIDGenerator_Formats(t *testing.T) {
+	now := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	node := 5
+	for strategy, pattern := range map[string]string{
+		"uuidv4":    `^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`,
+		"uuidv7":    `^[0-9a-f]{8}-[0-9a-f]{4}-7[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`,
+		"ulid":      `^[0-7][0-9A-HJKMNP-TV-Z]{25}$`,
+		"snowflake": `^[0-9]+$`,
+	} {
+		g := newScanIDGenerator(ScanIDConfig{Strategy: strategy, NodeID: &node})
+		assert.Regexp(t, regexp.MustCompile(pattern), g.next(now), strategy)
+	}
+
+	// the ULID of a known millisecond starts with its timestamp
+	g := newScanIDGenerator(ScanIDConfig{Strategy: "ulid"})
+	assert.Equal(t, "01JWNNSVG0", g.next(time.UnixMilli(1748779200000))[:10])
+
+	g = newScanIDGenerator(ScanIDConfig{Strategy: "snowflake", NodeID: &node})
+	id, err := strconv.ParseInt(g.next(now), 10, 64)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(5), id>>12&1023)
+	assert.Equal(t, now.UnixMilli()-snowflakeEpoch.UnixMilli(), id>>22)
+}
+
+func TestScanIDGenerator_Sortable(t *testing.T) {
+	now := time.Now()
+	for _, strategy := range []string{"uuidv7", "ulid"} {
+		g := newScanIDGenerator(ScanIDConfig{Strategy: strategy})
+		var ids []string
+		// many IDs in one millisecond, and a clock that steps back, stay in order
+		for i := 0; i < 5000; i++ {
+			ids = append(ids, g.next(now))
+		}
+		ids = append(ids, g.next(now.Add(-time.Second)), g.next(now.Add(time.Second)))
+		assert.True(t, sort.StringsAreSorted(ids), strategy)
+	}
+
+	node := 7
+	g := newScanIDGenerator(ScanIDConfig{Strategy: "snowflake", NodeID: &node})
+	last := int64(0)
+	for i := 0; i < 5000; i++ {
+		id, _ := strconv.ParseInt(g.next(now), 10, 64)
+		assert.Greater(t, id, last)
+		last = id
+	}
+}
+
+func TestScanIDGenerator_Nil(t *testing.T) {
+	var g *scanIDGenerator
+	assert.Equal(t, "", g.next(time.Now()))
+}
+
+func TestIntake_ScanID(t *testing.T) {
+	oldIDs := scanIDs
+	defer func() { scanIDs = oldIDs }()
+	scanIDs = newScanIDGenerator(ScanIDConfig{Strategy: "uuidv7"})
+
+	payloads := intake(&Config{}, Payload{ItemID: "1", DeviceType: "scanner0"})
+	assert.Len(t, payloads, 1)
+	assert.Len(t, payloads[0].ScanID, 36)
+
+	// an ID given upstream is kept
+	payloads = intake(&Config{}, Payload{ItemID: "1", DeviceType: "scanner0", ScanID: "given"})
+	assert.Equal(t, "given", payloads[0].ScanID)
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// jsonSchema is the subset of JSON Schema used to validate outgoing payloads.
+// Supported keywords: type, enum, const, required, properties,
+// additionalProperties, items, minLength, maxLength, pattern, minimum, maximum.
+type jsonSchema struct {
+	Type                 interface{}            `json:"type"`
+	Enum                 []interface{}          `json:"enum"`
+	Const                interface{}            `json:"const"`
+	Required             []string               `json:"required"`
+	Properties           map[string]*jsonSchema `json:"properties"`
+	AdditionalProperties *bool                  `json:"additionalProperties"`
+	Items                *jsonSchema            `json:"items"`
+	MinLength            *int                   `json:"minLength"`
+	MaxLength            *int                   `json:"maxLength"`
+	Pattern              string                 `json:"pattern"`
+	Minimum              *float64               `json:"minimum"`
+	Maximum              *float64               `json:"maximum"`
+
+	pattern *regexp.Regexp
+}
+
+var payloadSchema *jsonSchema
+
+// loadPayloadSchema reads and compiles the schema outgoing payloads must satisfy
+func loadPayloadSchema(path string) (*jsonSchema, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var schema jsonSchema
+	if err := json.Unmarshal(data, &schema); err != nil {
+		return nil, err
+	}
+	if err := schema.compile(); err != nil {
+		return nil, err
+	}
+	return &schema, nil
+}
+
+func (s *jsonSchema) compile() error {
+	if s.Pattern != "" {
+		re, err := regexp.Compile(s.Pattern)
+		if err != nil {
+			return fmt.Errorf("invalid pattern %q: %v", s.Pattern, err)
+		}
+		s.pattern = re
+	}
+	for name, child := range s.Properties {
+		if child == nil {
+			return fmt.Errorf("property %s: schema must be an object, not null", name)
+		}
+		if err := child.compile(); err != nil {
+			return fmt.Errorf("property %s: %v", name, err)
+		}
+	}
+	if s.Items != nil {
+		return s.Items.compile()
+	}
+	return nil
+}
+
+// validatePayload checks a payload against the schema, returning every violation found
+func validatePayload(schema *jsonSchema, payload Payload) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	var doc interface{}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return err
+	}
+	var problems []string
+	schema.validate("$", doc, &problems)
+	if len(problems) > 0 {
+		return fmt.Errorf("schema validation failed: %s", strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+func (s *jsonSchema) validate(path string, value interface{}, problems *[]string) {
+	fail := func(format string, args ...interface{}) {
+		*problems = append(*problems, path+": "+fmt.Sprintf(format, args...))
+	}
+
+	if s.Type != nil && !s.matchesType(value) {
+		fail("expected type %v, got %s", s.Type, jsonType(value))
+		return
+	}
+	if s.Const != nil && !jsonEqual(s.Const, value) {
+		fail("must equal %v", s.Const)
+	}
+	if len(s.Enum) > 0 {
+		found := false
+		for _, e := range s.Enum {
+			if jsonEqual(e, value) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			fail("must be one of %v", s.Enum)
+		}
+	}
+
+	switch v := value.(type) {
+	case string:
+		length := len([]rune(v))
+		if s.MinLength != nil && length < *s.MinLength {
+			fail("length %d is shorter than %d", length, *s.MinLength)
+		}
+		if s.MaxLength != nil && length > *s.MaxLength {
+			fail("length %d is longer than %d", length, *s.MaxLength)
+		}
+		if s.pattern != nil && !s.pattern.MatchString(v) {
+			fail("does not match pattern %q", s.Pattern)
+		}
+	case float64:
+		if s.Minimum != nil && v < *s.Minimum {
+			fail("%v is less than minimum %v", v, *s.Minimum)
+		}
+		if s.Maximum != nil && v > *s.Maximum {
+			fail("%v is greater than maximum %v", v, *s.Maximum)
+		}
+	case map[string]interface{}:
+		for _, name := range s.Required {
+			if _, ok := v[name]; !ok {
+				fail("missing required property %q", name)
+			}
+		}
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			if child, ok := s.Properties[key]; ok {
+				child.validate(path+"."+key, v[key], problems)
+			} else if s.AdditionalProperties != nil && !*s.AdditionalProperties {
+				fail("unexpected property %q", key)
+			}
+		}
+	case []interface{}:
+		if s.Items != nil {
+			for i, item := range v {
+				s.Items.validate(fmt.Sprintf("%s[%d]", path, i), item, problems)
+			}
+		}
+	}
+}
+
+func (s *jsonSchema) matchesType(value interface{}) bool {
+	var types []string
+	switch t := s.Type.(type) {
+	case string:
+		types = []string{t}
+	case []interface{}:
+		for _, item := range t {
+			if name, ok := item.(string); ok {
+				types = append(types, name)
+			}
+		}
+	}
+	actual := jsonType(value)
+	for _, expected := range types {
+		if expected == actual || (expected == "number" && actual == "integer") {
+			return true
+		}
+	}
+	return false
+}
+
+func jsonType(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case string:
+		return "string"
+	case float64:
+		if v == math.Trunc(v) {
+			return "integer"
+		}
+		return "number"
+	case []interface{}:
+		return "array"
+	default:
+		return "object"
+	}
+}
+
+func jsonEqual(a, b interface{}) bool {
+	x, _ := json.Marshal(a)
+	y, _ := json.Marshal(b)
+	return string(x) == string(y)
+}
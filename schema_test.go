@@ -0,0 +1,120 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func writeSchema(t *testing.T, content string) *jsonSchema {
+	path := filepath.Join(t.TempDir(), "schema.json")
+	assert.NoError(t, os.WriteFile(path, []byte(content), 0644))
+	schema, err := loadPayloadSchema(path)
+	assert.NoError(t, err)
+	return schema
+}
+
+func TestValidatePayload_Valid(t *testing.T) {
+	schema := writeSchema(t, `{
+		"type": "object",
+		"required": ["itemid", "deviceType"],
+		"properties": {
+			"itemid": {"type": "string", "minLength": 4, "pattern": "^[0-9]+$"},
+			"deviceType": {"enum": ["keyboard", "scanner0"]}
+		},
+		"additionalProperties": false
+	}`)
+	assert.NoError(t, validatePayload(schema, Payload{ItemID: "12345", DeviceType: "scanner0"}))
+}
+
+func TestValidatePayload_Violations(t *testing.T) {
+	schema := writeSchema(t, `{
+		"type": "object",
+		"properties": {
+			"itemid": {"type": "string", "minLength": 4, "pattern": "^[0-9]+$"},
+			"deviceType": {"enum": ["keyboard"]}
+		}
+	}`)
+	err := validatePayload(schema, Payload{ItemID: "ab", DeviceType: "scanner0"})
+	assert.EqualError(t, err, `schema validation failed: $.deviceType: must be one of [keyboard]; `+
+		`$.itemid: length 2 is shorter than 4; $.itemid: does not match pattern "^[0-9]+$"`)
+}
+
+func TestLoadPayloadSchema_InvalidPattern(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "schema.json")
+	assert.NoError(t, os.WriteFile(path, []byte(`{"properties": {"itemid": {"pattern": "("}}}`), 0644))
+	_, err := loadPayloadSchema(path)
+	assert.Error(t, err)
+}
+
+func TestLoadPayloadSchema_NullProperty(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "schema.json")
+	assert.NoError(t, os.WriteFile(path, []byte(`{"properties": {"location": {"properties": {"lat": null}}}}`), 0644))
+	_, err := loadPayloadSchema(path)
+	assert.EqualError(t, err, "property location: property lat: schema must be an object, not null")
+}
+
+func TestDispatchPayload_SchemaViolationIsDeadLettered(t *testing.T) {
+	oldSchema := payloadSchema
+	defer func() { payloadSchema = oldSchema }()
+	payloadSchema = &jsonSchema{Required: []string{"missing"}}
+	defer os.Remove(deadLetterFile)
+
+	dispatchPayload(&Config{}, Payload{ItemID: "12345", DeviceType: "scanner0"})
+
+	data, err := os.ReadFile(deadLetterFile)
+	assert.NoError(t, err)
+	assert.Contains(t, string(data), `missing required property \"missing\"`)
+	assert.Contains(t, string(data), `"payload":{"itemid":"12345","deviceType":"scanner0"}`)
+}
+
+func TestDispatchPayload_SchemaSeesSequence(t *testing.T) {
+	useTempQueue(t)
+	oldSchema, oldSequences, oldPost := payloadSchema, sequences, httpPost
+	defer func() { payloadSchema, sequences, httpPost = oldSchema, oldSequences, oldPost }()
+	payloadSchema = &jsonSchema{Required: []string{"sequence"}}
+	sequences = newSequenceTracker(filepath.Join(t.TempDir(), "sequences.json"))
+	client := new(MockHTTPClient)
+	client.On("Post", "http://example.com/api", "application/json", mock.AnythingOfType("*main.deviceBody")).Return(&http.Response{
+		StatusCode: http.StatusOK,
+		Body:       io.NopCloser(strings.NewReader("")),
+	}, nil)
+	httpPost = client.Post
+
+	dispatchPayload(&Config{APIEndpoint: "http://example.com/api"}, Payload{ItemID: "12345", DeviceType: "scanner0"})
+
+	client.AssertExpectations(t)
+	_, err := os.Stat(deadLetterFile)
+	assert.True(t, os.IsNotExist(err), "the numbered scan passed the schema")
+}
+
+func TestDispatchPayload_SchemaChecksAnnotations(t *testing.T) {
+	useTempQueue(t)
+	oldSchema, oldAnnotations := payloadSchema, annotations
+	defer func() { payloadSchema, annotations = oldSchema, oldAnnotations }()
+	payloadSchema = writeSchema(t, `{
+		"properties": {"annotations": {"type": "array", "items": {"enum": ["damaged"]}}}
+	}`)
+	annotations = newAnnotator(AnnotationConfig{HoldSeconds: 60, Codes: map[string]string{"1": "relabeled"}})
+
+	done := make(chan struct{})
+	go func() {
+		dispatchPayload(&Config{}, Payload{ItemID: "12345", DeviceType: "scanner0"})
+		close(done)
+	}()
+	assert.Eventually(t, func() bool { return annotations.held() != nil }, time.Second, time.Millisecond)
+	assert.NoError(t, annotations.annotate("1"))
+	annotations.release()
+	<-done
+
+	data, err := os.ReadFile(deadLetterFile)
+	assert.NoError(t, err)
+	assert.Contains(t, string(data), `"annotations":["relabeled"]`)
+}
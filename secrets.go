@@ -0,0 +1,54 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+)
+
+// secretPath is where a credential is kept, under the state directory
+func secretPath(name string) string {
+	return filepath.Join(stateDir, "credentials", name)
+}
+
+// saveSecret stores a credential readable only by the service. On Windows it
+// is encrypted with DPAPI for the machine; elsewhere it relies on file permissions.
+func saveSecret(name string, data []byte) error {
+	protected, err := protectSecret(data)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(secretPath(name)), 0700); err != nil {
+		return err
+	}
+	return writeFileAtomicMode(secretPath(name), protected, 0600)
+}
+
+// loadSecret reads a credential stored by saveSecret
+func loadSecret(name string) ([]byte, error) {
+	data, err := os.ReadFile(secretPath(name))
+	if err != nil {
+		return nil, err
+	}
+	return unprotectSecret(data)
+}
+
+// bearerTransport adds the station's credentials to every request, with the
+// token of the device the request was made for when it has one
+type bearerTransport struct {
+	base      http.RoundTripper
+	tokens    *apiCredentials
+	stationID string
+}
+
+func (t *bearerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	device, _ := req.Context().Value(deviceKey{}).(string)
+	if token := t.tokens.forDevice(device); token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	if t.stationID != "" {
+		req.Header.Set("X-Station-ID", t.stationID)
+	}
+	return t.base.RoundTrip(req)
+}
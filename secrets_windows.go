@@ -0,0 +1,36 @@
+//go:build windows
+
+package main
+
+import (
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// protectSecret encrypts data with DPAPI for the local machine, so the service
+// account can read credentials stored by an administrator running enroll
+func protectSecret(data []byte) ([]byte, error) {
+	return dpapi(data, windows.CryptProtectData)
+}
+
+func unprotectSecret(data []byte) ([]byte, error) {
+	return dpapi(data, func(in *windows.DataBlob, name *uint16, entropy *windows.DataBlob, reserved uintptr, prompt *windows.CryptProtectPromptStruct, flags uint32, out *windows.DataBlob) error {
+		return windows.CryptUnprotectData(in, nil, entropy, reserved, prompt, flags, out)
+	})
+}
+
+type dpapiFunc func(in *windows.DataBlob, name *uint16, entropy *windows.DataBlob, reserved uintptr, prompt *windows.CryptProtectPromptStruct, flags uint32, out *windows.DataBlob) error
+
+func dpapi(data []byte, call dpapiFunc) ([]byte, error) {
+	if len(data) == 0 {
+		return nil, nil
+	}
+	in := windows.DataBlob{Size: uint32(len(data)), Data: &data[0]}
+	var out windows.DataBlob
+	if err := call(&in, nil, nil, 0, nil, windows.CRYPTPROTECT_UI_FORBIDDEN|windows.CRYPTPROTECT_LOCAL_MACHINE, &out); err != nil {
+		return nil, err
+	}
+	defer windows.LocalFree(windows.Handle(unsafe.Pointer(out.Data)))
+	return append([]byte(nil), unsafe.Slice(out.Data, out.Size)...), nil
+}
@@ -0,0 +1,354 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SequenceConfig represents per-device sequence numbers and the detection of
+// scans that were captured but never delivered
+type SequenceConfig struct {
+	Enabled bool `json:"enabled"`
+	// GraceMinutes is how long a sequence may stay undelivered outside failures.log before it is flagged missing (default 60)
+	GraceMinutes int `json:"graceMinutes"`
+	// DigestAt is the local time of the daily gap digest, such as "07:00" (the default)
+	DigestAt string `json:"digestAt"`
+}
+
+func (s SequenceConfig) withDefaults() SequenceConfig {
+	if s.GraceMinutes <= 0 {
+		s.GraceMinutes = 60
+	}
+	if s.DigestAt == "" {
+		s.DigestAt = "07:00"
+	}
+	return s
+}
+
+func (s SequenceConfig) validate() error {
+	if _, err := time.Parse("15:04", s.withDefaults().DigestAt); err != nil {
+		return fmt.Errorf("digestAt %q is not a time such as 07:00", s.DigestAt)
+	}
+	return nil
+}
+
+// nextDigest returns the first digest time after now
+func (s SequenceConfig) nextDigest(now time.Time) time.Time {
+	at, _ := time.Parse("15:04", s.withDefaults().DigestAt)
+	next := time.Date(now.Year(), now.Month(), now.Day(), at.Hour(), at.Minute(), 0, 0, now.Location())
+	if !next.After(now) {
+		next = next.AddDate(0, 0, 1)
+	}
+	return next
+}
+
+// SequenceGap is a sequence that was issued but neither delivered, queued nor dead-lettered
+type SequenceGap struct {
+	Device    string    `json:"device"`
+	Sequence  uint64    `json:"sequence"`
+	IssuedAt  time.Time `json:"issuedAt"`
+	FlaggedAt time.Time `json:"flaggedAt"`
+}
+
+// SequenceStatus reports the sequences of one device, so the backend can
+// check what it received against what the station issued
+type SequenceStatus struct {
+	Device  string   `json:"device"`
+	Last    uint64   `json:"last"`
+	Missing []uint64 `json:"missing,omitempty"`
+}
+
+// deviceSequences is the saved state of one device's sequence
+type deviceSequences struct {
+	Last uint64 `json:"last"`
+	// Reserved is the end of the block of numbers saved as used, so a block is
+	// only written once instead of every number
+	Reserved uint64 `json:"reserved,omitempty"`
+	// Pending maps sequences not yet delivered to when they were issued
+	Pending map[uint64]time.Time    `json:"pending,omitempty"`
+	Missing map[uint64]*SequenceGap `json:"missing,omitempty"`
+}
+
+const (
+	// missingRetention is how long a flagged gap is reported before it is forgotten
+	missingRetention = 30 * 24 * time.Hour
+	// sequenceBlock is how many numbers are reserved in sequences.json at a time
+	sequenceBlock = 100
+)
+
+// sequenceTracker numbers payloads per device and remembers the ones not yet
+// delivered. A nil tracker numbers nothing.
+type sequenceTracker struct {
+	path    string
+	mu      sync.Mutex
+	devices map[string]*deviceSequences
+	dirty   bool
+}
+
+var sequences *sequenceTracker
+
+func newSequenceTracker(path string) *sequenceTracker {
+	return &sequenceTracker{path: path, devices: map[string]*deviceSequences{}}
+}
+
+// loadSequences reads the sequences saved in the state directory
+func loadSequences() (*sequenceTracker, error) {
+	s := newSequenceTracker(filepath.Join(stateDir, "sequences.json"))
+	data, err := os.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		return s, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &s.devices); err != nil {
+		return nil, err
+	}
+	// numbers reserved before a restart may have been issued, so they are skipped
+	for name, d := range s.devices {
+		if d.Reserved > d.Last {
+			logger.Infof("Skipping sequences %d to %d of %s, reserved before the restart", d.Last+1, d.Reserved, name)
+			d.Last = d.Reserved
+		}
+	}
+	return s, nil
+}
+
+// device returns the state of a device, creating it on first sight. The caller holds s.mu.
+func (s *sequenceTracker) device(name string) *deviceSequences {
+	d, ok := s.devices[name]
+	if !ok {
+		d = &deviceSequences{}
+		s.devices[name] = d
+	}
+	if d.Pending == nil {
+		d.Pending = map[uint64]time.Time{}
+	}
+	if d.Missing == nil {
+		d.Missing = map[uint64]*SequenceGap{}
+	}
+	return d
+}
+
+// issue returns the next sequence of the device. Numbers are reserved in
+// blocks that are saved before the first of them is returned, so a number is
+// never issued twice across restarts.
+func (s *sequenceTracker) issue(device string, now time.Time) uint64 {
+	if s == nil {
+		return 0
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	d := s.device(device)
+	d.Last++
+	d.Pending[d.Last] = now
+	if d.Last > d.Reserved {
+		d.Reserved = d.Last + sequenceBlock - 1
+		s.write()
+	} else {
+		s.dirty = true
+	}
+	return d.Last
+}
+
+// settle records that a payload was delivered or dead-lettered, so its sequence is accounted for
+func (s *sequenceTracker) settle(payload Payload) {
+	if s == nil || payload.Sequence == 0 {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	d, ok := s.devices[payload.DeviceType]
+	if !ok {
+		return
+	}
+	if _, missing := d.Missing[payload.Sequence]; missing {
+		logger.Infof("Sequence %d of %s turned up after being flagged missing", payload.Sequence, payload.DeviceType)
+		delete(d.Missing, payload.Sequence)
+	} else if _, pending := d.Pending[payload.Sequence]; !pending {
+		return
+	}
+	delete(d.Pending, payload.Sequence)
+	s.dirty = true
+}
+
+// check flags the sequences pending longer than grace that are not waiting
+// in the queue, and returns the newly flagged gaps
+func (s *sequenceTracker) check(now time.Time, grace time.Duration, queued map[string]map[uint64]bool) []SequenceGap {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var flagged []SequenceGap
+	changed := false
+	for name, d := range s.devices {
+		for seq, issuedAt := range d.Pending {
+			if now.Sub(issuedAt) < grace || queued[name][seq] {
+				continue
+			}
+			gap := &SequenceGap{Device: name, Sequence: seq, IssuedAt: issuedAt, FlaggedAt: now}
+			d.Missing[seq] = gap
+			delete(d.Pending, seq)
+			flagged = append(flagged, *gap)
+			changed = true
+		}
+		for seq, gap := range d.Missing {
+			if now.Sub(gap.FlaggedAt) >= missingRetention {
+				delete(d.Missing, seq)
+				changed = true
+			}
+		}
+	}
+	if changed {
+		s.write()
+	}
+	sortGaps(flagged)
+	return flagged
+}
+
+// gaps returns every sequence currently flagged missing
+func (s *sequenceTracker) gaps() []SequenceGap {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var result []SequenceGap
+	for _, d := range s.devices {
+		for _, gap := range d.Missing {
+			result = append(result, *gap)
+		}
+	}
+	sortGaps(result)
+	return result
+}
+
+// status returns the last issued and missing sequences of every device
+func (s *sequenceTracker) status() []SequenceStatus {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var result []SequenceStatus
+	for name, d := range s.devices {
+		status := SequenceStatus{Device: name, Last: d.Last}
+		for seq := range d.Missing {
+			status.Missing = append(status.Missing, seq)
+		}
+		sort.Slice(status.Missing, func(i, j int) bool { return status.Missing[i] < status.Missing[j] })
+		result = append(result, status)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Device < result[j].Device })
+	return result
+}
+
+// save writes the pending and settled sequences if they changed since the
+// last write. It runs every minute and on shutdown.
+func (s *sequenceTracker) save() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.dirty {
+		s.write()
+	}
+}
+
+// write saves the sequences. The caller holds s.mu.
+func (s *sequenceTracker) write() {
+	data, err := json.Marshal(s.devices)
+	if err != nil {
+		logger.Errorf("Error marshaling sequences: %v", err)
+		return
+	}
+	if err := writeFileAtomic(s.path, data); err != nil {
+		logger.Errorf("Error saving sequences to %s: %v", s.path, err)
+		return
+	}
+	s.dirty = false
+}
+
+func sortGaps(gaps []SequenceGap) {
+	sort.Slice(gaps, func(i, j int) bool {
+		if gaps[i].Device != gaps[j].Device {
+			return gaps[i].Device < gaps[j].Device
+		}
+		return gaps[i].Sequence < gaps[j].Sequence
+	})
+}
+
+// queuedSequences returns the sequences waiting in failures.log, by device
+func queuedSequences() map[string]map[uint64]bool {
+	queued := map[string]map[uint64]bool{}
+	data, err := os.ReadFile(failuresFile)
+	if err != nil {
+		return queued
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		payload, _, err := decodeQueueEntry(line)
+		if err != nil || payload.Sequence == 0 {
+			continue
+		}
+		if queued[payload.DeviceType] == nil {
+			queued[payload.DeviceType] = map[uint64]bool{}
+		}
+		queued[payload.DeviceType][payload.Sequence] = true
+	}
+	return queued
+}
+
+// sequenceDigest summarizes the missing sequences for the daily digest
+func sequenceDigest(gaps []SequenceGap, now time.Time) string {
+	if len(gaps) == 0 {
+		return "No sequences are missing."
+	}
+	recent := 0
+	var b strings.Builder
+	for _, gap := range gaps {
+		if now.Sub(gap.FlaggedAt) < 24*time.Hour {
+			recent++
+		}
+		fmt.Fprintf(&b, "%s sequence %d, issued %s\n", gap.Device, gap.Sequence, gap.IssuedAt.Format(time.RFC3339))
+	}
+	return fmt.Sprintf("%d sequences are missing, %d of them flagged in the last 24 hours. These scans were captured but never delivered, queued or dead-lettered:\n\n%s", len(gaps), recent, b.String())
+}
+
+// watchSequences flags missing sequences every minute and sends the daily digest
+func watchSequences(config *Config) {
+	sequenceConfig := config.Sequence.withDefaults()
+	grace := time.Duration(sequenceConfig.GraceMinutes) * time.Minute
+	nextDigest := sequenceConfig.nextDigest(time.Now())
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	for now := range ticker.C {
+		sequences.save()
+		for _, gap := range sequences.check(now, grace, queuedSequences()) {
+			logger.Warnf("Sequence %d of %s, issued at %s, was never delivered", gap.Sequence, gap.Device, gap.IssuedAt.Format(time.RFC3339))
+			receipts.recordMissing(gap)
+		}
+		if now.Before(nextDigest) {
+			continue
+		}
+		nextDigest = sequenceConfig.nextDigest(now)
+		gaps := sequences.gaps()
+		digest := sequenceDigest(gaps, now)
+		logger.Infof("Daily sequence digest: %s", digest)
+		if len(gaps) > 0 && config.Alerts.enabled() {
+			alert := Alert{Key: "sequence-digest", Subject: fmt.Sprintf("%d scans missing", len(gaps)), Body: digest}
+			if err := sendAlert(config.Alerts.SMTP, alert); err != nil {
+				logger.Errorf("Error sending sequence digest: %v", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSequenceTracker_FlagsUndelivered(t *testing.T) {
+	oldStateDir := stateDir
+	defer func() { stateDir = oldStateDir }()
+	stateDir = t.TempDir()
+	s := newSequenceTracker(filepath.Join(stateDir, "sequences.json"))
+	start := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	for i := 0; i < 4; i++ {
+		s.issue("scanner0", start)
+	}
+	assert.Equal(t, uint64(1), s.issue("scanner1", start))
+	s.settle(Payload{DeviceType: "scanner0", Sequence: 1})
+	s.settle(Payload{DeviceType: "scanner0", Sequence: 3})
+	s.settle(Payload{DeviceType: "scanner1", Sequence: 1})
+
+	// nothing is flagged within the grace period, and queued payloads are pending, not missing
+	assert.Empty(t, s.check(start.Add(30*time.Minute), time.Hour, nil))
+	queued := map[string]map[uint64]bool{"scanner0": {4: true}}
+	flagged := s.check(start.Add(2*time.Hour), time.Hour, queued)
+	assert.Equal(t, []SequenceGap{{Device: "scanner0", Sequence: 2, IssuedAt: start, FlaggedAt: start.Add(2 * time.Hour)}}, flagged)
+	assert.Empty(t, s.check(start.Add(3*time.Hour), time.Hour, queued))
+
+	assert.Equal(t, []SequenceStatus{{Device: "scanner0", Last: 4, Missing: []uint64{2}}, {Device: "scanner1", Last: 1}}, s.status())
+
+	// the numbers and gaps survive a restart
+	loaded, err := loadSequences()
+	assert.NoError(t, err)
+	assert.Equal(t, flagged, loaded.gaps())
+	assert.Equal(t, uint64(101), loaded.issue("scanner0", start), "the rest of the reserved block is skipped")
+
+	// a flagged sequence that turns up after all is no longer missing
+	loaded.settle(Payload{DeviceType: "scanner0", Sequence: 2})
+	assert.Empty(t, loaded.gaps())
+}
+
+func TestSequenceTracker_ReservesBlocks(t *testing.T) {
+	oldStateDir := stateDir
+	defer func() { stateDir = oldStateDir }()
+	stateDir = t.TempDir()
+	s := newSequenceTracker(filepath.Join(stateDir, "sequences.json"))
+	start := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	// only the first number of a block is written before it is issued
+	s.issue("scanner0", start)
+	info, err := os.Stat(s.path)
+	assert.NoError(t, err)
+	for i := 0; i < 99; i++ {
+		s.issue("scanner0", start)
+	}
+	s.settle(Payload{DeviceType: "scanner0", Sequence: 1})
+	after, err := os.Stat(s.path)
+	assert.NoError(t, err)
+	assert.Equal(t, info.ModTime(), after.ModTime())
+	assert.True(t, s.dirty)
+
+	s.save()
+	loaded, err := loadSequences()
+	assert.NoError(t, err)
+	assert.Len(t, loaded.devices["scanner0"].Pending, 99)
+	assert.Equal(t, uint64(101), s.issue("scanner0", start))
+	assert.Equal(t, uint64(200), s.devices["scanner0"].Reserved)
+}
+
+func TestQueuedSequences(t *testing.T) {
+	useTempQueue(t)
+	logFailure(Payload{ItemID: "12345", DeviceType: "scanner0", Sequence: 7})
+	logFailure(Payload{ItemID: "12346", DeviceType: "scanner0"})
+
+	assert.Equal(t, map[string]map[uint64]bool{"scanner0": {7: true}}, queuedSequences())
+}
+
+func TestSequenceConfig_NextDigest(t *testing.T) {
+	config := SequenceConfig{}
+	assert.NoError(t, config.validate())
+	now := time.Date(2024, 5, 1, 6, 30, 0, 0, time.UTC)
+	assert.Equal(t, time.Date(2024, 5, 1, 7, 0, 0, 0, time.UTC), config.nextDigest(now))
+	assert.Equal(t, time.Date(2024, 5, 2, 7, 0, 0, 0, time.UTC), config.nextDigest(now.Add(time.Hour)))
+
+	assert.Error(t, SequenceConfig{DigestAt: "7am"}.validate())
+}
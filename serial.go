@@ -0,0 +1,64 @@
+package main
+
+import (
+	"io"
+	"sync"
+)
+
+// SerialOutputConfig represents re-emitting scans on a serial port for legacy
+// applications that read scanners over serial. Prefix and suffix frame each
+// barcode, e.g. "\u0002" and "\u0003" for STX/ETX framing.
+type SerialOutputConfig struct {
+	// Port is the COM port to write to on Windows (one end of a virtual
+	// null-modem pair such as com0com), or the path at which a pseudo-terminal
+	// is created on Linux
+	Port   string  `json:"port"`
+	Prefix string  `json:"prefix"`
+	Suffix *string `json:"suffix"`
+}
+
+var (
+	serialOutput   io.WriteCloser
+	serialOutputMu sync.Mutex
+)
+
+// serialDevicePath returns the path to open for a port name such as COM10 or /dev/ttyACM0
+func serialDevicePath(port string) string {
+	if len(port) > 0 && port[0] != '\\' && port[0] != '/' {
+		// COM ports above COM9 are only reachable through the device namespace
+		return `\\.\` + port
+	}
+	return port
+}
+
+func (s SerialOutputConfig) suffix() string {
+	if s.Suffix == nil {
+		return "\r"
+	}
+	return *s.Suffix
+}
+
+// startSerialOutput opens the configured serial port
+func startSerialOutput(config *Config) {
+	port, err := openSerialOutput(config.SerialOutput.Port)
+	if err != nil {
+		logger.Errorf("Error opening serial output %s: %v", config.SerialOutput.Port, err)
+		return
+	}
+	serialOutput = port
+	logger.Infof("Writing scans to serial port %s", config.SerialOutput.Port)
+}
+
+// writeSerialOutput writes the framed, cleaned barcode to the serial port
+func writeSerialOutput(config *Config, payload Payload) {
+	serialOutputMu.Lock()
+	defer serialOutputMu.Unlock()
+	if serialOutput == nil {
+		return
+	}
+	payload.CleanItemId()
+	frame := config.SerialOutput.Prefix + payload.ItemID + config.SerialOutput.suffix()
+	if _, err := io.WriteString(serialOutput, frame); err != nil {
+		logger.Errorf("Error writing payload %v to serial port: %v", payload, err)
+	}
+}
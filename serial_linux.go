@@ -0,0 +1,76 @@
+//go:build linux
+
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// ptyOutput is the master side of a pseudo-terminal whose slave is linked at
+// the configured port path
+type ptyOutput struct {
+	master *os.File
+	slave  *os.File
+	link   string
+}
+
+// openSerialOutput creates a raw pseudo-terminal and links its slave device at path
+func openSerialOutput(path string) (*ptyOutput, error) {
+	master, err := os.OpenFile("/dev/ptmx", os.O_RDWR|unix.O_NOCTTY|unix.O_NONBLOCK, 0)
+	if err != nil {
+		return nil, err
+	}
+	fd := int(master.Fd())
+	if err := unix.IoctlSetPointerInt(fd, unix.TIOCSPTLCK, 0); err != nil {
+		master.Close()
+		return nil, err
+	}
+	n, err := unix.IoctlGetInt(fd, unix.TIOCGPTN)
+	if err != nil {
+		master.Close()
+		return nil, err
+	}
+	slavePath := fmt.Sprintf("/dev/pts/%d", n)
+
+	// keep the slave open so writes do not fail while no application is attached,
+	// and put it in raw mode so framing bytes like CR pass through untranslated
+	slave, err := os.OpenFile(slavePath, os.O_RDWR|unix.O_NOCTTY, 0)
+	if err != nil {
+		master.Close()
+		return nil, err
+	}
+	termios, err := unix.IoctlGetTermios(int(slave.Fd()), unix.TCGETS)
+	if err == nil {
+		termios.Iflag &^= unix.IGNBRK | unix.BRKINT | unix.PARMRK | unix.ISTRIP | unix.INLCR | unix.IGNCR | unix.ICRNL | unix.IXON
+		termios.Oflag &^= unix.OPOST
+		termios.Lflag &^= unix.ECHO | unix.ECHONL | unix.ICANON | unix.ISIG | unix.IEXTEN
+		err = unix.IoctlSetTermios(int(slave.Fd()), unix.TCSETS, termios)
+	}
+	if err != nil {
+		master.Close()
+		slave.Close()
+		return nil, err
+	}
+
+	os.Remove(path)
+	if err := os.Symlink(slavePath, path); err != nil {
+		master.Close()
+		slave.Close()
+		return nil, err
+	}
+	return &ptyOutput{master: master, slave: slave, link: path}, nil
+}
+
+// Write fails instead of blocking once the terminal buffer is full because no application is reading
+func (p *ptyOutput) Write(b []byte) (int, error) {
+	return p.master.Write(b)
+}
+
+func (p *ptyOutput) Close() error {
+	os.Remove(p.link)
+	p.slave.Close()
+	return p.master.Close()
+}
@@ -0,0 +1,36 @@
+//go:build linux
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSerialOutput_PTY(t *testing.T) {
+	link := filepath.Join(t.TempDir(), "ttyScanner")
+	port, err := openSerialOutput(link)
+	if err != nil {
+		t.Skipf("pseudo-terminals not available: %v", err)
+	}
+	oldOutput := serialOutput
+	defer func() { serialOutput = oldOutput }()
+	serialOutput = port
+	defer port.Close()
+
+	reader, err := os.Open(link)
+	assert.NoError(t, err)
+	defer reader.Close()
+
+	etx := "\u0003\r\n"
+	config := &Config{SerialOutput: SerialOutputConfig{Port: link, Prefix: "\u0002", Suffix: &etx}}
+	writeSerialOutput(config, Payload{ItemID: "prefix?id=12345", DeviceType: "scanner0"})
+
+	buf := make([]byte, 64)
+	n, err := reader.Read(buf)
+	assert.NoError(t, err)
+	assert.Equal(t, "\u000212345\u0003\r\n", string(buf[:n]))
+}
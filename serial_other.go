@@ -0,0 +1,10 @@
+//go:build !linux
+
+package main
+
+import "os"
+
+// openSerialOutput opens an existing serial port such as COM10 for writing
+func openSerialOutput(port string) (*os.File, error) {
+	return os.OpenFile(serialDevicePath(port), os.O_WRONLY, 0)
+}
@@ -0,0 +1,387 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SNMPConfig represents the configuration for the embedded SNMP agent
+type SNMPConfig struct {
+	Listen    string `json:"listen"`
+	Community string `json:"community"`
+	BaseOID   string `json:"baseOid"`
+}
+
+const defaultSNMPBaseOID = "1.3.6.1.4.1.8072.9999.1"
+
+// BER tags used by SNMPv1/v2c
+const (
+	berInteger        = 0x02
+	berOctetString    = 0x04
+	berNull           = 0x05
+	berOID            = 0x06
+	berSequence       = 0x30
+	berCounter32      = 0x41
+	berGauge32        = 0x42
+	berNoSuchObject   = 0x80
+	berEndOfMibView   = 0x82
+	pduGetRequest     = 0xa0
+	pduGetNextRequest = 0xa1
+	pduResponse       = 0xa2
+	snmpNoSuchName    = 2
+)
+
+type oid []int
+
+type snmpVar struct {
+	oid   oid
+	tag   byte
+	value []byte
+}
+
+type snmpRequest struct {
+	version   int
+	community string
+	pduType   byte
+	requestID int
+	oids      []oid
+}
+
+func parseOID(s string) (oid, error) {
+	var o oid
+	for _, part := range strings.Split(strings.Trim(s, "."), ".") {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid OID %q", s)
+		}
+		o = append(o, n)
+	}
+	if len(o) < 2 {
+		return nil, fmt.Errorf("invalid OID %q", s)
+	}
+	return o, nil
+}
+
+func (o oid) String() string {
+	parts := make([]string, len(o))
+	for i, n := range o {
+		parts[i] = strconv.Itoa(n)
+	}
+	return strings.Join(parts, ".")
+}
+
+func (o oid) child(arcs ...int) oid {
+	c := make(oid, 0, len(o)+len(arcs))
+	return append(append(c, o...), arcs...)
+}
+
+func (o oid) compare(other oid) int {
+	for i := 0; i < len(o) && i < len(other); i++ {
+		if o[i] != other[i] {
+			if o[i] < other[i] {
+				return -1
+			}
+			return 1
+		}
+	}
+	return len(o) - len(other)
+}
+
+// snmpTable builds the sorted list of health OIDs exposed by the agent
+func snmpTable(config *Config) ([]snmpVar, error) {
+	base, err := parseOID(config.SNMP.baseOID())
+	if err != nil {
+		return nil, err
+	}
+
+	health.mu.Lock()
+	scans, succeeded, failed := health.scansReceived, health.postsSucceeded, health.postsFailed
+	statuses := make([]int, config.NumberOfScanners)
+	for i := range statuses {
+		statuses[i] = 1
+		if _, missing := health.deviceMissingSince[i]; missing {
+			statuses[i] = 2
+		}
+	}
+	health.mu.Unlock()
+
+	vars := []snmpVar{
+		{base.child(1, 0), berCounter32, encodeUint(uint64(scans))},
+		{base.child(2, 0), berCounter32, encodeUint(uint64(succeeded))},
+		{base.child(3, 0), berCounter32, encodeUint(uint64(failed))},
+		{base.child(4, 0), berGauge32, encodeUint(uint64(queueDepth()))},
+		{base.child(5, 0), berInteger, encodeInt(config.NumberOfScanners)},
+		{base.child(7, 0), berGauge32, encodeUint(uint64(backlogAge(time.Now()).Seconds()))},
+	}
+	for i, status := range statuses {
+		vars = append(vars, snmpVar{base.child(6, i+1), berInteger, encodeInt(status)})
+	}
+	sort.Slice(vars, func(i, j int) bool { return vars[i].oid.compare(vars[j].oid) < 0 })
+	return vars, nil
+}
+
+func (c SNMPConfig) baseOID() string {
+	if c.BaseOID == "" {
+		return defaultSNMPBaseOID
+	}
+	return c.BaseOID
+}
+
+func (c SNMPConfig) community() string {
+	if c.Community == "" {
+		return "public"
+	}
+	return c.Community
+}
+
+// serveSNMP answers SNMPv1/v2c GET and GETNEXT requests for the health OIDs
+func serveSNMP(config *Config) {
+	conn, err := net.ListenPacket("udp", config.SNMP.Listen)
+	if err != nil {
+		logger.Errorf("Error starting SNMP agent: %v", err)
+		return
+	}
+	defer conn.Close()
+	logger.Infof("SNMP agent listening on %s", config.SNMP.Listen)
+
+	buf := make([]byte, 65535)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			logger.Errorf("Error reading SNMP request: %v", err)
+			continue
+		}
+		resp, err := handleSNMP(config, buf[:n])
+		if err != nil {
+			logger.Debugf("Ignoring SNMP request from %v: %v", addr, err)
+			continue
+		}
+		if _, err := conn.WriteTo(resp, addr); err != nil {
+			logger.Errorf("Error writing SNMP response: %v", err)
+		}
+	}
+}
+
+// handleSNMP decodes a request packet and returns the encoded response
+func handleSNMP(config *Config, packet []byte) ([]byte, error) {
+	req, err := decodeSNMPRequest(packet)
+	if err != nil {
+		return nil, err
+	}
+	if req.community != config.SNMP.community() {
+		return nil, errors.New("community mismatch")
+	}
+	table, err := snmpTable(config)
+	if err != nil {
+		return nil, err
+	}
+
+	errorStatus, errorIndex := 0, 0
+	vars := make([]snmpVar, len(req.oids))
+	for i, requested := range req.oids {
+		found := false
+		for _, v := range table {
+			cmp := v.oid.compare(requested)
+			if (req.pduType == pduGetRequest && cmp == 0) || (req.pduType == pduGetNextRequest && cmp > 0) {
+				vars[i], found = v, true
+				break
+			}
+		}
+		if found {
+			continue
+		}
+		vars[i] = snmpVar{oid: requested, tag: berNoSuchObject}
+		if req.pduType == pduGetNextRequest {
+			vars[i].tag = berEndOfMibView
+		}
+		if req.version == 0 && errorStatus == 0 {
+			errorStatus, errorIndex = snmpNoSuchName, i+1
+		}
+	}
+	if req.version == 0 && errorStatus != 0 {
+		// SNMPv1 has no exception values, the request is echoed back with an error
+		for i, requested := range req.oids {
+			vars[i] = snmpVar{oid: requested, tag: berNull}
+		}
+	}
+	return encodeSNMPResponse(req, errorStatus, errorIndex, vars), nil
+}
+
+func decodeSNMPRequest(packet []byte) (*snmpRequest, error) {
+	tag, msg, _, err := readTLV(packet)
+	if err != nil || tag != berSequence {
+		return nil, errors.New("malformed message")
+	}
+	req := &snmpRequest{}
+	tag, value, msg, err := readTLV(msg)
+	if err != nil || tag != berInteger {
+		return nil, errors.New("malformed version")
+	}
+	req.version = decodeInt(value)
+	if req.version != 0 && req.version != 1 {
+		return nil, fmt.Errorf("unsupported SNMP version %d", req.version)
+	}
+	tag, value, msg, err = readTLV(msg)
+	if err != nil || tag != berOctetString {
+		return nil, errors.New("malformed community")
+	}
+	req.community = string(value)
+	req.pduType, msg, _, err = readTLV(msg)
+	if err != nil {
+		return nil, err
+	}
+	if req.pduType != pduGetRequest && req.pduType != pduGetNextRequest {
+		return nil, fmt.Errorf("unsupported PDU type 0x%x", req.pduType)
+	}
+	tag, value, msg, err = readTLV(msg)
+	if err != nil || tag != berInteger {
+		return nil, errors.New("malformed request id")
+	}
+	req.requestID = decodeInt(value)
+	// skip error-status and error-index
+	for i := 0; i < 2; i++ {
+		if _, _, msg, err = readTLV(msg); err != nil {
+			return nil, err
+		}
+	}
+	tag, varbinds, _, err := readTLV(msg)
+	if err != nil || tag != berSequence {
+		return nil, errors.New("malformed varbind list")
+	}
+	for len(varbinds) > 0 {
+		var varbind []byte
+		if tag, varbind, varbinds, err = readTLV(varbinds); err != nil || tag != berSequence {
+			return nil, errors.New("malformed varbind")
+		}
+		if tag, value, _, err = readTLV(varbind); err != nil || tag != berOID {
+			return nil, errors.New("malformed varbind OID")
+		}
+		o, err := decodeOID(value)
+		if err != nil {
+			return nil, err
+		}
+		req.oids = append(req.oids, o)
+	}
+	return req, nil
+}
+
+func encodeSNMPResponse(req *snmpRequest, errorStatus, errorIndex int, vars []snmpVar) []byte {
+	var varbinds []byte
+	for _, v := range vars {
+		varbinds = append(varbinds, encodeTLV(berSequence, append(encodeTLV(berOID, encodeOID(v.oid)), encodeTLV(v.tag, v.value)...))...)
+	}
+	pdu := bytes.Join([][]byte{
+		encodeTLV(berInteger, encodeInt(req.requestID)),
+		encodeTLV(berInteger, encodeInt(errorStatus)),
+		encodeTLV(berInteger, encodeInt(errorIndex)),
+		encodeTLV(berSequence, varbinds),
+	}, nil)
+	msg := bytes.Join([][]byte{
+		encodeTLV(berInteger, encodeInt(req.version)),
+		encodeTLV(berOctetString, []byte(req.community)),
+		encodeTLV(pduResponse, pdu),
+	}, nil)
+	return encodeTLV(berSequence, msg)
+}
+
+// readTLV splits the first BER tag-length-value off b
+func readTLV(b []byte) (tag byte, value, rest []byte, err error) {
+	if len(b) < 2 {
+		return 0, nil, nil, errors.New("short BER element")
+	}
+	tag, length, offset := b[0], int(b[1]), 2
+	if length&0x80 != 0 {
+		n := length & 0x7f
+		if n == 0 || n > 4 || len(b) < 2+n {
+			return 0, nil, nil, errors.New("invalid BER length")
+		}
+		length = 0
+		for _, c := range b[2 : 2+n] {
+			length = length<<8 | int(c)
+		}
+		offset += n
+	}
+	if length < 0 || len(b) < offset+length {
+		return 0, nil, nil, errors.New("truncated BER element")
+	}
+	return tag, b[offset : offset+length], b[offset+length:], nil
+}
+
+func encodeTLV(tag byte, value []byte) []byte {
+	out := []byte{tag}
+	switch n := len(value); {
+	case n < 0x80:
+		out = append(out, byte(n))
+	case n <= 0xff:
+		out = append(out, 0x81, byte(n))
+	default:
+		out = append(out, 0x82, byte(n>>8), byte(n))
+	}
+	return append(out, value...)
+}
+
+func encodeInt(n int) []byte {
+	out := []byte{byte(n)}
+	for (n > 127 || n < -128) && len(out) < 8 {
+		n >>= 8
+		out = append([]byte{byte(n)}, out...)
+	}
+	return out
+}
+
+func decodeInt(b []byte) int {
+	n := 0
+	for i, c := range b {
+		if i == 0 && c&0x80 != 0 {
+			n = -1
+		}
+		n = n<<8 | int(c)
+	}
+	return n
+}
+
+func encodeUint(n uint64) []byte {
+	out := []byte{byte(n)}
+	for n > 0xff {
+		n >>= 8
+		out = append([]byte{byte(n)}, out...)
+	}
+	if out[0]&0x80 != 0 {
+		out = append([]byte{0}, out...)
+	}
+	return out
+}
+
+func encodeOID(o oid) []byte {
+	out := []byte{byte(o[0]*40 + o[1])}
+	for _, arc := range o[2:] {
+		chunk := []byte{byte(arc & 0x7f)}
+		for arc >>= 7; arc > 0; arc >>= 7 {
+			chunk = append([]byte{byte(arc&0x7f) | 0x80}, chunk...)
+		}
+		out = append(out, chunk...)
+	}
+	return out
+}
+
+func decodeOID(b []byte) (oid, error) {
+	if len(b) == 0 {
+		return nil, errors.New("empty OID")
+	}
+	o := oid{int(b[0]) / 40, int(b[0]) % 40}
+	arc := 0
+	for _, c := range b[1:] {
+		arc = arc<<7 | int(c&0x7f)
+		if c&0x80 == 0 {
+			o = append(o, arc)
+			arc = 0
+		}
+	}
+	return o, nil
+}
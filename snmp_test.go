@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func buildSNMPRequest(version int, community string, pduType byte, oids ...oid) []byte {
+	var varbinds []byte
+	for _, o := range oids {
+		varbinds = append(varbinds, encodeTLV(berSequence, append(encodeTLV(berOID, encodeOID(o)), encodeTLV(berNull, nil)...))...)
+	}
+	pdu := bytes.Join([][]byte{
+		encodeTLV(berInteger, encodeInt(42)),
+		encodeTLV(berInteger, encodeInt(0)),
+		encodeTLV(berInteger, encodeInt(0)),
+		encodeTLV(berSequence, varbinds),
+	}, nil)
+	return encodeTLV(berSequence, bytes.Join([][]byte{
+		encodeTLV(berInteger, encodeInt(version)),
+		encodeTLV(berOctetString, []byte(community)),
+		encodeTLV(pduType, pdu),
+	}, nil))
+}
+
+// firstVarbind decodes the OID and value of the first varbind in a response
+func firstVarbind(t *testing.T, resp []byte) (oid, byte, []byte) {
+	_, msg, _, err := readTLV(resp)
+	assert.NoError(t, err)
+	_, _, msg, _ = readTLV(msg)
+	_, _, msg, _ = readTLV(msg)
+	tag, pdu, _, _ := readTLV(msg)
+	assert.Equal(t, byte(pduResponse), tag)
+	for i := 0; i < 3; i++ {
+		_, _, pdu, _ = readTLV(pdu)
+	}
+	_, varbinds, _, _ := readTLV(pdu)
+	_, varbind, _, _ := readTLV(varbinds)
+	_, oidBytes, rest, _ := readTLV(varbind)
+	tag, value, _, _ := readTLV(rest)
+	o, err := decodeOID(oidBytes)
+	assert.NoError(t, err)
+	return o, tag, value
+}
+
+func TestOIDEncoding(t *testing.T) {
+	o, err := parseOID("1.3.6.1.4.1.8072.9999.1")
+	assert.NoError(t, err)
+	decoded, err := decodeOID(encodeOID(o))
+	assert.NoError(t, err)
+	assert.Equal(t, o, decoded)
+	assert.Equal(t, "1.3.6.1.4.1.8072.9999.1", decoded.String())
+}
+
+func TestHandleSNMP_Get(t *testing.T) {
+	health = &healthState{deviceMissingSince: map[int]time.Time{}}
+	recordScan()
+	recordScan()
+	config := &Config{NumberOfScanners: 1}
+	base, _ := parseOID(defaultSNMPBaseOID)
+
+	resp, err := handleSNMP(config, buildSNMPRequest(1, "public", pduGetRequest, base.child(1, 0)))
+	assert.NoError(t, err)
+	o, tag, value := firstVarbind(t, resp)
+	assert.Equal(t, base.child(1, 0), o)
+	assert.Equal(t, byte(berCounter32), tag)
+	assert.Equal(t, 2, decodeInt(value))
+}
+
+func TestHandleSNMP_GetNextWalksDeviceStatus(t *testing.T) {
+	health = &healthState{deviceMissingSince: map[int]time.Time{}}
+	markDeviceMissing(0)
+	config := &Config{NumberOfScanners: 1}
+	base, _ := parseOID(defaultSNMPBaseOID)
+
+	resp, err := handleSNMP(config, buildSNMPRequest(1, "public", pduGetNextRequest, base.child(5, 0)))
+	assert.NoError(t, err)
+	o, _, value := firstVarbind(t, resp)
+	assert.Equal(t, base.child(6, 1), o)
+	assert.Equal(t, 2, decodeInt(value))
+
+	resp, err = handleSNMP(config, buildSNMPRequest(1, "public", pduGetNextRequest, base.child(6, 1)))
+	assert.NoError(t, err)
+	o, tag, _ := firstVarbind(t, resp)
+	assert.Equal(t, base.child(7, 0), o)
+	assert.Equal(t, byte(berGauge32), tag)
+
+	resp, err = handleSNMP(config, buildSNMPRequest(1, "public", pduGetNextRequest, base.child(7, 0)))
+	assert.NoError(t, err)
+	_, tag, _ = firstVarbind(t, resp)
+	assert.Equal(t, byte(berEndOfMibView), tag)
+}
+
+func TestHandleSNMP_WrongCommunity(t *testing.T) {
+	config := &Config{SNMP: SNMPConfig{Community: "secret"}}
+	_, err := handleSNMP(config, buildSNMPRequest(1, "public", pduGetRequest, oid{1, 3, 6}))
+	assert.Error(t, err)
+}
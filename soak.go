@@ -0,0 +1,179 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"runtime"
+	"sync/atomic"
+	"time"
+)
+
+// SoakOptions controls a soak run
+type SoakOptions struct {
+	Rate     float64
+	Duration time.Duration
+	Report   time.Duration
+	// Endpoint receives the posts; empty uses a local stub that accepts everything
+	Endpoint string
+	// MaxGoroutineGrowth fails the run when goroutines grow by more than this; 0 disables it
+	MaxGoroutineGrowth int
+	// Settle is how long in-flight posts get to finish before the final measurement
+	Settle time.Duration
+}
+
+// SoakSample is one periodic measurement of a soak run
+type SoakSample struct {
+	Elapsed    time.Duration `json:"elapsed"`
+	Generated  uint64        `json:"generated"`
+	Delivered  uint32        `json:"delivered"`
+	Failed     uint32        `json:"failed"`
+	QueueDepth int           `json:"queueDepth"`
+	HeapBytes  uint64        `json:"heapBytes"`
+	Goroutines int           `json:"goroutines"`
+}
+
+// SoakReport summarizes a soak run
+type SoakReport struct {
+	Start           SoakSample `json:"start"`
+	End             SoakSample `json:"end"`
+	HeapGrowth      int64      `json:"heapGrowth"`
+	GoroutineGrowth int        `json:"goroutineGrowth"`
+}
+
+func soakSample(start time.Time, generated uint64) SoakSample {
+	runtime.GC()
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	health.mu.Lock()
+	delivered, failed := health.postsSucceeded, health.postsFailed
+	health.mu.Unlock()
+	return SoakSample{
+		Elapsed:    time.Since(start).Round(time.Second),
+		Generated:  generated,
+		Delivered:  delivered,
+		Failed:     failed,
+		QueueDepth: queueDepth(),
+		HeapBytes:  mem.HeapAlloc,
+		Goroutines: runtime.NumGoroutine(),
+	}
+}
+
+// soak pushes synthetic scans through the pipeline at a steady rate and
+// measures memory, goroutines and delivery as it goes
+func soak(config *Config, opts SoakOptions) SoakReport {
+	if opts.Endpoint == "" {
+		stub := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+		defer stub.Close()
+		opts.Endpoint = stub.URL
+	}
+	config.APIEndpoint = opts.Endpoint
+	// the side outputs in config.json are production systems, so synthetic
+	// scans only ever go to the endpoint above
+	outputsMu.Lock()
+	httpOutputs = nil
+	outputsMu.Unlock()
+	mirror = nil
+	if config.Batching.Enabled {
+		config.Batching.Endpoint = opts.Endpoint
+		apiBatcher = newBatcher(config.Batching.withDefaults(config.APIEndpoint))
+		go apiBatcher.run()
+	}
+
+	var generated uint64
+	start := time.Now()
+	report := SoakReport{Start: soakSample(start, 0)}
+	interval := time.Duration(float64(time.Second) / opts.Rate)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	var reports <-chan time.Time
+	if opts.Report > 0 {
+		reportTicker := time.NewTicker(opts.Report)
+		defer reportTicker.Stop()
+		reports = reportTicker.C
+	}
+	deadline := time.After(opts.Duration)
+
+	for {
+		select {
+		case <-ticker.C:
+			n := atomic.AddUint64(&generated, 1)
+			payload := Payload{ItemID: fmt.Sprintf("SOAK%08d", n), DeviceType: "soak"}
+			bus.scanReceived.publish(ScanReceived{Payload: payload, At: time.Now()})
+			go dispatchPayload(config, payload)
+		case <-reports:
+			s := soakSample(start, atomic.LoadUint64(&generated))
+			logger.Infof("Soak %s: generated %d, delivered %d, failed %d, queued %d, heap %d KiB, goroutines %d",
+				s.Elapsed, s.Generated, s.Delivered, s.Failed, s.QueueDepth, s.HeapBytes/1024, s.Goroutines)
+		case <-deadline:
+			if apiBatcher != nil {
+				apiBatcher.flush(10 * time.Second)
+			}
+			time.Sleep(opts.Settle)
+			report.End = soakSample(start, atomic.LoadUint64(&generated))
+			report.HeapGrowth = int64(report.End.HeapBytes) - int64(report.Start.HeapBytes)
+			report.GoroutineGrowth = report.End.Goroutines - report.Start.Goroutines
+			return report
+		}
+	}
+}
+
+// runSoak parses the soak command line, runs it and prints the report
+func runSoak(args []string) error {
+	flags := flag.NewFlagSet("soak", flag.ContinueOnError)
+	opts := SoakOptions{Settle: 2 * time.Second}
+	flags.Float64Var(&opts.Rate, "rate", 10, "synthetic scans per second")
+	flags.DurationVar(&opts.Duration, "duration", time.Hour, "how long to run")
+	flags.DurationVar(&opts.Report, "report", time.Minute, "how often to log a measurement")
+	flags.StringVar(&opts.Endpoint, "endpoint", "", "API endpoint to post to (default: a local stub)")
+	flags.IntVar(&opts.MaxGoroutineGrowth, "max-goroutine-growth", 50, "fail when goroutines grow by more than this (0 disables)")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if opts.Rate <= 0 {
+		return fmt.Errorf("rate must be positive")
+	}
+
+	// a dry run leaves config.json as it is, like the rest of the station's files
+	config, err := loadConfigFile("config.json", false)
+	if err != nil {
+		return err
+	}
+	// keep synthetic failures out of the station's real queue
+	dir, err := os.MkdirTemp("", "scanandpost-soak")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+	if err := applyStorage(StorageConfig{LogDir: dir, QueueDir: dir, StateDir: dir}); err != nil {
+		return err
+	}
+	if err := setupClients(config); err != nil {
+		return err
+	}
+	if config.PayloadSchema != "" {
+		if payloadSchema, err = loadPayloadSchema(config.PayloadSchema); err != nil {
+			return err
+		}
+	}
+	if experiments, err = newExperiments(config.Experiments, config.Plugins); err != nil {
+		return fmt.Errorf("experiments: %v", err)
+	}
+	registerPlugins(config)
+	// output plugins are configured outputs too, so they get no synthetic scans
+	outputPlugins = nil
+	logger.SetOutput(os.Stdout)
+
+	report := soak(config, opts)
+	fmt.Printf("Generated %d scans in %s: %d delivered, %d failed, %d queued\n",
+		report.End.Generated, report.End.Elapsed, report.End.Delivered, report.End.Failed, report.End.QueueDepth)
+	fmt.Printf("Heap %d KiB -> %d KiB (%+d KiB), goroutines %d -> %d (%+d)\n",
+		report.Start.HeapBytes/1024, report.End.HeapBytes/1024, report.HeapGrowth/1024,
+		report.Start.Goroutines, report.End.Goroutines, report.GoroutineGrowth)
+	if opts.MaxGoroutineGrowth > 0 && report.GoroutineGrowth > opts.MaxGoroutineGrowth {
+		return fmt.Errorf("goroutines grew by %d, more than the allowed %d", report.GoroutineGrowth, opts.MaxGoroutineGrowth)
+	}
+	return nil
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSoak_DeliversSyntheticScans(t *testing.T) {
+	useTempQueue(t)
+	health = &healthState{deviceMissingSince: map[int]time.Time{}}
+	oldPost := httpPost
+	defer func() { httpPost = oldPost }()
+	httpPost = func(url, contentType string, body io.Reader) (*http.Response, error) {
+		return http.Post(url, contentType, body)
+	}
+
+	report := soak(&Config{}, SoakOptions{Rate: 200, Duration: 250 * time.Millisecond, Settle: 100 * time.Millisecond})
+	assert.Greater(t, report.End.Generated, uint64(10))
+	assert.Equal(t, uint32(report.End.Generated), report.End.Delivered)
+	assert.Zero(t, report.End.Failed)
+	assert.Zero(t, report.End.QueueDepth)
+}
+
+func TestSoak_SkipsConfiguredOutputs(t *testing.T) {
+	useTempQueue(t)
+	health = &healthState{deviceMissingSince: map[int]time.Time{}}
+	oldOutputs := httpOutputs
+	defer func() {
+		outputsMu.Lock()
+		httpOutputs = oldOutputs
+		outputsMu.Unlock()
+	}()
+	oldPost := httpPost
+	defer func() { httpPost = oldPost }()
+	httpPost = func(url, contentType string, body io.Reader) (*http.Response, error) {
+		return http.Post(url, contentType, body)
+	}
+
+	var posts int32
+	production := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&posts, 1)
+	}))
+	defer production.Close()
+	outputs, err := loadOutputs(&Config{Outputs: []OutputConfig{{Name: "relay", Endpoint: production.URL}}})
+	assert.NoError(t, err)
+	outputsMu.Lock()
+	httpOutputs = outputs
+	outputsMu.Unlock()
+	startOutputs(outputs)
+	defer outputs[0].stop()
+
+	report := soak(&Config{}, SoakOptions{Rate: 200, Duration: 100 * time.Millisecond, Settle: 100 * time.Millisecond})
+	assert.Greater(t, report.End.Generated, uint64(0))
+	assert.Zero(t, atomic.LoadInt32(&posts))
+}
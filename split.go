@@ -0,0 +1,136 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// SplitConfig represents splitting of scans from imagers that decode several
+// barcodes per trigger and send them concatenated
+type SplitConfig struct {
+	// Separators are the strings the imager puts between codes, such as "|" or "\t"
+	Separators []string `json:"separators"`
+	// Symbology lists boundaries recognized without a separator:
+	// "aim" splits before each AIM symbology identifier such as ]E0 or ]d2,
+	// "gtin" splits digit runs into EAN-13, UPC-A or EAN-8 codes with valid check digits
+	Symbology []string `json:"symbology"`
+}
+
+var aimIdentifier = regexp.MustCompile(`\][A-Za-z][0-9A-Za-z]`)
+
+func (s SplitConfig) enabled() bool {
+	return len(s.Separators) > 0 || len(s.Symbology) > 0
+}
+
+func (s SplitConfig) validate() error {
+	for _, symbology := range s.Symbology {
+		if symbology != "aim" && symbology != "gtin" {
+			return fmt.Errorf("unknown symbology %q", symbology)
+		}
+	}
+	return nil
+}
+
+// splitPayload returns one payload per code in the scan. When a scan holds
+// several codes, they share a new trigger group ID.
+func splitPayload(config SplitConfig, payload Payload) []Payload {
+	codes := []string{payload.ItemID}
+	for _, separator := range config.Separators {
+		codes = splitEach(codes, func(code string) []string { return strings.Split(code, separator) })
+	}
+	for _, symbology := range config.Symbology {
+		switch symbology {
+		case "aim":
+			codes = splitEach(codes, splitAIM)
+		case "gtin":
+			codes = splitEach(codes, splitGTIN)
+		}
+	}
+	if len(codes) <= 1 {
+		return []Payload{payload}
+	}
+	group := newTriggerGroup()
+	payloads := make([]Payload, len(codes))
+	for i, code := range codes {
+		payloads[i] = payload
+		payloads[i].ItemID = code
+		payloads[i].TriggerGroup = group
+	}
+	return payloads
+}
+
+// splitEach applies split to every code, dropping empty codes
+func splitEach(codes []string, split func(string) []string) []string {
+	var result []string
+	for _, code := range codes {
+		for _, part := range split(code) {
+			if part != "" {
+				result = append(result, part)
+			}
+		}
+	}
+	return result
+}
+
+// splitAIM splits before each AIM symbology identifier after the first character
+func splitAIM(code string) []string {
+	var parts []string
+	start := 0
+	for _, loc := range aimIdentifier.FindAllStringIndex(code, -1) {
+		if loc[0] > start {
+			parts = append(parts, code[start:loc[0]])
+			start = loc[0]
+		}
+	}
+	return append(parts, code[start:])
+}
+
+// splitGTIN splits a run of digits into equal-length GTINs when every one has a
+// valid check digit, and leaves any other code alone
+func splitGTIN(code string) []string {
+	for _, c := range code {
+		if c < '0' || c > '9' {
+			return []string{code}
+		}
+	}
+	for _, size := range []int{13, 12, 8} {
+		if len(code) <= size || len(code)%size != 0 {
+			continue
+		}
+		var parts []string
+		for i := 0; i < len(code); i += size {
+			if !validGTIN(code[i : i+size]) {
+				parts = nil
+				break
+			}
+			parts = append(parts, code[i:i+size])
+		}
+		if parts != nil {
+			return parts
+		}
+	}
+	return []string{code}
+}
+
+// validGTIN checks the GS1 mod-10 check digit of a string of digits
+func validGTIN(digits string) bool {
+	sum := 0
+	for i := len(digits) - 2; i >= 0; i-- {
+		d := int(digits[i] - '0')
+		if (len(digits)-2-i)%2 == 0 {
+			d *= 3
+		}
+		sum += d
+	}
+	return (10-sum%10)%10 == int(digits[len(digits)-1]-'0')
+}
+
+// newTriggerGroup returns a random ID shared by the codes of one trigger
+func newTriggerGroup() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
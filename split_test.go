@@ -0,0 +1,35 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func itemIDs(payloads []Payload) []string {
+	var ids []string
+	for _, p := range payloads {
+		ids = append(ids, p.ItemID)
+	}
+	return ids
+}
+
+func TestSplitPayload(t *testing.T) {
+	config := SplitConfig{Separators: []string{"|"}, Symbology: []string{"aim", "gtin"}}
+
+	payloads := splitPayload(config, Payload{ItemID: "A1|B2||C3", DeviceType: "scanner0"})
+	assert.Equal(t, []string{"A1", "B2", "C3"}, itemIDs(payloads))
+	assert.NotEmpty(t, payloads[0].TriggerGroup)
+	assert.Equal(t, payloads[0].TriggerGroup, payloads[2].TriggerGroup)
+	assert.Equal(t, "scanner0", payloads[1].DeviceType)
+
+	assert.Equal(t, []string{"]E04006381333931", "]d2010123456789012815"}, itemIDs(splitPayload(config, Payload{ItemID: "]E04006381333931]d2010123456789012815"})))
+	// two EAN-13s with valid check digits
+	assert.Equal(t, []string{"4006381333931", "5901234123457"}, itemIDs(splitPayload(config, Payload{ItemID: "40063813339315901234123457"})))
+
+	single := splitPayload(config, Payload{ItemID: "40063813339315901234123450"})
+	assert.Equal(t, []string{"40063813339315901234123450"}, itemIDs(single))
+	assert.Empty(t, single[0].TriggerGroup)
+
+	assert.EqualError(t, SplitConfig{Symbology: []string{"qr"}}.validate(), `unknown symbology "qr"`)
+}
@@ -0,0 +1,616 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// StandbyConfig represents a warm standby pair of station PCs. The standby
+// watches the active station over the LAN, keeps a copy of its queue, and
+// takes over the scanners and the queue delivery when it stops answering.
+type StandbyConfig struct {
+	Enabled bool `json:"enabled"`
+	// Role is the role at startup, active or standby. The two stations of a pair take one each.
+	Role string `json:"role"`
+	// Peer is the admin API address of the other station, such as "10.0.4.12:8082"
+	Peer string `json:"peer"`
+	// IntervalSeconds is how often the peer is checked (default 2)
+	IntervalSeconds int `json:"intervalSeconds"`
+	// FailoverSeconds is how long the peer must be silent before the standby takes over (default 10)
+	FailoverSeconds int `json:"failoverSeconds"`
+	// Token is shared by the pair and sent as X-Standby-Token, since the queue holds item IDs
+	Token string `json:"token"`
+	// TakeoverExecutable runs before the scanners are claimed, such as the utility of a USB switch
+	TakeoverExecutable string   `json:"takeoverExecutable"`
+	TakeoverArgs       []string `json:"takeoverArgs"`
+}
+
+const (
+	roleActive  = "active"
+	roleStandby = "standby"
+)
+
+// exitSteppedDown is the exit code of a station configured as the standby
+// that stepped down because both stations were acting as active
+const exitSteppedDown = 4
+
+func (s StandbyConfig) withDefaults() StandbyConfig {
+	if s.IntervalSeconds <= 0 {
+		s.IntervalSeconds = 2
+	}
+	if s.FailoverSeconds <= 0 {
+		s.FailoverSeconds = 10
+	}
+	return s
+}
+
+func (s StandbyConfig) validate(admin AdminConfig) error {
+	if s.Role != roleActive && s.Role != roleStandby {
+		return fmt.Errorf("role must be %s or %s, not %q", roleActive, roleStandby, s.Role)
+	}
+	if s.Peer == "" {
+		return fmt.Errorf("the peer's admin API address is required")
+	}
+	if admin.Listen == "" {
+		return fmt.Errorf("admin.listen is required, since the peer watches this station through the admin API")
+	}
+	if s.Token == "" {
+		return fmt.Errorf("a token is required, since the peer copies the queue, which holds item IDs")
+	}
+	if s = s.withDefaults(); s.FailoverSeconds <= s.IntervalSeconds {
+		return fmt.Errorf("failoverSeconds must be longer than intervalSeconds")
+	}
+	return nil
+}
+
+// StandbyStatus reports the state of a standby pair from one station
+type StandbyStatus struct {
+	// Role is the configured role
+	Role string `json:"role"`
+	// Acting is the role the station plays now, active or standby
+	Acting        string     `json:"acting"`
+	PeerReachable bool       `json:"peerReachable"`
+	PeerLastSeen  *time.Time `json:"peerLastSeen,omitempty"`
+	PeerActing    string     `json:"peerActing,omitempty"`
+	// TakenOverAt is when this station took over from its peer
+	TakenOverAt    *time.Time `json:"takenOverAt,omitempty"`
+	TakeoverReason string     `json:"takeoverReason,omitempty"`
+	// ReplicatedQueue counts the peer's queued scans kept for a takeover
+	ReplicatedQueue int `json:"replicatedQueue"`
+	// QueueVersion changes whenever failures.log does, so the peer only copies it
+	// then. It is only sent to the peer.
+	QueueVersion string `json:"queueVersion,omitempty"`
+	// HandedOver has the CRC-32 of every queue entry this station took over,
+	// so the peer drops them from its own queue. It is only sent to the peer.
+	HandedOver []uint32 `json:"handedOver,omitempty"`
+}
+
+// standbyTakeover is what a station took over from its peer, kept across
+// restarts so a peer that comes back later still learns about it
+type standbyTakeover struct {
+	At      time.Time `json:"at"`
+	Reason  string    `json:"reason"`
+	Entries []uint32  `json:"entries"`
+}
+
+// standbyPair is this station's side of a standby pair. A nil pair is
+// always active.
+type standbyPair struct {
+	config   StandbyConfig
+	peerURL  string
+	client   *http.Client
+	interval time.Duration
+	failover time.Duration
+	// snapshotFile keeps the copy of the peer's queue across restarts
+	snapshotFile string
+	// takeoverFile keeps the last takeover across restarts
+	takeoverFile string
+	// deliver sends the queue after a takeover
+	deliver func()
+
+	mu             sync.Mutex
+	active         bool
+	activated      chan struct{}
+	peerLastSeen   time.Time
+	peerActing     string
+	peerFailing    bool
+	watchingSince  time.Time
+	takenOverAt    time.Time
+	takeoverReason string
+	snapshot       []string
+	snapshotOf     string
+	// handedOver has the CRC-32 of the queue entries taken over at takenOverAt
+	handedOver []uint32
+	// droppedFor is the peer's takeover whose entries were dropped from failures.log
+	droppedFor time.Time
+}
+
+var pair *standbyPair
+
+func newStandbyPair(config StandbyConfig, snapshotFile string, deliver func()) *standbyPair {
+	config = config.withDefaults()
+	peerURL := strings.TrimSuffix(config.Peer, "/")
+	if !strings.Contains(peerURL, "://") {
+		peerURL = "http://" + peerURL
+	}
+	p := &standbyPair{
+		config:       config,
+		peerURL:      peerURL,
+		interval:     time.Duration(config.IntervalSeconds) * time.Second,
+		failover:     time.Duration(config.FailoverSeconds) * time.Second,
+		snapshotFile: snapshotFile,
+		takeoverFile: strings.TrimSuffix(snapshotFile, filepath.Ext(snapshotFile)) + ".takeover.json",
+		deliver:      deliver,
+		activated:    make(chan struct{}),
+	}
+	p.client = &http.Client{Timeout: p.interval}
+	if data, err := os.ReadFile(snapshotFile); err == nil {
+		p.snapshot = queueLines(string(data))
+	}
+	if data, err := os.ReadFile(p.takeoverFile); err == nil {
+		var takeover standbyTakeover
+		if err := json.Unmarshal(data, &takeover); err != nil {
+			logger.Errorf("Error reading %s: %v", p.takeoverFile, err)
+		} else {
+			p.takenOverAt, p.takeoverReason, p.handedOver = takeover.At, takeover.Reason, takeover.Entries
+		}
+	}
+	return p
+}
+
+// entryCRC identifies a queue entry handed over between the stations
+func entryCRC(line string) uint32 {
+	return crc32.ChecksumIEEE([]byte(strings.TrimSpace(line)))
+}
+
+// queueLines splits a queue file into its non-empty entries
+func queueLines(data string) []string {
+	var lines []string
+	for _, line := range strings.Split(data, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+// start picks the role at startup. A station configured as active still
+// starts as the standby when its peer took over while it was down.
+func (p *standbyPair) start(now time.Time) {
+	peer, err := p.fetchState()
+	p.mu.Lock()
+	p.watchingSince, p.peerFailing = now, err != nil
+	if err == nil {
+		p.peerLastSeen, p.peerActing = now, peer.Acting
+	}
+	p.mu.Unlock()
+	switch {
+	case p.config.Role == roleActive && err == nil && peer.Acting == roleActive:
+		logger.Warnf("Peer %s is acting as the active station; starting as its standby", p.config.Peer)
+		p.dropHandedOver(peer)
+	case p.config.Role == roleActive:
+		p.activate("configured as the active station", now, false)
+	default:
+		logger.Infof("Starting as the standby of %s; scanners stay closed until a takeover", p.config.Peer)
+	}
+}
+
+// waitActive blocks until this station is the active one
+func (p *standbyPair) waitActive() {
+	if p == nil {
+		return
+	}
+	<-p.activated
+}
+
+// passive reports whether this station is the standby, so it captures nothing by design
+func (p *standbyPair) passive() bool {
+	if p == nil {
+		return false
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return !p.active
+}
+
+// peerRequest sends a request to the peer's admin API with the pair's token
+func (p *standbyPair) peerRequest(path string) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodGet, p.peerURL+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-Standby-Token", p.config.Token)
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("response code: %d", resp.StatusCode)
+	}
+	return resp, nil
+}
+
+func (p *standbyPair) fetchState() (StandbyStatus, error) {
+	var state StandbyStatus
+	resp, err := p.peerRequest("/standby")
+	if err != nil {
+		return state, err
+	}
+	defer resp.Body.Close()
+	err = json.NewDecoder(resp.Body).Decode(&state)
+	return state, err
+}
+
+// check polls the peer once. A standby copies the peer's queue when it
+// changed, and takes over once the peer has been silent for the failover time.
+func (p *standbyPair) check(now time.Time) {
+	peer, err := p.fetchState()
+	p.mu.Lock()
+	active, lastSeen, failing := p.active, p.peerLastSeen, p.peerFailing
+	p.peerFailing = err != nil
+	if err == nil {
+		p.peerLastSeen, p.peerActing = now, peer.Acting
+	}
+	p.mu.Unlock()
+
+	if err != nil {
+		if !failing {
+			logger.Warnf("Standby peer %s is not answering: %v", p.config.Peer, err)
+		}
+		since := lastSeen
+		if since.IsZero() {
+			since = p.watchingSince
+		}
+		if !active && now.Sub(since) >= p.failover {
+			p.activate(fmt.Sprintf("peer %s silent since %s", p.config.Peer, since.Format(time.RFC3339)), now, true)
+		}
+		return
+	}
+	if failing {
+		logger.Infof("Standby peer %s is answering again, acting as %s", p.config.Peer, peer.Acting)
+	}
+	if !active && peer.Acting == roleActive {
+		p.dropHandedOver(peer)
+	}
+	switch {
+	case active && peer.Acting == roleActive && p.config.Role == roleStandby:
+		p.stepDown()
+	case active && peer.Acting == roleActive && peer.Role == p.config.Role:
+		logger.Errorf("Error: both stations of the standby pair are configured and acting as %s; stop one of them", p.config.Role)
+	case active && peer.Acting == roleActive:
+		logger.Warnf("Standby peer %s is acting as active too; waiting for it to step down", p.config.Peer)
+	case !active && peer.Acting == roleStandby && p.config.Role == roleActive:
+		p.activate("both stations were on standby", now, false)
+	case !active && peer.QueueVersion != p.snapshotVersion():
+		if err := p.copyQueue(peer.QueueVersion); err != nil {
+			logger.Errorf("Error copying the queue of standby peer %s: %v", p.config.Peer, err)
+		}
+	}
+}
+
+func (p *standbyPair) snapshotVersion() string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.snapshotOf
+}
+
+// copyQueue replaces the copy of the peer's queue
+func (p *standbyPair) copyQueue(version string) error {
+	resp, err := p.peerRequest("/standby/queue")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	mu := recordLock(p.snapshotFile)
+	mu.Lock()
+	defer mu.Unlock()
+	if err := writeFileAtomic(p.snapshotFile, data); err != nil {
+		return err
+	}
+	p.mu.Lock()
+	p.snapshot, p.snapshotOf = queueLines(string(data)), version
+	p.mu.Unlock()
+	return nil
+}
+
+// activate makes this station the active one: the takeover command switches
+// the scanners over, the copy of the peer's queue joins failures.log and is
+// delivered, and the scanners are claimed. takeover is set when the peer was
+// the active station.
+func (p *standbyPair) activate(reason string, now time.Time, takeover bool) {
+	// a purge of the copy waits until it has joined failures.log
+	snapshotMu := recordLock(p.snapshotFile)
+	snapshotMu.Lock()
+	p.mu.Lock()
+	if p.active {
+		p.mu.Unlock()
+		snapshotMu.Unlock()
+		return
+	}
+	p.active = true
+	snapshot := p.snapshot
+	p.snapshot, p.snapshotOf = nil, ""
+	var record *standbyTakeover
+	if takeover {
+		p.takenOverAt, p.takeoverReason = now, reason
+		p.handedOver = make([]uint32, len(snapshot))
+		for i, line := range snapshot {
+			p.handedOver[i] = entryCRC(line)
+		}
+		record = &standbyTakeover{At: now, Reason: reason, Entries: p.handedOver}
+	}
+	p.mu.Unlock()
+
+	if takeover {
+		logger.Warnf("Taking over as the active station: %s", reason)
+	} else {
+		logger.Infof("Acting as the active station of the standby pair: %s", reason)
+	}
+	if record != nil {
+		// saved first, so the peer learns what was taken over even if this station restarts
+		data, _ := json.Marshal(record)
+		if err := writeFileAtomic(p.takeoverFile, data); err != nil {
+			logger.Errorf("Error saving the takeover to %s: %v", p.takeoverFile, err)
+		}
+	}
+	p.runTakeoverCommand()
+	if len(snapshot) > 0 {
+		failuresMu.Lock()
+		for _, line := range snapshot {
+			if err := appendRecord(failuresFile, []byte(line)); err != nil {
+				logger.Errorf("Error writing to %s: %v", failuresFile, err)
+			}
+		}
+		failuresMu.Unlock()
+		logger.Infof("Took over %d queued scans from standby peer %s", len(snapshot), p.config.Peer)
+	}
+	if err := os.Remove(p.snapshotFile); err != nil && !os.IsNotExist(err) {
+		logger.Errorf("Error removing %s: %v", p.snapshotFile, err)
+	}
+	snapshotMu.Unlock()
+	close(p.activated)
+	// after a takeover, failures.log may also hold scans this station queued
+	// the last time it was active
+	if (takeover || len(snapshot) > 0) && p.deliver != nil {
+		go p.deliver()
+	}
+}
+
+// stepDown ends a split where both stations act as active, such as after a
+// network outage between them. The station configured as the standby stops
+// replaying at once and exits, so the service manager restarts it as the
+// standby with its inputs closed.
+func (p *standbyPair) stepDown() {
+	p.mu.Lock()
+	p.active = false
+	p.mu.Unlock()
+	logger.Errorf("Error: both stations of the standby pair are acting as active; stepping down as the configured standby, exiting with code %d", exitSteppedDown)
+	exitProcess(exitSteppedDown)
+}
+
+// dropHandedOver removes from failures.log the entries the peer took over
+// when it became the active station, since the peer delivers them. Without
+// this, this station would post them again once it delivers its own queue.
+func (p *standbyPair) dropHandedOver(peer StandbyStatus) {
+	if peer.TakenOverAt == nil || len(peer.HandedOver) == 0 {
+		return
+	}
+	p.mu.Lock()
+	done := p.droppedFor.Equal(*peer.TakenOverAt)
+	p.mu.Unlock()
+	if done {
+		return
+	}
+	handedOver := map[uint32]bool{}
+	for _, crc := range peer.HandedOver {
+		handedOver[crc] = true
+	}
+	failuresMu.Lock()
+	removed, _, _, err := filterRecords(failuresFile, 0, func(records []string, headerBytes int64) []bool {
+		keep := make([]bool, len(records))
+		for i, record := range records {
+			keep[i] = !handedOver[entryCRC(record)]
+		}
+		return keep
+	})
+	failuresMu.Unlock()
+	if err != nil {
+		logger.Errorf("Error dropping the entries taken over by standby peer %s from %s: %v", p.config.Peer, failuresFile, err)
+		return
+	}
+	if removed > 0 {
+		logger.Infof("Dropped %d queued scans that standby peer %s took over", removed, p.config.Peer)
+	}
+	p.mu.Lock()
+	p.droppedFor = *peer.TakenOverAt
+	p.mu.Unlock()
+}
+
+// lockSnapshot keeps copies of the peer's queue from replacing the snapshot
+// file, and returns the unlock, which reloads the copy in memory from what a
+// purge left in the file
+func (p *standbyPair) lockSnapshot() func() {
+	mu := recordLock(p.snapshotFile)
+	mu.Lock()
+	return func() {
+		defer mu.Unlock()
+		data, err := os.ReadFile(p.snapshotFile)
+		if err != nil && !os.IsNotExist(err) {
+			logger.Errorf("Error reading %s: %v", p.snapshotFile, err)
+			return
+		}
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		if !p.active {
+			p.snapshot = queueLines(string(data))
+		}
+	}
+}
+
+// runTakeoverCommand runs the configured command, such as one switching a USB
+// switch over to this station. A failure is logged, and the takeover goes on.
+func (p *standbyPair) runTakeoverCommand() {
+	if p.config.TakeoverExecutable == "" {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	out, err := exec.CommandContext(ctx, p.config.TakeoverExecutable, p.config.TakeoverArgs...).CombinedOutput()
+	if err != nil {
+		logger.Errorf("Error running takeover command %s: %v: %s", p.config.TakeoverExecutable, err, strings.TrimSpace(string(out)))
+		return
+	}
+	logger.Infof("Ran takeover command %s", p.config.TakeoverExecutable)
+}
+
+// watch checks the peer every interval
+func (p *standbyPair) watch() {
+	for now := range time.Tick(p.interval) {
+		p.check(now)
+	}
+}
+
+// status returns nil when no standby pair is configured
+func (p *standbyPair) status(now time.Time) *StandbyStatus {
+	if p == nil {
+		return nil
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	status := &StandbyStatus{Role: p.config.Role, Acting: roleStandby, PeerActing: p.peerActing, ReplicatedQueue: len(p.snapshot)}
+	if p.active {
+		status.Acting = roleActive
+	}
+	if !p.peerLastSeen.IsZero() {
+		seen := p.peerLastSeen
+		status.PeerLastSeen = &seen
+		status.PeerReachable = now.Sub(seen) <= p.failover
+	}
+	if !p.takenOverAt.IsZero() {
+		at := p.takenOverAt
+		status.TakenOverAt, status.TakeoverReason = &at, p.takeoverReason
+	}
+	return status
+}
+
+// queueVersion identifies the current content of failures.log by its size and modification time
+func queueVersion() string {
+	info, err := os.Stat(failuresFile)
+	if err != nil {
+		return "none"
+	}
+	return fmt.Sprintf("%d-%d", info.Size(), info.ModTime().UnixNano())
+}
+
+// hasStandbyToken reports whether the request carries the pair's token
+func hasStandbyToken(token string, r *http.Request) bool {
+	return token != "" && subtle.ConstantTimeCompare([]byte(r.Header.Get("X-Standby-Token")), []byte(token)) == 1
+}
+
+// authorized checks the pair's token on a request from the peer
+func (p *standbyPair) authorized(w http.ResponseWriter, r *http.Request) bool {
+	if hasStandbyToken(p.config.Token, r) {
+		return true
+	}
+	http.Error(w, "invalid standby token", http.StatusUnauthorized)
+	return false
+}
+
+// fromLoopback reports whether the request came from this machine
+func fromLoopback(r *http.Request) bool {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return false
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// requireStandbyToken guards an admin API that a standby pair binds on the
+// LAN: requests from other hosts must carry the pair's token
+func requireStandbyToken(config StandbyConfig, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !fromLoopback(r) && !hasStandbyToken(config.Token, r) {
+			http.Error(w, "invalid standby token", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// serveState answers the peer's check with this station's state
+func (p *standbyPair) serveState(w http.ResponseWriter, r *http.Request) {
+	if !p.authorized(w, r) {
+		return
+	}
+	state := p.status(time.Now())
+	state.QueueVersion = queueVersion()
+	p.mu.Lock()
+	state.HandedOver = p.handedOver
+	p.mu.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(state)
+}
+
+// serveQueue sends failures.log to the peer
+func (p *standbyPair) serveQueue(w http.ResponseWriter, r *http.Request) {
+	if !p.authorized(w, r) {
+		return
+	}
+	failuresMu.Lock()
+	data, err := os.ReadFile(failuresFile)
+	failuresMu.Unlock()
+	if err != nil && !os.IsNotExist(err) {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Write(data)
+}
+
+// standbyAlert is raised while the peer of a standby pair is unreachable
+func standbyAlert(status *StandbyStatus) Alert {
+	body := "The standby station has not answered, so this station has no warm standby."
+	if status.Acting == roleStandby {
+		body = "The active station has not answered. This standby takes over once the failover time has passed."
+	}
+	if status.PeerLastSeen != nil {
+		body += fmt.Sprintf(" It was last seen at %s.", status.PeerLastSeen.Format(time.RFC3339))
+	}
+	return Alert{Key: "standby-peer", Subject: "standby peer unreachable", Body: body}
+}
+
+// standbySnapshotFile is where the copy of the peer's queue is kept
+func standbySnapshotFile() string {
+	return filepath.Join(stateDir, "standby.queue.log")
+}
+
+// startStandby picks the role of this station and watches its peer
+func startStandby(config *Config) error {
+	if err := config.Standby.validate(config.Admin); err != nil {
+		return err
+	}
+	pair = newStandbyPair(config.Standby, standbySnapshotFile(), func() {
+		flushAll(config, config.flushDeadline())
+	})
+	pair.start(time.Now())
+	go pair.watch()
+	return nil
+}
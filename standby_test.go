@@ -0,0 +1,246 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStandbyConfig_Validate(t *testing.T) {
+	admin := AdminConfig{Listen: ":8082"}
+	assert.NoError(t, StandbyConfig{Role: "standby", Peer: "10.0.4.12:8082", Token: "secret"}.validate(admin))
+	assert.Error(t, StandbyConfig{Role: "primary", Peer: "10.0.4.12:8082", Token: "secret"}.validate(admin))
+	assert.Error(t, StandbyConfig{Role: "active", Token: "secret"}.validate(admin))
+	assert.Error(t, StandbyConfig{Role: "active", Peer: "10.0.4.12:8082", Token: "secret"}.validate(AdminConfig{}))
+	assert.Error(t, StandbyConfig{Role: "active", Peer: "10.0.4.12:8082"}.validate(admin))
+	assert.Error(t, StandbyConfig{Role: "active", Peer: "10.0.4.12:8082", Token: "secret", IntervalSeconds: 10, FailoverSeconds: 5}.validate(admin))
+}
+
+// servePair serves the standby endpoints of a pair, as the admin API of the peer would
+func servePair(t *testing.T, p *standbyPair) *httptest.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/standby", p.serveState)
+	mux.HandleFunc("/standby/queue", p.serveQueue)
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestStandbyPair_Takeover(t *testing.T) {
+	useTempQueue(t)
+	dir := t.TempDir()
+	active := newStandbyPair(StandbyConfig{Role: "active", Peer: "127.0.0.1:1", Token: "secret"}, filepath.Join(dir, "a.log"), nil)
+	active.start(time.Now())
+	assert.False(t, active.passive())
+	server := servePair(t, active)
+
+	delivered := make(chan bool, 1)
+	standby := newStandbyPair(StandbyConfig{Role: "standby", Peer: server.URL, Token: "secret"}, filepath.Join(dir, "b.log"), func() { delivered <- true })
+	now := time.Now()
+	standby.start(now)
+	assert.True(t, standby.passive())
+
+	// the standby keeps a copy of the active station's queue
+	logFailure(Payload{ItemID: "1", DeviceType: "scanner0"})
+	logFailure(Payload{ItemID: "2", DeviceType: "scanner0"})
+	standby.check(now.Add(2 * time.Second))
+	assert.Equal(t, 2, standby.status(now.Add(2*time.Second)).ReplicatedQueue)
+	assert.True(t, standby.status(now.Add(2*time.Second)).PeerReachable)
+	copied, err := os.ReadFile(filepath.Join(dir, "b.log"))
+	assert.NoError(t, err)
+	assert.Equal(t, 2, strings.Count(string(copied), "\n"))
+
+	// the active station dies, and its queue is gone with it
+	server.Close()
+	os.Remove(failuresFile)
+	standby.check(now.Add(4 * time.Second))
+	assert.True(t, standby.passive())
+	standby.check(now.Add(13 * time.Second))
+	assert.False(t, standby.passive())
+	standby.waitActive()
+	<-delivered
+
+	status := standby.status(now.Add(13 * time.Second))
+	assert.Equal(t, "active", status.Acting)
+	assert.False(t, status.PeerReachable)
+	assert.NotNil(t, status.TakenOverAt)
+	assert.Equal(t, 0, status.ReplicatedQueue)
+	queued, err := os.ReadFile(failuresFile)
+	assert.NoError(t, err)
+	assert.Contains(t, string(queued), `"itemid":"1"`)
+	assert.Contains(t, string(queued), `"itemid":"2"`)
+	_, err = os.Stat(filepath.Join(dir, "b.log"))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestStandbyPair_ActiveStartsAsStandbyAfterTakeover(t *testing.T) {
+	useTempQueue(t)
+	dir := t.TempDir()
+	tookOver := newStandbyPair(StandbyConfig{Role: "standby", Peer: "127.0.0.1:1", Token: "secret"}, filepath.Join(dir, "b.log"), nil)
+	tookOver.start(time.Now())
+	tookOver.activate("peer silent", time.Now(), true)
+	server := servePair(t, tookOver)
+
+	restarted := newStandbyPair(StandbyConfig{Role: "active", Peer: server.URL, Token: "secret"}, filepath.Join(dir, "a.log"), nil)
+	restarted.start(time.Now())
+	assert.True(t, restarted.passive())
+	assert.Equal(t, "active", restarted.status(time.Now()).PeerActing)
+}
+
+func TestStandbyPair_ReturningStationDropsHandedOverEntries(t *testing.T) {
+	useTempQueue(t)
+	dir := t.TempDir()
+	handed, err := encodeQueueEntry(Payload{ItemID: "1", DeviceType: "scanner0"}, time.Now())
+	assert.NoError(t, err)
+	own, err := encodeQueueEntry(Payload{ItemID: "2", DeviceType: "scanner0"}, time.Now())
+	assert.NoError(t, err)
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "b.log"), []byte(handed+"\n"), 0644))
+	tookOver := newStandbyPair(StandbyConfig{Role: "standby", Peer: "127.0.0.1:1", Token: "secret"}, filepath.Join(dir, "b.log"), nil)
+	tookOver.activate("peer silent", time.Now(), true)
+
+	// the takeover survives a restart of the station that took over
+	tookOver = newStandbyPair(StandbyConfig{Role: "standby", Peer: "127.0.0.1:1", Token: "secret"}, filepath.Join(dir, "b.log"), nil)
+	tookOver.activate("peer silent", time.Now(), false)
+	assert.NotNil(t, tookOver.status(time.Now()).TakenOverAt)
+	server := servePair(t, tookOver)
+
+	// the returning station still has the entry it handed over, and one of its own
+	assert.NoError(t, os.WriteFile(failuresFile, []byte(handed+"\n"+own+"\n"), 0644))
+	returning := newStandbyPair(StandbyConfig{Role: "active", Peer: server.URL, Token: "secret"}, filepath.Join(dir, "a.log"), nil)
+	returning.start(time.Now())
+	assert.True(t, returning.passive())
+	data, err := os.ReadFile(failuresFile)
+	assert.NoError(t, err)
+	assert.Equal(t, own+"\n", string(data))
+
+	// nor does it replay its queue while on standby
+	oldPair, oldPost := pair, httpPost
+	defer func() { pair, httpPost = oldPair, oldPost }()
+	pair = returning
+	httpPost = func(url, contentType string, body io.Reader) (*http.Response, error) {
+		t.Fatal("a standby posted its queue")
+		return nil, nil
+	}
+	_, err = flushAll(&Config{}, time.Second)
+	assert.NoError(t, err)
+}
+
+func TestStandbyPair_ConfiguredStandbyStepsDown(t *testing.T) {
+	useTempQueue(t)
+	dir := t.TempDir()
+	oldExit := exitProcess
+	defer func() { exitProcess = oldExit }()
+	var exitCode int
+	exitProcess = func(code int) { exitCode = code }
+
+	// after a network outage between them, both stations act as active
+	active := newStandbyPair(StandbyConfig{Role: "active", Peer: "127.0.0.1:1", Token: "secret"}, filepath.Join(dir, "a.log"), nil)
+	active.activate("configured as the active station", time.Now(), false)
+	tookOver := newStandbyPair(StandbyConfig{Role: "standby", Peer: "127.0.0.1:1", Token: "secret"}, filepath.Join(dir, "b.log"), nil)
+	tookOver.activate("peer silent", time.Now(), true)
+	active.peerURL = servePair(t, tookOver).URL
+	tookOver.peerURL = servePair(t, active).URL
+
+	active.check(time.Now())
+	assert.False(t, active.passive())
+	assert.Zero(t, exitCode)
+
+	tookOver.check(time.Now())
+	assert.True(t, tookOver.passive(), "the configured standby stops replaying at once")
+	assert.Equal(t, exitSteppedDown, exitCode)
+}
+
+func TestStandbyPair_RepostsScansDeliveredAfterTheLastCopy(t *testing.T) {
+	useTempQueue(t)
+	dir := t.TempDir()
+	active := newStandbyPair(StandbyConfig{Role: "active", Peer: "127.0.0.1:1", Token: "secret"}, filepath.Join(dir, "a.log"), nil)
+	active.start(time.Now())
+	server := servePair(t, active)
+	standby := newStandbyPair(StandbyConfig{Role: "standby", Peer: server.URL, Token: "secret"}, filepath.Join(dir, "b.log"), nil)
+	now := time.Now()
+	standby.start(now)
+	logFailure(Payload{ItemID: "1", DeviceType: "scanner0"})
+	standby.check(now.Add(2 * time.Second))
+
+	// the active station delivers the scan within one interval of the copy, then dies
+	os.Remove(failuresFile)
+	server.Close()
+	standby.check(now.Add(13 * time.Second))
+	assert.False(t, standby.passive())
+
+	// the copy still has the delivered scan, so the standby posts it again
+	queued, err := os.ReadFile(failuresFile)
+	assert.NoError(t, err)
+	assert.Contains(t, string(queued), `"itemid":"1"`)
+}
+
+func TestStandbyPair_Token(t *testing.T) {
+	useTempQueue(t)
+	dir := t.TempDir()
+	active := newStandbyPair(StandbyConfig{Role: "active", Peer: "127.0.0.1:1", Token: "secret"}, filepath.Join(dir, "a.log"), nil)
+	server := servePair(t, active)
+
+	resp, err := http.Get(server.URL + "/standby/queue")
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	standby := newStandbyPair(StandbyConfig{Role: "standby", Peer: server.URL, Token: "wrong"}, filepath.Join(dir, "b.log"), nil)
+	_, err = standby.fetchState()
+	assert.EqualError(t, err, "response code: 401")
+}
+
+func TestRequireStandbyToken(t *testing.T) {
+	handler := requireStandbyToken(StandbyConfig{Token: "secret"}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	for _, tc := range []struct {
+		remote, token string
+		code          int
+	}{
+		{"127.0.0.1:40000", "", http.StatusOK},
+		{"[::1]:40000", "", http.StatusOK},
+		{"10.0.4.12:40000", "", http.StatusUnauthorized},
+		{"10.0.4.12:40000", "wrong", http.StatusUnauthorized},
+		{"10.0.4.12:40000", "secret", http.StatusOK},
+	} {
+		r := httptest.NewRequest(http.MethodPost, "/purge", nil)
+		r.RemoteAddr = tc.remote
+		if tc.token != "" {
+			r.Header.Set("X-Standby-Token", tc.token)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		assert.Equal(t, tc.code, w.Code, "%s with token %q", tc.remote, tc.token)
+	}
+}
+
+func TestNewAdminClient_SendsTokens(t *testing.T) {
+	got := make(chan http.Header, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got <- r.Header
+	}))
+	defer server.Close()
+
+	config := &Config{Admin: AdminConfig{Token: "admin-secret"}, Standby: StandbyConfig{Enabled: true, Token: "secret"}}
+	resp, err := newAdminClient(config, time.Second).Get(server.URL)
+	assert.NoError(t, err)
+	resp.Body.Close()
+	header := <-got
+	assert.Equal(t, "secret", header.Get("X-Standby-Token"))
+	assert.Equal(t, "admin-secret", header.Get("X-Admin-Token"))
+}
+
+func TestNoInputs_PassiveStandby(t *testing.T) {
+	oldPair := pair
+	defer func() { pair = oldPair }()
+	pair = newStandbyPair(StandbyConfig{Role: "standby", Peer: "127.0.0.1:1", Token: "secret"}, filepath.Join(t.TempDir(), "b.log"), nil)
+
+	state := &noInputsState{}
+	assert.False(t, state.check(&Config{NoInputs: NoInputsConfig{ExitAfterSeconds: 1}}, time.Now()))
+	assert.True(t, state.current().IsZero())
+}
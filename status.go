@@ -0,0 +1,475 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+)
+
+// AdminConfig represents the local admin HTTP API
+type AdminConfig struct {
+	Listen string `json:"listen"`
+	// ReadOnly refuses the requests that change anything, such as /flush and /trigger
+	ReadOnly bool `json:"readOnly"`
+	// Token lets other hosts change things when sent as X-Admin-Token. Without it,
+	// only this station can.
+	Token string `json:"token"`
+}
+
+// HeartbeatConfig represents the periodic status report sent to the backend
+type HeartbeatConfig struct {
+	Endpoint string `json:"endpoint"`
+	Interval int    `json:"interval"`
+	// Delta sends only changed fields, with a full snapshot every FullEveryMinutes (default 15)
+	Delta            bool `json:"delta"`
+	FullEveryMinutes int  `json:"fullEveryMinutes"`
+}
+
+// DeviceStatus represents the state of one configured scanner
+type DeviceStatus struct {
+	Name         string     `json:"name"`
+	Nickname     string     `json:"nickname,omitempty"`
+	Connected    bool       `json:"connected"`
+	MissingSince *time.Time `json:"missingSince,omitempty"`
+	// TriggerDisabled is set while the host has disabled the scanner's trigger
+	TriggerDisabled bool `json:"triggerDisabled,omitempty"`
+	// Asleep is set while the scanner was sent to sleep after being idle
+	Asleep bool `json:"asleep,omitempty"`
+	// NoReads counts the no-read payloads sent for the scanner since the service started
+	NoReads int `json:"noReads,omitempty"`
+	// Lifetime counts the slot's scans and errors across restarts
+	Lifetime *DeviceLifetime `json:"lifetime,omitempty"`
+}
+
+// Status is the station status exposed on the admin API and sent as a heartbeat
+type Status struct {
+	Time      time.Time `json:"time"`
+	Hostname  string    `json:"hostname"`
+	StationID string    `json:"stationId,omitempty"`
+	Group     string    `json:"group,omitempty"`
+	// CredentialsVersion is the version of the API tokens in effect, see TokenRotation
+	CredentialsVersion string     `json:"credentialsVersion,omitempty"`
+	ScansReceived      uint32     `json:"scansReceived"`
+	PostsSucceeded     uint32     `json:"postsSucceeded"`
+	PostsFailed        uint32     `json:"postsFailed"`
+	TransformsFailed   uint32     `json:"transformsFailed,omitempty"`
+	QueueDepth         int        `json:"queueDepth"`
+	OldestQueuedAt     *time.Time `json:"oldestQueuedAt,omitempty"`
+	// BacklogAgeSeconds is how long the oldest payload in failures.log has waited
+	BacklogAgeSeconds int        `json:"backlogAgeSeconds"`
+	QueueCheck        QueueCheck `json:"queueCheck"`
+	DegradedSince     *time.Time `json:"degradedSince,omitempty"`
+	// NoInputsSince is present while no scanner is connected and no other input is configured
+	NoInputsSince *time.Time `json:"noInputsSince,omitempty"`
+	// DiskPressure is present while free disk space is below the first threshold
+	DiskPressure *DiskPressureStatus `json:"diskPressure,omitempty"`
+	// RetryBudget is present when the retry budget is enabled
+	RetryBudget *RetryBudgetStatus `json:"retryBudget,omitempty"`
+	// ReviewPending counts the scans waiting for a supervisor's approval
+	ReviewPending int `json:"reviewPending,omitempty"`
+	// Standby is present when the station is one of a standby pair
+	Standby *StandbyStatus `json:"standby,omitempty"`
+	// ScanBuffer is present when the ring buffer is enabled
+	ScanBuffer *RingBufferStatus `json:"scanBuffer,omitempty"`
+	// Health is "red" while no input is active, "yellow" while degraded, under disk pressure, over the retry budget, without its standby peer or a scanner is missing, else "green"
+	Health  string         `json:"health"`
+	Devices []DeviceStatus `json:"devices"`
+	Outputs []OutputStatus `json:"outputs"`
+	// Sequences is present while sequence tracking is enabled
+	Sequences []SequenceStatus `json:"sequences,omitempty"`
+}
+
+// currentStatus gathers a snapshot of the station status
+func currentStatus(config *Config) Status {
+	hostname, _ := os.Hostname()
+	status := Status{Time: time.Now(), Hostname: hostname, StationID: config.StationID, Group: config.Group, CredentialsVersion: apiTokens.currentVersion(), QueueDepth: queueDepth(), QueueCheck: queueCheckResult(), Outputs: outputStatuses(), Sequences: sequences.status()}
+
+	if oldest := oldestQueued(); !oldest.IsZero() {
+		status.OldestQueuedAt = &oldest
+		status.BacklogAgeSeconds = int(status.Time.Sub(oldest).Seconds())
+	}
+	if since := budget.degraded(); !since.IsZero() {
+		status.DegradedSince = &since
+	}
+	if since := noInputs.current(); !since.IsZero() {
+		status.NoInputsSince = &since
+	}
+	status.DiskPressure = diskPressure.status()
+	status.ScanBuffer = scanBuffer.status()
+	status.RetryBudget = retryBudget.status(status.Time)
+	status.ReviewPending = review.count()
+	status.Standby = pair.status(status.Time)
+
+	health.mu.Lock()
+	status.ScansReceived = health.scansReceived
+	status.PostsSucceeded = health.postsSucceeded
+	status.PostsFailed = health.postsFailed
+	status.TransformsFailed = health.transformsFailed
+	for i := 0; i < config.NumberOfScanners; i++ {
+		device := DeviceStatus{Name: scannerName(i), Nickname: config.scannerNickname(i), Connected: true, Lifetime: deviceStats.lifetime(scannerName(i)), TriggerDisabled: triggerDisabled(scannerName(i)), Asleep: scannerAsleep(scannerName(i)), NoReads: noReads.count(scannerName(i))}
+		if since, missing := health.deviceMissingSince[i]; missing {
+			since := since
+			device.Connected = false
+			device.MissingSince = &since
+		}
+		status.Devices = append(status.Devices, device)
+	}
+	health.mu.Unlock()
+	status.Health = healthOf(status)
+	return status
+}
+
+// scannerName is the device type reported for the scanner with the given ID
+func scannerName(deviceID int) string {
+	return fmt.Sprintf("scanner%d", deviceID)
+}
+
+// scannerNickname is the configured nickname of the scanner with the given ID, or ""
+func (c *Config) scannerNickname(deviceID int) string {
+	if deviceID < 0 || deviceID >= len(c.ScannerNicknames) {
+		return ""
+	}
+	return c.ScannerNicknames[deviceID]
+}
+
+// scannerLabel names a scanner for people, such as "scanner3 (Receiving Door 3)"
+func (c *Config) scannerLabel(deviceID int) string {
+	if nickname := c.scannerNickname(deviceID); nickname != "" {
+		return fmt.Sprintf("%s (%s)", scannerName(deviceID), nickname)
+	}
+	return scannerName(deviceID)
+}
+
+// adminRefused answers a request that would change something when the admin
+// API is read-only, or when it comes from another host without a token
+func adminRefused(config *Config, w http.ResponseWriter, r *http.Request) bool {
+	if config.Admin.ReadOnly {
+		http.Error(w, "the admin API is read-only", http.StatusForbidden)
+		return true
+	}
+	if !fromLoopback(r) && !hasAdminToken(config, r) {
+		http.Error(w, "changes from other hosts require the admin token", http.StatusUnauthorized)
+		return true
+	}
+	return false
+}
+
+// hasAdminToken reports whether the request carries admin.token, or the
+// standby pair's token, which already opens the whole admin API to the peer
+func hasAdminToken(config *Config, r *http.Request) bool {
+	if config.Standby.Enabled && hasStandbyToken(config.Standby.Token, r) {
+		return true
+	}
+	return config.Admin.Token != "" && subtle.ConstantTimeCompare([]byte(r.Header.Get("X-Admin-Token")), []byte(config.Admin.Token)) == 1
+}
+
+// adminMux returns the handlers of the admin API
+func adminMux(config *Config) *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/status", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(currentStatus(config))
+	})
+	mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
+		// red fails the check, so load balancers and orchestration see a station that captures nothing
+		status := currentStatus(config)
+		w.Header().Set("Content-Type", "application/json")
+		if status.Health == healthRed {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		json.NewEncoder(w).Encode(map[string]string{"health": status.Health})
+	})
+	mux.HandleFunc("/devices", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(deviceStats.snapshot())
+	})
+	mux.HandleFunc("/gaps", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(sequences.gaps())
+	})
+	mux.HandleFunc("/recent", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(recent.snapshot())
+	})
+	mux.HandleFunc("/reload", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if adminRefused(config, w, r) {
+			return
+		}
+		result, err := reloadOutputs(config)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(result)
+	})
+	mux.HandleFunc("/trigger", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.Method == http.MethodGet {
+			json.NewEncoder(w).Encode(triggerStates())
+			return
+		}
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if adminRefused(config, w, r) {
+			return
+		}
+		scanner, action := r.URL.Query().Get("scanner"), r.URL.Query().Get("action")
+		if _, ok := triggers[scanner]; !ok {
+			http.Error(w, fmt.Sprintf("%q has no host trigger configured", scanner), http.StatusNotFound)
+			return
+		}
+		if !validTriggerAction(action) {
+			http.Error(w, fmt.Sprintf("unknown trigger action %q", action), http.StatusBadRequest)
+			return
+		}
+		if err := triggerScanner(scanner, action); err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		json.NewEncoder(w).Encode(TriggerState{Scanner: scanner, Disabled: triggerDisabled(scanner)})
+	})
+	mux.HandleFunc("/pairing", func(w http.ResponseWriter, r *http.Request) {
+		if pairing == nil {
+			http.Error(w, "pairing is not enabled", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(pairing.list(enumerateDevices()))
+	})
+	mux.HandleFunc("/pairing/unbind", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if adminRefused(config, w, r) {
+			return
+		}
+		scanner := r.URL.Query().Get("scanner")
+		if pairing == nil || !pairing.unbind(scanner) {
+			http.Error(w, fmt.Sprintf("%q is not bound", scanner), http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+	mux.HandleFunc("/pairing/barcode.svg", func(w http.ResponseWriter, r *http.Request) {
+		data, err := config.Pairing.barcode()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		svg, err := code128SVG(data)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "image/svg+xml")
+		io.WriteString(w, svg)
+	})
+	mux.HandleFunc("/annotate", func(w http.ResponseWriter, r *http.Request) {
+		if annotations == nil {
+			http.Error(w, "annotations are not enabled", http.StatusNotFound)
+			return
+		}
+		if r.Method == http.MethodPost && adminRefused(config, w, r) {
+			return
+		}
+		annotations.ServeHTTP(w, r)
+	})
+	mux.HandleFunc("/review", func(w http.ResponseWriter, r *http.Request) {
+		if review == nil {
+			http.Error(w, "the review queue is not enabled", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(review.list())
+	})
+	for path, approve := range map[string]bool{"/review/approve": true, "/review/reject": false} {
+		approve := approve
+		mux.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodPost {
+				http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+				return
+			}
+			if adminRefused(config, w, r) {
+				return
+			}
+			if review == nil {
+				http.Error(w, "the review queue is not enabled", http.StatusNotFound)
+				return
+			}
+			id, err := strconv.ParseInt(r.URL.Query().Get("id"), 10, 64)
+			if err != nil {
+				http.Error(w, "id must be the number of a held scan", http.StatusBadRequest)
+				return
+			}
+			if err := review.decide(id, approve, unverifiedSupervisor(r.URL.Query().Get("by"))); err != nil {
+				http.Error(w, err.Error(), http.StatusConflict)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+		})
+	}
+	mux.HandleFunc("/retention", func(w http.ResponseWriter, r *http.Request) {
+		if !config.Retention.Enabled {
+			http.Error(w, "retention is not enabled", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(lastPurgeReport())
+	})
+	mux.HandleFunc("/retention/purge", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if adminRefused(config, w, r) {
+			return
+		}
+		if !config.Retention.Enabled {
+			http.Error(w, "retention is not enabled", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(purgeArtifacts(config.Retention, time.Now()))
+	})
+	mux.HandleFunc("/purge", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if adminRefused(config, w, r) {
+			return
+		}
+		var request PurgeRequest
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		cert, err := purgeItems(config, request, time.Now())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(cert)
+	})
+	mux.HandleFunc("/closeout", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if closeouts == nil {
+			http.Error(w, "close-out is not enabled", http.StatusNotFound)
+			return
+		}
+		if adminRefused(config, w, r) {
+			return
+		}
+		result, err := closeOut(config, time.Now(), "")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if r.URL.Query().Get("format") == "text" {
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			io.WriteString(w, result.Report)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(result)
+	})
+	mux.HandleFunc("/standby", func(w http.ResponseWriter, r *http.Request) {
+		if pair == nil {
+			http.Error(w, "the standby pair is not enabled", http.StatusNotFound)
+			return
+		}
+		pair.serveState(w, r)
+	})
+	mux.HandleFunc("/standby/queue", func(w http.ResponseWriter, r *http.Request) {
+		if pair == nil {
+			http.Error(w, "the standby pair is not enabled", http.StatusNotFound)
+			return
+		}
+		pair.serveQueue(w, r)
+	})
+	mux.HandleFunc("/rollup", func(w http.ResponseWriter, r *http.Request) {
+		if rollups == nil {
+			http.Error(w, "the roll-up is not enabled", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(rollups.groups(time.Now()))
+	})
+	mux.HandleFunc("/flush", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if adminRefused(config, w, r) {
+			return
+		}
+		result, err := flushAll(config, config.flushDeadline())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(result)
+	})
+	return mux
+}
+
+// tokenTransport adds the admin and standby pair tokens to requests
+type tokenTransport struct {
+	headers map[string]string
+}
+
+func (t tokenTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	r = r.Clone(r.Context())
+	for name, value := range t.headers {
+		r.Header.Set(name, value)
+	}
+	return http.DefaultTransport.RoundTrip(r)
+}
+
+// newAdminClient returns a client for the local admin API. The admin API may
+// listen on a LAN address, where changes require admin.token and a standby
+// pair requires its token for everything, so the client sends both.
+func newAdminClient(config *Config, timeout time.Duration) *http.Client {
+	client := &http.Client{Timeout: timeout}
+	headers := map[string]string{}
+	if config.Admin.Token != "" {
+		headers["X-Admin-Token"] = config.Admin.Token
+	}
+	if config.Standby.Enabled {
+		headers["X-Standby-Token"] = config.Standby.Token
+	}
+	if len(headers) > 0 {
+		client.Transport = tokenTransport{headers: headers}
+	}
+	return client
+}
+
+// serveAdmin runs the admin API
+func serveAdmin(config *Config) {
+	logger.Infof("Admin API listening on %s", config.Admin.Listen)
+	var handler http.Handler = adminMux(config)
+	if config.Standby.Enabled {
+		handler = requireStandbyToken(config.Standby, handler)
+	}
+	if err := http.ListenAndServe(config.Admin.Listen, handler); err != nil {
+		logger.Errorf("Error running admin API: %v", err)
+	}
+}
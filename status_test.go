@@ -0,0 +1,76 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAdminStatus(t *testing.T) {
+	health = &healthState{deviceMissingSince: map[int]time.Time{}}
+	markDeviceMissing(1)
+	recordScan()
+	recordPostResult(http.StatusOK, true)
+
+	w := httptest.NewRecorder()
+	adminMux(&Config{NumberOfScanners: 2}).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/status", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var status Status
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &status))
+	assert.Equal(t, uint32(1), status.ScansReceived)
+	assert.Equal(t, uint32(1), status.PostsSucceeded)
+	assert.Len(t, status.Devices, 2)
+	assert.Equal(t, "scanner1", status.Devices[1].Name)
+	assert.False(t, status.Devices[1].Connected)
+	assert.NotNil(t, status.Devices[1].MissingSince)
+}
+
+func TestScannerNicknames(t *testing.T) {
+	health = &healthState{deviceMissingSince: map[int]time.Time{}}
+	config := &Config{NumberOfScanners: 2, ScannerNicknames: []string{"Receiving Door 3"}}
+	status := currentStatus(config)
+	assert.Equal(t, "Receiving Door 3", status.Devices[0].Nickname)
+	assert.Equal(t, "", status.Devices[1].Nickname)
+	assert.Equal(t, "scanner0 (Receiving Door 3)", config.scannerLabel(0))
+	assert.Equal(t, "scanner1", config.scannerLabel(1))
+}
+
+// localRequest is a request to the admin API from this station
+func localRequest(method, target string) *http.Request {
+	r := httptest.NewRequest(method, target, nil)
+	r.RemoteAddr = "127.0.0.1:50000"
+	return r
+}
+
+func TestAdminChangesFromOtherHostsNeedToken(t *testing.T) {
+	useTempQueue(t)
+	purge := func(config *Config, header, token string) int {
+		r := httptest.NewRequest(http.MethodPost, "/purge", strings.NewReader(`{"item":"12345","dryRun":true}`))
+		r.RemoteAddr = "10.0.4.12:50000"
+		if header != "" {
+			r.Header.Set(header, token)
+		}
+		w := httptest.NewRecorder()
+		adminMux(config).ServeHTTP(w, r)
+		return w.Code
+	}
+
+	config := &Config{}
+	assert.Equal(t, http.StatusUnauthorized, purge(config, "", ""))
+	config.Admin.Token = "admin-secret"
+	assert.Equal(t, http.StatusUnauthorized, purge(config, "X-Admin-Token", "wrong"))
+	assert.Equal(t, http.StatusOK, purge(config, "X-Admin-Token", "admin-secret"))
+	config.Standby = StandbyConfig{Enabled: true, Token: "pair-secret"}
+	assert.Equal(t, http.StatusOK, purge(config, "X-Standby-Token", "pair-secret"))
+
+	// reading needs no token
+	w := httptest.NewRecorder()
+	adminMux(&Config{}).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/status", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+}
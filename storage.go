@@ -0,0 +1,113 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+)
+
+// StorageConfig represents where the service keeps its files. Empty
+// directories default to the platform locations from storageDefaults.
+type StorageConfig struct {
+	// LogDir holds service.log, commands.audit.log and purge.certificates.log
+	LogDir string `json:"logDir"`
+	// QueueDir holds failures.log, its quarantine file and deadletter.log
+	QueueDir string `json:"queueDir"`
+	// StateDir holds small state files such as the outbox offset
+	StateDir string `json:"stateDir"`
+}
+
+// stateDir is the resolved state directory, empty until applyStorage runs
+var stateDir string
+
+// storageDefaults returns the platform locations used for directories left empty in config
+var storageDefaults = func() StorageConfig {
+	switch {
+	case runtime.GOOS == "windows":
+		base := os.Getenv("ProgramData")
+		if base == "" {
+			base = `C:\ProgramData`
+		}
+		base = filepath.Join(base, "SPCBarcodeService")
+		return StorageConfig{
+			LogDir:   filepath.Join(base, "logs"),
+			QueueDir: filepath.Join(base, "queue"),
+			StateDir: filepath.Join(base, "state"),
+		}
+	case os.Geteuid() == 0:
+		return StorageConfig{
+			LogDir:   "/var/log/scanandpost",
+			QueueDir: "/var/lib/scanandpost/queue",
+			StateDir: "/var/lib/scanandpost",
+		}
+	default:
+		base := os.Getenv("XDG_STATE_HOME")
+		if base == "" {
+			home, _ := os.UserHomeDir()
+			base = filepath.Join(home, ".local", "state")
+		}
+		base = filepath.Join(base, "scanandpost")
+		return StorageConfig{
+			LogDir:   filepath.Join(base, "logs"),
+			QueueDir: filepath.Join(base, "queue"),
+			StateDir: base,
+		}
+	}
+}
+
+// withDefaults fills empty directories with the platform defaults
+func (s StorageConfig) withDefaults() StorageConfig {
+	defaults := storageDefaults()
+	if s.LogDir == "" {
+		s.LogDir = defaults.LogDir
+	}
+	if s.QueueDir == "" {
+		s.QueueDir = defaults.QueueDir
+	}
+	if s.StateDir == "" {
+		s.StateDir = defaults.StateDir
+	}
+	return s
+}
+
+// applyStorage creates the storage directories and points every persisted file into them
+func applyStorage(s StorageConfig) error {
+	s = s.withDefaults()
+	for _, dir := range []string{s.LogDir, s.QueueDir, s.StateDir} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+	}
+
+	legacyFailures := failuresFile
+	failuresFile = filepath.Join(s.QueueDir, "failures.log")
+	quarantineFile = filepath.Join(s.QueueDir, "failures.quarantine.log")
+	deadLetterFile = filepath.Join(s.QueueDir, "deadletter.log")
+	outputQueueDir = filepath.Join(s.QueueDir, "outputs")
+	commandAuditFile = filepath.Join(s.LogDir, "commands.audit.log")
+	purgeCertificateFile = filepath.Join(s.LogDir, "purge.certificates.log")
+	stateDir = s.StateDir
+	migrateLegacyQueue(legacyFailures, failuresFile)
+
+	return setLogFile(filepath.Join(s.LogDir, "service.log"))
+}
+
+// migrateLegacyQueue moves a failures.log left in the working directory by an
+// older version into the queue directory, so no saved payload is stranded
+func migrateLegacyQueue(legacy, path string) {
+	if legacy == path {
+		return
+	}
+	if _, err := os.Stat(legacy); err != nil {
+		return
+	}
+	if _, err := os.Stat(path); err == nil {
+		logger.Warnf("Both %s and %s exist; replay %s manually", legacy, path, legacy)
+		return
+	}
+	if err := os.Rename(legacy, path); err != nil {
+		logger.Errorf("Error moving %s to %s: %v", legacy, path, err)
+		return
+	}
+	logger.Infof("Moved %s to %s", legacy, path)
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// useTempStorage moves the state directory to a temporary one, restoring it
+// and the other paths and log output applyStorage changes when the test ends
+func useTempStorage(t *testing.T) {
+	useTempQueue(t)
+	audit, certificates, state, out, file := commandAuditFile, purgeCertificateFile, stateDir, logger.Out, logFile
+	t.Cleanup(func() {
+		if logFile != file {
+			logFile.Close()
+		}
+		commandAuditFile, purgeCertificateFile, stateDir, logFile = audit, certificates, state, file
+		logger.SetOutput(out)
+	})
+	stateDir = t.TempDir()
+}
+
+func TestStorageConfig_WithDefaults(t *testing.T) {
+	s := StorageConfig{QueueDir: "/data/queue"}.withDefaults()
+	assert.Equal(t, "/data/queue", s.QueueDir)
+	assert.Equal(t, storageDefaults().LogDir, s.LogDir)
+	assert.Equal(t, storageDefaults().StateDir, s.StateDir)
+}
+
+func TestApplyStorage_MovesLegacyQueue(t *testing.T) {
+	oldWd, _ := os.Getwd()
+	defer os.Chdir(oldWd)
+	os.Chdir(t.TempDir())
+	defer func(f, q, d, c, s string) {
+		failuresFile, quarantineFile, deadLetterFile, commandAuditFile, stateDir = f, q, d, c, s
+	}(failuresFile, quarantineFile, deadLetterFile, commandAuditFile, stateDir)
+
+	failuresFile = "failures.log"
+	os.WriteFile("failures.log", []byte("queued\n"), 0644)
+	dir := t.TempDir()
+	assert.NoError(t, applyStorage(StorageConfig{
+		LogDir:   filepath.Join(dir, "logs"),
+		QueueDir: filepath.Join(dir, "queue"),
+		StateDir: filepath.Join(dir, "state"),
+	}))
+
+	assert.Equal(t, filepath.Join(dir, "queue", "failures.log"), failuresFile)
+	assert.Equal(t, filepath.Join(dir, "logs", "commands.audit.log"), commandAuditFile)
+	data, err := os.ReadFile(failuresFile)
+	assert.NoError(t, err)
+	assert.Equal(t, "queued\n", string(data))
+	assert.NoFileExists(t, "failures.log")
+	assert.FileExists(t, filepath.Join(dir, "logs", "service.log"))
+	assert.Equal(t, filepath.Join(dir, "state", "outbox.offset"), OutboxConfig{}.withDefaults().OffsetFile)
+}
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/debug"
+	"strings"
+	"time"
+)
+
+// supportLogTail is how much of the end of each log goes into a support bundle
+const supportLogTail = 1 << 20
+
+// VersionInfo describes the build of the running binary
+type VersionInfo struct {
+	Version   string            `json:"version"`
+	GoVersion string            `json:"goVersion"`
+	Platform  string            `json:"platform"`
+	Settings  map[string]string `json:"settings,omitempty"`
+}
+
+// QueueStats summarizes the payloads waiting for replay without changing the queue
+type QueueStats struct {
+	Path        string `json:"path"`
+	SizeBytes   int64  `json:"sizeBytes"`
+	Entries     int    `json:"entries"`
+	Corrupt     int    `json:"corrupt"`
+	DeadLetters int    `json:"deadLetters"`
+}
+
+// CheckResult is the outcome of one health check included in a support bundle
+type CheckResult struct {
+	Name   string `json:"name"`
+	OK     bool   `json:"ok"`
+	Detail string `json:"detail,omitempty"`
+}
+
+func versionInfo() VersionInfo {
+	info := VersionInfo{Version: version, GoVersion: runtime.Version(), Platform: runtime.GOOS + "/" + runtime.GOARCH}
+	if build, ok := debug.ReadBuildInfo(); ok {
+		info.Settings = map[string]string{}
+		for _, s := range build.Settings {
+			if strings.HasPrefix(s.Key, "vcs.") || s.Key == "GOOS" || s.Key == "GOARCH" {
+				info.Settings[s.Key] = s.Value
+			}
+		}
+	}
+	return info
+}
+
+// queueStats reads failures.log and deadletter.log
+func queueStats() QueueStats {
+	stats := QueueStats{Path: failuresFile}
+	if info, err := os.Stat(failuresFile); err == nil {
+		stats.SizeBytes = info.Size()
+	}
+	if file, err := os.Open(failuresFile); err == nil {
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			if line == "" {
+				continue
+			}
+			if _, _, err := decodeQueueEntry(line); err != nil {
+				stats.Corrupt++
+			} else {
+				stats.Entries++
+			}
+		}
+		file.Close()
+	}
+	if data, err := os.ReadFile(deadLetterFile); err == nil {
+		stats.DeadLetters = bytes.Count(data, []byte("\n"))
+	}
+	return stats
+}
+
+// runChecks runs the basic health checks of a station
+func runChecks(config *Config) []CheckResult {
+	var results []CheckResult
+	check := func(name string, err error, ok string) {
+		result := CheckResult{Name: name, OK: err == nil, Detail: ok}
+		if err != nil {
+			result.Detail = err.Error()
+		}
+		results = append(results, result)
+	}
+
+	for _, path := range []string{failuresFile, commandAuditFile, filepath.Join(stateDir, "outbox.offset")} {
+		dir := filepath.Dir(path)
+		file, err := os.CreateTemp(dir, ".write-check*")
+		if err == nil {
+			file.Close()
+			os.Remove(file.Name())
+		}
+		check("writable "+dir, err, "")
+	}
+	check("tls policy", config.TLSPolicy.validate(), "")
+	check("fsync policy", config.Fsync.validate(), "")
+
+	if u, err := url.Parse(config.APIEndpoint); err != nil || u.Host == "" {
+		check("api endpoint", fmt.Errorf("invalid apiEndpoint %q", config.APIEndpoint), "")
+	} else {
+		host := u.Host
+		if u.Port() == "" {
+			port := "80"
+			if u.Scheme == "https" {
+				port = "443"
+			}
+			host = net.JoinHostPort(u.Hostname(), port)
+		}
+		conn, err := net.DialTimeout("tcp", host, 5*time.Second)
+		if err == nil {
+			conn.Close()
+		}
+		check("api reachable", err, host)
+	}
+	return results
+}
+
+// fetchServiceStatus asks the running service for its status through the admin API
+func fetchServiceStatus(config *Config) ([]byte, error) {
+	if config.Admin.Listen == "" {
+		return nil, fmt.Errorf("admin API not configured")
+	}
+	addr := config.Admin.Listen
+	if strings.HasPrefix(addr, ":") {
+		addr = "127.0.0.1" + addr
+	}
+	client := newAdminClient(config, 5*time.Second)
+	resp, err := client.Get("http://" + addr + "/status")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	return io.ReadAll(resp.Body)
+}
+
+// tailFile returns up to max bytes from the end of a file
+func tailFile(path string, max int64) ([]byte, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if info.Size() > max {
+		if _, err := file.Seek(info.Size()-max, io.SeekStart); err != nil {
+			return nil, err
+		}
+	}
+	return io.ReadAll(file)
+}
+
+// writeSupportBundle writes a zip with everything needed to diagnose the station.
+// configErr is recorded when the config could not be read, and config may then be empty.
+func writeSupportBundle(w io.Writer, config *Config, configErr error) error {
+	z := zip.NewWriter(w)
+	add := func(name string, data []byte) error {
+		f, err := z.Create(name)
+		if err != nil {
+			return err
+		}
+		_, err = f.Write(data)
+		return err
+	}
+	addJSON := func(name string, v interface{}) error {
+		data, err := json.MarshalIndent(v, "", "  ")
+		if err != nil {
+			return err
+		}
+		return add(name, data)
+	}
+
+	var errs []string
+	if configErr != nil {
+		errs = append(errs, "config: "+configErr.Error())
+	}
+	if err := addJSON("version.json", versionInfo()); err != nil {
+		return err
+	}
+	if fields, err := redactedConfig(config); err == nil {
+		if err := addJSON("config.json", fields); err != nil {
+			return err
+		}
+	}
+	if err := addJSON("queue.json", queueStats()); err != nil {
+		return err
+	}
+	devices := enumerateDevices()
+	if err := addJSON("devices.json", map[string]interface{}{
+		"bindings": deviceBindings(config, devices),
+		"detected": devices,
+	}); err != nil {
+		return err
+	}
+	if err := addJSON("checks.json", runChecks(config)); err != nil {
+		return err
+	}
+	if status, err := fetchServiceStatus(config); err == nil {
+		if err := add("status.json", status); err != nil {
+			return err
+		}
+	} else {
+		errs = append(errs, "status: "+err.Error())
+	}
+	logs := []string{commandAuditFile}
+	if path := logFileName(); path != "" {
+		logs = append([]string{path}, logs...)
+	}
+	for _, path := range logs {
+		data, err := tailFile(path, supportLogTail)
+		if err != nil {
+			if !os.IsNotExist(err) {
+				errs = append(errs, fmt.Sprintf("%s: %v", path, err))
+			}
+			continue
+		}
+		if err := add("logs/"+filepath.Base(path), data); err != nil {
+			return err
+		}
+	}
+	if len(errs) > 0 {
+		if err := add("errors.txt", []byte(strings.Join(errs, "\n")+"\n")); err != nil {
+			return err
+		}
+	}
+	return z.Close()
+}
+
+// createSupportBundle writes the bundle to a new zip named after the host and time
+func createSupportBundle() (string, error) {
+	// the bundle reports on the station without changing it, so config.json is not migrated in place
+	config, configErr := loadConfigFile("config.json", false)
+	if config == nil {
+		config = &Config{}
+	}
+	if err := applyStorage(config.Storage); err != nil {
+		return "", err
+	}
+	hostname, _ := os.Hostname()
+	path := fmt.Sprintf("support-bundle-%s-%s.zip", hostname, time.Now().Format("20060102-150405"))
+	file, err := os.Create(path)
+	if err != nil {
+		return "", err
+	}
+	if err := writeSupportBundle(file, config, configErr); err != nil {
+		file.Close()
+		os.Remove(path)
+		return "", err
+	}
+	return path, file.Close()
+}
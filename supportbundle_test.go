@@ -0,0 +1,53 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"testing"
+
+	"github.com/karalabe/hid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWriteSupportBundle(t *testing.T) {
+	useTempQueue(t)
+	oldEnumerate := enumerateDevices
+	defer func() { enumerateDevices = oldEnumerate }()
+	enumerateDevices = func() []hid.DeviceInfo { return []hid.DeviceInfo{{Product: "DS2208"}} }
+	logFailure(Payload{ItemID: "1"})
+	appendRecord(failuresFile, []byte("garbage"))
+
+	config := &Config{NumberOfScanners: 1, Alerts: AlertConfig{SMTP: SMTPConfig{Password: "hunter2"}}}
+	var buf bytes.Buffer
+	assert.NoError(t, writeSupportBundle(&buf, config, errors.New("bad json")))
+
+	z, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	assert.NoError(t, err)
+	files := map[string]string{}
+	for _, f := range z.File {
+		r, _ := f.Open()
+		data, _ := io.ReadAll(r)
+		r.Close()
+		files[f.Name] = string(data)
+	}
+
+	for _, name := range []string{"version.json", "config.json", "queue.json", "devices.json", "checks.json", "errors.txt"} {
+		assert.Contains(t, files, name)
+	}
+	assert.NotContains(t, files["config.json"], "hunter2")
+	assert.Contains(t, files["queue.json"], `"entries": 1`)
+	assert.Contains(t, files["queue.json"], `"corrupt": 1`)
+	assert.Contains(t, files["devices.json"], "DS2208")
+	assert.Contains(t, files["errors.txt"], "config: bad json")
+}
+
+func TestTailFile(t *testing.T) {
+	path := t.TempDir() + "/log"
+	os.WriteFile(path, []byte("0123456789"), 0644)
+	data, err := tailFile(path, 4)
+	assert.NoError(t, err)
+	assert.Equal(t, "6789", string(data))
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+)
+
+// TLSConfig represents the TLS settings used to reach one output
+type TLSConfig struct {
+	// CAFile is a PEM bundle trusted instead of the system roots
+	CAFile string `json:"caFile"`
+	// CertFile and KeyFile are the client certificate presented for mTLS
+	CertFile string `json:"certFile"`
+	KeyFile  string `json:"keyFile"`
+	// Enrolled presents the client certificate obtained by certEnrollment instead
+	Enrolled bool `json:"enrolled"`
+	// PinnedSHA256 lists base64 SHA-256 hashes of accepted server public keys
+	PinnedSHA256 []string `json:"pinnedSha256"`
+	// MinVersion is "1.2" or "1.3"
+	MinVersion string `json:"minVersion"`
+	ServerName string `json:"serverName"`
+}
+
+// TLSPolicyConfig represents the site security baseline applied to every TLS
+// connection the service makes. Per-output settings can only tighten it.
+type TLSPolicyConfig struct {
+	// MinVersion is "1.2" (default) or "1.3"
+	MinVersion string `json:"minVersion"`
+	// CipherSuites restricts the TLS 1.2 cipher suites by their standard names,
+	// e.g. "TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384". TLS 1.3 suites are not configurable.
+	CipherSuites []string `json:"cipherSuites"`
+}
+
+// tlsPolicy is the effective policy, set from config at startup
+var tlsPolicy TLSPolicyConfig
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// validate checks that the policy names known versions and secure cipher suites
+func (p TLSPolicyConfig) validate() error {
+	return p.apply(&tls.Config{})
+}
+
+// apply raises the minimum version and restricts cipher suites on cfg
+func (p TLSPolicyConfig) apply(cfg *tls.Config) error {
+	if cfg.MinVersion < tls.VersionTLS12 {
+		cfg.MinVersion = tls.VersionTLS12
+	}
+	if p.MinVersion != "" {
+		version, ok := tlsVersions[p.MinVersion]
+		if !ok || version < tls.VersionTLS12 {
+			return fmt.Errorf("unsupported TLS policy version %q", p.MinVersion)
+		}
+		if version > cfg.MinVersion {
+			cfg.MinVersion = version
+		}
+	}
+	if len(p.CipherSuites) > 0 {
+		secure := map[string]uint16{}
+		for _, suite := range tls.CipherSuites() {
+			secure[suite.Name] = suite.ID
+		}
+		cfg.CipherSuites = nil
+		for _, name := range p.CipherSuites {
+			id, ok := secure[name]
+			if !ok {
+				return fmt.Errorf("unknown or insecure cipher suite %q", name)
+			}
+			cfg.CipherSuites = append(cfg.CipherSuites, id)
+		}
+	}
+	return nil
+}
+
+// build converts the settings to a crypto/tls configuration that satisfies the site policy
+func (t TLSConfig) build() (*tls.Config, error) {
+	cfg := &tls.Config{MinVersion: tls.VersionTLS12, ServerName: t.ServerName}
+	if t.MinVersion != "" {
+		version, ok := tlsVersions[t.MinVersion]
+		if !ok {
+			return nil, fmt.Errorf("unknown TLS version %q", t.MinVersion)
+		}
+		cfg.MinVersion = version
+	}
+	if err := tlsPolicy.apply(cfg); err != nil {
+		return nil, err
+	}
+	if t.CAFile != "" {
+		pem, err := os.ReadFile(t.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", t.CAFile)
+		}
+		cfg.RootCAs = pool
+	}
+	if t.CertFile != "" || t.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(t.CertFile, t.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	if t.Enrolled {
+		// looked up per handshake so renewed certificates are used without a restart
+		cfg.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			return enrolledCert.current(), nil
+		}
+	}
+	if len(t.PinnedSHA256) > 0 {
+		pins := map[string]bool{}
+		for _, pin := range t.PinnedSHA256 {
+			pins[pin] = true
+		}
+		cfg.VerifyPeerCertificate = func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			for _, raw := range rawCerts {
+				cert, err := x509.ParseCertificate(raw)
+				if err != nil {
+					continue
+				}
+				sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+				if pins[base64.StdEncoding.EncodeToString(sum[:])] {
+					return nil
+				}
+			}
+			return errors.New("server certificate does not match any pinned key")
+		}
+	}
+	return cfg, nil
+}
+
+// newHTTPClient returns a client that uses the given TLS settings
+func newHTTPClient(t TLSConfig) (*http.Client, error) {
+	tlsConfig, err := t.build()
+	if err != nil {
+		return nil, err
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	return &http.Client{Transport: transport, Timeout: 30 * time.Second}, nil
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func writeServerCA(t *testing.T, server *httptest.Server) string {
+	path := filepath.Join(t.TempDir(), "ca.pem")
+	data := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	assert.NoError(t, os.WriteFile(path, data, 0644))
+	return path
+}
+
+func TestNewHTTPClient_CAAndPinning(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+	caFile := writeServerCA(t, server)
+	sum := sha256.Sum256(server.Certificate().RawSubjectPublicKeyInfo)
+	pin := base64.StdEncoding.EncodeToString(sum[:])
+
+	client, err := newHTTPClient(TLSConfig{CAFile: caFile, PinnedSHA256: []string{pin}})
+	assert.NoError(t, err)
+	resp, err := client.Get(server.URL)
+	assert.NoError(t, err)
+	resp.Body.Close()
+
+	client, err = newHTTPClient(TLSConfig{CAFile: caFile, PinnedSHA256: []string{"AAAA"}})
+	assert.NoError(t, err)
+	_, err = client.Get(server.URL)
+	assert.ErrorContains(t, err, "pinned key")
+
+	client, err = newHTTPClient(TLSConfig{})
+	assert.NoError(t, err)
+	_, err = client.Get(server.URL)
+	assert.Error(t, err)
+}
+
+func TestTLSConfigBuild_MinVersion(t *testing.T) {
+	cfg, err := TLSConfig{MinVersion: "1.3"}.build()
+	assert.NoError(t, err)
+	assert.Equal(t, uint16(0x0304), cfg.MinVersion)
+
+	_, err = TLSConfig{MinVersion: "2.0"}.build()
+	assert.Error(t, err)
+}
+
+func TestTLSPolicy(t *testing.T) {
+	oldPolicy := tlsPolicy
+	defer func() { tlsPolicy = oldPolicy }()
+
+	tlsPolicy = TLSPolicyConfig{MinVersion: "1.3"}
+	cfg, err := TLSConfig{MinVersion: "1.2"}.build()
+	assert.NoError(t, err)
+	assert.Equal(t, uint16(tls.VersionTLS13), cfg.MinVersion)
+
+	tlsPolicy = TLSPolicyConfig{CipherSuites: []string{"TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384"}}
+	cfg, err = TLSConfig{}.build()
+	assert.NoError(t, err)
+	assert.Equal(t, []uint16{tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384}, cfg.CipherSuites)
+
+	assert.Error(t, TLSPolicyConfig{CipherSuites: []string{"TLS_RSA_WITH_RC4_128_SHA"}}.validate())
+	assert.Error(t, TLSPolicyConfig{MinVersion: "1.0"}.validate())
+}
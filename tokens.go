@@ -0,0 +1,188 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+)
+
+// deviceTokensSecret is the name of the stored per-device tokens and credentials version
+const deviceTokensSecret = "device-tokens"
+
+// TokenRotation carries new API credentials from the backend, on enrollment or in a heartbeat response
+type TokenRotation struct {
+	// Version identifies the credential set. The station reports the version in effect
+	// as credentialsVersion, so the backend knows when the old tokens can be revoked.
+	Version string `json:"version"`
+	// Token replaces the station token when set
+	Token string `json:"token"`
+	// DeviceTokens are sent instead of the station token for payloads from the named
+	// scanners, e.g. "scanner0". Omitted keeps the current ones; {} removes them all.
+	DeviceTokens map[string]string `json:"deviceTokens"`
+	// RotateAt switches to the new credentials at that time instead of at once
+	RotateAt *time.Time `json:"rotateAt"`
+}
+
+// HeartbeatResponse is what the backend may answer to a heartbeat
+type HeartbeatResponse struct {
+	Credentials *TokenRotation `json:"credentials"`
+}
+
+// storedDeviceTokens is the form of the device tokens in deviceTokensSecret
+type storedDeviceTokens struct {
+	Version string            `json:"version"`
+	Devices map[string]string `json:"devices"`
+}
+
+// apiCredentials holds the station and device tokens sent to the API
+type apiCredentials struct {
+	mu      sync.Mutex
+	token   string
+	devices map[string]string
+	version string
+	// pending is the version of a scheduled rotation, and timer switches to it
+	pending string
+	timer   *time.Timer
+}
+
+var apiTokens = &apiCredentials{}
+
+// load reads the stored tokens. Missing tokens are not an error.
+func (c *apiCredentials) load() error {
+	token, err := loadSecret(apiTokenSecret)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	var stored storedDeviceTokens
+	data, err := loadSecret(deviceTokensSecret)
+	if err == nil {
+		err = json.Unmarshal(data, &stored)
+	}
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.token = string(token)
+	c.devices = stored.Devices
+	c.version = stored.Version
+	return nil
+}
+
+// forDevice returns the token for payloads from device, falling back to the station token
+func (c *apiCredentials) forDevice(device string) string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if token, ok := c.devices[device]; ok && device != "" {
+		return token
+	}
+	return c.token
+}
+
+// currentVersion is the version of the credentials in effect
+func (c *apiCredentials) currentVersion() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.version
+}
+
+// apply stores the rotated credentials and uses them for new requests
+func (c *apiCredentials) apply(rotation TokenRotation) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if rotation.Token != "" {
+		if err := saveSecret(apiTokenSecret, []byte(rotation.Token)); err != nil {
+			return err
+		}
+	}
+	devices := c.devices
+	if rotation.DeviceTokens != nil {
+		devices = rotation.DeviceTokens
+	}
+	data, err := json.Marshal(storedDeviceTokens{Version: rotation.Version, Devices: devices})
+	if err != nil {
+		return err
+	}
+	if err := saveSecret(deviceTokensSecret, data); err != nil {
+		return err
+	}
+	if rotation.Token != "" {
+		c.token = rotation.Token
+	}
+	c.devices = devices
+	c.version = rotation.Version
+	if c.pending == rotation.Version {
+		c.pending = ""
+	}
+	return nil
+}
+
+// rotate applies a rotation from the backend now, or schedules it for RotateAt.
+// A rotation to the version in effect or already scheduled is ignored, since
+// the backend repeats it until the heartbeat reports the new version.
+func (c *apiCredentials) rotate(rotation TokenRotation, now time.Time) {
+	c.mu.Lock()
+	if rotation.Version == c.version || (rotation.Version == c.pending && c.pending != "") {
+		c.mu.Unlock()
+		return
+	}
+	if c.timer != nil {
+		c.timer.Stop()
+	}
+	c.pending = rotation.Version
+	c.mu.Unlock()
+
+	apply := func() {
+		if err := c.apply(rotation); err != nil {
+			logger.Errorf("Error rotating API credentials: %v", err)
+			c.mu.Lock()
+			c.pending = ""
+			c.mu.Unlock()
+			return
+		}
+		logger.Infof("Rotated API credentials to version %q", rotation.Version)
+	}
+	if rotation.RotateAt == nil || !rotation.RotateAt.After(now) {
+		apply()
+		return
+	}
+	logger.Infof("API credentials version %q scheduled for %s", rotation.Version, rotation.RotateAt.Format(time.RFC3339))
+	c.mu.Lock()
+	c.timer = time.AfterFunc(rotation.RotateAt.Sub(now), apply)
+	c.mu.Unlock()
+}
+
+// handleHeartbeatResponse applies credentials sent back by the backend
+func handleHeartbeatResponse(body []byte) {
+	if len(bytes.TrimSpace(body)) == 0 {
+		return
+	}
+	var response HeartbeatResponse
+	if err := json.Unmarshal(body, &response); err != nil {
+		logger.Warnf("Ignoring heartbeat response: %v", err)
+		return
+	}
+	if response.Credentials != nil {
+		apiTokens.rotate(*response.Credentials, time.Now())
+	}
+}
+
+type deviceKey struct{}
+
+// withDevice marks a request context as sending payloads from device, so the device's token is used
+func withDevice(ctx context.Context, device string) context.Context {
+	return context.WithValue(ctx, deviceKey{}, device)
+}
+
+// deviceBody is a request body from one device, for httpPost
+type deviceBody struct {
+	*bytes.Reader
+	device string
+}
+
+func newDeviceBody(device string, data []byte) *deviceBody {
+	return &deviceBody{Reader: bytes.NewReader(data), device: device}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// useTempTokens starts the test without stored API tokens
+func useTempTokens(t *testing.T) {
+	oldTokens, oldClient := apiTokens, apiClient
+	apiTokens = &apiCredentials{}
+	t.Cleanup(func() {
+		apiTokens, apiClient = oldTokens, oldClient
+		os.Remove(secretPath(apiTokenSecret))
+		os.Remove(secretPath(deviceTokensSecret))
+	})
+}
+
+func TestHTTPPost_DeviceTokens(t *testing.T) {
+	useTempTokens(t)
+	var auth string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth = r.Header.Get("Authorization")
+	}))
+	defer server.Close()
+
+	assert.NoError(t, apiTokens.apply(TokenRotation{Version: "v1", Token: "station", DeviceTokens: map[string]string{"scanner1": "dev1"}}))
+	assert.NoError(t, setupClients(&Config{}))
+
+	resp, err := httpPost(server.URL, "application/json", newDeviceBody("scanner1", []byte(`{}`)))
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, "Bearer dev1", auth)
+
+	resp, err = httpPost(server.URL, "application/json", newDeviceBody("scanner0", []byte(`{}`)))
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, "Bearer station", auth)
+}
+
+func TestAPICredentials_Rotate(t *testing.T) {
+	useTempTokens(t)
+	now := time.Now()
+	assert.NoError(t, apiTokens.apply(TokenRotation{Version: "v1", Token: "old", DeviceTokens: map[string]string{"scanner0": "dev-old"}}))
+
+	// omitted device tokens are kept
+	handleHeartbeatResponse([]byte(`{"credentials": {"version": "v2", "token": "new"}}`))
+	assert.Equal(t, "v2", apiTokens.currentVersion())
+	assert.Equal(t, "new", apiTokens.forDevice(""))
+	assert.Equal(t, "dev-old", apiTokens.forDevice("scanner0"))
+
+	// scheduled rotations wait for rotateAt, and repeats are ignored
+	at := now.Add(50 * time.Millisecond)
+	apiTokens.rotate(TokenRotation{Version: "v3", DeviceTokens: map[string]string{}, RotateAt: &at}, now)
+	apiTokens.rotate(TokenRotation{Version: "v3", DeviceTokens: map[string]string{}, RotateAt: &at}, now)
+	assert.Equal(t, "dev-old", apiTokens.forDevice("scanner0"))
+	assert.Eventually(t, func() bool { return apiTokens.currentVersion() == "v3" }, time.Second, 10*time.Millisecond)
+	assert.Equal(t, "new", apiTokens.forDevice("scanner0"))
+
+	// the rotated credentials survive a restart
+	reloaded := &apiCredentials{}
+	assert.NoError(t, reloaded.load())
+	assert.Equal(t, "v3", reloaded.currentVersion())
+	assert.Equal(t, "new", reloaded.forDevice("scanner0"))
+
+	handleHeartbeatResponse([]byte(`not json`))
+	handleHeartbeatResponse(nil)
+	assert.Equal(t, "v3", apiTokens.currentVersion())
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"io"
+	"regexp"
+	"sync/atomic"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// TraceConfig represents trace logging of every pipeline step for sampled scans
+type TraceConfig struct {
+	Enabled bool `json:"enabled"`
+	// SampleEvery traces one in every N scans; 0 or 1 traces all of them
+	SampleEvery int `json:"sampleEvery"`
+	// Pattern restricts tracing to scans whose item ID matches the regular expression
+	Pattern string `json:"pattern"`
+}
+
+// scanTracer decides which scans are traced and writes their steps at trace level
+type scanTracer struct {
+	config  TraceConfig
+	pattern *regexp.Regexp
+	seen    uint64
+	log     *logrus.Logger
+}
+
+// scanTrace follows one scan through the pipeline. A nil trace records nothing.
+type scanTrace struct {
+	log   *logrus.Entry
+	start time.Time
+}
+
+var tracer *scanTracer
+
+// newScanTracer builds a tracer that writes to the service log, leaving the
+// level of the service log untouched so debug output stays off
+func newScanTracer(config TraceConfig) (*scanTracer, error) {
+	t := &scanTracer{config: config, log: logrus.New()}
+	if config.Pattern != "" {
+		pattern, err := regexp.Compile(config.Pattern)
+		if err != nil {
+			return nil, err
+		}
+		t.pattern = pattern
+	}
+	t.log.SetOutput(logger.Out)
+	t.log.SetFormatter(logger.Formatter)
+	t.log.SetLevel(logrus.TraceLevel)
+	return t, nil
+}
+
+// setOutput moves the trace lines to out, following the service log
+func (t *scanTracer) setOutput(out io.Writer) {
+	if t == nil {
+		return
+	}
+	t.log.SetOutput(out)
+}
+
+// start begins tracing the scan if it is sampled, returning nil otherwise
+func (t *scanTracer) start(payload Payload) *scanTrace {
+	if t == nil {
+		return nil
+	}
+	if t.pattern != nil && !t.pattern.MatchString(payload.ItemID) {
+		return nil
+	}
+	n := atomic.AddUint64(&t.seen, 1)
+	if t.config.SampleEvery > 1 && (n-1)%uint64(t.config.SampleEvery) != 0 {
+		return nil
+	}
+	if !diskPressure.allowAudit("trace") {
+		return nil
+	}
+	trace := &scanTrace{
+		log:   t.log.WithFields(logrus.Fields{"trace": n, "itemId": payload.ItemID, "deviceType": payload.DeviceType}),
+		start: time.Now(),
+	}
+	trace.step("received")
+	return trace
+}
+
+// step records that the scan reached a pipeline step
+func (s *scanTrace) step(name string, args ...interface{}) {
+	if s == nil {
+		return
+	}
+	fields := logrus.Fields{"step": name, "elapsedMicros": time.Since(s.start).Microseconds()}
+	for i := 0; i+1 < len(args); i += 2 {
+		if key, ok := args[i].(string); ok {
+			fields[key] = args[i+1]
+		}
+	}
+	s.log.WithFields(fields).Trace("Scan trace")
+}
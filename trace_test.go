@@ -0,0 +1,53 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestScanTracer_Sampling(t *testing.T) {
+	tracer, err := newScanTracer(TraceConfig{Enabled: true, SampleEvery: 2, Pattern: "^PAL"})
+	assert.NoError(t, err)
+
+	var sampled []string
+	for _, id := range []string{"PAL1", "BOX1", "PAL2", "PAL3"} {
+		if tracer.start(Payload{ItemID: id}) != nil {
+			sampled = append(sampled, id)
+		}
+	}
+	assert.Equal(t, []string{"PAL1", "PAL3"}, sampled)
+}
+
+func TestScanTrace_Steps(t *testing.T) {
+	tracer, err := newScanTracer(TraceConfig{Enabled: true})
+	assert.NoError(t, err)
+	var buf bytes.Buffer
+	tracer.log.SetOutput(&buf)
+	tracer.log.SetFormatter(&logrus.JSONFormatter{})
+
+	trace := tracer.start(Payload{ItemID: "12345", DeviceType: "scanner0"})
+	trace.step("dead-lettered", "reason", "bad")
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	assert.Len(t, lines, 2)
+	assert.Contains(t, lines[0], `"step":"received"`)
+	assert.Contains(t, lines[1], `"reason":"bad"`)
+	assert.Contains(t, lines[1], `"itemId":"12345"`)
+	assert.Contains(t, lines[1], `"level":"trace"`)
+}
+
+func TestScanTrace_NilIsNoop(t *testing.T) {
+	var tracer *scanTracer
+	trace := tracer.start(Payload{ItemID: "1"})
+	assert.Nil(t, trace)
+	trace.step("posted")
+}
+
+func TestNewScanTracer_InvalidPattern(t *testing.T) {
+	_, err := newScanTracer(TraceConfig{Pattern: "("})
+	assert.Error(t, err)
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// TriggerConfig represents the host trigger port of a scanner that supports
+// software triggering, such as a Zebra scanner in SSI mode
+type TriggerConfig struct {
+	// Scanner is the ID of the scanner, 0 for scanner0
+	Scanner int `json:"scanner"`
+	// Port is the scanner's serial or USB CDC port, such as COM7 or /dev/ttyACM0
+	Port string `json:"port"`
+	// Protocol is the host command protocol; only "ssi" (the default) is supported
+	Protocol string `json:"protocol"`
+}
+
+// Trigger actions, for the admin API and command barcodes
+const (
+	triggerRead    = "read"
+	triggerStop    = "stop"
+	triggerDisable = "disable"
+	triggerEnable  = "enable"
+	triggerSleep   = "sleep"
+	triggerWake    = "wake"
+)
+
+// ssiOpcodes are the SSI commands of each trigger action
+var ssiOpcodes = map[string]byte{
+	triggerRead:    0xE4, // START_DECODE
+	triggerStop:    0xE5, // STOP_DECODE
+	triggerEnable:  0xE9, // SCAN_ENABLE
+	triggerDisable: 0xEA, // SCAN_DISABLE
+	triggerSleep:   0xEB, // SLEEP
+}
+
+// ssiWakeup is the byte that wakes a sleeping scanner, which then needs
+// ssiWakeDelay before it accepts a command
+var (
+	ssiWakeup    = []byte{0x00}
+	ssiWakeDelay = 20 * time.Millisecond
+)
+
+// validTriggerAction reports whether action is a trigger action
+func validTriggerAction(action string) bool {
+	_, ok := ssiOpcodes[action]
+	return ok || action == triggerWake
+}
+
+// TriggerState reports the trigger of one scanner
+type TriggerState struct {
+	Scanner  string `json:"scanner"`
+	Disabled bool   `json:"disabled"`
+	Asleep   bool   `json:"asleep,omitempty"`
+}
+
+// hostTrigger sends trigger commands to one scanner, opening its port on first use
+type hostTrigger struct {
+	config   TriggerConfig
+	mu       sync.Mutex
+	port     io.WriteCloser
+	disabled bool
+	asleep   bool
+}
+
+// triggers holds the host triggers by scanner name, such as scanner0
+var triggers = map[string]*hostTrigger{}
+
+// openTriggerPort opens the port of a host trigger. Tests replace it.
+var openTriggerPort = func(port string) (io.WriteCloser, error) {
+	return os.OpenFile(serialDevicePath(port), os.O_RDWR, 0)
+}
+
+// loadTriggers checks the trigger configuration
+func loadTriggers(config *Config) (map[string]*hostTrigger, error) {
+	result := map[string]*hostTrigger{}
+	for _, cfg := range config.Triggers {
+		if cfg.Scanner < 0 || cfg.Scanner >= config.NumberOfScanners {
+			return nil, fmt.Errorf("trigger for scanner %d: only %d scanners are configured", cfg.Scanner, config.NumberOfScanners)
+		}
+		if cfg.Port == "" {
+			return nil, fmt.Errorf("trigger for %s: no port", scannerName(cfg.Scanner))
+		}
+		if cfg.Protocol != "" && cfg.Protocol != "ssi" {
+			return nil, fmt.Errorf("trigger for %s: unsupported protocol %q", scannerName(cfg.Scanner), cfg.Protocol)
+		}
+		name := scannerName(cfg.Scanner)
+		if _, ok := result[name]; ok {
+			return nil, fmt.Errorf("trigger for %s is defined twice", name)
+		}
+		result[name] = &hostTrigger{config: cfg}
+	}
+	return result, nil
+}
+
+// ssiPacket frames an SSI command sent by the host: length, opcode, source,
+// status, then the two's complement of the byte sum
+func ssiPacket(opcode byte) []byte {
+	packet := []byte{4, opcode, 0x04, 0x00}
+	sum := 0
+	for _, b := range packet {
+		sum += int(b)
+	}
+	checksum := uint16(0x10000 - sum)
+	return append(packet, byte(checksum>>8), byte(checksum))
+}
+
+// do sends the action to the scanner, reopening the port after a failed write.
+// A sleeping scanner is woken first.
+func (t *hostTrigger) do(action string) error {
+	if !validTriggerAction(action) {
+		return fmt.Errorf("unknown trigger action %q", action)
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.port == nil {
+		port, err := openTriggerPort(t.config.Port)
+		if err != nil {
+			return err
+		}
+		t.port = port
+	}
+	if (t.asleep && action != triggerSleep) || action == triggerWake {
+		if err := t.write(ssiWakeup); err != nil {
+			return err
+		}
+		t.asleep = false
+		time.Sleep(ssiWakeDelay)
+	}
+	if action == triggerWake {
+		return nil
+	}
+	if err := t.write(ssiPacket(ssiOpcodes[action])); err != nil {
+		return err
+	}
+	switch action {
+	case triggerDisable:
+		t.disabled = true
+	case triggerEnable:
+		t.disabled = false
+	case triggerSleep:
+		t.asleep = true
+	}
+	return nil
+}
+
+// write sends bytes to the port, closing it on failure so the next action reopens it
+func (t *hostTrigger) write(data []byte) error {
+	if _, err := t.port.Write(data); err != nil {
+		t.port.Close()
+		t.port = nil
+		return err
+	}
+	return nil
+}
+
+// triggerScanner runs a trigger action on the named scanner
+func triggerScanner(scanner, action string) error {
+	t, ok := triggers[scanner]
+	if !ok {
+		return fmt.Errorf("%s has no host trigger configured", scanner)
+	}
+	if err := t.do(action); err != nil {
+		logger.Errorf("Error sending trigger %s to %s: %v", action, scanner, err)
+		return err
+	}
+	logger.Infof("Sent trigger %s to %s", action, scanner)
+	if action == triggerRead {
+		noReads.arm(scanner)
+	}
+	return nil
+}
+
+// triggerStates returns the trigger state of every scanner with a host trigger
+func triggerStates() []TriggerState {
+	var result []TriggerState
+	for name, t := range triggers {
+		t.mu.Lock()
+		result = append(result, TriggerState{Scanner: name, Disabled: t.disabled, Asleep: t.asleep})
+		t.mu.Unlock()
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Scanner < result[j].Scanner })
+	return result
+}
+
+// triggerDisabled reports whether the named scanner's trigger was disabled from the host
+func triggerDisabled(scanner string) bool {
+	t, ok := triggers[scanner]
+	if !ok {
+		return false
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.disabled
+}
+
+// scannerAsleep reports whether the named scanner was sent to sleep and has not woken since
+func scannerAsleep(scanner string) bool {
+	t, ok := triggers[scanner]
+	if !ok {
+		return false
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.asleep
+}
+
+// scannerWoke records that the named scanner woke by itself, such as when its
+// trigger was pulled
+func scannerWoke(scanner string) {
+	t, ok := triggers[scanner]
+	if !ok {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.asleep = false
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeTriggerPort records what is written to a trigger port
+type fakeTriggerPort struct {
+	bytes.Buffer
+	fail bool
+}
+
+func (p *fakeTriggerPort) Write(b []byte) (int, error) {
+	if p.fail {
+		return 0, errors.New("port gone")
+	}
+	return p.Buffer.Write(b)
+}
+
+func (p *fakeTriggerPort) Close() error { return nil }
+
+// useFakeTriggers configures a host trigger on scanner0 that writes to the returned port
+func useFakeTriggers(t *testing.T) *fakeTriggerPort {
+	oldTriggers, oldOpen := triggers, openTriggerPort
+	t.Cleanup(func() { triggers, openTriggerPort = oldTriggers, oldOpen })
+	port := &fakeTriggerPort{}
+	openTriggerPort = func(string) (io.WriteCloser, error) { return port, nil }
+	var err error
+	triggers, err = loadTriggers(&Config{NumberOfScanners: 2, Triggers: []TriggerConfig{{Scanner: 0, Port: "COM7"}}})
+	assert.NoError(t, err)
+	return port
+}
+
+func TestSSIPacket(t *testing.T) {
+	assert.Equal(t, []byte{0x04, 0xE4, 0x04, 0x00, 0xFF, 0x14}, ssiPacket(ssiOpcodes[triggerRead]))
+	assert.Equal(t, []byte{0x04, 0xEA, 0x04, 0x00, 0xFF, 0x0E}, ssiPacket(ssiOpcodes[triggerDisable]))
+}
+
+func TestLoadTriggers_Validation(t *testing.T) {
+	_, err := loadTriggers(&Config{NumberOfScanners: 1, Triggers: []TriggerConfig{{Scanner: 1, Port: "COM7"}}})
+	assert.Error(t, err)
+	_, err = loadTriggers(&Config{NumberOfScanners: 1, Triggers: []TriggerConfig{{Port: "COM7", Protocol: "hid"}}})
+	assert.EqualError(t, err, `trigger for scanner0: unsupported protocol "hid"`)
+}
+
+func TestAdminTrigger(t *testing.T) {
+	port := useFakeTriggers(t)
+	mux := adminMux(&Config{NumberOfScanners: 2})
+
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, localRequest(http.MethodPost, "/trigger?scanner=scanner0&action=disable"))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"scanner":"scanner0","disabled":true}`, w.Body.String())
+	assert.Equal(t, ssiPacket(0xEA), port.Bytes())
+	assert.True(t, currentStatus(&Config{NumberOfScanners: 1}).Devices[0].TriggerDisabled)
+
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, localRequest(http.MethodPost, "/trigger?scanner=scanner1&action=read"))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, localRequest(http.MethodPost, "/trigger?scanner=scanner0&action=fire"))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	// a failed write is reported and leaves the state alone
+	port.fail = true
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, localRequest(http.MethodPost, "/trigger?scanner=scanner0&action=enable"))
+	assert.Equal(t, http.StatusBadGateway, w.Code)
+	assert.Equal(t, []TriggerState{{Scanner: "scanner0", Disabled: true}}, triggerStates())
+}
+
+func TestRunScanCommands_Trigger(t *testing.T) {
+	port := useFakeTriggers(t)
+	config := &Config{Commands: []ScanCommandConfig{{Name: "supervise", Pattern: "^TRIGGER$", Trigger: triggerRead, Consume: true}}}
+	oldCommands := scanCommands
+	defer func() { scanCommands = oldCommands }()
+	var err error
+	scanCommands, err = loadScanCommands(config)
+	assert.NoError(t, err)
+	oldAudit := commandAuditFile
+	defer func() { commandAuditFile = oldAudit }()
+	commandAuditFile = filepath.Join(t.TempDir(), "commands.audit.log")
+
+	assert.True(t, runScanCommands(Payload{ItemID: "TRIGGER", DeviceType: "scanner0"}))
+	assert.Equal(t, ssiPacket(0xE4), port.Bytes())
+	audit, err := os.ReadFile(commandAuditFile)
+	assert.NoError(t, err)
+	assert.Contains(t, string(audit), `"trigger":"read scanner0","exitCode":0`)
+
+	config.Commands[0].TriggerScanner = "scanner1"
+	_, err = loadScanCommands(config)
+	assert.EqualError(t, err, "command supervise: scanner1 has no host trigger configured")
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/api"
+	"github.com/tetratelabs/wazero/imports/wasi_snapshot_preview1"
+)
+
+// wasmTransform is a sandboxed payload transform loaded from a WASM module.
+//
+// The module must export its linear memory as "memory" and two functions:
+//
+//	alloc(size i32) i32                 reserves size bytes and returns their offset
+//	transform(ptr i32, len i32) i64     handles a JSON plugin request and returns
+//	                                    the offset and length of the JSON reply
+//	                                    packed as (offset << 32) | length
+//
+// Requests and replies use the same JSON messages as process plugins. Modules
+// get WASI with no filesystem, network or environment access.
+type wasmTransform struct {
+	config   PluginConfig
+	mu       sync.Mutex
+	runtime  wazero.Runtime
+	compiled wazero.CompiledModule
+	module   api.Module
+}
+
+// loadWASMTransform compiles the module declared in cfg and instantiates it
+func loadWASMTransform(cfg PluginConfig) (*wasmTransform, error) {
+	code, err := os.ReadFile(cfg.Module)
+	if err != nil {
+		return nil, err
+	}
+	ctx := context.Background()
+	runtime := wazero.NewRuntimeWithConfig(ctx, wazero.NewRuntimeConfig().WithCloseOnContextDone(true))
+	wasi_snapshot_preview1.MustInstantiate(ctx, runtime)
+
+	compiled, err := runtime.CompileModule(ctx, code)
+	if err != nil {
+		runtime.Close(ctx)
+		return nil, err
+	}
+	w := &wasmTransform{config: cfg, runtime: runtime, compiled: compiled}
+	if err := w.instantiate(); err != nil {
+		runtime.Close(ctx)
+		return nil, err
+	}
+	return w, nil
+}
+
+// instantiate creates a fresh module instance, replacing one closed by a timeout
+func (w *wasmTransform) instantiate() error {
+	ctx := context.Background()
+	if w.module != nil {
+		w.module.Close(ctx)
+	}
+	module, err := w.runtime.InstantiateModule(ctx, w.compiled, wazero.NewModuleConfig().WithName("").WithStartFunctions("_initialize"))
+	if err != nil {
+		return err
+	}
+	for _, name := range []string{"alloc", "transform"} {
+		if module.ExportedFunction(name) == nil {
+			module.Close(ctx)
+			return fmt.Errorf("module %s does not export %q", w.config.Module, name)
+		}
+	}
+	if module.Memory() == nil {
+		module.Close(ctx)
+		return fmt.Errorf("module %s does not export memory", w.config.Module)
+	}
+	w.module = module
+	return nil
+}
+
+func (w *wasmTransform) name() string {
+	return w.config.Name
+}
+
+// call runs the module's transform export on a payload
+func (w *wasmTransform) call(payload Payload) (*pluginResponse, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.module == nil || w.module.IsClosed() {
+		if err := w.instantiate(); err != nil {
+			return nil, err
+		}
+	}
+
+	data, err := json.Marshal(pluginRequest{Type: "transform", Payload: payload})
+	if err != nil {
+		return nil, err
+	}
+
+	timeout := time.Duration(w.config.Timeout) * time.Second
+	if timeout <= 0 {
+		timeout = time.Second
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	results, err := w.module.ExportedFunction("alloc").Call(ctx, uint64(len(data)))
+	if err != nil {
+		return nil, err
+	}
+	ptr := uint32(results[0])
+	if !w.module.Memory().Write(ptr, data) {
+		return nil, errors.New("alloc returned an out of range offset")
+	}
+
+	results, err = w.module.ExportedFunction("transform").Call(ctx, uint64(ptr), uint64(len(data)))
+	if err != nil {
+		return nil, err
+	}
+	out, ok := w.module.Memory().Read(uint32(results[0]>>32), uint32(results[0]))
+	if !ok {
+		return nil, errors.New("transform returned an out of range reply")
+	}
+
+	var resp pluginResponse
+	if err := json.Unmarshal(out, &resp); err != nil {
+		return nil, fmt.Errorf("invalid reply %q: %v", out, err)
+	}
+	if resp.Error != "" {
+		return &resp, errors.New(resp.Error)
+	}
+	return &resp, nil
+}
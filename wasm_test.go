@@ -0,0 +1,48 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// echoWASM is a minimal module whose transform returns the request unchanged,
+// which is a valid reply carrying the same payload. It is equivalent to:
+//
+//	(module
+//	  (memory (export "memory") 1)
+//	  (func (export "alloc") (param i32) (result i32) (i32.const 2048))
+//	  (func (export "transform") (param i32 i32) (result i64)
+//	    (i64.or (i64.shl (i64.extend_i32_u (local.get 0)) (i64.const 32))
+//	            (i64.extend_i32_u (local.get 1)))))
+var echoWASM = []byte{
+	0x00, 0x61, 0x73, 0x6d, 0x01, 0x00, 0x00, 0x00, 0x01, 0x0c, 0x02, 0x60,
+	0x01, 0x7f, 0x01, 0x7f, 0x60, 0x02, 0x7f, 0x7f, 0x01, 0x7e, 0x03, 0x03,
+	0x02, 0x00, 0x01, 0x05, 0x03, 0x01, 0x00, 0x01, 0x07, 0x1e, 0x03, 0x06,
+	0x6d, 0x65, 0x6d, 0x6f, 0x72, 0x79, 0x02, 0x00, 0x05, 0x61, 0x6c, 0x6c,
+	0x6f, 0x63, 0x00, 0x00, 0x09, 0x74, 0x72, 0x61, 0x6e, 0x73, 0x66, 0x6f,
+	0x72, 0x6d, 0x00, 0x01, 0x0a, 0x14, 0x02, 0x05, 0x00, 0x41, 0x80, 0x10,
+	0x0b, 0x0c, 0x00, 0x20, 0x00, 0xad, 0x42, 0x20, 0x86, 0x20, 0x01, 0xad,
+	0x84, 0x0b,
+}
+
+func TestWASMTransform_Echo(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "echo.wasm")
+	assert.NoError(t, os.WriteFile(path, echoWASM, 0644))
+
+	w, err := loadWASMTransform(PluginConfig{Name: "echo", Type: "wasm-transform", Module: path})
+	assert.NoError(t, err)
+	resp, err := w.call(Payload{ItemID: "12345", DeviceType: "scanner0"})
+	assert.NoError(t, err)
+	assert.Equal(t, &Payload{ItemID: "12345", DeviceType: "scanner0"}, resp.Payload)
+}
+
+func TestWASMTransform_MissingExports(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "empty.wasm")
+	assert.NoError(t, os.WriteFile(path, []byte{0x00, 0x61, 0x73, 0x6d, 0x01, 0x00, 0x00, 0x00}, 0644))
+
+	_, err := loadWASMTransform(PluginConfig{Name: "empty", Type: "wasm-transform", Module: path})
+	assert.Error(t, err)
+}
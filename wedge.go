@@ -0,0 +1,50 @@
+package main
+
+import "strings"
+
+// KeyboardPassthroughConfig represents re-emitting scans as synthetic keystrokes
+// into the focused application, for legacy software that expects wedge input
+type KeyboardPassthroughConfig struct {
+	Enabled bool `json:"enabled"`
+	// Suffix is typed after each barcode, "\n" (Enter) if unset
+	Suffix *string `json:"suffix"`
+}
+
+// keyboardEmitter types text into the focused application
+type keyboardEmitter interface {
+	Type(text string) error
+	Close() error
+}
+
+var keyboardOutput keyboardEmitter
+
+func (k KeyboardPassthroughConfig) suffix() string {
+	if k.Suffix == nil {
+		return "\n"
+	}
+	return *k.Suffix
+}
+
+// startKeyboardPassthrough opens the platform keyboard emitter
+func startKeyboardPassthrough() {
+	emitter, err := newKeyboardEmitter()
+	if err != nil {
+		logger.Errorf("Error starting keyboard passthrough: %v", err)
+		return
+	}
+	keyboardOutput = emitter
+	logger.Infof("Keyboard passthrough enabled")
+}
+
+// passthroughKeyboard types the cleaned barcode into the focused application
+func passthroughKeyboard(config *Config, payload Payload) {
+	if keyboardOutput == nil || payload.DeviceType == "keyboard" {
+		// scans typed on the keyboard already reached the focused application
+		return
+	}
+	payload.CleanItemId()
+	text := strings.TrimRight(payload.ItemID, "\r\n") + config.KeyboardPassthrough.suffix()
+	if err := keyboardOutput.Type(text); err != nil {
+		logger.Errorf("Error typing payload %v: %v", payload, err)
+	}
+}
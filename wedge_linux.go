@@ -0,0 +1,142 @@
+//go:build linux
+
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// uinput ioctls and input event constants from linux/uinput.h and linux/input.h
+const (
+	uiSetEvBit   = 0x40045564
+	uiSetKeyBit  = 0x40045565
+	uiDevCreate  = 0x5501
+	uiDevDestroy = 0x5502
+	evSyn        = 0x00
+	evKey        = 0x01
+	synReport    = 0
+	keyLeftShift = 42
+	busUSB       = 0x03
+)
+
+type keyStroke struct {
+	code  uint16
+	shift bool
+}
+
+// usKeyboard maps printable characters to US layout key codes
+var usKeyboard = func() map[rune]keyStroke {
+	keys := map[rune]keyStroke{'\n': {28, false}, '\t': {15, false}, ' ': {57, false}}
+	rows := []struct {
+		plain, shifted string
+		first          uint16
+	}{
+		{"1234567890-=", "!@#$%^&*()_+", 2},
+		{"qwertyuiop[]", "QWERTYUIOP{}", 16},
+		{"asdfghjkl;'`", "ASDFGHJKL:\"~", 30},
+		{"\\zxcvbnm,./", "|ZXCVBNM<>?", 43},
+	}
+	for _, row := range rows {
+		shifted := []rune(row.shifted)
+		for i, r := range []rune(row.plain) {
+			keys[r] = keyStroke{row.first + uint16(i), false}
+			keys[shifted[i]] = keyStroke{row.first + uint16(i), true}
+		}
+	}
+	return keys
+}()
+
+// uinputEmitter types text through a virtual keyboard created with uinput
+type uinputEmitter struct {
+	file *os.File
+}
+
+func newKeyboardEmitter() (keyboardEmitter, error) {
+	file, err := os.OpenFile("/dev/uinput", os.O_WRONLY|unix.O_NONBLOCK, 0)
+	if err != nil {
+		return nil, err
+	}
+	fd := int(file.Fd())
+	if err := unix.IoctlSetInt(fd, uiSetEvBit, evKey); err != nil {
+		file.Close()
+		return nil, err
+	}
+	for _, key := range usKeyboard {
+		unix.IoctlSetInt(fd, uiSetKeyBit, int(key.code))
+	}
+	unix.IoctlSetInt(fd, uiSetKeyBit, keyLeftShift)
+
+	// struct uinput_user_dev: name[80], input_id, ff_effects_max, abs arrays
+	var dev bytes.Buffer
+	name := make([]byte, 80)
+	copy(name, "SPC Barcode Service keyboard")
+	dev.Write(name)
+	binary.Write(&dev, binary.LittleEndian, [4]uint16{busUSB, 0x1209, 0x0001, 1})
+	binary.Write(&dev, binary.LittleEndian, uint32(0))
+	dev.Write(make([]byte, 4*64*4))
+	if _, err := file.Write(dev.Bytes()); err != nil {
+		file.Close()
+		return nil, err
+	}
+	if _, _, errno := unix.Syscall(unix.SYS_IOCTL, uintptr(fd), uiDevCreate, 0); errno != 0 {
+		file.Close()
+		return nil, errno
+	}
+	// give the desktop time to pick up the new device before the first scan
+	time.Sleep(500 * time.Millisecond)
+	return &uinputEmitter{file: file}, nil
+}
+
+// keyEvents encodes the input events typing text, returning an error for
+// characters that have no key on the US layout
+func keyEvents(text string) ([]byte, error) {
+	var buf bytes.Buffer
+	event := func(typ, code uint16, value int32) {
+		var tv unix.Timeval
+		buf.Write((*[unsafe.Sizeof(tv)]byte)(unsafe.Pointer(&tv))[:])
+		binary.Write(&buf, binary.LittleEndian, typ)
+		binary.Write(&buf, binary.LittleEndian, code)
+		binary.Write(&buf, binary.LittleEndian, value)
+	}
+	for _, r := range text {
+		if r == '\r' {
+			continue
+		}
+		key, ok := usKeyboard[r]
+		if !ok {
+			return nil, fmt.Errorf("no key for character %q", r)
+		}
+		if key.shift {
+			event(evKey, keyLeftShift, 1)
+		}
+		event(evKey, key.code, 1)
+		event(evSyn, synReport, 0)
+		event(evKey, key.code, 0)
+		if key.shift {
+			event(evKey, keyLeftShift, 0)
+		}
+		event(evSyn, synReport, 0)
+	}
+	return buf.Bytes(), nil
+}
+
+func (u *uinputEmitter) Type(text string) error {
+	events, err := keyEvents(text)
+	if err != nil {
+		return err
+	}
+	_, err = u.file.Write(events)
+	return err
+}
+
+func (u *uinputEmitter) Close() error {
+	unix.Syscall(unix.SYS_IOCTL, u.file.Fd(), uiDevDestroy, 0)
+	return u.file.Close()
+}
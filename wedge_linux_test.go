@@ -0,0 +1,28 @@
+//go:build linux
+
+package main
+
+import (
+	"testing"
+	"unsafe"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/sys/unix"
+)
+
+func TestKeyEvents(t *testing.T) {
+	var tv unix.Timeval
+	eventSize := int(unsafe.Sizeof(tv)) + 8
+
+	events, err := keyEvents("a")
+	assert.NoError(t, err)
+	assert.Len(t, events, 4*eventSize)
+
+	// shift is pressed and released around uppercase characters
+	events, err = keyEvents("A\n")
+	assert.NoError(t, err)
+	assert.Len(t, events, 10*eventSize)
+
+	_, err = keyEvents("é")
+	assert.Error(t, err)
+}
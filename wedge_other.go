@@ -0,0 +1,12 @@
+//go:build !linux && !windows
+
+package main
+
+import (
+	"fmt"
+	"runtime"
+)
+
+func newKeyboardEmitter() (keyboardEmitter, error) {
+	return nil, fmt.Errorf("keyboard passthrough is not supported on %s", runtime.GOOS)
+}
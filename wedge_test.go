@@ -0,0 +1,36 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeKeyboard struct {
+	typed []string
+}
+
+func (f *fakeKeyboard) Type(text string) error {
+	f.typed = append(f.typed, text)
+	return nil
+}
+
+func (f *fakeKeyboard) Close() error {
+	return nil
+}
+
+func TestPassthroughKeyboard(t *testing.T) {
+	keyboard := &fakeKeyboard{}
+	oldOutput := keyboardOutput
+	defer func() { keyboardOutput = oldOutput }()
+	keyboardOutput = keyboard
+
+	tab := "\t"
+	config := &Config{}
+	passthroughKeyboard(config, Payload{ItemID: "https://x.example/?id=12345\r\n", DeviceType: "scanner0"})
+	config.KeyboardPassthrough.Suffix = &tab
+	passthroughKeyboard(config, Payload{ItemID: "678", DeviceType: "scanner1"})
+	passthroughKeyboard(config, Payload{ItemID: "typed", DeviceType: "keyboard"})
+
+	assert.Equal(t, []string{"12345\n", "678\t"}, keyboard.typed)
+}
@@ -0,0 +1,90 @@
+//go:build windows
+
+package main
+
+import (
+	"fmt"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+const (
+	inputKeyboard    = 1
+	keyeventfKeyUp   = 0x0002
+	keyeventfUnicode = 0x0004
+	virtualKeyTab    = 0x09
+	virtualKeyReturn = 0x0d
+)
+
+// keybdInput mirrors the Win32 KEYBDINPUT structure
+type keybdInput struct {
+	wVk         uint16
+	wScan       uint16
+	dwFlags     uint32
+	time        uint32
+	dwExtraInfo uintptr
+}
+
+// keyboardInputEvent mirrors the Win32 INPUT structure; the padding covers the
+// larger MOUSEINPUT member of the union
+type keyboardInputEvent struct {
+	inputType uint32
+	ki        keybdInput
+	padding   [8]byte
+}
+
+var procSendInput = windows.NewLazySystemDLL("user32.dll").NewProc("SendInput")
+
+// sendInputEmitter types text with SendInput, using Unicode events so the
+// active keyboard layout does not matter
+type sendInputEmitter struct{}
+
+func newKeyboardEmitter() (keyboardEmitter, error) {
+	if err := procSendInput.Find(); err != nil {
+		return nil, err
+	}
+	return sendInputEmitter{}, nil
+}
+
+func (sendInputEmitter) Type(text string) error {
+	var inputs []keyboardInputEvent
+	for _, r := range text {
+		down := keyboardInputEvent{inputType: inputKeyboard}
+		switch r {
+		case '\n':
+			down.ki.wVk = virtualKeyReturn
+		case '\t':
+			down.ki.wVk = virtualKeyTab
+		case '\r':
+			continue
+		default:
+			down.ki.dwFlags = keyeventfUnicode
+			for _, unit := range windows.StringToUTF16(string(r)) {
+				if unit == 0 {
+					continue
+				}
+				down.ki.wScan = unit
+				up := down
+				up.ki.dwFlags |= keyeventfKeyUp
+				inputs = append(inputs, down, up)
+			}
+			continue
+		}
+		up := down
+		up.ki.dwFlags |= keyeventfKeyUp
+		inputs = append(inputs, down, up)
+	}
+	if len(inputs) == 0 {
+		return nil
+	}
+	sent, _, err := procSendInput.Call(uintptr(len(inputs)), uintptr(unsafe.Pointer(&inputs[0])), unsafe.Sizeof(inputs[0]))
+	if int(sent) != len(inputs) {
+		return fmt.Errorf("SendInput sent %d of %d events: %v", sent, len(inputs), err)
+	}
+	return nil
+}
+
+func (sendInputEmitter) Close() error {
+	return nil
+}